@@ -4,53 +4,52 @@ go 1.23.0
 
 require (
 	fyne.io/fyne/v2 v2.5.1
+	github.com/alexmullins/zip v0.0.0-20180717182244-4affb64b04d0
+	github.com/chai2010/webp v1.1.1
+	github.com/dsnet/compress v0.0.1
+	github.com/gen2brain/avif v0.3.2
+	github.com/gen2brain/svg v0.1.0
 	github.com/grafana/pyroscope-go v1.2.0
+	github.com/jdeng/goheif v0.0.0-20200323230657-a0d6a8b3e68f
+	github.com/klauspost/compress v1.17.9
 	github.com/mattn/go-sqlite3 v1.14.23
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	github.com/stretchr/testify v1.9.0
+	github.com/strukturag/libheif v1.18.2
+	github.com/ulikunitz/xz v0.5.12
+	github.com/xfmoulet/qoi v0.2.0
 	golang.org/x/image v0.20.0
 )
 
-require (
-	github.com/grafana/pyroscope-go/godeltaprof v0.1.8 // indirect
-	github.com/klauspost/compress v1.17.9 // indirect
-)
+require github.com/grafana/pyroscope-go/godeltaprof v0.1.8 // indirect
 
 require (
 	fyne.io/systray v1.11.0 // indirect
 	fyne.io/x/fyne v0.0.0-20240803204126-8b5b5bfe65ef // indirect
 	github.com/BurntSushi/toml v1.4.0 // indirect
 	github.com/ajstarks/svgo v0.0.0-20211024235047-1546f124cd8b // indirect
-	github.com/alexmullins/zip v0.0.0-20180717182244-4affb64b04d0 // indirect
-	github.com/chai2010/webp v1.1.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/dsnet/compress v0.0.1 // indirect
 	github.com/ebitengine/purego v0.7.1 // indirect
 	github.com/fredbi/uri v1.1.0 // indirect
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/fyne-io/gl-js v0.0.0-20230506162202-1fdaa286a934 // indirect
 	github.com/fyne-io/glfw-js v0.0.0-20240101223322-6e1efdc71b7a // indirect
 	github.com/fyne-io/image v0.0.0-20240417123036-dc0ee9e7c964 // indirect
-	github.com/gen2brain/avif v0.3.2 // indirect
-	github.com/gen2brain/svg v0.1.0 // indirect
 	github.com/go-gl/gl v0.0.0-20231021071112-07e5d0ea2e71 // indirect
 	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20240506104042-037f3cc74f2a // indirect
 	github.com/go-text/render v0.1.1 // indirect
 	github.com/go-text/typesetting v0.1.1 // indirect
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/gopherjs/gopherjs v1.17.2 // indirect
-	github.com/jdeng/goheif v0.0.0-20200323230657-a0d6a8b3e68f // indirect
 	github.com/jeandeaual/go-locale v0.0.0-20240223122105-ce5225dcaa49 // indirect
 	github.com/jsummers/gobmp v0.0.0-20230614200233-a9de23ed2e25 // indirect
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646 // indirect
 	github.com/nicksnyder/go-i18n/v2 v2.4.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd // indirect
 	github.com/rymdport/portal v0.2.6 // indirect
 	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c // indirect
 	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef // indirect
-	github.com/strukturag/libheif v1.18.2 // indirect
 	github.com/tetratelabs/wazero v1.7.3 // indirect
-	github.com/xfmoulet/qoi v0.2.0 // indirect
 	github.com/yuin/goldmark v1.7.4 // indirect
 	golang.org/x/crypto v0.27.0 // indirect
 	golang.org/x/mobile v0.0.0-20240909163608-642950227fb3 // indirect
@@ -3,26 +3,41 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"flag"
 	"fmt"
 	"image"
 	"time"
 
-	// "image/color"
+	"image/color"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"main/pkg/apptheme"
+	"main/pkg/archives"
+	"main/pkg/colorutils"
 	"main/pkg/database"
 	"main/pkg/fileutils"
 	"main/pkg/icon"
+	"main/pkg/imageconv"
+	"main/pkg/imageedit"
+	"main/pkg/imagemeta"
+	"main/pkg/jxl"
 	"main/pkg/logger"
 	"main/pkg/options"
+	"main/pkg/palette"
 	"main/pkg/profiling"
+	"main/pkg/selection"
+	"main/pkg/shortcuts"
+	"main/pkg/tagquery"
 	"main/pkg/tagwindow"
 	"main/pkg/utilwindows"
+	"main/pkg/viewer"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -43,6 +58,7 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	fyneGif "fyne.io/x/fyne/widget"
@@ -55,13 +71,17 @@ import (
 
 type imageButton struct {
 	widget.BaseWidget
-	image        *canvas.Image
-	onTapped     func()
-	onLongTap    func()
-	onRightClick func()
-	pressedTime  time.Time
-	longTapTimer *time.Timer
-	selected     bool
+	image         *canvas.Image
+	focusRing     *canvas.Rectangle
+	focused       bool
+	onTapped      func()
+	onToggle      func() // ctrl or cmd click
+	onRangeSelect func() // shift click
+	onLongTap     func()
+	onRightClick  func()
+	pressedTime   time.Time
+	longTapTimer  *time.Timer
+	selected      bool
 }
 
 func newImageButton(resource fyne.Resource) *imageButton {
@@ -70,10 +90,24 @@ func newImageButton(resource fyne.Resource) *imageButton {
 	img.image = canvas.NewImageFromResource(resource)
 	img.image.FillMode = canvas.ImageFillContain
 	img.image.SetMinSize(fyne.NewSize(150, 150))
+	img.focusRing = canvas.NewRectangle(color.Transparent)
+	img.focusRing.StrokeColor = theme.Color(theme.ColorNameFocus)
+	img.focusRing.StrokeWidth = 3
+	img.focusRing.Hide()
 	return img
 }
 
 func (b *imageButton) Tapped(me *desktop.MouseEvent) {
+	if me != nil {
+		switch {
+		case me.Modifier&fyne.KeyModifierShift != 0 && b.onRangeSelect != nil:
+			b.onRangeSelect()
+			return
+		case me.Modifier&(fyne.KeyModifierControl|fyne.KeyModifierSuper) != 0 && b.onToggle != nil:
+			b.onToggle()
+			return
+		}
+	}
 	if b.onTapped != nil {
 		b.onTapped()
 	}
@@ -89,8 +123,6 @@ func (b *imageButton) LongTap(me *desktop.MouseEvent) {
 	if me.Button == desktop.MouseButtonPrimary {
 		if b.onLongTap != nil {
 			b.onLongTap()
-			b.selected = !b.selected
-			b.Refresh()
 		}
 	}
 }
@@ -101,7 +133,13 @@ func (b *imageButton) Refresh() {
 	} else {
 		b.image.Translucency = 0
 	}
+	if b.focused {
+		b.focusRing.Show()
+	} else {
+		b.focusRing.Hide()
+	}
 	canvas.Refresh(b.image)
+	canvas.Refresh(b.focusRing)
 }
 
 func (b *imageButton) MouseDown(me *desktop.MouseEvent) {
@@ -115,17 +153,17 @@ func (b *imageButton) MouseDown(me *desktop.MouseEvent) {
 	}
 }
 
-func (b *imageButton) MouseUp(_ *desktop.MouseEvent) {
+func (b *imageButton) MouseUp(me *desktop.MouseEvent) {
 	if b.longTapTimer != nil {
 		b.longTapTimer.Stop()
 	}
 	if time.Since(b.pressedTime) < time.Millisecond*200 {
-		b.Tapped(nil)
+		b.Tapped(me)
 	}
 }
 
 func (b *imageButton) CreateRenderer() fyne.WidgetRenderer {
-	return widget.NewSimpleRenderer(b.image)
+	return widget.NewSimpleRenderer(container.NewStack(b.image, b.focusRing))
 }
 
 var (
@@ -134,15 +172,40 @@ var (
 	optionsExist  = false
 	appLogger     = logger.InitLogger()
 	page          = 0
-	selectedFiles = map[string]bool{}
+	selectedFiles = selection.New()
 	home, _       = os.UserHomeDir()
 	prevoiusImage = ""
+	// files of the current results in the order they were listed, the viewer steps through them
+	resultsMutex   sync.Mutex
+	currentResults []string
+	resultsPaged   bool // the results are the library loaded a page at a time while scrolling
+	// tiles of the grid, keyboard navigation moves the focus ring between them
+	gridMutex         sync.Mutex
+	gridTiles         []*gridTile
+	focusedTile       *gridTile
+	selectionAnchor   *gridTile
+	sidebarTagDisplay *fyne.Container
+	keyBindings       *shortcuts.Bindings
 )
 
+type gridTile struct {
+	path   string
+	button *imageButton
+	parent *fyne.Container
+	object fyne.CanvasObject
+}
+
 func main() {
 	db := database.Init()
 	defer db.Close()
 
+	// Headless commands run without opening a window
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		exitCode := runVerifyCommand(db, os.Args[2:])
+		db.Close()
+		os.Exit(exitCode)
+	}
+
 	appLogger.Println("Check Obsidian Todo list")
 	appLogger.Println("Make displayImages work with getImagesFromDatabase")
 
@@ -197,7 +260,7 @@ func main() {
 	go func() {
 		defer wg.Done()
 		// discoverImages(db)
-		database.DiscoverImages(db, appOptions.ExcludedDirs)
+		database.DiscoverImages(db, appOptions.ExcludedDirs, appOptions.DirectoryTags)
 	}()
 
 	wg.Wait()
@@ -302,18 +365,20 @@ func main() {
 	input := widget.NewEntry()
 	input.SetPlaceHolder("Enter a Tag to Search by")
 	form := widget.NewEntry()
-	form.SetPlaceHolder("Enter a Tag to Search by")
+	form.SetPlaceHolder("Search tags, like artist:* -project:old")
 
 	imageContent := content
 
 	form.OnSubmitted = func(s string) {
-		imagePaths, err := database.GetImagePathsByTag(db, "%"+s+"%")
+		imagePaths, err := database.SearchFiles(db, s)
 		if err != nil {
 			fmt.Print("searchImagesByTag")
 			dialog.ShowError(err, w)
 			return
 		}
 		updateContentWithSearchResults(imageContent, imagePaths, db, w, sidebar, sidebarScroll, split, a)
+		// an empty search lists the first page of the library
+		setResultsPaged(len(tagquery.Parse(s)) == 0)
 	}
 
 	// form.OnChanged = func(s string) {
@@ -330,12 +395,31 @@ func main() {
 	settingsButton := widget.NewButtonWithIcon("", theme.SettingsIcon(), func() {
 		utilwindows.ShowSettingsWindow(a, w, db, appOptions)
 	})
+	// tapping a tag of the shown file searches for the files with it, quoted so it matches the whole name
+	tagwindow.OnTagSearch = func(name string) {
+		form.SetText(`"` + name + `"`)
+		form.OnSubmitted(form.Text)
+	}
+	utilwindows.OnShortcutsChanged = func() {
+		bindGridShortcuts(a, w, db, scroll, form, sidebarScroll)
+	}
+	bindGridShortcuts(a, w, db, scroll, form, sidebarScroll)
+	selectedFiles.OnChanged(func(int) { syncTileSelection() })
 
 	loadFilterButton := fyne.NewStaticResource("filterIcon", icon.FilterIconLight)
-	filterButton := widget.NewButton("", func() {
-		return
-	})
+	filterButton := widget.NewButton("", nil)
 	filterButton.Icon = loadFilterButton
+	var filterPopUp *widget.PopUp
+	filterButton.OnTapped = func() {
+		if filterPopUp == nil {
+			showResults := func(paths []string) {
+				updateContentWithSearchResults(imageContent, paths, db, w, sidebar, sidebarScroll, split, a)
+			}
+			filterPopUp = widget.NewPopUp(createColorFilter(db, w, form, showResults), w.Canvas())
+		}
+		position := fyne.CurrentApp().Driver().AbsolutePositionForObject(filterButton)
+		filterPopUp.ShowAtPosition(position.Add(fyne.NewPos(filterButton.Size().Width-filterPopUp.MinSize().Width, filterButton.Size().Height)))
+	}
 
 	optContainer := container.NewGridWithColumns(2, filterButton, settingsButton)
 	controls := container.NewBorder(nil, nil, nil, optContainer, form)
@@ -343,7 +427,7 @@ func main() {
 	// Create main container with tabs above controls
 	mainContainer := container.NewBorder(
 		controls,
-		nil,
+		createSelectionBar(a, w, db),
 		nil,
 		nil,
 		container.NewPadded(split),
@@ -374,6 +458,7 @@ func main() {
 		}
 		displayImages := createDisplayImagesFunctionFromDb(db, w, sidebar, sidebarScroll, split, a, imageContent, dbImages)
 		displayImages("")
+		setResultsPaged(true)
 	}
 
 	scroll.OnScrolled = func(pos fyne.Position) {
@@ -400,6 +485,39 @@ func main() {
 	w.ShowAndRun()
 }
 
+// Usage: verify [-password pass] <archive> [source files...]
+// Source files are checked against the md5 stored in the database, without them only the archive itself is checked
+func runVerifyCommand(db *sql.DB, args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	password := flags.String("password", "", "password of an encrypted archive")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		fmt.Println("Usage: verify [-password pass] <archive> [source files...]")
+		return 2
+	}
+
+	var expected map[string]string
+	if flags.NArg() > 1 {
+		hashes, err := database.GetFileHashes(db, flags.Args()[1:])
+		if err != nil {
+			fmt.Println("Failed to get source checksums: ", err)
+			return 2
+		}
+		expected = hashes
+	} else {
+		fmt.Println("No source files given, checksums are not compared")
+	}
+
+	archives.ArchivePassword = *password
+	report := archives.VerifyArchive(flags.Arg(0), expected, *password != "")
+	fmt.Print(report.String())
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
 func setupMainWindow(a fyne.App) fyne.Window {
 	w := a.NewWindow("Tag Vault")
 	w.Resize(fyne.NewSize(1000, 600))
@@ -441,6 +559,7 @@ func createDisplayImagesFunction(db *sql.DB, w fyne.Window, sidebar *fyne.Contai
 			if !file.IsDir() && fileutils.IsImageFileMap(file.Name()) {
 				// get full image path
 				imgPath := filepath.Join(dir, file.Name())
+				addResults(imgPath)
 				wg.Add(1)
 				go func(path string) {
 					defer wg.Done()
@@ -484,6 +603,7 @@ func createDisplayImagesFunctionFromDb(db *sql.DB, w fyne.Window, sidebar *fyne.
 
 		var wg sync.WaitGroup
 		semaphore := make(chan struct{}, runtime.NumCPU())
+		addResults(files...)
 
 		// loop through images
 		for _, file := range files {
@@ -534,13 +654,15 @@ func displayImage(db *sql.DB, w fyne.Window, path string, imageContainer *fyne.C
 		// appLogger.Println("Skipping GIF")
 	} else {
 		imgButton := newImageButton(placeholderResource)
+		// files selected before a search or in an earlier page stay selected
+		imgButton.selected = selectedFiles.Contains(path)
 
 		resourceChan := make(chan fyne.Resource, 1)
 
 		// claude ai solution to load images in bg
 		go func() {
 			// load the image as a fyne resource
-			resource, err := loadImageResourceThumbnailEfficient(path)
+			resource, err := loadImageResourceThumbnailEfficient(db, path)
 			if err != nil {
 				appLogger.Printf("No resource image empty %s: %v", path, err)
 				resourceChan <- placeholderResource
@@ -550,45 +672,49 @@ func displayImage(db *sql.DB, w fyne.Window, path string, imageContainer *fyne.C
 
 			// set the image button image to the resource
 			imgButton.image.Resource = resource
-			imgButton.image.Translucency = 0
+			imgButton.Refresh()
 			// imgButton.image.Refresh()
 			canvas.Refresh(imgButton)
 			resourceChan <- resource
 		}()
 
 		resource := <-resourceChan
+		tile := &gridTile{path: path, button: imgButton}
 		imgButton.onTapped = func() {
+			setSelectionAnchor(tile)
 			// updates the sidebar
 			updateSidebar(db, w, path, resource, sidebar, sidebarScroll, split, a, imageContainer)
 		}
 
-		imgButton.onLongTap = func() {
-			// If image is not already selected and selectedFiles is 0 or bigger than 0
-			if len(selectedFiles) >= 0 && !selectedFiles[path] {
-				selectedFiles[path] = true
-				appLogger.Println("Added new file: ", path)
-				imgButton.image.Translucency = 0.7
-				imgButton.selected = true
-				canvas.Refresh(imgButton)
-				// If image is already selected and selectedFiles is 0 or bigger than 0
-			} else if len(selectedFiles) >= 0 && selectedFiles[path] {
-				appLogger.Println("Removed file: ", path)
-				delete(selectedFiles, path)
-				imgButton.image.Translucency = 0
-				imgButton.selected = false
-				canvas.Refresh(imgButton)
+		imgButton.onToggle = func() {
+			selectedFiles.Toggle(path)
+			setSelectionAnchor(tile)
+		}
+		imgButton.onRangeSelect = func() {
+			tiles, _ := visualTiles()
+			gridMutex.Lock()
+			if selectionAnchor == nil {
+				selectionAnchor = tile
 			}
-			appLogger.Println("Selected files: ", selectedFiles)
+			anchor := selectionAnchor
+			gridMutex.Unlock()
+			selectTileRange(tiles, anchor, tile)
+		}
+		imgButton.onLongTap = func() {
+			selectedFiles.Toggle(path)
+			appLogger.Println("Selected files: ", selectedFiles.Paths())
 		}
 
 		imgButton.onRightClick = func() {
 			appLogger.Println("Add functionality to open menu to add to archive and compress")
-			utilwindows.ShowRightClickMenu(w, selectedFiles, a)
+			utilwindows.ShowRightClickMenu(w, selectedFiles.Paths(), a, db, appOptions)
 		}
 
 		// make a parent container to hold the image button and label
 		imageTile := container.NewVBox(container.NewPadded(imgButton))
 		imageContainer.Add(imageTile)
+		tile.parent, tile.object = imageContainer, imageTile
+		addGridTile(tile)
 	}
 	// appLogger.Println("Showing ", len(imageContainer.Objects), " images")
 }
@@ -616,20 +742,51 @@ func updateSidebar(db *sql.DB, w fyne.Window, path string, resource fyne.Resourc
 
 	imageId := database.GetImageId(db, path)
 	tagDisplay := tagwindow.CreateTagDisplay(db, imageId, appLogger, sidebar, w)
+	sidebarTagDisplay = tagDisplay
 
-	addTagButton := widget.NewButton("+", func() {
+	// typing a tag is the quick way, the tag window lists every tag
+	tagEntry := tagwindow.CreateTagEntry(db, imageId, tagDisplay, w)
+	addTagButton := widget.NewButton("Browse Tags", func() {
 		tagwindow.ShowTagWindow(a, w, db, imageId, tagDisplay)
 	})
 
 	createTagButton := widget.NewButton("Create Tag", func() {
 		// showCreateTagWindow(a, w, db)
-		tagwindow.ShowCreateTagWindow(a, w, db, appOptions, false, "", 0)
+		tagwindow.ShowCreateTagWindow(a, w, db, appOptions, false, "", 0, nil)
+	})
+
+	viewButton := widget.NewButtonWithIcon("View Full Size", theme.ViewFullScreenIcon(), func() {
+		openViewer(a, db, path)
 	})
 
 	sidebar.Add(paddedImg)
+	sidebar.Add(container.NewPadded(viewButton))
 	sidebar.Add(container.NewGridWithRows(3, dateAdded, fullLabel, fileType))
 	sidebar.Add(tagDisplay)
+	sidebar.Add(container.NewPadded(tagEntry))
 	sidebar.Add(container.NewPadded(container.NewGridWithColumns(2, addTagButton, createTagButton)))
+	sidebar.Add(createEditPanel(db, w, path, fullImg, sidebar, sidebarScroll, split, a, imageContainer))
+
+	// converted copies and the originals they came from
+	versions, err := database.GetFileVersions(db, path)
+	if err != nil {
+		appLogger.Println("Error getting file versions:", err)
+	}
+	if len(versions) > 0 {
+		versionList := container.NewVBox(widget.NewLabel("Versions:"))
+		for _, version := range versions {
+			versionButton := widget.NewButton(filepath.Base(version), func() {
+				versionResource, err := loadImageResourceEfficient(db, version)
+				if err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				updateSidebar(db, w, version, versionResource, sidebar, sidebarScroll, split, a, imageContainer)
+			})
+			versionList.Add(versionButton)
+		}
+		sidebar.Add(container.NewPadded(versionList))
+	}
 
 	// Show sidebar if hidden else show
 	if prevoiusImage == path && sidebarScroll.Visible() {
@@ -648,6 +805,144 @@ func updateSidebar(db *sql.DB, w fyne.Window, path string, resource fyne.Resourc
 	// sidebar.Refresh()
 }
 
+// Buttons that add rotate, flip and crop steps to the saved edit stack of the image. The file itself only
+// changes through "Apply to File", which writes the result as a new version next to it.
+func createEditPanel(db *sql.DB, w fyne.Window, path string, fullImg *canvas.Image, sidebar *fyne.Container, sidebarScroll *container.Scroll, split *container.Split, a fyne.App, imageContainer *fyne.Container) fyne.CanvasObject {
+	edits, err := database.GetEdits(db, path)
+	if err != nil {
+		appLogger.Println("Error getting edits:", err)
+	}
+
+	editsLabel := widget.NewLabel("")
+	editsLabel.Wrapping = fyne.TextWrapWord
+	var applyButton, undoButton, resetButton *widget.Button
+
+	showEdits := func() {
+		if len(edits) == 0 {
+			editsLabel.SetText("Edits: none")
+		} else {
+			steps := make([]string, len(edits))
+			for i, edit := range edits {
+				steps[i] = edit.String()
+			}
+			editsLabel.SetText("Edits: " + strings.Join(steps, ", "))
+		}
+		for _, button := range []*widget.Button{applyButton, undoButton, resetButton} {
+			if len(edits) == 0 {
+				button.Disable()
+			} else {
+				button.Enable()
+			}
+		}
+	}
+
+	// saves the stack and renders the image again from the untouched file
+	setEdits := func(newEdits []imageedit.Edit) {
+		if err := database.SetEdits(db, path, newEdits); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		edits = newEdits
+		resourceCache.Delete(path)
+		resource, err := loadImageResourceEfficient(db, path)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		fullImg.Resource = resource
+		fullImg.Refresh()
+		showEdits()
+	}
+	addEdit := func(edit imageedit.Edit) {
+		setEdits(append(slices.Clone(edits), edit))
+	}
+
+	rotateLeftButton := widget.NewButtonWithIcon("", theme.MediaReplayIcon(), func() {
+		addEdit(imageedit.Edit{Op: imageedit.RotateLeft})
+	})
+	rotateRightButton := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), func() {
+		addEdit(imageedit.Edit{Op: imageedit.RotateRight})
+	})
+	flipHorizontalButton := widget.NewButton("Flip H", func() {
+		addEdit(imageedit.Edit{Op: imageedit.FlipHorizontal})
+	})
+	flipVerticalButton := widget.NewButton("Flip V", func() {
+		addEdit(imageedit.Edit{Op: imageedit.FlipVertical})
+	})
+	cropButton := widget.NewButtonWithIcon("Crop", theme.ContentCutIcon(), func() {
+		showCropDialog(w, addEdit)
+	})
+	undoButton = widget.NewButtonWithIcon("Undo", theme.ContentUndoIcon(), func() {
+		setEdits(edits[:len(edits)-1])
+	})
+	resetButton = widget.NewButton("Reset", func() {
+		setEdits(nil)
+	})
+
+	applyButton = widget.NewButtonWithIcon("Apply to File", theme.DocumentSaveIcon(), func() {
+		message := fmt.Sprintf("Save the edited image as a new file next to %s? The original is kept.", filepath.Base(path))
+		dialog.ShowConfirm("Apply Edits", message, func(ok bool) {
+			if !ok {
+				return
+			}
+			output, err := imageconv.ApplyEdits(path, edits)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if err := database.AddConvertedFile(db, path, output, false); err != nil {
+				dialog.ShowError(fmt.Errorf("%s was saved but not added to the database: %w", output, err), w)
+				return
+			}
+			// the new version carries the edits, the original goes back to how it is on disk
+			setEdits(nil)
+			prevoiusImage = ""
+			updateSidebar(db, w, path, fullImg.Resource, sidebar, sidebarScroll, split, a, imageContainer)
+			dialog.ShowInformation("Edits Applied", "Saved "+output, w)
+		}, w)
+	})
+
+	showEdits()
+	return container.NewPadded(container.NewVBox(
+		editsLabel,
+		container.NewGridWithColumns(4, rotateLeftButton, rotateRightButton, flipHorizontalButton, flipVerticalButton),
+		container.NewGridWithColumns(3, cropButton, undoButton, resetButton),
+		applyButton,
+	))
+}
+
+// Asks how much to cut from each side of the image, in percent of its size
+func showCropDialog(w fyne.Window, addEdit func(imageedit.Edit)) {
+	entries := make([]*widget.Entry, 4)
+	items := make([]*widget.FormItem, 4)
+	for i, side := range []string{"Left %", "Top %", "Right %", "Bottom %"} {
+		entries[i] = widget.NewEntry()
+		entries[i].SetText("0")
+		items[i] = widget.NewFormItem(side, entries[i])
+	}
+
+	dialog.ShowForm("Crop", "Crop", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		var margins [4]float64
+		for i, entry := range entries {
+			value, err := strconv.ParseFloat(strings.TrimSpace(entry.Text), 64)
+			if err != nil || value < 0 || value >= 100 {
+				dialog.ShowError(fmt.Errorf("crop margins must be between 0 and 100 percent"), w)
+				return
+			}
+			margins[i] = value / 100
+		}
+		left, top, right, bottom := margins[0], margins[1], margins[2], margins[3]
+		if left+right >= 1 || top+bottom >= 1 {
+			dialog.ShowError(fmt.Errorf("the crop would leave nothing of the image"), w)
+			return
+		}
+		addEdit(imageedit.Edit{Op: imageedit.Crop, X: left, Y: top, Width: 1 - left - right, Height: 1 - top - bottom})
+	}, w)
+}
+
 func truncateFilename(filename string, maxLength int) string {
 	// get the file extension
 	ext := filepath.Ext(filename)
@@ -664,7 +959,7 @@ func truncateFilename(filename string, maxLength int) string {
 
 // Optimized function to load image resources
 // Use this for thumbnails only or add a thumbnail bool
-func loadImageResourceEfficient(path string) (fyne.Resource, error) {
+func loadImageResourceEfficient(db *sql.DB, path string) (fyne.Resource, error) {
 	if cachedResource, ok := resourceCache.Load(path); ok {
 		return cachedResource.(fyne.Resource), nil
 	}
@@ -693,6 +988,8 @@ func loadImageResourceEfficient(path string) (fyne.Resource, error) {
 		img, err = avif.Decode(file)
 	case ".qoi":
 		img, err = qoi.Decode(file)
+	case ".jxl":
+		img, err = jxl.Decode(file)
 	case ".tiff", ".tif":
 		img, err = tiff.Decode(file)
 	case ".svg":
@@ -703,6 +1000,9 @@ func loadImageResourceEfficient(path string) (fyne.Resource, error) {
 	if err != nil {
 		return nil, err
 	}
+	img = applyOrientationAndEdits(db, path, img)
+	// editing clears the palette and loads the image again, it is found from the edited image here
+	savePaletteIfMissing(db, path, img)
 
 	// Calculate the thumbnail dimensions while maintaining aspect ratio
 	bounds := img.Bounds()
@@ -744,6 +1044,8 @@ func loadImageResourceEfficient(path string) (fyne.Resource, error) {
 	case ".qoi":
 		// err = qoi.Encode(&buf, thumbImg)
 		err = jpeg.Encode(&buf, thumbImg, &jpeg.Options{Quality: 85})
+	case ".jxl":
+		err = jpeg.Encode(&buf, thumbImg, &jpeg.Options{Quality: 85})
 	case ".tiff", ".tif":
 		// err = tiff.Encode(&buf, thumbImg, &tiff.Options{Compression: tiff.Deflate})
 		err = jpeg.Encode(&buf, thumbImg, &jpeg.Options{Quality: 85})
@@ -763,7 +1065,7 @@ func loadImageResourceEfficient(path string) (fyne.Resource, error) {
 	return resource, nil
 }
 
-func loadImageResourceThumbnailEfficient(path string) (fyne.Resource, error) {
+func loadImageResourceThumbnailEfficient(db *sql.DB, path string) (fyne.Resource, error) {
 	if cachedResource, ok := resourceCache.Load(path); ok {
 		return cachedResource.(fyne.Resource), nil
 	}
@@ -798,6 +1100,8 @@ func loadImageResourceThumbnailEfficient(path string) (fyne.Resource, error) {
 		img, err = avif.Decode(file)
 	case ".qoi":
 		img, err = qoi.Decode(file)
+	case ".jxl":
+		img, err = jxl.Decode(file)
 	case ".tiff", ".tif":
 		img, err = tiff.Decode(file)
 	case ".svg":
@@ -808,6 +1112,8 @@ func loadImageResourceThumbnailEfficient(path string) (fyne.Resource, error) {
 	if err != nil {
 		return nil, err
 	}
+	img = applyOrientationAndEdits(db, path, img)
+	savePaletteIfMissing(db, path, img)
 
 	// Calculate the square crop region from the center of the image
 	bounds := img.Bounds()
@@ -855,6 +1161,8 @@ func loadImageResourceThumbnailEfficient(path string) (fyne.Resource, error) {
 	case ".qoi":
 		// img, err = qoi.Decode(file)
 		err = jpeg.Encode(&buf, thumbImg, &jpeg.Options{Quality: 85})
+	case ".jxl":
+		err = jpeg.Encode(&buf, thumbImg, &jpeg.Options{Quality: 85})
 	case ".tiff", ".tif":
 		// img, err = tiff.Decode(file)
 		err = jpeg.Encode(&buf, thumbImg, &jpeg.Options{Quality: 85})
@@ -877,9 +1185,511 @@ func loadImageResourceThumbnailEfficient(path string) (fyne.Resource, error) {
 	return resource, nil
 }
 
+func addGridTile(tile *gridTile) {
+	gridMutex.Lock()
+	defer gridMutex.Unlock()
+	gridTiles = append(gridTiles, tile)
+}
+
+// Shows the selection on the tiles after it changed, tiles added later read it in displayImage
+func syncTileSelection() {
+	gridMutex.Lock()
+	tiles := slices.Clone(gridTiles)
+	gridMutex.Unlock()
+	for _, tile := range tiles {
+		if selected := selectedFiles.Contains(tile.path); tile.button.selected != selected {
+			tile.button.selected = selected
+			tile.button.Refresh()
+		}
+	}
+}
+
+// Sets the tile a shift click or shift move selects from
+func setSelectionAnchor(tile *gridTile) {
+	gridMutex.Lock()
+	defer gridMutex.Unlock()
+	selectionAnchor = tile
+}
+
+// Selects every tile shown from one tile to the other, both included
+func selectTileRange(tiles []*gridTile, from *gridTile, to *gridTile) {
+	start, end := slices.Index(tiles, from), slices.Index(tiles, to)
+	if end < 0 {
+		return
+	}
+	if start < 0 {
+		start = end
+	}
+	paths := make([]string, 0, max(start, end)-min(start, end)+1)
+	for _, tile := range tiles[min(start, end) : max(start, end)+1] {
+		paths = append(paths, tile.path)
+	}
+	selectedFiles.SetAll(paths, true)
+}
+
+// Selects every file of the current results. The library is listed a page at a time, it is read whole from the
+// database so the pages not loaded yet are selected too.
+func selectAllResults(db *sql.DB) {
+	resultsMutex.Lock()
+	paths := slices.Clone(currentResults)
+	paged := resultsPaged
+	resultsMutex.Unlock()
+	if paged {
+		all, err := database.GetImagePaths(db)
+		if err != nil {
+			appLogger.Println("Failed to list the library: ", err)
+		} else {
+			paths = all
+		}
+	}
+	selectedFiles.SetAll(paths, true)
+}
+
+func invertSelection() {
+	resultsMutex.Lock()
+	paths := slices.Clone(currentResults)
+	resultsMutex.Unlock()
+	selectedFiles.Invert(paths)
+}
+
+// Opens the bulk tag window on the selected files, the sidebar is reloaded when their tags change
+func showBulkTagWindow(a fyne.App, w fyne.Window, db *sql.DB) {
+	tagwindow.ShowBulkTagWindow(a, w, db, selectedFiles.Paths(), func() {
+		refreshSidebarTags(db, w)
+	})
+}
+
+// Reloads the tags of the image shown in the sidebar after they were changed somewhere else
+func refreshSidebarTags(db *sql.DB, w fyne.Window) {
+	if sidebarTagDisplay == nil || prevoiusImage == "" {
+		return
+	}
+	fresh := tagwindow.CreateTagDisplay(db, database.GetImageId(db, prevoiusImage), appLogger, nil, w)
+	sidebarTagDisplay.Objects = fresh.Objects
+	sidebarTagDisplay.Refresh()
+}
+
+// Bar under the grid showing how many files are selected with the actions for them
+func createSelectionBar(a fyne.App, w fyne.Window, db *sql.DB) fyne.CanvasObject {
+	count := widget.NewLabel("")
+	clearButton := widget.NewButtonWithIcon("Clear", theme.CancelIcon(), selectedFiles.Clear)
+	tagButton := widget.NewButtonWithIcon("Tag", theme.ContentAddIcon(), func() {
+		showBulkTagWindow(a, w, db)
+	})
+	actionsButton := widget.NewButtonWithIcon("Actions", theme.MenuIcon(), func() {
+		utilwindows.ShowRightClickMenu(w, selectedFiles.Paths(), a, db, appOptions)
+	})
+	trashButton := widget.NewButtonWithIcon("Move to Trash", theme.DeleteIcon(), func() {
+		trashFiles(w, db)
+	})
+
+	update := func(selected int) {
+		count.SetText(fmt.Sprintf("%d selected", selected))
+		for _, button := range []*widget.Button{clearButton, tagButton, actionsButton, trashButton} {
+			if selected == 0 {
+				button.Disable()
+			} else {
+				button.Enable()
+			}
+		}
+	}
+	selectedFiles.OnChanged(update)
+	update(selectedFiles.Len())
+
+	return container.NewHBox(
+		count,
+		layout.NewSpacer(),
+		widget.NewButtonWithIcon("Select All", theme.CheckButtonCheckedIcon(), func() { selectAllResults(db) }),
+		widget.NewButtonWithIcon("Invert", theme.ViewRefreshIcon(), invertSelection),
+		clearButton,
+		tagButton,
+		actionsButton,
+		trashButton,
+	)
+}
+
+// Returns the tiles sorted the way they are shown, row by row, and where every tile is on screen
+func visualTiles() ([]*gridTile, map[*gridTile]fyne.Position) {
+	gridMutex.Lock()
+	tiles := slices.Clone(gridTiles)
+	gridMutex.Unlock()
+
+	driver := fyne.CurrentApp().Driver()
+	positions := make(map[*gridTile]fyne.Position, len(tiles))
+	for _, tile := range tiles {
+		positions[tile] = driver.AbsolutePositionForObject(tile.object)
+	}
+	sort.SliceStable(tiles, func(i, j int) bool {
+		a, b := positions[tiles[i]], positions[tiles[j]]
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		return a.X < b.X
+	})
+	return tiles, positions
+}
+
+// Returns the tile next to the focused one, rows moves up or down to the closest tile in that row
+func neighbourTile(tiles []*gridTile, positions map[*gridTile]fyne.Position, current *gridTile, step int, rows int) *gridTile {
+	index := slices.Index(tiles, current)
+	if index < 0 {
+		return tiles[0]
+	}
+	if rows == 0 {
+		return tiles[min(max(0, index+step), len(tiles)-1)]
+	}
+
+	from := positions[current]
+	var best *gridTile
+	var bestRow, bestDistance float32
+	for _, tile := range tiles {
+		pos := positions[tile]
+		if (rows > 0 && pos.Y <= from.Y) || (rows < 0 && pos.Y >= from.Y) {
+			continue
+		}
+		row := pos.Y - from.Y
+		if row < 0 {
+			row = -row
+		}
+		distance := pos.X - from.X
+		if distance < 0 {
+			distance = -distance
+		}
+		if best == nil || row < bestRow || (row == bestRow && distance < bestDistance) {
+			best, bestRow, bestDistance = tile, row, distance
+		}
+	}
+	if best == nil {
+		return current
+	}
+	return best
+}
+
+// Moves the focus ring, with extend every tile between the anchor and the new focus gets selected
+func moveFocus(scroll *container.Scroll, step int, rows int, extend bool) {
+	tiles, positions := visualTiles()
+	if len(tiles) == 0 {
+		return
+	}
+
+	gridMutex.Lock()
+	previous := focusedTile
+	next := tiles[0]
+	if previous != nil {
+		next = neighbourTile(tiles, positions, previous, step, rows)
+	}
+	focusedTile = next
+	if !extend || selectionAnchor == nil {
+		selectionAnchor = next
+		if extend && previous != nil {
+			selectionAnchor = previous
+		}
+	}
+	anchor := selectionAnchor
+	gridMutex.Unlock()
+
+	if previous != nil {
+		previous.button.focused = false
+		previous.button.Refresh()
+	}
+	next.button.focused = true
+	next.button.Refresh()
+
+	if extend {
+		selectTileRange(tiles, anchor, next)
+	}
+	scrollToTile(scroll, next, positions[next])
+}
+
+// Scrolls just enough for the whole tile to be visible
+func scrollToTile(scroll *container.Scroll, tile *gridTile, position fyne.Position) {
+	top := position.Y - fyne.CurrentApp().Driver().AbsolutePositionForObject(scroll).Y
+	bottom := top + tile.object.Size().Height
+	switch {
+	case top < 0:
+		scroll.Offset.Y += top
+	case bottom > scroll.Size().Height:
+		scroll.Offset.Y += bottom - scroll.Size().Height
+	default:
+		return
+	}
+	scroll.Refresh()
+}
+
+func focusedGridTile() (*gridTile, bool) {
+	gridMutex.Lock()
+	defer gridMutex.Unlock()
+	return focusedTile, focusedTile != nil
+}
+
+// Binds the keys from the Shortcuts option to the grid actions, called again when the shortcuts are changed
+func bindGridShortcuts(a fyne.App, w fyne.Window, db *sql.DB, scroll *container.Scroll, search *widget.Entry, sidebarScroll *container.Scroll) {
+	if keyBindings == nil {
+		keyBindings = shortcuts.Bind(w.Canvas())
+	}
+	keyBindings.Clear()
+
+	moves := map[string][2]int{
+		options.ShortcutMoveLeft:  {-1, 0},
+		options.ShortcutMoveRight: {1, 0},
+		options.ShortcutMoveUp:    {0, -1},
+		options.ShortcutMoveDown:  {0, 1},
+	}
+	actions := map[string]func(){
+		options.ShortcutOpen: func() {
+			if tile, ok := focusedGridTile(); ok {
+				openViewer(a, db, tile.path)
+			}
+		},
+		options.ShortcutToggleSelect: func() {
+			if tile, ok := focusedGridTile(); ok {
+				selectedFiles.Toggle(tile.path)
+				setSelectionAnchor(tile)
+			}
+		},
+		options.ShortcutAddTag: func() {
+			if selectedFiles.Len() > 0 {
+				showBulkTagWindow(a, w, db)
+				return
+			}
+			tile, ok := focusedGridTile()
+			if !ok {
+				return
+			}
+			// the tag window adds to the tags shown in the sidebar, so the sidebar has to show this image
+			if prevoiusImage != tile.path || !sidebarScroll.Visible() {
+				tile.button.onTapped()
+			}
+			tagwindow.ShowTagWindow(a, w, db, database.GetImageId(db, tile.path), sidebarTagDisplay)
+		},
+		options.ShortcutDelete: func() {
+			trashFiles(w, db)
+		},
+		options.ShortcutSearch: func() {
+			w.Canvas().Focus(search)
+		},
+		options.ShortcutSelectAll: func() {
+			selectAllResults(db)
+		},
+		options.ShortcutClearSelection:  selectedFiles.Clear,
+		options.ShortcutInvertSelection: invertSelection,
+	}
+
+	for _, action := range options.ShortcutActions {
+		binding := appOptions.Shortcuts[action]
+		if binding == "" {
+			continue
+		}
+		key, modifier, err := shortcuts.Parse(binding)
+		if err != nil {
+			appLogger.Printf("Invalid shortcut for %s: %v", action, err)
+			continue
+		}
+		if move, ok := moves[action]; ok {
+			keyBindings.AddKey(key, modifier, func() { moveFocus(scroll, move[0], move[1], false) })
+			// holding shift while moving extends the selection
+			keyBindings.AddKey(key, modifier|fyne.KeyModifierShift, func() { moveFocus(scroll, move[0], move[1], true) })
+			continue
+		}
+		keyBindings.AddKey(key, modifier, actions[action])
+	}
+}
+
+// Moves the selected files, or the focused one if none are selected, to the trash after asking
+func trashFiles(w fyne.Window, db *sql.DB) {
+	trash := selectedFiles.Paths()
+	if len(trash) == 0 {
+		tile, ok := focusedGridTile()
+		if !ok {
+			return
+		}
+		trash = append(trash, tile.path)
+	}
+
+	message := fmt.Sprintf("Move %d files to the trash?", len(trash))
+	if len(trash) == 1 {
+		message = fmt.Sprintf("Move %s to the trash?", filepath.Base(trash[0]))
+	}
+	dialog.ShowConfirm("Move to Trash", message, func(ok bool) {
+		if !ok {
+			return
+		}
+		var failed []string
+		for _, path := range trash {
+			if err := fileutils.MoveToTrash(path); err != nil {
+				failed = append(failed, err.Error())
+				continue
+			}
+			if err := database.RemoveFile(db, path); err != nil {
+				appLogger.Println("Failed to remove trashed file from the database: ", err)
+			}
+			removeGridFile(path)
+		}
+		if len(failed) > 0 {
+			dialog.ShowError(fmt.Errorf("%s", strings.Join(failed, "\n")), w)
+		}
+	}, w)
+}
+
+// Removes a file from the grid, the results and the selection, it does not have to be shown
+func removeGridFile(path string) {
+	gridMutex.Lock()
+	var removed []*gridTile
+	gridTiles = slices.DeleteFunc(gridTiles, func(tile *gridTile) bool {
+		if tile.path != path {
+			return false
+		}
+		removed = append(removed, tile)
+		if focusedTile == tile {
+			focusedTile = nil
+		}
+		if selectionAnchor == tile {
+			selectionAnchor = nil
+		}
+		return true
+	})
+	gridMutex.Unlock()
+
+	resultsMutex.Lock()
+	currentResults = slices.DeleteFunc(currentResults, func(result string) bool { return result == path })
+	resultsMutex.Unlock()
+
+	selectedFiles.Set(path, false)
+	resourceCache.Delete(path)
+	for _, tile := range removed {
+		tile.parent.Remove(tile.object)
+	}
+}
+
+// Marks whether the current results are the library loaded a page at a time while scrolling
+func setResultsPaged(paged bool) {
+	resultsMutex.Lock()
+	resultsPaged = paged
+	resultsMutex.Unlock()
+}
+
+// Adds files to the end of the current results, pages loaded while scrolling are appended
+func addResults(paths ...string) {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+	currentResults = append(currentResults, paths...)
+}
+
+// Opens the viewer on the file, next and previous go through the current results it is part of
+func openViewer(a fyne.App, db *sql.DB, path string) {
+	resultsMutex.Lock()
+	paths := slices.Clone(currentResults)
+	resultsMutex.Unlock()
+
+	index := slices.Index(paths, path)
+	if index < 0 {
+		paths, index = []string{path}, 0
+	}
+	viewer.Show(a, db, appOptions, paths, index)
+}
+
+// Finds the dominant colors of the whole image before it is cropped to a thumbnail. They are kept until the edits
+// of the image change, which clears them.
+func savePaletteIfMissing(db *sql.DB, path string, img image.Image) {
+	if database.HasPalette(db, path) {
+		return
+	}
+	if err := database.SavePalette(db, path, palette.Extract(img, palette.Size)); err != nil {
+		appLogger.Println("Failed to save the palette of", path, err)
+	}
+}
+
+// Turns the image upright according to its EXIF orientation and renders its saved edits on top
+func applyOrientationAndEdits(db *sql.DB, path string, img image.Image) image.Image {
+	img = imageedit.Orient(img, imagemeta.ReadOrientation(path))
+	edits, err := database.GetEdits(db, path)
+	if err != nil {
+		appLogger.Println("Error getting edits:", err)
+		return img
+	}
+	if len(edits) == 0 {
+		return img
+	}
+	return imageedit.Apply(img, edits)
+}
+
+// Filter panel finding images with a dominant color close to a picked one. A tag search in the search bar narrows
+// the results down further.
+func createColorFilter(db *sql.DB, w fyne.Window, search *widget.Entry, showResults func([]string)) fyne.CanvasObject {
+	picked := "#3C78D8"
+	pickedColor, _ := colorutils.HexToColor(picked)
+	swatch := canvas.NewRectangle(pickedColor)
+	swatch.SetMinSize(fyne.NewSize(32, 32))
+	swatch.CornerRadius = theme.InputRadiusSize()
+	hexLabel := widget.NewLabel(picked)
+
+	pickButton := widget.NewButton("Pick Color", func() {
+		picker := dialog.NewColorPicker("Search by Color", "Find images with a color close to this one", func(c color.Color) {
+			picked = colorutils.ColorToHex(c)
+			swatch.FillColor = c
+			swatch.Refresh()
+			hexLabel.SetText(picked)
+		}, w)
+		picker.Advanced = true
+		picker.SetColor(swatch.FillColor)
+		picker.Show()
+	})
+
+	// CIE76 distance, around 10 is the same color in another light and 40 a neighbouring hue
+	closenessLabel := widget.NewLabel("")
+	closeness := widget.NewSlider(5, 50)
+	closeness.Step = 1
+	closeness.OnChanged = func(value float64) {
+		closenessLabel.SetText(fmt.Sprintf("Closeness: within %.0f", value))
+	}
+	closeness.SetValue(20)
+
+	searchButton := widget.NewButtonWithIcon("Search", theme.SearchIcon(), func() {
+		paths, err := database.SearchFilesByColor(db, picked, closeness.Value)
+		if err == nil && strings.TrimSpace(search.Text) != "" {
+			var tagged []string
+			tagged, err = database.SearchFiles(db, search.Text)
+			inSearch := map[string]bool{}
+			for _, path := range tagged {
+				inSearch[path] = true
+			}
+			paths = slices.DeleteFunc(paths, func(path string) bool { return !inSearch[path] })
+		}
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		showResults(paths)
+	})
+	searchButton.Importance = widget.HighImportance
+	clearButton := widget.NewButton("Clear", func() {
+		search.OnSubmitted(search.Text)
+	})
+
+	note := widget.NewLabel("Colors are found as thumbnails are made")
+	note.Importance = widget.LowImportance
+
+	return container.NewVBox(
+		widget.NewLabelWithStyle("Color", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		container.NewHBox(swatch, hexLabel, layout.NewSpacer(), pickButton),
+		closenessLabel,
+		closeness,
+		note,
+		container.NewHBox(layout.NewSpacer(), clearButton, searchButton),
+	)
+}
+
 // Function to update the main content based on search results
 func updateContentWithSearchResults(content *fyne.Container, imagePaths []string, db *sql.DB, w fyne.Window, sidebar *fyne.Container, sidebarScroll *container.Scroll, split *container.Split, a fyne.App) {
 	content.RemoveAll()
+	resultsMutex.Lock()
+	currentResults = nil
+	resultsPaged = false
+	resultsMutex.Unlock()
+	addResults(imagePaths...)
+	gridMutex.Lock()
+	gridTiles, focusedTile, selectionAnchor = nil, nil, nil
+	gridMutex.Unlock()
 	imageContainer := container.NewAdaptiveGrid(4)
 	content.Add(imageContainer)
 
@@ -1,12 +1,44 @@
 package main_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"main/pkg/animation"
+	"main/pkg/archives"
+	"main/pkg/autotag"
+	"main/pkg/colorutils"
+	"main/pkg/database"
+	"main/pkg/dirtags"
 	"main/pkg/fileutils"
+	"main/pkg/fynecomponents/tagchip"
+	"main/pkg/imageconv"
+	"main/pkg/imageedit"
+	"main/pkg/imagemeta"
+	"main/pkg/jxl"
+	"main/pkg/options"
+	"main/pkg/palette"
+	"main/pkg/selection"
+	"main/pkg/shortcuts"
+	"main/pkg/tagpath"
+	"main/pkg/tagquery"
+	"main/pkg/tagsuggest"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/test"
 	"github.com/stretchr/testify/assert"
@@ -105,6 +137,412 @@ func TestFullFilepathExclusion(t *testing.T) {
 	assert.True(t, isExcludedDir("/home/amaterasu/Audio", blackList), "Full Filepath is not excluded")
 }
 
+func TestArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	testFiles := []string{filepath.Join(dir, "first.png"), filepath.Join(dir, "second.jpg")}
+	for i, file := range testFiles {
+		assert.Nil(t, os.WriteFile(file, bytes.Repeat([]byte{byte(i)}, 4096), 0644), "Failed to write test file")
+	}
+
+	createFuncs := map[string]func(string, []string, fyne.Window) error{
+		archives.FormatZstd: archives.CreateTarZstdArchive,
+		archives.FormatXz:   archives.CreateTarXzArchive,
+	}
+	for format, create := range createFuncs {
+		archivePath := filepath.Join(dir, "test"+format)
+		assert.Nil(t, create(archivePath, testFiles, window), "Failed to create archive")
+
+		extractDir := filepath.Join(dir, "extracted"+format)
+		assert.Nil(t, archives.ExtractArchive(archivePath, extractDir, false), "Failed to extract archive")
+		for _, file := range testFiles {
+			original, _ := os.ReadFile(file)
+			extracted, err := os.ReadFile(filepath.Join(extractDir, filepath.Base(file)))
+			assert.Nil(t, err, "Extracted file is missing")
+			assert.Equal(t, original, extracted, "Extracted file content differs")
+		}
+	}
+}
+
+func TestArchiveExtractPaths(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "test"+archives.FormatZip)
+	file, err := os.Create(archivePath)
+	assert.Nil(t, err, "Failed to create archive")
+	writer := zip.NewWriter(file)
+	for _, name := range []string{"a/x.png", "b/x.png", "../../evil.png", "x.png"} {
+		entry, err := writer.Create(name)
+		assert.Nil(t, err, "Failed to add entry")
+		entry.Write([]byte(name))
+	}
+	assert.Nil(t, writer.Close(), "Failed to write archive")
+	file.Close()
+
+	// entries keep their directories, nothing escapes the destination and existing files are kept
+	extractDir := filepath.Join(dir, "extracted")
+	assert.Nil(t, os.MkdirAll(filepath.Join(extractDir, "a"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(extractDir, "a", "x.png"), []byte("existing"), 0644))
+	assert.Nil(t, archives.ExtractArchive(archivePath, extractDir, false), "Failed to extract archive")
+	for path, content := range map[string]string{
+		filepath.Join("a", "x.png"):   "existing",
+		filepath.Join("a", "x-2.png"): "a/x.png",
+		filepath.Join("b", "x.png"):   "b/x.png",
+		"evil.png":                    "../../evil.png",
+		"x.png":                       "x.png",
+	} {
+		extracted, err := os.ReadFile(filepath.Join(extractDir, path))
+		assert.Nil(t, err, "Extracted file is missing")
+		assert.Equal(t, content, string(extracted), "Wrong content in "+path)
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "..", "evil.png"), "Entry escaped the destination")
+}
+
+func TestArchiveNameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 9, 21, 14, 5, 9, 0, time.UTC)
+
+	name := archives.ExpandNameTemplate("{tag}_{date}_{time}_{count}", "Places/Riga", 12, now)
+	assert.Equal(t, "Places-Riga_21-09-2024_14-05-09_12", name, "Template was not expanded correctly")
+	assert.Equal(t, "21-09-2024", archives.ExpandNameTemplate("", "", 1, now), "Empty template should fall back to date")
+
+	first := archives.NextFreePath(dir, "photos", archives.FormatZstd)
+	assert.Nil(t, os.WriteFile(first, nil, 0644), "Failed to write test archive")
+	assert.Equal(t, filepath.Join(dir, "photos-2.tar.zst"), archives.NextFreePath(dir, "photos", archives.FormatZstd), "Existing archive would be overwritten")
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "set-1.zip"), nil, 0644), "Failed to write test archive")
+	assert.Equal(t, filepath.Join(dir, "set-2.zip"), archives.NextFreePath(dir, "set-{n}", archives.FormatZip), "Counter did not skip existing archive")
+}
+
+func TestArchiveVerify(t *testing.T) {
+	dir := t.TempDir()
+	testFiles := []string{filepath.Join(dir, "first.png"), filepath.Join(dir, "second.jpg")}
+	expected := map[string]string{}
+	for _, file := range testFiles {
+		assert.Nil(t, os.WriteFile(file, []byte(file), 0644), "Failed to write test file")
+		hash, err := fileutils.GetFileMD5HashBuffered(file)
+		assert.Nil(t, err, "Failed to hash test file")
+		expected[file] = hash
+	}
+
+	archivePath := filepath.Join(dir, "test"+archives.FormatGzip)
+	assert.Nil(t, archives.CreateTarGzipArchive(archivePath, testFiles, window), "Failed to create archive")
+	assert.True(t, archives.VerifyArchive(archivePath, expected, false).OK(), "Sound archive failed verification")
+
+	// a changed source and a source that was never archived
+	expected[testFiles[0]] = "00000000000000000000000000000000"
+	expected[filepath.Join(dir, "third.png")] = "00000000000000000000000000000000"
+	report := archives.VerifyArchive(archivePath, expected, false)
+	assert.False(t, report.OK(), "Changed archive passed verification")
+	assert.Contains(t, report.Corrupt, "first.png", "Checksum mismatch was not reported")
+	assert.Equal(t, []string{"third.png"}, report.Missing, "Missing file was not reported")
+
+	// files with the same name from different directories are stored and checked apart
+	sameName := []string{filepath.Join(dir, "a", "x.png"), filepath.Join(dir, "b", "x.png")}
+	expected = map[string]string{}
+	for _, file := range sameName {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(file), 0755))
+		assert.Nil(t, os.WriteFile(file, []byte(file), 0644), "Failed to write test file")
+		hash, err := fileutils.GetFileMD5HashBuffered(file)
+		assert.Nil(t, err, "Failed to hash test file")
+		expected[file] = hash
+	}
+	assert.Equal(t, map[string]string{sameName[0]: "a/x.png", sameName[1]: "b/x.png"}, archives.EntryNames(sameName), "Wrong entry names")
+	archivePath = filepath.Join(dir, "same"+archives.FormatZip)
+	assert.Nil(t, archives.CreateZipArchive(archivePath, sameName, window), "Failed to create archive")
+	report = archives.VerifyArchive(archivePath, expected, false)
+	assert.True(t, report.OK(), "Files with the same name failed verification: "+report.String())
+	assert.Equal(t, []string{"a/x.png", "b/x.png"}, report.Verified, "Files with the same name were not verified apart")
+}
+
+func TestArchiveVolumes(t *testing.T) {
+	dir := t.TempDir()
+	testFiles := []string{filepath.Join(dir, "first.png"), filepath.Join(dir, "second.jpg")}
+	for _, file := range testFiles {
+		// random content does not compress so the archive is split into several volumes
+		content := make([]byte, 8192)
+		rand.Read(content)
+		assert.Nil(t, os.WriteFile(file, content, 0644), "Failed to write test file")
+	}
+
+	archives.VolumeSize = 4096
+	archives.ArchivePassword = "secret"
+	defer func() { archives.VolumeSize = 0 }()
+
+	createFuncs := map[string]func(string, []string, fyne.Window) error{
+		archives.FormatZip:  archives.CreateEncryptedZipArchive,
+		archives.FormatGzip: archives.CreateEncryptedTarGzipArchive,
+	}
+	for format, create := range createFuncs {
+		archivePath := filepath.Join(dir, "test"+format)
+		assert.Nil(t, create(archivePath, testFiles, window), "Failed to create archive")
+		assert.FileExists(t, archivePath+".004", "Archive was not split into volumes")
+		assert.FileExists(t, archivePath+archives.ManifestExtension, "Manifest was not written")
+
+		extractDir := filepath.Join(dir, "extracted"+format)
+		assert.Nil(t, archives.ExtractArchive(archivePath+".001", extractDir, true), "Failed to extract split archive")
+		for _, file := range testFiles {
+			original, _ := os.ReadFile(file)
+			extracted, err := os.ReadFile(filepath.Join(extractDir, filepath.Base(file)))
+			assert.Nil(t, err, "Extracted file is missing")
+			assert.Equal(t, original, extracted, "Extracted file content differs")
+		}
+
+		assert.Nil(t, os.WriteFile(archivePath+".002", []byte("damaged"), 0644), "Failed to damage volume")
+		assert.NotNil(t, archives.ExtractArchive(archivePath+".001", extractDir, true), "Damaged volume was not detected")
+
+		// a file missing half way leaves nothing that looks like a whole archive
+		failedPath := filepath.Join(dir, "failed"+format)
+		missing := append(slices.Clone(testFiles), filepath.Join(dir, "missing.png"))
+		assert.NotNil(t, create(failedPath, missing, window), "Missing file was not reported")
+		assert.NoFileExists(t, failedPath+archives.ManifestExtension, "Failed archive got a manifest")
+		assert.NoFileExists(t, failedPath+".001", "Volumes of the failed archive were left behind")
+	}
+}
+
+func TestEncryptedZipUsesAES(t *testing.T) {
+	dir := t.TempDir()
+	testFiles := []string{filepath.Join(dir, "first.png"), filepath.Join(dir, "second.jpg")}
+	for _, file := range testFiles {
+		assert.Nil(t, os.WriteFile(file, []byte(file), 0644), "Failed to write test file")
+	}
+	archivePath := filepath.Join(dir, "test"+archives.FormatZip)
+
+	archives.ArchivePassword = ""
+	assert.ErrorIs(t, archives.CreateEncryptedZipArchive(archivePath, testFiles, window), archives.ErrEmptyPassword, "Archive without a password was created")
+
+	archives.ArchivePassword = "secret"
+	assert.Nil(t, archives.CreateEncryptedZipArchive(archivePath, testFiles, window), "Failed to create archive")
+
+	// read with the standard library so the check does not trust the writer
+	reader, err := zip.OpenReader(archivePath)
+	assert.Nil(t, err, "Failed to open archive")
+	defer reader.Close()
+	for _, file := range reader.File {
+		assert.Equal(t, uint16(99), file.Method, "Entry is not WinZip AES encrypted")
+		assert.Equal(t, []byte{0x01, 0x99, 7, 0, 2, 0, 'A', 'E', 3}, file.Extra[:9], "Entry is not AES-256 AE-2")
+	}
+}
+
+func TestImageMetadataRoundTrip(t *testing.T) {
+	// an icc profile bigger than one jpeg segment has to be split and joined again
+	meta := &imagemeta.Metadata{Exif: []byte("II*\x00\x08\x00\x00\x00\x00\x00"), ICC: bytes.Repeat([]byte("icc"), 30000)}
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+
+	var jpegData, pngData bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&jpegData, img, nil), "Failed to encode jpeg")
+	assert.Nil(t, png.Encode(&pngData, img), "Failed to encode png")
+
+	for name, write := range map[string]func([]byte, *imagemeta.Metadata) ([]byte, error){"jpeg": imagemeta.WriteJPEG, "png": imagemeta.WritePNG} {
+		source := jpegData.Bytes()
+		if name == "png" {
+			source = pngData.Bytes()
+		}
+
+		withMeta, err := write(source, meta)
+		assert.Nil(t, err, "Failed to write %s metadata", name)
+		_, _, err = image.Decode(bytes.NewReader(withMeta))
+		assert.Nil(t, err, "Image with %s metadata no longer decodes", name)
+
+		parsed, err := imagemeta.Parse(withMeta)
+		assert.Nil(t, err, "Failed to parse %s metadata", name)
+		assert.Equal(t, meta, parsed, "The %s metadata changed", name)
+	}
+}
+
+func TestAnimationRoundTrip(t *testing.T) {
+	anim := &animation.Animation{LoopCount: 3}
+	for i, c := range []color.NRGBA{{255, 0, 0, 255}, {0, 0, 255, 255}, {0, 0, 0, 0}} {
+		frame := image.NewNRGBA(image.Rect(0, 0, 6, 4))
+		for p := 0; p < len(frame.Pix); p += 4 {
+			frame.Pix[p], frame.Pix[p+1], frame.Pix[p+2], frame.Pix[p+3] = c.R, c.G, c.B, c.A
+		}
+		anim.Frames = append(anim.Frames, animation.Frame{Image: frame, Delay: time.Duration(i+1) * 50 * time.Millisecond})
+	}
+
+	var apngData, gifData bytes.Buffer
+	assert.Nil(t, animation.EncodeAPNG(&apngData, anim), "Failed to encode apng")
+	assert.Nil(t, animation.EncodeGIF(&gifData, anim, true), "Failed to encode gif")
+
+	decodedAPNG, err := animation.DecodeAPNG(apngData.Bytes())
+	assert.Nil(t, err, "Failed to decode apng")
+	decodedGIF, err := animation.DecodeGIF(&gifData)
+	assert.Nil(t, err, "Failed to decode gif")
+
+	for name, decoded := range map[string]*animation.Animation{"apng": decodedAPNG, "gif": decodedGIF} {
+		assert.Equal(t, anim.LoopCount, decoded.LoopCount, "The %s loop count changed", name)
+		assert.Equal(t, len(anim.Frames), len(decoded.Frames), "The %s frame count changed", name)
+		for i := range decoded.Frames {
+			assert.Equal(t, anim.Frames[i].Delay, decoded.Frames[i].Delay, "The %s delay of frame %d changed", name, i+1)
+			assert.Equal(t, anim.Frames[i].Image.NRGBAAt(2, 2), decoded.Frames[i].Image.NRGBAAt(2, 2), "The %s pixels of frame %d changed", name, i+1)
+		}
+	}
+}
+
+func TestJXLRoundTrip(t *testing.T) {
+	// the larger images are split into several groups, each coded on its own
+	translucent := image.NewNRGBA(image.Rect(0, 0, 300, 270))
+	deep := image.NewNRGBA64(image.Rect(0, 0, 40, 30))
+	grey := image.NewGray(image.Rect(0, 0, 600, 20))
+	for i := range translucent.Pix {
+		translucent.Pix[i] = uint8(i*7 + i/1200)
+	}
+	for i := range deep.Pix {
+		deep.Pix[i] = uint8(i * 13)
+	}
+	for i := range grey.Pix {
+		grey.Pix[i] = uint8(i / 3)
+	}
+
+	for name, img := range map[string]image.Image{"translucent": translucent, "16 bit": deep, "grey": grey} {
+		var buf bytes.Buffer
+		assert.Nil(t, jxl.Encode(&buf, img), "Failed to encode %s jxl", name)
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err, "Failed to read the %s jxl header", name)
+		assert.Equal(t, "jxl", format)
+		assert.Equal(t, img.Bounds().Dx(), config.Width, "The %s width changed", name)
+		assert.Equal(t, img.ColorModel(), config.ColorModel, "The %s color model changed", name)
+
+		decoded, err := jxl.Decode(&buf)
+		assert.Nil(t, err, "Failed to decode %s jxl", name)
+		assert.Equal(t, img.Bounds(), decoded.Bounds(), "The %s size changed", name)
+		for y := 0; y < img.Bounds().Dy(); y++ {
+			for x := 0; x < img.Bounds().Dx(); x++ {
+				if img.At(x, y) != decoded.At(x, y) {
+					t.Fatalf("The %s pixel at %d,%d changed from %v to %v", name, x, y, img.At(x, y), decoded.At(x, y))
+				}
+			}
+		}
+	}
+
+	_, err := jxl.Decode(bytes.NewReader([]byte("\xff\x0a")))
+	assert.Error(t, err, "A truncated jxl decoded")
+}
+
+func TestImageEditOrientation(t *testing.T) {
+	// little endian exif with a single orientation entry set to 6, rotate right to display
+	exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00")
+	meta := &imagemeta.Metadata{Exif: exif}
+	assert.Equal(t, 6, meta.Orientation(), "Wrong exif orientation")
+	meta.ResetOrientation()
+	assert.Equal(t, imagemeta.OrientationNormal, meta.Orientation(), "Orientation was not reset")
+	assert.Equal(t, byte(6), exif[18], "Resetting the orientation changed the source exif")
+
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	upright := imageedit.Orient(img, 6)
+	assert.Equal(t, image.Rect(0, 0, 2, 3), upright.Bounds(), "Rotating did not swap the sides")
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, upright.At(1, 0), "The top left pixel did not move to the top right")
+
+	edits := []imageedit.Edit{{Op: imageedit.RotateLeft}, {Op: imageedit.FlipHorizontal}, {Op: imageedit.Crop, X: 0.5, Y: 0, Width: 0.5, Height: 1}}
+	saved, err := imageedit.Marshal(edits)
+	assert.Nil(t, err, "Failed to save the edit stack")
+	parsed, err := imageedit.Parse(saved)
+	assert.Nil(t, err, "Failed to parse the edit stack")
+	assert.Equal(t, edits, parsed, "The edit stack changed")
+	assert.Equal(t, image.Rect(0, 0, 1, 3), imageedit.Apply(img, parsed).Bounds(), "Wrong size after the edits")
+
+	_, err = imageedit.Marshal([]imageedit.Edit{{Op: imageedit.Crop, X: 0.5, Width: 0.8, Height: 1}})
+	assert.NotNil(t, err, "A crop outside of the image was saved")
+}
+
+func TestShortcutParsing(t *testing.T) {
+	key, modifier, err := shortcuts.Parse("ctrl+shift+f")
+	assert.Nil(t, err, "Failed to parse shortcut")
+	assert.Equal(t, fyne.KeyF, key, "Wrong shortcut key")
+	assert.Equal(t, fyne.KeyModifierControl|fyne.KeyModifierShift, modifier, "Wrong shortcut modifiers")
+	assert.Equal(t, "Ctrl+Shift+F", shortcuts.Format(key, modifier), "Shortcut is not written the way it is read")
+
+	key, modifier, err = shortcuts.Parse("Ctrl++")
+	assert.Nil(t, err, "Failed to parse the plus key")
+	assert.Equal(t, fyne.KeyPlus, key, "Wrong key for Ctrl++")
+	assert.Equal(t, fyne.KeyModifierControl, modifier, "Wrong modifier for Ctrl++")
+
+	for _, binding := range []string{"", "Hyper+F", "Ctrl+Banana"} {
+		_, _, err := shortcuts.Parse(binding)
+		assert.NotNil(t, err, "Invalid shortcut %q was accepted", binding)
+	}
+	for action, binding := range options.DefaultShortcuts() {
+		_, _, err := shortcuts.Parse(binding)
+		assert.Nil(t, err, "Default shortcut of %s does not parse", action)
+	}
+}
+
+func TestSelectionModel(t *testing.T) {
+	selected := selection.New()
+	changes, count := 0, 0
+	selected.OnChanged(func(n int) { changes, count = changes+1, n })
+
+	results := []string{"c.png", "a.png", "b.png"}
+	assert.True(t, selected.Toggle("a.png"), "Toggled file is not selected")
+	selected.SetAll(results, true)
+	assert.Equal(t, []string{"a.png", "b.png", "c.png"}, selected.Paths(), "Select all missed files")
+	assert.Equal(t, 3, count, "Listener got the wrong count")
+
+	selected.Set("d.png", true)
+	selected.Invert(results)
+	assert.Equal(t, []string{"d.png"}, selected.Paths(), "Invert changed files outside of the results")
+
+	changes = 0
+	selected.SetAll([]string{"d.png"}, true)
+	assert.Equal(t, 0, changes, "Listener ran without a change")
+
+	selected.Clear()
+	assert.Equal(t, 0, selected.Len(), "Selection is not empty after clearing")
+	assert.False(t, selected.Contains("d.png"), "Cleared file is still selected")
+}
+
+func TestTagPath(t *testing.T) {
+	assert.Equal(t, "Places/Latvia/Riga", tagpath.Clean(" Places / Latvia//Riga/ "), "Path is not cleaned")
+	assert.Equal(t, "Places/Latvia", tagpath.Parent("Places/Latvia/Riga"), "Wrong parent")
+	assert.Equal(t, "", tagpath.Parent("Places"), "Top level tag has a parent")
+	assert.Equal(t, "Riga", tagpath.Leaf("Places/Latvia/Riga"), "Wrong leaf")
+	assert.Equal(t, 2, tagpath.Depth("Places/Latvia/Riga"), "Wrong depth")
+	assert.Equal(t, []string{"Places", "Places/Latvia"}, tagpath.Ancestors("Places/Latvia/Riga"), "Wrong ancestors")
+	assert.True(t, tagpath.IsDescendant("Places/Latvia", "Places"), "Child is not a descendant")
+	assert.False(t, tagpath.IsDescendant("Places2", "Places"), "Sibling with the same prefix is a descendant")
+
+	paths := []string{"Places/Latvia2", "Places/Latvia Riga", "People", "Places/Latvia/Riga", "Places", "Places/Latvia"}
+	slices.SortFunc(paths, tagpath.Compare)
+	assert.Equal(t, []string{"People", "Places", "Places/Latvia", "Places/Latvia/Riga", "Places/Latvia Riga", "Places/Latvia2"}, paths, "Tags are not sorted as a tree")
+}
+
+func TestTagNamespaceSearch(t *testing.T) {
+	for name, want := range map[string][2]string{
+		"artist:someone":     {"artist", "someone"},
+		"camera:x100v":       {"camera", "x100v"},
+		"Places/12:30":       {"", "Places/12:30"},
+		"place:Latvia/Riga":  {"place", "Latvia/Riga"},
+		"a:":                 {"", "a:"},
+		":value":             {"", ":value"},
+		"converted-from:png": {"converted-from", "png"},
+	} {
+		namespace, value := tagpath.SplitNamespace(name)
+		assert.Equal(t, want, [2]string{namespace, value}, "Wrong namespace split of %s", name)
+	}
+
+	terms := tagquery.Parse(`artist:*  -project:old "new york" 100% -"Cat"`)
+	assert.Equal(t, []tagquery.Term{
+		{Pattern: "artist:%"},
+		{Pattern: "project:old", Exclude: true},
+		{Pattern: "new york"},
+		{Pattern: `%100\%%`},
+		{Pattern: "Cat", Exclude: true},
+	}, terms, "Search is not parsed")
+	assert.Empty(t, tagquery.Parse("   "), "Blank search has terms")
+
+	// a quoted tag matches that tag and its descendants, not every tag containing it
+	db := openTestDatabase(t)
+	addTestFile(t, db, "/a.png", "Cat")
+	addTestFile(t, db, "/b.png", "Cat/Black")
+	addTestFile(t, db, "/c.png", "Wildcat")
+	paths, err := database.SearchFiles(db, `"Cat"`)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/a.png", "/b.png"}, paths, "Quoted tag did not match exactly")
+	paths, err = database.SearchFiles(db, "cat")
+	assert.NoError(t, err)
+	assert.Len(t, paths, 3, "Bare word did not match every tag containing it")
+}
+
 func isExcludedDir(dir string, blackList map[string]int) bool {
 	// checks if the directory is blacklisted
 	for key := range blackList {
@@ -118,3 +556,675 @@ func isExcludedDir(dir string, blackList map[string]int) bool {
 	// Claude solution
 	return strings.HasPrefix(filepath.Base(dir), ".")
 }
+
+func TestTagSuggestions(t *testing.T) {
+	_, ok := tagsuggest.Score("pl/ri", "Places/Latvia/Riga")
+	assert.True(t, ok, "Letters in order do not match")
+	_, ok = tagsuggest.Score("rp", "Places/Latvia/Riga")
+	assert.False(t, ok, "Letters out of order match")
+	start, _ := tagsuggest.Score("sea", "sea")
+	middle, _ := tagsuggest.Score("sea", "research")
+	assert.Greater(t, start, middle, "Exact match does not score higher")
+
+	now := time.Now()
+	candidates := []tagsuggest.Candidate{
+		{Id: 1, Name: "summer", Uses: 1},
+		{Id: 2, Name: "sunset", Uses: 40},
+		{Id: 3, Name: "sunrise", Uses: 1, LastUsed: now.Add(-time.Hour)},
+		{Id: 4, Name: "beach", Uses: 100},
+	}
+	ranked := tagsuggest.Rank("su", candidates, now, 2)
+	assert.Equal(t, []string{"sunset", "sunrise"}, []string{ranked[0].Name, ranked[1].Name}, "Used tags are not ranked first")
+	assert.Equal(t, "beach", tagsuggest.Rank("", candidates, now, 0)[0].Name, "Empty query does not list the most used tag first")
+	assert.Equal(t, colorutils.AutoColor("sea"), colorutils.AutoColor("sea"), "Auto color is not stable")
+}
+
+func TestRelatedTags(t *testing.T) {
+	const beach, sea, jpg, summer, sand = 1, 2, 3, 4, 5
+	tagFiles := map[int]int{beach: 4, sea: 5, jpg: 10, summer: 3, sand: 2}
+	pairs := []tagsuggest.Pair{
+		{TagId: beach, OtherId: sea, Files: 3},
+		{TagId: beach, OtherId: jpg, Files: 4},
+		{TagId: beach, OtherId: summer, Files: 1},
+		{TagId: beach, OtherId: sand, Files: 2},
+		{TagId: sea, OtherId: beach, Files: 3},
+	}
+	related := tagsuggest.RankRelated(pairs, tagFiles, 10, []int{beach}, 0)
+	ids := []int{}
+	for _, r := range related {
+		ids = append(ids, r.Id)
+	}
+	assert.Equal(t, []int{sea, sand}, ids, "Wrong related tags")
+	assert.InDelta(t, 0.75, related[0].Confidence, 0.001, "Wrong confidence")
+	assert.InDelta(t, 1.5, related[0].Lift, 0.001, "Wrong lift")
+	assert.Empty(t, tagsuggest.RankRelated(pairs, tagFiles, 10, []int{beach, sea, sand}, 0), "Present tags are suggested")
+}
+
+func TestAutoTagRules(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Holidays", "2023")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	path := filepath.Join(dir, "IMG_0001.png")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(file, image.NewRGBA(image.Rect(0, 0, 300, 200))))
+	file.Close()
+
+	matches := func(conditions ...autotag.Condition) bool {
+		rule := autotag.Rule{Conditions: conditions, Add: []string{"holiday"}}
+		assert.NoError(t, rule.Validate(), "Rule is not valid")
+		ok, err := rule.Matches(autotag.NewFile(path))
+		assert.NoError(t, err)
+		return ok
+	}
+	assert.True(t, matches(autotag.Condition{Field: autotag.FieldPath, Value: "**/holidays/**"}), "Path glob does not match")
+	assert.True(t, matches(autotag.Condition{Field: autotag.FieldFilename, Value: "img_*.PNG"}), "Filename glob does not match")
+	assert.False(t, matches(autotag.Condition{Field: autotag.FieldFilename, Value: "*/IMG_*.png"}), "Filename glob matches the directory")
+	assert.True(t, matches(autotag.Condition{Field: autotag.FieldPathRegex, Value: `/20\d\d/`}), "Path regex does not match")
+	assert.True(t, matches(
+		autotag.Condition{Field: autotag.FieldWidth, Min: "250"},
+		autotag.Condition{Field: autotag.FieldOrientation, Value: "landscape"},
+		autotag.Condition{Field: autotag.FieldSize, Max: "1MB"},
+	), "Dimensions and size do not match")
+	assert.False(t, matches(autotag.Condition{Field: autotag.FieldHeight, Min: "201"}), "Too short image matches")
+	assert.False(t, matches(autotag.Condition{Field: autotag.FieldCamera, Value: "Canon"}), "File without EXIF matches a camera")
+
+	assert.Error(t, autotag.Rule{Conditions: []autotag.Condition{{Field: autotag.FieldPathRegex, Value: "("}}, Add: []string{"a"}}.Validate(), "Bad regex is valid")
+	assert.Error(t, autotag.Rule{Conditions: []autotag.Condition{{Field: autotag.FieldDate, Min: "yesterday"}}, Add: []string{"a"}}.Validate(), "Bad date is valid")
+	assert.Error(t, autotag.Rule{Conditions: []autotag.Condition{{Field: autotag.FieldPath, Value: "*"}}}.Validate(), "Rule without tags is valid")
+
+	size, err := autotag.ParseSize("2.5MB")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2.5*1024*1024), size, "Wrong size")
+
+	rule := autotag.Rule{Conditions: []autotag.Condition{{Field: autotag.FieldSize, Min: "1KB"}}, Add: []string{"big"}, Remove: []string{"small"}}
+	data, err := autotag.Marshal(rule)
+	assert.NoError(t, err)
+	parsed, err := autotag.Parse(data)
+	assert.NoError(t, err)
+	assert.Equal(t, rule, parsed, "Rule does not survive a round trip")
+}
+
+func TestDirectoryTags(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator)+"home", "user")
+	path := filepath.Join(root, "Photos", "2023", ".hidden", "japan", "Kyoto old town", "IMG_0001.jpg")
+	settings := options.DefaultDirectoryTagOptions()
+
+	assert.Equal(t, []string{"2023", "japan", "Kyoto old town"}, dirtags.Names(root, path, settings), "Wrong directory tags")
+	settings.MaxDepth = 2
+	settings.Case = options.CaseTitle
+	assert.Equal(t, []string{"Japan", "Kyoto Old Town"}, dirtags.Names(root, path, settings), "Depth or case not applied")
+	settings.Nested = true
+	settings.Parent = "Places"
+	assert.Equal(t, []string{"Places/Japan/Kyoto Old Town"}, dirtags.Names(root, path, settings), "Directories are not nested")
+	assert.Empty(t, dirtags.Names(root, filepath.Join(root, "IMG_0002.jpg"), settings), "File in the root gets tags")
+	assert.Empty(t, dirtags.Names(root, filepath.Join(string(filepath.Separator)+"tmp", "a", "b.jpg"), settings), "File outside the root gets tags")
+
+	// only the tags made by the run are flagged, tags that existed before are kept when the run is taken back
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	db := openTestDatabase(t)
+	addTestFile(t, db, filepath.Join(home, "Japan", "Kyoto", "IMG_0001.jpg"), "Places/Japan")
+	addTestFile(t, db, filepath.Join(home, "Japan", "Osaka", "IMG_0002.jpg"), "Places")
+	added, err := database.ApplyDirectoryTags(context.Background(), db, settings)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added, "Wrong number of tags added")
+	flagged := func() []string {
+		rows, err := db.Query("SELECT name FROM Tag WHERE fromDirectory ORDER BY name")
+		assert.NoError(t, err)
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			assert.NoError(t, rows.Scan(&name))
+			names = append(names, name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"Places/Japan/Kyoto", "Places/Japan/Osaka"}, flagged(), "Existing tags were flagged")
+	removed, err := database.RemoveDirectoryTags(db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+	assert.Equal(t, []string{"Places", "Places/Japan"}, tagNames(t, db), "Existing tags were deleted")
+}
+
+func TestColorPalette(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			switch {
+			case x < 150:
+				img.Set(x, y, color.RGBA{R: 200, G: 30, B: 30, A: 255})
+			default:
+				img.Set(x, y, color.RGBA{R: 30, G: 60, B: 200, A: 255})
+			}
+		}
+	}
+
+	swatches := palette.Extract(img, palette.Size)
+	if assert.Len(t, swatches, 2, "Wrong number of dominant colors") {
+		assert.Equal(t, "#C81E1E", swatches[0].Color, "Largest color is not first")
+		assert.InDelta(t, 0.75, swatches[0].Weight, 0.02, "Wrong share of the image")
+		assert.Equal(t, "#1E3CC8", swatches[1].Color, "Wrong second color")
+	}
+	assert.Equal(t, palette.Extract(img, palette.Size), swatches, "Palette is not deterministic")
+
+	assert.Zero(t, colorutils.DeltaE("#123456", "#123456"), "Same colors have a distance")
+	assert.InDelta(t, 100, colorutils.DeltaE("#000000", "#FFFFFF"), 0.5, "Black to white is not 100")
+
+	_, ok := palette.Match(swatches, "#D02828", 20)
+	assert.True(t, ok, "Close red does not match")
+	_, ok = palette.Match(swatches, "#20C040", 20)
+	assert.False(t, ok, "Green matches a red and blue image")
+	red, _ := palette.Match(swatches, "#C81E1E", 20)
+	blue, _ := palette.Match(swatches, "#1E3CC8", 20)
+	assert.Less(t, red, blue, "Larger color does not match closer")
+
+	assert.Equal(t, "#C81E1E", palette.TagColor(swatches), "Wrong tag color")
+	assert.Empty(t, palette.TagColor([]palette.Swatch{{Color: "#808080", Weight: 1}}), "Gray picked as a tag color")
+
+	// edits change the colors, so saving them clears the palette until it is found again
+	db := openTestDatabase(t)
+	addTestFile(t, db, "/a.png")
+	assert.NoError(t, database.SavePalette(db, "/a.png", swatches))
+	assert.True(t, database.HasPalette(db, "/a.png"), "Palette was not saved")
+	assert.NoError(t, database.SetEdits(db, "/a.png", []imageedit.Edit{{Op: imageedit.RotateLeft}}))
+	assert.False(t, database.HasPalette(db, "/a.png"), "Editing kept the old palette")
+}
+
+func TestTagChip(t *testing.T) {
+	assert.Equal(t, color.Black, colorutils.TextColor(color.RGBA{R: 255, G: 230, B: 0, A: 255}), "Yellow tags get light text")
+	assert.Equal(t, color.White, colorutils.TextColor(color.RGBA{R: 20, G: 30, B: 120, A: 255}), "Dark tags get dark text")
+	assert.InDelta(t, 21, colorutils.ContrastRatio(color.Black, color.White), 0.01, "Wrong contrast of black on white")
+
+	test.NewApp()
+	defer test.NewApp()
+
+	tapped, removed := 0, 0
+	chip := tagchip.NewRemovable("Places › Latvia › Riga › Old Town › Cathedral", color.RGBA{R: 255, G: 230, B: 0, A: 255},
+		func() { tapped++ }, func() { removed++ })
+	w := test.NewWindow(container.NewWithoutLayout(chip))
+	defer w.Close()
+
+	text := func() *canvas.Text {
+		for _, object := range test.WidgetRenderer(chip).Objects() {
+			if text, ok := object.(*canvas.Text); ok {
+				return text
+			}
+		}
+		return nil
+	}
+	chip.Resize(chip.MinSize())
+	assert.Equal(t, color.Black, text().Color, "Text is not readable on yellow")
+	assert.Len(t, []rune(text().Text), tagchip.DefaultMaxLength, "Long name is not limited")
+	assert.True(t, strings.HasSuffix(text().Text, "…"), "Limited name has no ellipsis")
+
+	chip.Resize(fyne.NewSize(120, chip.MinSize().Height))
+	assert.Less(t, len([]rune(text().Text)), tagchip.DefaultMaxLength, "Name is not shortened to fit")
+
+	test.Tap(chip)
+	assert.Equal(t, 1, tapped, "Tapping the chip does nothing")
+	assert.Zero(t, removed, "Tapping the chip removes it")
+}
+
+func TestConvertImages(t *testing.T) {
+	writePNG := func(path string) {
+		file, err := os.Create(path)
+		assert.NoError(t, err)
+		assert.NoError(t, png.Encode(file, image.NewRGBA(image.Rect(0, 0, 16, 16))))
+		file.Close()
+	}
+
+	cases := []struct {
+		name     string
+		sources  []string // made in a source directory, a/ and b/ are subdirectories
+		existing []string // already in the output directory
+		cancel   bool
+		outputs  []string // names expected in the report, empty for cancelled files
+	}{
+		{name: "plain", sources: []string{"a.png"}, outputs: []string{"a.jpg"}},
+		{name: "same name in the batch", sources: []string{"a/x.png", "b/x.png"}, outputs: []string{"x.jpg", "x-2.jpg"}},
+		{name: "existing file", sources: []string{"x.png"}, existing: []string{"x.jpg", "x-2.jpg"}, outputs: []string{"x-3.jpg"}},
+		{name: "batch and disk", sources: []string{"a/x.png", "b/x.png"}, existing: []string{"x.jpg"}, outputs: []string{"x-2.jpg", "x-3.jpg"}},
+		{name: "cancelled", sources: []string{"a.png", "b.png"}, cancel: true, outputs: []string{"", ""}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sourceDir, outputDir := t.TempDir(), t.TempDir()
+			var sources []string
+			for _, source := range c.sources {
+				path := filepath.Join(sourceDir, source)
+				assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				writePNG(path)
+				sources = append(sources, path)
+			}
+			for _, name := range c.existing {
+				assert.NoError(t, os.WriteFile(filepath.Join(outputDir, name), []byte("keep"), 0644))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if c.cancel {
+				cancel()
+			}
+			defer cancel()
+			report := imageconv.ConvertImages(ctx, sources, "JPG", outputDir, options.DefaultConvertOptions(), 2, nil)
+
+			for i, result := range report.Results {
+				if c.outputs[i] == "" {
+					assert.True(t, imageconv.IsCancelled(result.Err), "File was not cancelled")
+					assert.Empty(t, result.Output, "Cancelled file has an output")
+					continue
+				}
+				assert.NoError(t, result.Err)
+				assert.Equal(t, filepath.Join(outputDir, c.outputs[i]), result.Output, "Wrong output path")
+				assert.FileExists(t, result.Output)
+			}
+			for _, name := range c.existing {
+				data, _ := os.ReadFile(filepath.Join(outputDir, name))
+				assert.Equal(t, "keep", string(data), "Existing file was overwritten")
+			}
+			if c.cancel {
+				entries, _ := os.ReadDir(outputDir)
+				assert.Empty(t, entries, "Cancelled batch wrote files")
+			}
+		})
+	}
+
+	// converting to the same format in the same directory keeps the source
+	dir := t.TempDir()
+	source := filepath.Join(dir, "same.png")
+	writePNG(source)
+	original, _ := os.ReadFile(source)
+	report := imageconv.ConvertImages(context.Background(), []string{source}, "PNG", dir, options.DefaultConvertOptions(), 1, nil)
+	assert.NoError(t, report.Results[0].Err)
+	assert.Equal(t, filepath.Join(dir, "same-2.png"), report.Results[0].Output, "Source would be overwritten")
+	unchanged, _ := os.ReadFile(source)
+	assert.Equal(t, original, unchanged, "Source was changed")
+}
+
+// Opens an empty in-memory database, every test gets its own
+func openTestDatabase(t *testing.T) *sql.DB {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := database.Open("file:" + name + "?mode=memory&cache=shared&_busy_timeout=10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Adds a file row without hashing the file, the path doubles as the md5
+func addTestFile(t *testing.T, db *sql.DB, path string, tags ...string) int {
+	result, err := db.Exec("INSERT INTO File (path, md5, dateAdded) VALUES (?, ?, DATETIME('now', '-1 day'))", path, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileId, _ := result.LastInsertId()
+	for _, tag := range tags {
+		tagId, err := database.EnsureTag(db, tag, "#373c40")
+		assert.NoError(t, err)
+		assert.NoError(t, database.AddTagToFile(db, int(fileId), tagId))
+	}
+	return int(fileId)
+}
+
+// Returns the tag names of the file sorted, a tag on the file twice is listed twice
+func fileTagNames(t *testing.T, db *sql.DB, path string) []string {
+	rows, err := db.Query("SELECT Tag.name FROM FileTag JOIN Tag ON Tag.id = FileTag.tagId JOIN File ON File.id = FileTag.fileId WHERE File.path = ? ORDER BY Tag.name", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		assert.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestConvertedFileVersions(t *testing.T) {
+	db := openTestDatabase(t)
+	dir := t.TempDir()
+	source, output, again := filepath.Join(dir, "a.png"), filepath.Join(dir, "a.jpg"), filepath.Join(dir, "a.webp")
+	for i, path := range []string{source, output, again} {
+		assert.NoError(t, os.WriteFile(path, []byte{byte(i)}, 0644))
+	}
+	addTestFile(t, db, source, "PNG", "Places/Riga")
+
+	assert.NoError(t, database.AddConvertedFile(db, source, output, true))
+	assert.Equal(t, []string{"JPG", "Places/Riga", "converted-from:png"}, fileTagNames(t, db, output), "Tags were not copied without the type")
+	assert.Equal(t, []string{"PNG", "Places/Riga"}, fileTagNames(t, db, source), "Source tags changed")
+
+	// converting again updates the same file instead of adding it twice
+	assert.NoError(t, database.AddConvertedFile(db, source, output, true))
+	assert.Equal(t, []string{"JPG", "Places/Riga", "converted-from:png"}, fileTagNames(t, db, output), "Tags were added twice")
+	var files int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM File").Scan(&files))
+	assert.Equal(t, 2, files, "Converted file was added twice")
+
+	// the chain is followed both ways, without tagSource no converted-from tag is added
+	assert.NoError(t, database.AddConvertedFile(db, output, again, false))
+	assert.NotContains(t, fileTagNames(t, db, again), "converted-from:jpg", "Tagged the source without tagSource")
+	assert.Contains(t, fileTagNames(t, db, again), "Places/Riga", "Tags were not copied along the chain")
+	versions, err := database.GetFileVersions(db, source)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{output, again}, versions, "Wrong versions of the source")
+	versions, err = database.GetFileVersions(db, again)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{source, output}, versions, "Wrong versions of the last conversion")
+
+	// an output with the same bytes as a known file links that file instead of failing on its md5
+	copied, same := filepath.Join(dir, "copy.jpg"), filepath.Join(dir, "same.png")
+	assert.NoError(t, os.WriteFile(copied, []byte{1}, 0644))
+	assert.NoError(t, os.WriteFile(same, []byte{0}, 0644))
+	hash, err := fileutils.GetFileMD5HashBuffered(source)
+	assert.NoError(t, err)
+	_, err = db.Exec("UPDATE File SET md5 = ? WHERE path = ?", hash, source)
+	assert.NoError(t, err)
+	assert.NoError(t, database.AddConvertedFile(db, again, copied, false))
+	assert.NoError(t, database.AddConvertedFile(db, source, same, true))
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM File").Scan(&files))
+	assert.Equal(t, 3, files, "Identical output was added twice")
+	assert.Equal(t, []string{"PNG", "Places/Riga"}, fileTagNames(t, db, source), "Output identical to the source tagged it")
+	versions, err = database.GetFileVersions(db, source)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{output, again}, versions, "Identical output changed the versions")
+
+	// a source that is not in the database links nothing
+	other := filepath.Join(dir, "b.webp")
+	assert.NoError(t, os.WriteFile(other, []byte("other"), 0644))
+	assert.NoError(t, database.AddConvertedFile(db, filepath.Join(dir, "missing.png"), other, false))
+	assert.Equal(t, []string{"WEBP"}, fileTagNames(t, db, other), "Unknown source added tags")
+	versions, err = database.GetFileVersions(db, other)
+	assert.NoError(t, err)
+	assert.Empty(t, versions, "Unknown source was linked")
+}
+
+func TestLibraryPaths(t *testing.T) {
+	db := openTestDatabase(t)
+	for i, path := range []string{"/a.png", "/b.png", "/c.png"} {
+		_, err := db.Exec("INSERT INTO File (path, md5, dateAdded) VALUES (?, ?, DATETIME('now', ?))", path, path, fmt.Sprintf("-%d days", 3-i))
+		assert.NoError(t, err)
+	}
+
+	// select all reads the whole library, not just the first page
+	firstPage, err := database.GetImagesFromDatabase(db, 0, 2)
+	assert.NoError(t, err)
+	paths, err := database.GetImagePaths(db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/c.png", "/b.png", "/a.png"}, paths, "Library is not listed newest first")
+	assert.Equal(t, firstPage, paths[:2], "Pages are listed in another order")
+}
+
+func TestBulkTagUndo(t *testing.T) {
+	db := openTestDatabase(t)
+	first := addTestFile(t, db, "/a.png", "Red", "Blue")
+	addTestFile(t, db, "/b.png", "Blue")
+	addTestFile(t, db, "/c.png", "Green")
+	paths := []string{"/a.png", "/b.png", "/c.png", "/missing.png"}
+	before := map[string][]string{}
+	for _, path := range paths[:3] {
+		before[path] = fileTagNames(t, db, path)
+	}
+
+	red, err := database.EnsureTag(db, "Red", "#373c40")
+	assert.NoError(t, err)
+	green, err := database.EnsureTag(db, "Green", "#373c40")
+	assert.NoError(t, err)
+	blue, err := database.EnsureTag(db, "Blue", "#373c40")
+	assert.NoError(t, err)
+	color, err := database.EnsureTag(db, "Color", "#373c40")
+	assert.NoError(t, err)
+	assert.NoError(t, database.AddImplication(db, green, color))
+
+	changes, err := database.ApplyTagChanges(db, paths, []int{red, green}, []int{blue})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Color", "Green", "Red"}, fileTagNames(t, db, "/a.png"), "Tags were not applied")
+	assert.Equal(t, []string{"Color", "Green", "Red"}, fileTagNames(t, db, "/b.png"), "Tags were not applied")
+	assert.Equal(t, []string{"Color", "Green", "Red"}, fileTagNames(t, db, "/c.png"), "Tags were added twice")
+	// tags a file already had are not changes, undoing them would take them away
+	assert.NotContains(t, changes, database.TagChange{FileId: first, TagId: red, Added: true}, "Existing tag was recorded as added")
+	assert.Len(t, changes, 9, "Wrong number of changes")
+
+	assert.NoError(t, database.UndoTagChanges(db, changes))
+	for path, tags := range before {
+		assert.Equal(t, tags, fileTagNames(t, db, path), "Undo did not restore "+path)
+	}
+}
+
+// Returns the names of every tag sorted
+func tagNames(t *testing.T, db *sql.DB) []string {
+	rows, err := db.Query("SELECT name FROM Tag ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		assert.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestTagManager(t *testing.T) {
+	db := openTestDatabase(t)
+	addTestFile(t, db, "/a.png", "Places/Riga", "Places/Riga/Old Town", "Cat")
+	addTestFile(t, db, "/b.png", "Kitty", "Cat")
+	addTestFile(t, db, "/c.png", "Kitty", "Places")
+	resolve := func(name string) int {
+		id, err := database.ResolveTag(db, name)
+		assert.NoError(t, err)
+		return id
+	}
+
+	// renaming a parent moves its children along, the files keep them
+	places := resolve("Places")
+	assert.NoError(t, database.UpdateTag(db, places, "World/Places", "#ff0000"))
+	assert.Equal(t, []string{"Cat", "Kitty", "World", "World/Places", "World/Places/Riga", "World/Places/Riga/Old Town"}, tagNames(t, db))
+	assert.Equal(t, []string{"Cat", "World/Places/Riga", "World/Places/Riga/Old Town"}, fileTagNames(t, db, "/a.png"), "Children were not renamed on the file")
+	assert.Equal(t, places, resolve("World/Places"), "Renamed tag got a new id")
+	var parentId int
+	assert.NoError(t, db.QueryRow("SELECT parentId FROM Tag WHERE id = ?", resolve("World/Places/Riga")).Scan(&parentId))
+	assert.Equal(t, places, parentId, "Child lost its parent")
+	assert.Error(t, database.UpdateTag(db, places, "World/Places/Riga/Places", "#ff0000"), "Tag was moved under itself")
+	assert.ErrorIs(t, database.UpdateTag(db, resolve("Cat"), "Kitty", "#ff0000"), database.ErrTagExists)
+
+	// merging onto a file that already has the target keeps one row, the rules follow the merged tag
+	cat, kitty := resolve("Cat"), resolve("Kitty")
+	animal, err := database.EnsureTag(db, "Animal", "#373c40")
+	assert.NoError(t, err)
+	assert.NoError(t, database.AddAlias(db, "Kitten", kitty))
+	assert.NoError(t, database.AddImplication(db, kitty, animal))
+	assert.NoError(t, database.AddImplication(db, cat, animal))
+	assert.NoError(t, database.MergeTags(db, cat, []int{kitty}))
+	assert.Equal(t, []string{"Cat"}, fileTagNames(t, db, "/b.png"), "File has the target twice")
+	assert.Equal(t, []string{"Cat", "World/Places"}, fileTagNames(t, db, "/c.png"), "Files were not moved")
+	assert.Equal(t, cat, resolve("Kitten"), "Alias was not moved to the target")
+	implications, err := database.GetImplications(db)
+	assert.NoError(t, err)
+	assert.Equal(t, []database.TagImplication{{TagId: cat, TagName: "Cat", ImpliedId: animal, ImpliedName: "Animal"}}, implications, "Implications were not merged")
+	assert.NotContains(t, tagNames(t, db), "Kitty", "Merged tag was not deleted")
+
+	// an implication that would close a cycle once it points at the target is dropped
+	ids := map[string]int{}
+	for _, name := range []string{"A", "B", "C"} {
+		ids[name], err = database.EnsureTag(db, name, "#373c40")
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, database.AddImplication(db, ids["A"], ids["B"]))
+	assert.NoError(t, database.AddImplication(db, ids["B"], ids["C"]))
+	assert.NoError(t, database.MergeTags(db, ids["C"], []int{ids["A"]}))
+	implications, err = database.GetImplications(db)
+	assert.NoError(t, err)
+	assert.Equal(t, []database.TagImplication{
+		{TagId: ids["B"], TagName: "B", ImpliedId: ids["C"], ImpliedName: "C"},
+		{TagId: cat, TagName: "Cat", ImpliedId: animal, ImpliedName: "Animal"},
+	}, implications, "Merge left an implication cycle")
+	assert.NoError(t, database.DeleteTag(db, ids["B"]))
+	assert.NoError(t, database.DeleteTag(db, ids["C"]))
+
+	// deleting a tag removes its aliases and the implications from and to it
+	assert.NoError(t, database.DeleteTag(db, cat))
+	assert.Equal(t, []string{"World/Places"}, fileTagNames(t, db, "/c.png"), "Deleted tag stayed on the file")
+	aliases, err := database.GetAliases(db)
+	assert.NoError(t, err)
+	assert.Empty(t, aliases, "Aliases of the deleted tag were kept")
+	var rules int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM TagImplication").Scan(&rules))
+	assert.Zero(t, rules, "Implications of the deleted tag were kept")
+	assert.NoError(t, database.AddImplication(db, animal, resolve("World")))
+	assert.NoError(t, database.DeleteTag(db, places))
+	assert.Equal(t, []string{"Animal", "World"}, tagNames(t, db), "Children of the deleted tag were kept")
+	assert.Empty(t, fileTagNames(t, db, "/a.png"), "Deleted children stayed on the file")
+}
+
+func TestTagImplications(t *testing.T) {
+	db := openTestDatabase(t)
+	ids := map[string]int{}
+	for _, name := range []string{"Kitten", "Cat", "Animal", "Living"} {
+		id, err := database.EnsureTag(db, name, "#373c40")
+		assert.NoError(t, err)
+		ids[name] = id
+	}
+
+	assert.ErrorIs(t, database.AddImplication(db, ids["Cat"], ids["Cat"]), database.ErrImplicationCycle, "Tag implied itself")
+	assert.NoError(t, database.AddImplication(db, ids["Kitten"], ids["Cat"]))
+	assert.NoError(t, database.AddImplication(db, ids["Cat"], ids["Animal"]))
+	assert.NoError(t, database.AddImplication(db, ids["Animal"], ids["Living"]))
+	assert.NoError(t, database.AddImplication(db, ids["Cat"], ids["Animal"]), "Adding an implication twice failed")
+	assert.ErrorIs(t, database.AddImplication(db, ids["Cat"], ids["Kitten"]), database.ErrImplicationCycle, "Direct cycle was added")
+	assert.ErrorIs(t, database.AddImplication(db, ids["Living"], ids["Kitten"]), database.ErrImplicationCycle, "Transitive cycle was added")
+	implications, err := database.GetImplications(db)
+	assert.NoError(t, err)
+	assert.Len(t, implications, 3, "Refused implications were stored")
+
+	// tagging a file adds every implied tag once, also the ones it already has
+	fileId := addTestFile(t, db, "/a.png", "Animal")
+	assert.NoError(t, database.AddTagToFile(db, fileId, ids["Kitten"]))
+	assert.Equal(t, []string{"Animal", "Cat", "Kitten", "Living"}, fileTagNames(t, db, "/a.png"), "Implied tags were not added")
+}
+
+// Returns TagPair and the same pairs counted from FileTag, they differ when the triggers missed a change
+func tagPairCounts(t *testing.T, db *sql.DB) (kept []string, counted []string) {
+	read := func(query string) []string {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var pairs []string
+		for rows.Next() {
+			var tagId, otherId, files int
+			assert.NoError(t, rows.Scan(&tagId, &otherId, &files))
+			pairs = append(pairs, fmt.Sprintf("%d-%d:%d", tagId, otherId, files))
+		}
+		return pairs
+	}
+	kept = read("SELECT tagId, otherId, files FROM TagPair ORDER BY tagId, otherId")
+	counted = read(`SELECT a.tagId, b.tagId, COUNT(*) FROM FileTag AS a JOIN FileTag AS b ON a.fileId = b.fileId
+		AND (a.tagId != b.tagId OR a.id = b.id) GROUP BY a.tagId, b.tagId ORDER BY a.tagId, b.tagId`)
+	return kept, counted
+}
+
+func TestTagPairCounts(t *testing.T) {
+	db := openTestDatabase(t)
+	check := func(message string) {
+		kept, counted := tagPairCounts(t, db)
+		assert.Equal(t, counted, kept, message)
+	}
+	first := addTestFile(t, db, "/a.png", "beach", "sea", "sand")
+	addTestFile(t, db, "/b.png", "beach", "sea")
+	addTestFile(t, db, "/c.png", "beach", "seaside", "summer")
+	addTestFile(t, db, "/d.png", "city")
+	check("Wrong counts after tagging")
+	resolve := func(name string) int {
+		id, err := database.ResolveTag(db, name)
+		assert.NoError(t, err)
+		return id
+	}
+
+	related, err := database.GetRelatedTags(db, []int{resolve("sea")}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []database.TagCount{{Id: resolve("beach"), Name: "beach", Color: "#373c40", Files: 3}}, related, "Wrong related tags")
+
+	assert.NoError(t, database.RemoveTagFromImage(db, first, resolve("sand")))
+	check("Wrong counts after untagging")
+	assert.NoError(t, database.RemoveFile(db, "/b.png"))
+	check("Wrong counts after removing a file")
+	changes, err := database.ApplyTagChanges(db, []string{"/a.png", "/c.png", "/d.png"}, []int{resolve("summer")}, []int{resolve("beach")})
+	assert.NoError(t, err)
+	check("Wrong counts after re-tagging")
+	assert.NoError(t, database.UndoTagChanges(db, changes))
+	check("Wrong counts after undoing")
+	assert.NoError(t, database.MergeTags(db, resolve("sea"), []int{resolve("seaside")}))
+	check("Wrong counts after merging")
+	assert.NoError(t, database.AddTagToFile(db, first, resolve("city")))
+	assert.NoError(t, database.MergeTags(db, resolve("sea"), []int{resolve("city")}))
+	check("Wrong counts after merging onto a file that has the target")
+	assert.NoError(t, database.DeleteTag(db, resolve("summer")))
+	check("Wrong counts after deleting a tag")
+
+	// a database made before the counts is filled when opened
+	_, err = db.Exec("DELETE FROM TagPair WHERE tagId = otherId")
+	assert.NoError(t, err)
+	again := openTestDatabase(t)
+	kept, counted := tagPairCounts(t, again)
+	assert.Equal(t, counted, kept, "Counts were not filled")
+}
+
+func TestRunTagRules(t *testing.T) {
+	db := openTestDatabase(t)
+	// more files than one batch so the run commits more than once
+	for i := 0; i < 450; i++ {
+		dir := "/photos/holidays/"
+		if i%3 == 0 {
+			dir = "/photos/work/"
+		}
+		tags := []string{"unsorted"}
+		if i%5 == 0 {
+			tags = append(tags, "sea")
+		}
+		addTestFile(t, db, fmt.Sprintf("%s%d.png", dir, i), tags...)
+	}
+	sea, err := database.ResolveTag(db, "sea")
+	assert.NoError(t, err)
+	water, err := database.EnsureTag(db, "water", "#373c40")
+	assert.NoError(t, err)
+	assert.NoError(t, database.AddImplication(db, sea, water))
+	rules := []autotag.Rule{
+		{Name: "Holidays", Conditions: []autotag.Condition{{Field: autotag.FieldPath, Value: "**/holidays/**"}}, Add: []string{"sea", "trip/summer"}, Remove: []string{"unsorted"}},
+		{Name: "Work", Conditions: []autotag.Condition{{Field: autotag.FieldPath, Value: "**/work/**"}}, Add: []string{"trip/summer"}, Remove: []string{"trip/summer", "missing"}},
+	}
+	snapshot := func() string {
+		var tags, fileTags int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM Tag").Scan(&tags))
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM FileTag").Scan(&fileTags))
+		return fmt.Sprintf("%d tags, %d file tags", tags, fileTags)
+	}
+	before := snapshot()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = database.RunTagRules(cancelled, db, rules, nil, false, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, before, snapshot(), "Cancelled run changed tags")
+
+	preview, err := database.RunTagRules(context.Background(), db, rules, nil, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, before, snapshot(), "Dry run changed tags")
+
+	var done []int
+	result, err := database.RunTagRules(context.Background(), db, rules, nil, false, func(d int, total int) {
+		assert.Equal(t, 450, total)
+		done = append(done, d)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, result, preview, "Dry run does not tell what the run changes")
+	assert.Equal(t, database.AutoTagResult{Files: 450, Added: 300 + 240 + 300 + 150, Removed: 300 + 150}, result)
+	assert.Len(t, done, 450, "Progress was not told about every file")
+	assert.Equal(t, []string{"sea", "trip/summer", "water"}, fileTagNames(t, db, "/photos/holidays/1.png"))
+	assert.Equal(t, []string{"unsorted"}, fileTagNames(t, db, "/photos/work/3.png"))
+}
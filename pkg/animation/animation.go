@@ -0,0 +1,143 @@
+package animation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Frames are always the size of the whole canvas, already composed with the frames before them
+type Frame struct {
+	Image *image.NRGBA
+	Delay time.Duration
+}
+
+// LoopCount is the number of times the animation plays, 0 plays it forever
+type Animation struct {
+	Frames    []Frame
+	LoopCount int
+}
+
+func (a *Animation) Bounds() image.Rectangle {
+	if len(a.Frames) == 0 {
+		return image.Rectangle{}
+	}
+	return a.Frames[0].Image.Bounds()
+}
+
+// Delay used for frames that do not set one, browsers use the same minimum
+const DefaultDelay = 100 * time.Millisecond
+
+var ErrNotAnimation = errors.New("file is not a gif, png or webp image")
+
+// Reads every frame of a GIF, APNG or WebP file, still images give a single frame
+func Read(path string) (*Animation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gif":
+		return DecodeGIF(bytes.NewReader(data))
+	case ".png", ".apng":
+		return DecodeAPNG(data)
+	case ".webp":
+		return DecodeWebP(data)
+	}
+	return nil, ErrNotAnimation
+}
+
+// Reports whether the file is a GIF, APNG or WebP with more than one frame, without decoding the frames
+func IsAnimated(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gif":
+		return countGIFFrames(data) > 1
+	case ".png", ".apng":
+		return apngFrameCount(data) > 1
+	case ".webp":
+		return webpFrameCount(data) > 1
+	}
+	return false
+}
+
+// Builds an animation from still images, every image is scaled to fit the first one and centered
+func FromImages(images []image.Image, delay time.Duration, loopCount int) (*Animation, error) {
+	if len(images) == 0 {
+		return nil, errors.New("no images to animate")
+	}
+
+	bounds := image.Rect(0, 0, images[0].Bounds().Dx(), images[0].Bounds().Dy())
+	anim := &Animation{LoopCount: loopCount}
+	for i, img := range images {
+		if img.Bounds().Empty() {
+			return nil, fmt.Errorf("image %d is empty", i+1)
+		}
+		frame := image.NewNRGBA(bounds)
+		fitInto(frame, img)
+		anim.Frames = append(anim.Frames, Frame{Image: frame, Delay: delay})
+	}
+	return anim, nil
+}
+
+// Scales img to fit dst keeping its aspect ratio, the rest of dst stays transparent
+func fitInto(dst *image.NRGBA, img image.Image) {
+	src := img.Bounds()
+	if src.Dx() == dst.Rect.Dx() && src.Dy() == dst.Rect.Dy() {
+		draw.Draw(dst, dst.Rect, img, src.Min, draw.Src)
+		return
+	}
+
+	scale := min(float64(dst.Rect.Dx())/float64(src.Dx()), float64(dst.Rect.Dy())/float64(src.Dy()))
+	width := max(1, int(float64(src.Dx())*scale+0.5))
+	height := max(1, int(float64(src.Dy())*scale+0.5))
+	x := (dst.Rect.Dx() - width) / 2
+	y := (dst.Rect.Dy() - height) / 2
+	scaleInto(dst, image.Rect(x, y, x+width, y+height), img)
+}
+
+func scaleInto(dst *image.NRGBA, rect image.Rectangle, img image.Image) {
+	xdraw.CatmullRom.Scale(dst, rect, img, img.Bounds(), xdraw.Src, nil)
+}
+
+// Scales every frame to the given size
+func (a *Animation) Resize(width int, height int) {
+	if bounds := a.Bounds(); bounds.Dx() == width && bounds.Dy() == height {
+		return
+	}
+	for i, frame := range a.Frames {
+		resized := image.NewNRGBA(image.Rect(0, 0, width, height))
+		scaleInto(resized, resized.Rect, frame.Image)
+		a.Frames[i].Image = resized
+	}
+}
+
+func cloneFrame(canvas *image.NRGBA) *image.NRGBA {
+	frame := image.NewNRGBA(canvas.Rect)
+	copy(frame.Pix, canvas.Pix)
+	return frame
+}
+
+// Turns a delay given as a fraction of a second into a duration, 0 frames get DefaultDelay
+func fractionDelay(numerator int, denominator int) time.Duration {
+	if denominator == 0 {
+		denominator = 100
+	}
+	if numerator == 0 {
+		return DefaultDelay
+	}
+	return time.Duration(numerator) * time.Second / time.Duration(denominator)
+}
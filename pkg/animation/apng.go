@@ -0,0 +1,352 @@
+package animation
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"time"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// APNG frame control values
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendSource       = 0
+	apngBlendOver         = 1
+)
+
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a png image")
+	}
+
+	var chunks []pngChunk
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if length < 0 || pos+12+length > len(data) {
+			return nil, errors.New("truncated png chunk")
+		}
+		chunk := pngChunk{kind: string(data[pos+4 : pos+8]), data: data[pos+8 : pos+8+length]}
+		chunks = append(chunks, chunk)
+		pos += 12 + length
+		if chunk.kind == "IEND" {
+			break
+		}
+	}
+	if len(chunks) == 0 || chunks[0].kind != "IHDR" || len(chunks[0].data) != 13 {
+		return nil, errors.New("png has no header")
+	}
+	return chunks, nil
+}
+
+func writePNGChunk(w io.Writer, kind string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], kind)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+
+	for _, part := range [][]byte{header[:], data, footer[:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the number of frames the acTL chunk announces, a plain PNG has one
+func apngFrameCount(data []byte) int {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return 0
+	}
+	for _, chunk := range chunks {
+		if chunk.kind == "acTL" && len(chunk.data) >= 8 {
+			return int(binary.BigEndian.Uint32(chunk.data[:4]))
+		}
+		if chunk.kind == "IDAT" {
+			break
+		}
+	}
+	return 1
+}
+
+type apngFrame struct {
+	width, height  int
+	x, y           int
+	delay          time.Duration
+	dispose, blend byte
+	data           []byte // concatenated zlib stream of the frame
+}
+
+// Decodes every frame of an APNG, a plain PNG gives a single frame
+func DecodeAPNG(data []byte) (*Animation, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	header := chunks[0].data
+	width := int(binary.BigEndian.Uint32(header[0:4]))
+	height := int(binary.BigEndian.Uint32(header[4:8]))
+
+	anim := &Animation{}
+	animated := false
+	// chunks like PLTE and tRNS are shared by all frames
+	var shared []pngChunk
+	var frames []*apngFrame
+	var current *apngFrame
+	seenIDAT := false
+
+	for _, chunk := range chunks[1:] {
+		switch chunk.kind {
+		case "acTL":
+			if len(chunk.data) < 8 {
+				return nil, errors.New("invalid apng acTL chunk")
+			}
+			animated = true
+			anim.LoopCount = int(binary.BigEndian.Uint32(chunk.data[4:8]))
+		case "fcTL":
+			if len(chunk.data) < 26 {
+				return nil, errors.New("invalid apng fcTL chunk")
+			}
+			current = &apngFrame{
+				width:   int(binary.BigEndian.Uint32(chunk.data[4:8])),
+				height:  int(binary.BigEndian.Uint32(chunk.data[8:12])),
+				x:       int(binary.BigEndian.Uint32(chunk.data[12:16])),
+				y:       int(binary.BigEndian.Uint32(chunk.data[16:20])),
+				delay:   fractionDelay(int(binary.BigEndian.Uint16(chunk.data[20:22])), int(binary.BigEndian.Uint16(chunk.data[22:24]))),
+				dispose: chunk.data[24],
+				blend:   chunk.data[25],
+			}
+			frames = append(frames, current)
+		case "IDAT":
+			seenIDAT = true
+			// without an fcTL before it the default image is not part of the animation
+			if current != nil {
+				current.data = append(current.data, chunk.data...)
+			}
+		case "fdAT":
+			if len(chunk.data) < 4 {
+				return nil, errors.New("invalid apng fdAT chunk")
+			}
+			if current != nil {
+				current.data = append(current.data, chunk.data[4:]...)
+			}
+		case "IEND":
+		default:
+			if !seenIDAT {
+				shared = append(shared, chunk)
+			}
+		}
+	}
+
+	if !animated || len(frames) == 0 {
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		frame := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(frame, frame.Rect, img, img.Bounds().Min, draw.Src)
+		return &Animation{Frames: []Frame{{Image: frame, Delay: DefaultDelay}}}, nil
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, frame := range frames {
+		img, err := decodeAPNGFrame(header, shared, frame)
+		if err != nil {
+			return nil, err
+		}
+		rect := image.Rect(frame.x, frame.y, frame.x+frame.width, frame.y+frame.height)
+
+		// the first frame is drawn on an empty canvas so it always replaces it
+		dispose := frame.dispose
+		if i == 0 && dispose == apngDisposePrevious {
+			dispose = apngDisposeBackground
+		}
+		var previous *image.NRGBA
+		if dispose == apngDisposePrevious {
+			previous = cloneFrame(canvas)
+		}
+
+		op := draw.Over
+		if frame.blend == apngBlendSource {
+			op = draw.Src
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+		anim.Frames = append(anim.Frames, Frame{Image: cloneFrame(canvas), Delay: frame.delay})
+
+		switch dispose {
+		case apngDisposeBackground:
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		case apngDisposePrevious:
+			canvas = previous
+		}
+	}
+	return anim, nil
+}
+
+// Rebuilds a standalone PNG from the frame so the standard decoder can read it
+func decodeAPNGFrame(header []byte, shared []pngChunk, frame *apngFrame) (image.Image, error) {
+	frameHeader := bytes.Clone(header)
+	binary.BigEndian.PutUint32(frameHeader[0:4], uint32(frame.width))
+	binary.BigEndian.PutUint32(frameHeader[4:8], uint32(frame.height))
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	writePNGChunk(&buf, "IHDR", frameHeader)
+	for _, chunk := range shared {
+		writePNGChunk(&buf, chunk.kind, chunk.data)
+	}
+	writePNGChunk(&buf, "IDAT", frame.data)
+	writePNGChunk(&buf, "IEND", nil)
+	return png.Decode(&buf)
+}
+
+// Encodes the animation as an APNG with 8 bit RGBA frames, the first frame is also the image
+// viewers without APNG support show
+func EncodeAPNG(w io.Writer, anim *Animation) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+	bounds := anim.Bounds()
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:4], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(header[4:8], uint32(bounds.Dy()))
+	header[8] = 8 // bit depth
+	header[9] = 6 // truecolor with alpha
+	writePNGChunk(&buf, "IHDR", header)
+
+	control := make([]byte, 8)
+	binary.BigEndian.PutUint32(control[0:4], uint32(len(anim.Frames)))
+	binary.BigEndian.PutUint32(control[4:8], uint32(max(0, anim.LoopCount)))
+	writePNGChunk(&buf, "acTL", control)
+
+	sequence := uint32(0)
+	for i, frame := range anim.Frames {
+		frameControl := make([]byte, 26)
+		binary.BigEndian.PutUint32(frameControl[0:4], sequence)
+		binary.BigEndian.PutUint32(frameControl[4:8], uint32(bounds.Dx()))
+		binary.BigEndian.PutUint32(frameControl[8:12], uint32(bounds.Dy()))
+		// delays are stored in milliseconds
+		binary.BigEndian.PutUint16(frameControl[20:22], uint16(min(frame.Delay.Milliseconds(), 65535)))
+		binary.BigEndian.PutUint16(frameControl[22:24], 1000)
+		frameControl[24] = apngDisposeNone
+		frameControl[25] = apngBlendSource
+		writePNGChunk(&buf, "fcTL", frameControl)
+		sequence++
+
+		compressed, err := compressPNGRows(frame.Image)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			writePNGChunk(&buf, "IDAT", compressed)
+			continue
+		}
+		frameData := make([]byte, 4, 4+len(compressed))
+		binary.BigEndian.PutUint32(frameData, sequence)
+		writePNGChunk(&buf, "fdAT", append(frameData, compressed...))
+		sequence++
+	}
+
+	writePNGChunk(&buf, "IEND", nil)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Filters every row of the RGBA pixels with the filter that gives the smallest sum, like libpng does
+func compressPNGRows(img *image.NRGBA) ([]byte, error) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	rowLen := width * 4
+	previous := make([]byte, rowLen)
+	filtered := make([][]byte, 5)
+	for i := range filtered {
+		filtered[i] = make([]byte, rowLen+1)
+		filtered[i][0] = byte(i)
+	}
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+rowLen]
+		for x := 0; x < rowLen; x++ {
+			var left, upLeft byte
+			if x >= 4 {
+				left, upLeft = row[x-4], previous[x-4]
+			}
+			up := previous[x]
+			filtered[0][x+1] = row[x]
+			filtered[1][x+1] = row[x] - left
+			filtered[2][x+1] = row[x] - up
+			filtered[3][x+1] = row[x] - byte((int(left)+int(up))/2)
+			filtered[4][x+1] = row[x] - paeth(left, up, upLeft)
+		}
+
+		best, bestSum := 0, -1
+		for i, candidate := range filtered {
+			sum := 0
+			for _, b := range candidate[1:] {
+				sum += abs(int(int8(b)))
+			}
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = i, sum
+			}
+		}
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		copy(previous, row)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,118 @@
+package animation
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// Decodes every frame of a GIF, applying each frame's disposal so frames are complete pictures
+func DecodeGIF(r io.Reader) (*Animation, error) {
+	decoded, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded.Image) == 0 {
+		return nil, errors.New("gif has no frames")
+	}
+
+	bounds := image.Rect(0, 0, decoded.Config.Width, decoded.Config.Height)
+	if bounds.Empty() {
+		bounds = decoded.Image[0].Bounds()
+	}
+
+	anim := &Animation{LoopCount: gifPlays(decoded.LoopCount)}
+	canvas := image.NewNRGBA(bounds)
+	for i, paletted := range decoded.Image {
+		var previous *image.NRGBA
+		disposal := byte(0)
+		if i < len(decoded.Disposal) {
+			disposal = decoded.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = cloneFrame(canvas)
+		}
+
+		draw.Draw(canvas, paletted.Bounds(), paletted, paletted.Bounds().Min, draw.Over)
+
+		delay := DefaultDelay
+		if i < len(decoded.Delay) && decoded.Delay[i] > 0 {
+			delay = time.Duration(decoded.Delay[i]) * 10 * time.Millisecond
+		}
+		anim.Frames = append(anim.Frames, Frame{Image: cloneFrame(canvas), Delay: delay})
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, paletted.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return anim, nil
+}
+
+func countGIFFrames(data []byte) int {
+	decoded, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	return len(decoded.Image)
+}
+
+// The gif loop count is the number of repeats after the first play and -1 for no repeats
+func gifPlays(loopCount int) int {
+	switch {
+	case loopCount < 0:
+		return 1
+	case loopCount == 0:
+		return 0
+	}
+	return loopCount + 1
+}
+
+func gifLoopCount(plays int) int {
+	switch {
+	case plays <= 0:
+		return 0
+	case plays == 1:
+		return -1
+	}
+	return plays - 1
+}
+
+// Encodes the animation as a GIF with one palette built from all frames by median cut,
+// dithering spreads the error of the reduced palette so gradients do not band
+func EncodeGIF(w io.Writer, anim *Animation, dither bool) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+
+	frames := make([]image.Image, len(anim.Frames))
+	for i, frame := range anim.Frames {
+		frames[i] = frame.Image
+	}
+	palette := Quantize(frames, 256)
+
+	var drawer draw.Drawer = draw.Src
+	if dither {
+		drawer = draw.FloydSteinberg
+	}
+
+	out := &gif.GIF{LoopCount: gifLoopCount(anim.LoopCount)}
+	for _, frame := range anim.Frames {
+		paletted := image.NewPaletted(frame.Image.Rect, palette)
+		drawer.Draw(paletted, paletted.Rect, frame.Image, frame.Image.Rect.Min)
+
+		// gif delays are in hundredths of a second
+		out.Image = append(out.Image, paletted)
+		out.Delay = append(out.Delay, max(1, int((frame.Delay+5*time.Millisecond)/(10*time.Millisecond))))
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+	}
+	out.Config = image.Config{ColorModel: color.Palette(palette), Width: anim.Bounds().Dx(), Height: anim.Bounds().Dy()}
+	return gif.EncodeAll(w, out)
+}
@@ -0,0 +1,113 @@
+package animation
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Pixels looked at when building a palette, bigger images are sampled evenly
+const quantizeSamples = 250000
+
+type colorBox struct {
+	colors [][3]uint8
+}
+
+// Returns the channel with the widest spread of values and that spread
+func (b *colorBox) widestChannel() (int, int) {
+	channel, spread := 0, -1
+	for c := 0; c < 3; c++ {
+		low, high := uint8(255), uint8(0)
+		for _, col := range b.colors {
+			low = min(low, col[c])
+			high = max(high, col[c])
+		}
+		if int(high)-int(low) > spread {
+			channel, spread = c, int(high)-int(low)
+		}
+	}
+	return channel, spread
+}
+
+func (b *colorBox) average() color.RGBA {
+	var sum [3]int
+	for _, col := range b.colors {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(col[c])
+		}
+	}
+	n := len(b.colors)
+	return color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), 255}
+}
+
+// Builds a palette of at most maxColors for the images by median cut. If any pixel is mostly
+// transparent the last entry is fully transparent.
+func Quantize(images []image.Image, maxColors int) color.Palette {
+	total := 0
+	for _, img := range images {
+		total += img.Bounds().Dx() * img.Bounds().Dy()
+	}
+	stride := max(1, total/quantizeSamples)
+
+	var samples [][3]uint8
+	transparent := false
+	index := 0
+	for _, img := range images {
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				index++
+				if index%stride != 0 {
+					continue
+				}
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if c.A < 128 {
+					transparent = true
+					continue
+				}
+				samples = append(samples, [3]uint8{c.R, c.G, c.B})
+			}
+		}
+	}
+
+	colors := maxColors
+	if transparent {
+		colors--
+	}
+
+	var palette color.Palette
+	if len(samples) > 0 {
+		boxes := []*colorBox{{colors: samples}}
+		for len(boxes) < colors {
+			// split the box with the widest spread at its median
+			widest, channel, spread := -1, 0, 0
+			for i, box := range boxes {
+				if len(box.colors) < 2 {
+					continue
+				}
+				c, s := box.widestChannel()
+				if s > spread {
+					widest, channel, spread = i, c, s
+				}
+			}
+			if widest < 0 {
+				break
+			}
+
+			box := boxes[widest]
+			sort.Slice(box.colors, func(i, j int) bool { return box.colors[i][channel] < box.colors[j][channel] })
+			median := len(box.colors) / 2
+			boxes[widest] = &colorBox{colors: box.colors[:median]}
+			boxes = append(boxes, &colorBox{colors: box.colors[median:]})
+		}
+
+		for _, box := range boxes {
+			palette = append(palette, box.average())
+		}
+	}
+
+	if transparent || len(palette) == 0 {
+		palette = append(palette, color.RGBA{})
+	}
+	return palette
+}
@@ -0,0 +1,243 @@
+package animation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// VP8X flags and ANMF frame flags
+const (
+	webpFlagAnimation = 0x02
+	webpFlagAlpha     = 0x10
+	webpFrameNoBlend  = 0x02
+	webpFrameDispose  = 0x01
+)
+
+type webpChunk struct {
+	kind string
+	data []byte
+}
+
+func readWebPChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a webp image")
+	}
+	return parseWebPChunks(data[12:])
+}
+
+func parseWebPChunks(data []byte) ([]webpChunk, error) {
+	var chunks []webpChunk
+	pos := 0
+	for pos+8 <= len(data) {
+		length := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		if length < 0 || pos+8+length > len(data) {
+			return nil, errors.New("truncated webp chunk")
+		}
+		chunks = append(chunks, webpChunk{kind: string(data[pos : pos+4]), data: data[pos+8 : pos+8+length]})
+		// chunks are padded to an even size
+		pos += 8 + length + length%2
+	}
+	return chunks, nil
+}
+
+func writeWebPChunk(buf *bytes.Buffer, kind string, data []byte) {
+	buf.WriteString(kind)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func webpFrameCount(data []byte) int {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return 0
+	}
+	frames := 0
+	for _, chunk := range chunks {
+		if chunk.kind == "ANMF" {
+			frames++
+		}
+	}
+	return max(1, frames)
+}
+
+// Decodes every frame of an animated WebP, a still WebP gives a single frame
+func DecodeWebP(data []byte) (*Animation, error) {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var width, height int
+	anim := &Animation{}
+	var frames []webpChunk
+	for _, chunk := range chunks {
+		switch chunk.kind {
+		case "VP8X":
+			if len(chunk.data) < 10 {
+				return nil, errors.New("invalid webp VP8X chunk")
+			}
+			width = uint24(chunk.data[4:7]) + 1
+			height = uint24(chunk.data[7:10]) + 1
+		case "ANIM":
+			if len(chunk.data) < 6 {
+				return nil, errors.New("invalid webp ANIM chunk")
+			}
+			anim.LoopCount = int(binary.LittleEndian.Uint16(chunk.data[4:6]))
+		case "ANMF":
+			frames = append(frames, chunk)
+		}
+	}
+
+	if len(frames) == 0 {
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		frame := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(frame, frame.Rect, img, img.Bounds().Min, draw.Src)
+		return &Animation{Frames: []Frame{{Image: frame, Delay: DefaultDelay}}}, nil
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for _, chunk := range frames {
+		if len(chunk.data) < 16 {
+			return nil, errors.New("invalid webp ANMF chunk")
+		}
+		x := uint24(chunk.data[0:3]) * 2
+		y := uint24(chunk.data[3:6]) * 2
+		frameWidth := uint24(chunk.data[6:9]) + 1
+		frameHeight := uint24(chunk.data[9:12]) + 1
+		delay := time.Duration(uint24(chunk.data[12:15])) * time.Millisecond
+		if delay == 0 {
+			delay = DefaultDelay
+		}
+		flags := chunk.data[15]
+
+		img, err := decodeWebPFrame(chunk.data[16:], frameWidth, frameHeight)
+		if err != nil {
+			return nil, err
+		}
+
+		rect := image.Rect(x, y, x+frameWidth, y+frameHeight)
+		op := draw.Over
+		if flags&webpFrameNoBlend != 0 {
+			op = draw.Src
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+		anim.Frames = append(anim.Frames, Frame{Image: cloneFrame(canvas), Delay: delay})
+
+		if flags&webpFrameDispose != 0 {
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+	return anim, nil
+}
+
+// Wraps the bitstream chunks of a frame into a standalone WebP so the standard decoder can read it
+func decodeWebPFrame(data []byte, width int, height int) (image.Image, error) {
+	chunks, err := parseWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	hasAlpha := false
+	for _, chunk := range chunks {
+		if chunk.kind == "ALPH" {
+			hasAlpha = true
+		}
+	}
+	// a lossy frame with an alpha channel needs the extended header
+	if hasAlpha {
+		header := make([]byte, 10)
+		header[0] = webpFlagAlpha
+		putUint24(header[4:7], width-1)
+		putUint24(header[7:10], height-1)
+		writeWebPChunk(&body, "VP8X", header)
+	}
+	for _, chunk := range chunks {
+		switch chunk.kind {
+		case "ALPH", "VP8 ", "VP8L":
+			writeWebPChunk(&body, chunk.kind, chunk.data)
+		}
+	}
+
+	return webp.Decode(bytes.NewReader(wrapRIFF(body.Bytes())))
+}
+
+func wrapRIFF(body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(body)+4))
+	buf.WriteString("WEBP")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// Encodes the animation as an animated WebP. encodeFrame turns a frame into a still WebP file,
+// its bitstream is moved into the animation.
+func EncodeWebP(w io.Writer, anim *Animation, encodeFrame func(image.Image) ([]byte, error)) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+	bounds := anim.Bounds()
+
+	var body bytes.Buffer
+	header := make([]byte, 10)
+	header[0] = webpFlagAnimation | webpFlagAlpha
+	putUint24(header[4:7], bounds.Dx()-1)
+	putUint24(header[7:10], bounds.Dy()-1)
+	writeWebPChunk(&body, "VP8X", header)
+
+	animation := make([]byte, 6)
+	binary.LittleEndian.PutUint16(animation[4:6], uint16(min(max(0, anim.LoopCount), 65535)))
+	writeWebPChunk(&body, "ANIM", animation)
+
+	for _, frame := range anim.Frames {
+		encoded, err := encodeFrame(frame.Image)
+		if err != nil {
+			return err
+		}
+		chunks, err := readWebPChunks(encoded)
+		if err != nil {
+			return err
+		}
+
+		var frameData bytes.Buffer
+		frameHeader := make([]byte, 16)
+		putUint24(frameHeader[6:9], bounds.Dx()-1)
+		putUint24(frameHeader[9:12], bounds.Dy()-1)
+		putUint24(frameHeader[12:15], int(min(frame.Delay.Milliseconds(), 1<<24-1)))
+		// every frame is a whole picture so it replaces the canvas
+		frameHeader[15] = webpFrameNoBlend
+		frameData.Write(frameHeader)
+		for _, chunk := range chunks {
+			switch chunk.kind {
+			case "ALPH", "VP8 ", "VP8L":
+				writeWebPChunk(&frameData, chunk.kind, chunk.data)
+			}
+		}
+		writeWebPChunk(&body, "ANMF", frameData.Bytes())
+	}
+
+	_, err := w.Write(wrapRIFF(body.Bytes()))
+	return err
+}
@@ -2,7 +2,6 @@ package archives
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -11,135 +10,58 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/dialog"
 	"github.com/alexmullins/zip"
-	"github.com/dsnet/compress/bzip2"
 )
 
 var ArchivePassword string
 
-func CreateTarBzip2Archive(archivePath string, fileList []string, w fyne.Window) error {
-	if len(fileList) <= 1 {
-		dialog.ShowError(errors.New("no files to archive"), w)
-	}
-
-	archive, err := os.Create(archivePath)
-	if err != nil {
-		return fmt.Errorf("failed to create archive: %w", err)
-	}
-	defer archive.Close()
-
-	bzipWriter, err := bzip2.NewWriter(archive, &bzip2.WriterConfig{
-		Level: bzip2.BestCompression,
-	})
-	if err != nil {
-		dialog.ShowError(err, nil)
-	}
-	defer bzipWriter.Close() // Ensure bzipWriter is closed
-
-	tarWriter := tar.NewWriter(bzipWriter)
-	defer tarWriter.Close() // Ensure tarWriter is closed
-
-	for _, filePath := range fileList {
-		err := addFileToTarArchive(filePath, tarWriter)
-		if err != nil {
-			return fmt.Errorf("failed to add file %s to archive: %w", filePath, err)
-		}
-	}
-
-	// Ensure tarWriter is closed properly
-	if err := tarWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
-	}
-
-	// Ensure gzipWriter/bzip2Writer is closed properly
-	if err := bzipWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
-	}
+// Encryption shown to the user for each kind of encrypted archive
+const (
+	ZipEncryptionMethod = "WinZip AES-256 (AE-2)"
+	TarEncryptionMethod = "AES-256-GCM per file"
+)
 
-	// Ensure archive is closed properly
-	if err := archive.Close(); err != nil {
-		return fmt.Errorf("failed to close archive: %w", err)
-	}
+var ErrEmptyPassword = errors.New("an encrypted archive needs a password")
 
-	// Verify the archive is not empty
-	info, err := os.Stat(archivePath)
-	if err != nil {
-		return fmt.Errorf("failed to stat archive file: %w", err)
-	}
+// Compression method zip readers expect on WinZip AES entries, legacy ZipCrypto keeps the real method
+const zipMethodWinZipAES = 99
 
-	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, info.Size())
-	return nil
+func CreateTarBzip2Archive(archivePath string, fileList []string, w fyne.Window) error {
+	return createTarArchive(archivePath, fileList, w, FormatBzip2, false)
 }
 
 func CreateTarGzipArchive(archivePath string, fileList []string, w fyne.Window) error {
-	if len(fileList) <= 1 {
-		dialog.ShowError(errors.New("no files to archive"), w)
-	}
-
-	archive, err := os.Create(archivePath)
-	if err != nil {
-		return fmt.Errorf("failed to create archive: %w", err)
-	}
-	defer archive.Close()
-
-	gzipWriter := gzip.NewWriter(archive)
-	defer gzipWriter.Close() // Ensure gzipWriter is closed to finalize the archive
-
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close() // Ensure tarWriter is closed
-
-	for _, filePath := range fileList {
-		err := addFileToTarArchive(filePath, tarWriter)
-		if err != nil {
-			return fmt.Errorf("failed to add file %s to archive: %w", filePath, err)
-		}
-	}
-
-	// Ensure tarWriter is closed properly
-	if err := tarWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
-	}
-
-	// Ensure gzipWriter/bzip2Writer is closed properly
-	if err := gzipWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
-	}
-
-	// Ensure archive is closed properly
-	if err := archive.Close(); err != nil {
-		return fmt.Errorf("failed to close archive: %w", err)
-	}
+	return createTarArchive(archivePath, fileList, w, FormatGzip, false)
+}
 
-	// Verify the archive is not empty
-	info, err := os.Stat(archivePath)
-	if err != nil {
-		return fmt.Errorf("failed to stat archive file: %w", err)
-	}
+func CreateTarZstdArchive(archivePath string, fileList []string, w fyne.Window) error {
+	return createTarArchive(archivePath, fileList, w, FormatZstd, false)
+}
 
-	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, info.Size())
-	return nil
+func CreateTarXzArchive(archivePath string, fileList []string, w fyne.Window) error {
+	return createTarArchive(archivePath, fileList, w, FormatXz, false)
 }
 
-func CreateZipArchive(archivePath string, fileList []string, w fyne.Window) error {
+func CreateZipArchive(archivePath string, fileList []string, w fyne.Window) (err error) {
 	if len(fileList) <= 1 {
 		dialog.ShowError(errors.New("no files to archive"), w)
 	}
 
-	archive, err := os.Create(archivePath)
+	archive, err := newArchiveWriter(archivePath)
 	if err != nil {
 		return fmt.Errorf("failed to create archive: %w", err)
 	}
-	defer archive.Close()
+	defer closeArchive(archive, &err)
 
 	zipWriter := zip.NewWriter(archive)
 	defer zipWriter.Close() // Ensure gzipWriter is closed to finalize the archive
 
+	names := EntryNames(fileList)
 	for _, filePath := range fileList {
-		err := addFileZipToArchive(filePath, zipWriter)
+		err := addFileZipToArchive(filePath, names[filePath], zipWriter)
 		if err != nil {
 			return fmt.Errorf("failed to add file %s to archive: %w", filePath, err)
 		}
@@ -156,31 +78,35 @@ func CreateZipArchive(archivePath string, fileList []string, w fyne.Window) erro
 	}
 
 	// Verify the archive is not empty
-	info, err := os.Stat(archivePath)
+	size, err := archiveSize(archivePath)
 	if err != nil {
 		return fmt.Errorf("failed to stat archive file: %w", err)
 	}
 
-	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, info.Size())
+	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, size)
 	return nil
 }
 
-func CreateEncryptedZipArchive(archivePath string, fileList []string, w fyne.Window) error {
+func CreateEncryptedZipArchive(archivePath string, fileList []string, w fyne.Window) (err error) {
 	if len(fileList) <= 1 {
 		dialog.ShowError(errors.New("no files to archive"), w)
 	}
+	if ArchivePassword == "" {
+		return ErrEmptyPassword
+	}
 
-	archive, err := os.Create(archivePath)
+	archive, err := newArchiveWriter(archivePath)
 	if err != nil {
 		return fmt.Errorf("failed to create archive: %w", err)
 	}
-	defer archive.Close()
+	defer closeArchive(archive, &err)
 
 	zipWriter := zip.NewWriter(archive)
 	defer zipWriter.Close() // Ensure gzipWriter is closed to finalize the archive
 
+	names := EntryNames(fileList)
 	for _, filePath := range fileList {
-		err := addEncryptedFileZipToArchive(filePath, zipWriter)
+		err := addEncryptedFileZipToArchive(filePath, names[filePath], zipWriter)
 		if err != nil {
 			return fmt.Errorf("failed to add file %s to archive: %w", filePath, err)
 		}
@@ -197,88 +123,62 @@ func CreateEncryptedZipArchive(archivePath string, fileList []string, w fyne.Win
 	}
 
 	// Verify the archive is not empty
-	info, err := os.Stat(archivePath)
+	size, err := archiveSize(archivePath)
 	if err != nil {
 		return fmt.Errorf("failed to stat archive file: %w", err)
 	}
 
-	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, info.Size())
+	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, size)
 	return nil
 }
 
 func CreateEncryptedTarBzip2Archive(archivePath string, fileList []string, w fyne.Window) error {
-	if len(fileList) <= 1 {
-		dialog.ShowError(errors.New("no files to archive"), w)
-	}
-
-	archive, err := os.Create(archivePath)
-	if err != nil {
-		return fmt.Errorf("failed to create archive: %w", err)
-	}
-	defer archive.Close()
-
-	bzipWriter, err := bzip2.NewWriter(archive, &bzip2.WriterConfig{
-		Level: bzip2.BestCompression,
-	})
-	if err != nil {
-		dialog.ShowError(err, nil)
-	}
-	defer bzipWriter.Close() // Ensure bzipWriter is closed
-
-	tarWriter := tar.NewWriter(bzipWriter)
-	defer tarWriter.Close() // Ensure tarWriter is closed
-
-	for _, filePath := range fileList {
-		err := addEncryptedFileToTarArchive(filePath, tarWriter)
-		if err != nil {
-			return fmt.Errorf("failed to add file %s to archive: %w", filePath, err)
-		}
-	}
-
-	// Ensure tarWriter is closed properly
-	if err := tarWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
-	}
-
-	// Ensure gzipWriter/bzip2Writer is closed properly
-	if err := bzipWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
-	}
+	return createTarArchive(archivePath, fileList, w, FormatBzip2, true)
+}
 
-	// Ensure archive is closed properly
-	if err := archive.Close(); err != nil {
-		return fmt.Errorf("failed to close archive: %w", err)
-	}
+func CreateEncryptedTarGzipArchive(archivePath string, fileList []string, w fyne.Window) error {
+	return createTarArchive(archivePath, fileList, w, FormatGzip, true)
+}
 
-	// Verify the archive is not empty
-	info, err := os.Stat(archivePath)
-	if err != nil {
-		return fmt.Errorf("failed to stat archive file: %w", err)
-	}
+func CreateEncryptedTarZstdArchive(archivePath string, fileList []string, w fyne.Window) error {
+	return createTarArchive(archivePath, fileList, w, FormatZstd, true)
+}
 
-	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, info.Size())
-	return nil
+func CreateEncryptedTarXzArchive(archivePath string, fileList []string, w fyne.Window) error {
+	return createTarArchive(archivePath, fileList, w, FormatXz, true)
 }
 
-func CreateEncryptedTarGzipArchive(archivePath string, fileList []string, w fyne.Window) error {
+// Writes a tar archive compressed with the given format, encrypting each entry with ArchivePassword if encrypted is set
+func createTarArchive(archivePath string, fileList []string, w fyne.Window, format string, encrypted bool) (err error) {
 	if len(fileList) <= 1 {
 		dialog.ShowError(errors.New("no files to archive"), w)
 	}
+	if encrypted && ArchivePassword == "" {
+		return ErrEmptyPassword
+	}
 
-	archive, err := os.Create(archivePath)
+	archive, err := newArchiveWriter(archivePath)
 	if err != nil {
 		return fmt.Errorf("failed to create archive: %w", err)
 	}
-	defer archive.Close()
+	defer closeArchive(archive, &err)
 
-	gzipWriter := gzip.NewWriter(archive)
-	defer gzipWriter.Close() // Ensure gzipWriter is closed to finalize the archive
+	compressor, err := newCompressor(format, archive)
+	if err != nil {
+		return fmt.Errorf("failed to create %s compressor: %w", format, err)
+	}
+	defer compressor.Close() // Ensure compressor is closed to finalize the archive
 
-	tarWriter := tar.NewWriter(gzipWriter)
+	tarWriter := tar.NewWriter(compressor)
 	defer tarWriter.Close() // Ensure tarWriter is closed
 
+	names := EntryNames(fileList)
 	for _, filePath := range fileList {
-		err := addEncryptedFileToTarArchive(filePath, tarWriter)
+		if encrypted {
+			err = addEncryptedFileToTarArchive(filePath, names[filePath], tarWriter)
+		} else {
+			err = addFileToTarArchive(filePath, names[filePath], tarWriter)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to add file %s to archive: %w", filePath, err)
 		}
@@ -289,9 +189,9 @@ func CreateEncryptedTarGzipArchive(archivePath string, fileList []string, w fyne
 		return fmt.Errorf("failed to close tar writer: %w", err)
 	}
 
-	// Ensure gzipWriter/bzip2Writer is closed properly
-	if err := gzipWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
+	// Ensure the compressor is closed properly
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to close %s writer: %w", format, err)
 	}
 
 	// Ensure archive is closed properly
@@ -300,16 +200,16 @@ func CreateEncryptedTarGzipArchive(archivePath string, fileList []string, w fyne
 	}
 
 	// Verify the archive is not empty
-	info, err := os.Stat(archivePath)
+	size, err := archiveSize(archivePath)
 	if err != nil {
 		return fmt.Errorf("failed to stat archive file: %w", err)
 	}
 
-	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, info.Size())
+	fmt.Printf("Archive created successfully at %s with size %d bytes\n", archivePath, size)
 	return nil
 }
 
-func addFileToTarArchive(filePath string, tarWriter *tar.Writer) error {
+func addFileToTarArchive(filePath string, name string, tarWriter *tar.Writer) error {
 
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -327,7 +227,7 @@ func addFileToTarArchive(filePath string, tarWriter *tar.Writer) error {
 		return fmt.Errorf("failed to create tar header for %s: %w", filePath, err)
 	}
 
-	header.Name = filepath.Base(filePath)
+	header.Name = name
 
 	if err := tarWriter.WriteHeader(header); err != nil {
 		return fmt.Errorf("failed to write tar header for %s: %w", filePath, err)
@@ -342,7 +242,7 @@ func addFileToTarArchive(filePath string, tarWriter *tar.Writer) error {
 	return nil
 }
 
-func addFileZipToArchive(filePath string, zipWriter *zip.Writer) error {
+func addFileZipToArchive(filePath string, name string, zipWriter *zip.Writer) error {
 
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -360,7 +260,7 @@ func addFileZipToArchive(filePath string, zipWriter *zip.Writer) error {
 		return fmt.Errorf("failed to create tar header for %s: %w", filePath, err)
 	}
 
-	header.Name = filepath.Base(filePath)
+	header.Name = name
 
 	writer, err := zipWriter.CreateHeader(header)
 	if err != nil {
@@ -376,7 +276,7 @@ func addFileZipToArchive(filePath string, zipWriter *zip.Writer) error {
 	return nil
 }
 
-func addEncryptedFileZipToArchive(filePath string, zipWriter *zip.Writer) error {
+func addEncryptedFileZipToArchive(filePath string, name string, zipWriter *zip.Writer) error {
 
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -393,15 +293,19 @@ func addEncryptedFileZipToArchive(filePath string, zipWriter *zip.Writer) error
 	if err != nil {
 		return fmt.Errorf("failed to create tar header for %s: %w", filePath, err)
 	}
+	// SetPassword makes the writer encrypt the entry as WinZip AES-256 (AE-2) which 7-Zip, WinZip and unzip tools open
 	header.SetPassword(ArchivePassword)
 
-	header.Name = filepath.Base(filePath)
+	header.Name = name
 
-	// --- Normal Zip Section Start
 	writer, err := zipWriter.CreateHeader(header)
 	if err != nil {
 		return fmt.Errorf("failed to write zip header for %s: %w", filePath, err)
 	}
+	// never ship an entry that is only protected by ZipCrypto
+	if header.Method != zipMethodWinZipAES {
+		return fmt.Errorf("%s would not be encrypted with AES, refusing to write it", filePath)
+	}
 
 	_, err = io.Copy(writer, file) // you can replace _ with bytes and uncoment the print below to see info
 	if err != nil {
@@ -410,50 +314,9 @@ func addEncryptedFileZipToArchive(filePath string, zipWriter *zip.Writer) error
 
 	// fmt.Printf("Added %s to archive (size: %d bytes)\n", filePath, bytesWritten)
 	return nil
-	// --- Normal Zip Section End
-
-	// hasher := sha256.New()
-	// hasher.Write([]byte(archivePassword))
-	// hashedPass := sha256.Sum256([]byte(archivePassword))
-
-	// block, err := aes.NewCipher(hashedPass[:])
-	// if err != nil {
-	// 	return fmt.Errorf("failed to create cipher: %w", err)
-	// }
-
-	// gcm, err := cipher.NewGCM(block)
-	// if err != nil {
-	// 	return fmt.Errorf("failed to create GCM: %w", err)
-	// }
-
-	// nonce := make([]byte, gcm.NonceSize())
-	// if _, err = rand.Read(nonce); err != nil {
-	// 	return fmt.Errorf("failed to generate nonce: %w", err)
-	// }
-
-	// fileBytes, err := io.ReadAll(file)
-	// if err != nil {
-	// 	return fmt.Errorf("failed to read file content for %s: %w", filePath, err)
-	// }
-
-	// cipherText := gcm.Seal(nil, nonce, fileBytes, nil)
-
-	// fullEncryptedContent := append(nonce, cipherText...)
-
-	// encryptedWriter, err := zipWriter.CreateHeader(header)
-	// if err != nil {
-	// 	return fmt.Errorf("failed to write zip header for %s: %w", filePath, err)
-	// }
-
-	// _, err = encryptedWriter.Write(fullEncryptedContent)
-	// if err != nil {
-	// 	return fmt.Errorf("failed to write file content for %s: %w", filePath, err)
-	// }
-
-	// return nil
 }
 
-func addEncryptedFileToTarArchive(filePath string, tarWriter *tar.Writer) error {
+func addEncryptedFileToTarArchive(filePath string, name string, tarWriter *tar.Writer) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file %s: %w", filePath, err)
@@ -470,7 +333,7 @@ func addEncryptedFileToTarArchive(filePath string, tarWriter *tar.Writer) error
 		return fmt.Errorf("failed to create tar header for %s: %w", filePath, err)
 	}
 
-	header.Name = filepath.Base(filePath)
+	header.Name = name
 
 	hasher := sha256.New()
 	hasher.Write([]byte(ArchivePassword))
@@ -0,0 +1,242 @@
+package archives
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Archive formats, the value doubles as the file extension
+const (
+	FormatGzip  = ".tar.gz"
+	FormatBzip2 = ".tar.bz2"
+	FormatZstd  = ".tar.zst"
+	FormatXz    = ".tar.xz"
+	FormatZip   = ".zip"
+)
+
+// Compression levels for the zstd and xz formats
+const (
+	LevelFastest = iota
+	LevelDefault
+	LevelBetter
+	LevelBest
+)
+
+// Names shown in the compression level picker, indexed by level
+var LevelNames = []string{"Fastest", "Default", "Better", "Best"}
+
+// Set by the UI before creating a zstd or xz archive
+var CompressionLevel = LevelDefault
+
+// Returns the archive format of a path based on its extension or an empty string if unknown
+func FormatFromPath(archivePath string) string {
+	name := strings.ToLower(filepath.Base(BaseArchivePath(archivePath)))
+	switch {
+	case strings.HasSuffix(name, FormatGzip), strings.HasSuffix(name, ".tgz"):
+		return FormatGzip
+	case strings.HasSuffix(name, FormatBzip2), strings.HasSuffix(name, ".tbz2"):
+		return FormatBzip2
+	case strings.HasSuffix(name, FormatZstd), strings.HasSuffix(name, ".tzst"):
+		return FormatZstd
+	case strings.HasSuffix(name, FormatXz), strings.HasSuffix(name, ".txz"):
+		return FormatXz
+	case strings.HasSuffix(name, FormatZip):
+		return FormatZip
+	}
+	return ""
+}
+
+func newCompressor(format string, w io.Writer) (io.WriteCloser, error) {
+	switch format {
+	case FormatGzip:
+		return gzip.NewWriter(w), nil
+	case FormatBzip2:
+		return bzip2.NewWriter(w, &bzip2.WriterConfig{
+			Level: bzip2.BestCompression,
+		})
+	case FormatZstd:
+		return zstd.NewWriter(w,
+			zstd.WithEncoderLevel(zstdLevel(CompressionLevel)),
+			zstd.WithEncoderConcurrency(runtime.NumCPU()),
+		)
+	case FormatXz:
+		return newParallelXzWriter(w, CompressionLevel)
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+func newDecompressor(format string, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case FormatGzip:
+		return gzip.NewReader(r)
+	case FormatBzip2:
+		return bzip2.NewReader(r, nil)
+	case FormatZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(runtime.NumCPU()))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case FormatXz:
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch level {
+	case LevelFastest:
+		return zstd.SpeedFastest
+	case LevelBetter:
+		return zstd.SpeedBetterCompression
+	case LevelBest:
+		return zstd.SpeedBestCompression
+	default:
+		return zstd.SpeedDefault
+	}
+}
+
+// xz has no multi-threaded encoder so the input is split into blocks that are
+// compressed concurrently as separate xz streams. Concatenated streams are valid xz
+// and are read back by xz.NewReader and the xz command line tool.
+type parallelXzWriter struct {
+	out       io.Writer
+	config    xz.WriterConfig
+	blockSize int
+	buf       []byte
+	pending   []chan xzBlock
+	streams   int
+	closed    bool
+	err       error
+}
+
+type xzBlock struct {
+	data []byte
+	err  error
+}
+
+func newParallelXzWriter(w io.Writer, level int) (*parallelXzWriter, error) {
+	dictCaps := []int{1 << 20, 8 << 20, 16 << 20, 32 << 20}
+	if level < 0 || level >= len(dictCaps) {
+		level = LevelDefault
+	}
+
+	config := xz.WriterConfig{DictCap: dictCaps[level]}
+	if err := config.Verify(); err != nil {
+		return nil, err
+	}
+
+	// blocks smaller than the dictionary would waste most of the window
+	blockSize := 3 * dictCaps[level]
+	if blockSize < 8<<20 {
+		blockSize = 8 << 20
+	}
+
+	return &parallelXzWriter{out: w, config: config, blockSize: blockSize}, nil
+}
+
+func (x *parallelXzWriter) Write(p []byte) (int, error) {
+	if x.err != nil {
+		return 0, x.err
+	}
+	if x.closed {
+		return 0, errors.New("write to closed xz writer")
+	}
+
+	written := len(p)
+	for len(p) > 0 {
+		n := x.blockSize - len(x.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		x.buf = append(x.buf, p[:n]...)
+		p = p[n:]
+
+		if len(x.buf) == x.blockSize {
+			if err := x.flushBlock(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Hands the buffered block to a worker, waiting for the oldest one when all workers are busy
+func (x *parallelXzWriter) flushBlock() error {
+	block := x.buf
+	x.buf = nil
+
+	result := make(chan xzBlock, 1)
+	x.pending = append(x.pending, result)
+	x.streams++
+	go func() {
+		var compressed bytes.Buffer
+		xzWriter, err := x.config.NewWriter(&compressed)
+		if err == nil {
+			_, err = xzWriter.Write(block)
+		}
+		if err == nil {
+			err = xzWriter.Close()
+		}
+		result <- xzBlock{data: compressed.Bytes(), err: err}
+	}()
+
+	for len(x.pending) >= runtime.NumCPU() {
+		if err := x.writeOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *parallelXzWriter) writeOldest() error {
+	block := <-x.pending[0]
+	x.pending = x.pending[1:]
+	if block.err != nil {
+		x.err = block.err
+		return block.err
+	}
+	if _, err := x.out.Write(block.data); err != nil {
+		x.err = err
+		return err
+	}
+	return nil
+}
+
+func (x *parallelXzWriter) Close() error {
+	if x.closed || x.err != nil {
+		return x.err
+	}
+	x.closed = true
+
+	// an empty input still needs one stream to be a valid xz file
+	if len(x.buf) > 0 || x.streams == 0 {
+		if err := x.flushBlock(); err != nil {
+			return err
+		}
+	}
+
+	for len(x.pending) > 0 {
+		if err := x.writeOldest(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexmullins/zip"
+)
+
+// Extracts every file of the archive into destDir, decrypting entries with ArchivePassword if encrypted is set
+func ExtractArchive(archivePath string, destDir string, encrypted bool) error {
+	if FormatFromPath(archivePath) == "" {
+		return fmt.Errorf("unsupported archive type: %s", filepath.Base(archivePath))
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	return walkArchive(archivePath, encrypted, func(name string, content io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		return writeExtractedFile(destDir, name, content)
+	})
+}
+
+// Calls fn with the content of every file in the archive. Errors limited to a single entry, like a failed
+// decryption, are passed to fn, errors that make the rest of the archive unreadable are returned.
+func walkArchive(archivePath string, encrypted bool, fn func(name string, content io.Reader, err error) error) error {
+	format := FormatFromPath(archivePath)
+	if format == "" {
+		return fmt.Errorf("unsupported archive type: %s", filepath.Base(archivePath))
+	}
+
+	if format == FormatZip {
+		return walkZipArchive(archivePath, encrypted, fn)
+	}
+
+	archive, err := openArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	decompressor, err := newDecompressor(format, archive)
+	if err != nil {
+		return fmt.Errorf("failed to create %s decompressor: %w", format, err)
+	}
+	defer decompressor.Close()
+
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if !encrypted {
+			if err := fn(header.Name, tarReader, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		encryptedContent, err := io.ReadAll(tarReader)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		plainText, err := decryptContent(encryptedContent)
+		if err != nil {
+			err = fmt.Errorf("failed to decrypt %s: %w", header.Name, err)
+		}
+		if err := fn(header.Name, bytes.NewReader(plainText), err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkZipArchive(archivePath string, encrypted bool, fn func(name string, content io.Reader, err error) error) error {
+	archive, err := openArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	zipReader, err := zip.NewReader(archive, archive.Size())
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	for _, file := range zipReader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if file.IsEncrypted() {
+			if !encrypted {
+				if err := fn(file.Name, nil, fmt.Errorf("%s is encrypted, a password is required", file.Name)); err != nil {
+					return err
+				}
+				continue
+			}
+			if !hasWinZipAESExtra(file.Extra) {
+				if err := fn(file.Name, nil, fmt.Errorf("%s uses legacy ZipCrypto encryption which is not supported", file.Name)); err != nil {
+					return err
+				}
+				continue
+			}
+			file.SetPassword(ArchivePassword)
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			if err := fn(file.Name, nil, fmt.Errorf("failed to open %s: %w", file.Name, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		err = fn(file.Name, content, nil)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reports whether the zip extra field has the 0x9901 record WinZip AES entries carry
+func hasWinZipAESExtra(extra []byte) bool {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if id == 0x9901 {
+			return true
+		}
+		if len(extra) < 4+size {
+			return false
+		}
+		extra = extra[4+size:]
+	}
+	return false
+}
+
+// Writes the entry under destDir keeping its directories. Names are cleaned so nothing escapes destDir, and a
+// file that already exists is never overwritten, the entry gets the next free name like photo-2.png instead.
+func writeExtractedFile(destDir string, name string, content io.Reader) error {
+	relative := strings.TrimPrefix(filepath.Clean(string(filepath.Separator)+filepath.FromSlash(name)), string(filepath.Separator))
+	if relative == "" {
+		return fmt.Errorf("invalid file name in archive: %q", name)
+	}
+
+	path := filepath.Join(destDir, relative)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", name, err)
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	for n := 2; os.IsExist(err); n++ {
+		file, err = os.OpenFile(base+"-"+strconv.Itoa(n)+ext, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", name, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, content); err != nil {
+		return fmt.Errorf("failed to write file content for %s: %w", name, err)
+	}
+
+	return file.Close()
+}
+
+// Reverses addEncryptedFileToTarArchive, the content is the nonce followed by the AES-GCM ciphertext
+func decryptContent(content []byte) ([]byte, error) {
+	hashedPass := sha256.Sum256([]byte(ArchivePassword))
+
+	block, err := aes.NewCipher(hashedPass[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	if len(content) < gcm.NonceSize() {
+		return nil, errors.New("encrypted content is too short")
+	}
+
+	nonce, cipherText := content[:gcm.NonceSize()], content[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, cipherText, nil)
+}
@@ -0,0 +1,96 @@
+package archives
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultNameTemplate = "{date}"
+
+// Placeholders supported by archive name templates:
+//
+//	{date}  day the archive is created, dd-mm-yyyy
+//	{time}  time the archive is created, hh-mm-ss
+//	{tag}   tag shared by most of the archived files
+//	{count} number of archived files
+//	{n}     lowest number that gives a name not used in the destination directory
+func ExpandNameTemplate(template string, tag string, count int, now time.Time) string {
+	if strings.TrimSpace(template) == "" {
+		template = DefaultNameTemplate
+	}
+	if tag == "" {
+		tag = "untagged"
+	}
+
+	name := strings.NewReplacer(
+		"{date}", now.Format("02-01-2006"),
+		"{time}", now.Format("15-04-05"),
+		"{tag}", tag,
+		"{count}", strconv.Itoa(count),
+	).Replace(template)
+
+	// the name must stay inside the chosen directory
+	return strings.NewReplacer("/", "-", "\\", "-").Replace(name)
+}
+
+// Returns a path in dir for name+ext that does not overwrite an existing file.
+// {n} in the name is replaced by the first free number, without it -2, -3... is appended on collisions.
+func NextFreePath(dir string, name string, ext string) string {
+	if strings.Contains(name, "{n}") {
+		for n := 1; ; n++ {
+			archivePath := filepath.Join(dir, strings.ReplaceAll(name, "{n}", strconv.Itoa(n))+ext)
+			if !archiveExists(archivePath) {
+				return archivePath
+			}
+		}
+	}
+
+	archivePath := filepath.Join(dir, name+ext)
+	for n := 2; archiveExists(archivePath); n++ {
+		archivePath = filepath.Join(dir, name+"-"+strconv.Itoa(n)+ext)
+	}
+	return archivePath
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Like fileExists but also counts the volumes and manifest of a split archive
+func archiveExists(archivePath string) bool {
+	return fileExists(archivePath) || fileExists(volumePath(archivePath, 1)) || fileExists(archivePath+ManifestExtension)
+}
+
+// Names the files get inside an archive, their paths relative to the deepest directory holding all of them.
+// Files from one directory keep only their file names, files from several keep the directories telling them apart.
+func EntryNames(fileList []string) map[string]string {
+	names := make(map[string]string, len(fileList))
+	if len(fileList) == 0 {
+		return names
+	}
+
+	common := filepath.Dir(filepath.Clean(fileList[0]))
+	for _, path := range fileList[1:] {
+		for !isWithin(common, filepath.Clean(path)) && filepath.Dir(common) != common {
+			common = filepath.Dir(common)
+		}
+	}
+	for _, path := range fileList {
+		relative, err := filepath.Rel(common, filepath.Clean(path))
+		if err != nil || strings.HasPrefix(relative, "..") {
+			// files on different volumes share no directory
+			relative = filepath.Base(path)
+		}
+		names[path] = filepath.ToSlash(relative)
+	}
+	return names
+}
+
+func isWithin(dir string, path string) bool {
+	relative, err := filepath.Rel(dir, path)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
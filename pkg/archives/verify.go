@@ -0,0 +1,110 @@
+package archives
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Result of VerifyArchive, entries are listed by their name inside the archive
+type VerifyReport struct {
+	Verified []string
+	Missing  []string          // expected files that are not in the archive
+	Corrupt  map[string]string // entry name -> reason it failed
+	Extra    []string          // entries that were not expected
+	Error    error             // set when the archive could not be read to the end
+}
+
+func (r *VerifyReport) OK() bool {
+	return r.Error == nil && len(r.Missing) == 0 && len(r.Corrupt) == 0 && len(r.Extra) == 0
+}
+
+func (r *VerifyReport) String() string {
+	var sb strings.Builder
+	if r.OK() {
+		fmt.Fprintf(&sb, "Archive is sound, %d files verified\n", len(r.Verified))
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "Verified: %d\n", len(r.Verified))
+	if r.Error != nil {
+		fmt.Fprintf(&sb, "Archive is unreadable: %v\n", r.Error)
+	}
+
+	corrupt := make([]string, 0, len(r.Corrupt))
+	for name := range r.Corrupt {
+		corrupt = append(corrupt, name)
+	}
+	sort.Strings(corrupt)
+	for _, name := range corrupt {
+		fmt.Fprintf(&sb, "Corrupt: %s (%s)\n", name, r.Corrupt[name])
+	}
+	for _, name := range r.Missing {
+		fmt.Fprintf(&sb, "Missing: %s\n", name)
+	}
+	for _, name := range r.Extra {
+		fmt.Fprintf(&sb, "Extra: %s\n", name)
+	}
+	return sb.String()
+}
+
+// Re-reads the archive and checks every entry decompresses, decrypts and matches the md5 of its source.
+// expected maps source file paths to their md5, if it is nil only the archive itself is checked.
+func VerifyArchive(archivePath string, expected map[string]string, encrypted bool) *VerifyReport {
+	report := &VerifyReport{Corrupt: map[string]string{}}
+
+	// the sources are named the way the archive names its entries
+	paths := make([]string, 0, len(expected))
+	for path := range expected {
+		paths = append(paths, path)
+	}
+	names := EntryNames(paths)
+	expectedByName := make(map[string]string, len(expected))
+	for path, hash := range expected {
+		expectedByName[names[path]] = hash
+	}
+	seen := map[string]bool{}
+
+	report.Error = walkArchive(archivePath, encrypted, func(name string, content io.Reader, err error) error {
+		seen[name] = true
+		if err != nil {
+			report.Corrupt[name] = err.Error()
+			return nil
+		}
+
+		hash := md5.New()
+		if _, err := io.Copy(hash, content); err != nil {
+			report.Corrupt[name] = err.Error()
+			return nil
+		}
+
+		if expected == nil {
+			report.Verified = append(report.Verified, name)
+			return nil
+		}
+
+		expectedHash, ok := expectedByName[name]
+		switch {
+		case !ok:
+			report.Extra = append(report.Extra, name)
+		case expectedHash != hex.EncodeToString(hash.Sum(nil)):
+			report.Corrupt[name] = "checksum does not match the source file"
+		default:
+			report.Verified = append(report.Verified, name)
+		}
+		return nil
+	})
+
+	for name := range expectedByName {
+		// entries after a read error were never reached so they cannot be called missing
+		if !seen[name] && report.Error == nil {
+			report.Missing = append(report.Missing, name)
+		}
+	}
+	sort.Strings(report.Missing)
+
+	return report
+}
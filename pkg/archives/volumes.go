@@ -0,0 +1,352 @@
+package archives
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Sizes offered by the volume picker, a size of 0 writes a single file
+var VolumeSizes = []int64{0, 100 << 20, 700 << 20, 1 << 30, 4<<30 - 1}
+
+// Names shown in the volume picker, indexed like VolumeSizes
+var VolumeSizeNames = []string{"Single file", "100 MiB", "700 MiB (CD)", "1 GiB", "4 GiB (FAT32)"}
+
+// Set by the UI before creating an archive, archives bigger than this are split into numbered volumes
+var VolumeSize int64 = 0
+
+const ManifestExtension = ".manifest"
+
+var volumeSuffix = regexp.MustCompile(`\.\d{3}$`)
+
+// Returns the archive path without a volume number or manifest extension
+func BaseArchivePath(archivePath string) string {
+	archivePath = strings.TrimSuffix(archivePath, ManifestExtension)
+	return volumeSuffix.ReplaceAllString(archivePath, "")
+}
+
+func volumePath(archivePath string, volume int) string {
+	return fmt.Sprintf("%s.%03d", archivePath, volume)
+}
+
+// Opens the file the archive is written to, a volumeWriter if VolumeSize is set
+func newArchiveWriter(archivePath string) (io.WriteCloser, error) {
+	if VolumeSize <= 0 {
+		return os.Create(archivePath)
+	}
+
+	// the save dialog leaves an empty file behind that the volumes replace
+	if info, err := os.Stat(archivePath); err == nil && info.Size() == 0 {
+		os.Remove(archivePath)
+	}
+
+	return &volumeWriter{archivePath: archivePath, volumeSize: VolumeSize}, nil
+}
+
+// Returns the number of bytes written to the archive, summing all volumes
+func archiveSize(archivePath string) (int64, error) {
+	if info, err := os.Stat(archivePath); err == nil {
+		return info.Size(), nil
+	}
+
+	var size int64
+	for volume := 1; ; volume++ {
+		info, err := os.Stat(volumePath(archivePath, volume))
+		if errors.Is(err, os.ErrNotExist) && volume > 1 {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+}
+
+// Splits everything written to it into archivePath.001, archivePath.002... and writes
+// archivePath.manifest with the sha256 of every volume in the format of sha256sum
+type volumeWriter struct {
+	archivePath string
+	volumeSize  int64
+	volume      int
+	file        *os.File
+	written     int64
+	hash        hash.Hash
+	manifest    []string
+	closed      bool
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	if v.closed {
+		return 0, errors.New("write to closed archive")
+	}
+
+	total := 0
+	for len(p) > 0 {
+		if v.file == nil || v.written == v.volumeSize {
+			if err := v.nextVolume(); err != nil {
+				return total, err
+			}
+		}
+
+		chunk := p
+		if remaining := v.volumeSize - v.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		n, err := v.file.Write(chunk)
+		v.hash.Write(chunk[:n])
+		v.written += int64(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func (v *volumeWriter) nextVolume() error {
+	if err := v.closeVolume(); err != nil {
+		return err
+	}
+
+	v.volume++
+	file, err := os.Create(volumePath(v.archivePath, v.volume))
+	if err != nil {
+		return fmt.Errorf("failed to create volume %d: %w", v.volume, err)
+	}
+	v.file = file
+	v.written = 0
+	v.hash = sha256.New()
+	return nil
+}
+
+func (v *volumeWriter) closeVolume() error {
+	if v.file == nil {
+		return nil
+	}
+	v.manifest = append(v.manifest, fmt.Sprintf("%s  %s", hex.EncodeToString(v.hash.Sum(nil)), filepath.Base(v.file.Name())))
+	err := v.file.Close()
+	v.file = nil
+	return err
+}
+
+func (v *volumeWriter) Close() error {
+	if v.closed {
+		return nil
+	}
+	v.closed = true
+
+	// an empty archive still gets a volume so there is something to open
+	if v.volume == 0 {
+		if err := v.nextVolume(); err != nil {
+			return err
+		}
+	}
+	if err := v.closeVolume(); err != nil {
+		return err
+	}
+
+	manifest := strings.Join(v.manifest, "\n") + "\n"
+	if err := os.WriteFile(v.archivePath+ManifestExtension, []byte(manifest), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// Closes the volumes without writing a manifest and removes them, a partial set must not pass verification
+func (v *volumeWriter) abort() {
+	v.closed = true
+	if v.file != nil {
+		v.file.Close()
+		v.file = nil
+	}
+	for volume := 1; volume <= v.volume; volume++ {
+		os.Remove(volumePath(v.archivePath, volume))
+	}
+}
+
+// Deferred by the archive writers with their error, a split archive that failed part way is removed
+func closeArchive(archive io.WriteCloser, err *error) {
+	if v, ok := archive.(*volumeWriter); ok && *err != nil {
+		v.abort()
+		return
+	}
+	archive.Close()
+}
+
+// An archive opened for reading, split archives are joined back into one stream
+type archiveReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// Opens a single file archive or joins the volumes of a split one after checking them against the manifest
+func openArchive(archivePath string) (archiveReader, error) {
+	archivePath = BaseArchivePath(archivePath)
+
+	if fileExists(archivePath) {
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to stat archive: %w", err)
+		}
+		return &joinedVolumes{files: []*os.File{file}, sizes: []int64{info.Size()}, size: info.Size()}, nil
+	}
+
+	volumes, err := listVolumes(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	joined := &joinedVolumes{}
+	for _, volume := range volumes {
+		file, err := os.Open(volume)
+		if err != nil {
+			joined.Close()
+			return nil, fmt.Errorf("failed to open volume: %w", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			joined.Close()
+			return nil, fmt.Errorf("failed to stat volume: %w", err)
+		}
+		joined.files = append(joined.files, file)
+		joined.sizes = append(joined.sizes, info.Size())
+		joined.size += info.Size()
+	}
+	return joined, nil
+}
+
+// Returns the volume paths in order, checking their sha256 if the archive has a manifest
+func listVolumes(archivePath string) ([]string, error) {
+	manifest, err := os.Open(archivePath + ManifestExtension)
+	if errors.Is(err, os.ErrNotExist) {
+		// without a manifest take every consecutive volume there is
+		var volumes []string
+		for volume := 1; fileExists(volumePath(archivePath, volume)); volume++ {
+			volumes = append(volumes, volumePath(archivePath, volume))
+		}
+		if len(volumes) == 0 {
+			return nil, fmt.Errorf("archive %s does not exist", filepath.Base(archivePath))
+		}
+		return volumes, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer manifest.Close()
+
+	var volumes []string
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		expectedHash, name, found := strings.Cut(scanner.Text(), "  ")
+		if !found {
+			continue
+		}
+
+		volume := filepath.Join(filepath.Dir(archivePath), filepath.Base(name))
+		hash, err := fileSHA256(volume)
+		if err != nil {
+			return nil, fmt.Errorf("volume %s: %w", name, err)
+		}
+		if hash != expectedHash {
+			return nil, fmt.Errorf("volume %s does not match its checksum in the manifest", name)
+		}
+		volumes = append(volumes, volume)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if len(volumes) == 0 {
+		return nil, errors.New("manifest does not list any volumes")
+	}
+	return volumes, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Presents the volumes as one file for sequential reads and for zip's random access
+type joinedVolumes struct {
+	files   []*os.File
+	sizes   []int64
+	size    int64
+	current int
+}
+
+func (j *joinedVolumes) Read(p []byte) (int, error) {
+	for j.current < len(j.files) {
+		n, err := j.files[j.current].Read(p)
+		if err == io.EOF {
+			j.current++
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+func (j *joinedVolumes) ReadAt(p []byte, off int64) (int, error) {
+	total := 0
+	for i, file := range j.files {
+		if off >= j.sizes[i] {
+			off -= j.sizes[i]
+			continue
+		}
+
+		n, err := file.ReadAt(p[total:], off)
+		total += n
+		if total == len(p) {
+			return total, nil
+		}
+		if err != nil && err != io.EOF {
+			return total, err
+		}
+		off = 0
+	}
+	return total, io.EOF
+}
+
+func (j *joinedVolumes) Size() int64 {
+	return j.size
+}
+
+func (j *joinedVolumes) Close() error {
+	var err error
+	for _, file := range j.files {
+		if closeErr := file.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,274 @@
+package autotag
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields a condition can test
+const (
+	FieldPath        = "path"        // glob on the whole path, ** crosses directories
+	FieldPathRegex   = "path regex"  // regular expression on the whole path
+	FieldFilename    = "filename"    // glob on the file name
+	FieldCamera      = "camera"      // text in the EXIF make and model
+	FieldLens        = "lens"        // text in the EXIF lens model
+	FieldDate        = "date taken"  // range of days the photo was taken on
+	FieldWidth       = "width"       // range of widths in pixels, after the EXIF orientation
+	FieldHeight      = "height"      // range of heights in pixels, after the EXIF orientation
+	FieldOrientation = "orientation" // landscape, portrait or square
+	FieldSize        = "file size"   // range of file sizes like 500KB or 2MB
+)
+
+var Fields = []string{FieldPath, FieldPathRegex, FieldFilename, FieldCamera, FieldLens, FieldDate, FieldWidth,
+	FieldHeight, FieldOrientation, FieldSize}
+
+var Orientations = []string{"landscape", "portrait", "square"}
+
+const dateLayout = "2006-01-02"
+
+// One test of a rule. Patterns use Value, ranges use Min and Max and either end can be left empty.
+type Condition struct {
+	Field string `json:"field"`
+	Value string `json:"value,omitempty"`
+	Min   string `json:"min,omitempty"`
+	Max   string `json:"max,omitempty"`
+}
+
+// Tags added to and removed from every file matching all the conditions
+type Rule struct {
+	Id         int         `json:"-"`
+	Name       string      `json:"-"`
+	Enabled    bool        `json:"-"`
+	Conditions []Condition `json:"conditions"`
+	Add        []string    `json:"add,omitempty"`
+	Remove     []string    `json:"remove,omitempty"`
+}
+
+// Checks that the rule can be run, patterns, numbers and dates have to parse
+func (r Rule) Validate() error {
+	if len(r.Conditions) == 0 {
+		return fmt.Errorf("the rule needs at least one condition")
+	}
+	if len(r.Add) == 0 && len(r.Remove) == 0 {
+		return fmt.Errorf("the rule does not add or remove any tags")
+	}
+	for _, c := range r.Conditions {
+		if _, err := c.matches(&File{}, true); err != nil {
+			return fmt.Errorf("%s: %w", c.Field, err)
+		}
+	}
+	return nil
+}
+
+// Reads the conditions and tags of a rule, the name and whether it is enabled are stored apart
+func Parse(data string) (Rule, error) {
+	var rule Rule
+	err := json.Unmarshal([]byte(data), &rule)
+	return rule, err
+}
+
+func Marshal(rule Rule) (string, error) {
+	if err := rule.Validate(); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(rule)
+	return string(data), err
+}
+
+// Tells whether the file matches all the conditions of the rule
+func (r Rule) Matches(f *File) (bool, error) {
+	for _, c := range r.Conditions {
+		ok, err := c.matches(f, false)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return len(r.Conditions) > 0, nil
+}
+
+// With validate the condition is only parsed, the file is not looked at
+func (c Condition) matches(f *File, validate bool) (bool, error) {
+	switch c.Field {
+	case FieldPath:
+		re, err := globToRegexp(c.Value, true)
+		if err != nil || validate {
+			return false, err
+		}
+		return re.MatchString(filepath.ToSlash(f.Path)), nil
+	case FieldPathRegex:
+		re, err := regexp.Compile(c.Value)
+		if err != nil || validate {
+			return false, err
+		}
+		return re.MatchString(f.Path), nil
+	case FieldFilename:
+		re, err := globToRegexp(c.Value, false)
+		if err != nil || validate {
+			return false, err
+		}
+		return re.MatchString(filepath.Base(f.Path)), nil
+	case FieldCamera, FieldLens:
+		if strings.TrimSpace(c.Value) == "" {
+			return false, fmt.Errorf("the text to look for is empty")
+		}
+		if validate {
+			return false, nil
+		}
+		value := f.camera()
+		if c.Field == FieldLens {
+			value = f.lens()
+		}
+		return strings.Contains(strings.ToLower(value), strings.ToLower(strings.TrimSpace(c.Value))), nil
+	case FieldDate:
+		from, to, err := parseRange(c, func(s string) (float64, error) {
+			day, err := time.ParseInLocation(dateLayout, s, time.Local)
+			return float64(day.Unix()), err
+		})
+		if err != nil || validate {
+			return false, err
+		}
+		taken := f.taken()
+		if taken.IsZero() {
+			return false, nil
+		}
+		// the last day counts as a whole
+		return inRange(float64(taken.Unix()), from, to+24*60*60-1), nil
+	case FieldWidth, FieldHeight:
+		from, to, err := parseRange(c, func(s string) (float64, error) { return strconv.ParseFloat(s, 64) })
+		if err != nil || validate {
+			return false, err
+		}
+		width, height := f.dimensions()
+		if width == 0 {
+			return false, nil
+		}
+		if c.Field == FieldHeight {
+			return inRange(float64(height), from, to), nil
+		}
+		return inRange(float64(width), from, to), nil
+	case FieldOrientation:
+		value := strings.ToLower(strings.TrimSpace(c.Value))
+		if !contains(Orientations, value) {
+			return false, fmt.Errorf("orientation has to be one of %s", strings.Join(Orientations, ", "))
+		}
+		if validate {
+			return false, nil
+		}
+		width, height := f.dimensions()
+		switch {
+		case width == 0:
+			return false, nil
+		case width > height:
+			return value == "landscape", nil
+		case width < height:
+			return value == "portrait", nil
+		}
+		return value == "square", nil
+	case FieldSize:
+		from, to, err := parseRange(c, func(s string) (float64, error) {
+			size, err := ParseSize(s)
+			return float64(size), err
+		})
+		if err != nil || validate {
+			return false, err
+		}
+		size := f.size()
+		return size >= 0 && inRange(float64(size), from, to), nil
+	}
+	return false, fmt.Errorf("unknown field %q", c.Field)
+}
+
+// Parses both ends of a range, an empty end is open
+func parseRange(c Condition, parse func(string) (float64, error)) (float64, float64, error) {
+	if strings.TrimSpace(c.Min) == "" && strings.TrimSpace(c.Max) == "" {
+		return 0, 0, fmt.Errorf("the range needs a minimum or a maximum")
+	}
+	from, to := -1e18, 1e18
+	if strings.TrimSpace(c.Min) != "" {
+		value, err := parse(strings.TrimSpace(c.Min))
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad minimum %q", c.Min)
+		}
+		from = value
+	}
+	if strings.TrimSpace(c.Max) != "" {
+		value, err := parse(strings.TrimSpace(c.Max))
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad maximum %q", c.Max)
+		}
+		to = value
+	}
+	return from, to, nil
+}
+
+func inRange(value float64, from float64, to float64) bool {
+	return value >= from && value <= to
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses sizes like 1500, 500KB, 2.5MB or 1GB, units are powers of 1024
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		size   float64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	multiplier := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return int64(value * multiplier), nil
+}
+
+// Turns a glob into a case insensitive regular expression. * and ? stay inside a directory, with paths ** also
+// matches across directories.
+func globToRegexp(glob string, path bool) (*regexp.Regexp, error) {
+	if strings.TrimSpace(glob) == "" {
+		return nil, fmt.Errorf("the pattern is empty")
+	}
+	var expr strings.Builder
+	expr.WriteString("(?i)^")
+	runes := []rune(filepath.ToSlash(glob))
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '*' && path && i+1 < len(runes) && runes[i+1] == '*':
+			i++
+			// **/ also matches no directory at all
+			if i+1 < len(runes) && runes[i+1] == '/' {
+				expr.WriteString("(?:.*/)?")
+				i++
+			} else {
+				expr.WriteString(".*")
+			}
+		case r == '*':
+			expr.WriteString("[^/]*")
+		case r == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,122 @@
+package autotag
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"main/pkg/imagemeta"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// A file rules are tested on. The size, image header and EXIF data are read the first time a condition needs
+// them and kept for the other rules.
+type File struct {
+	Path string
+
+	statted  bool
+	fileSize int64
+
+	decoded    bool
+	width      int
+	height     int
+	cameraName string
+	lensModel  string
+	dateTaken  time.Time
+}
+
+func NewFile(path string) *File {
+	return &File{Path: path}
+}
+
+// Size in bytes, -1 when the file cannot be read
+func (f *File) size() int64 {
+	if !f.statted {
+		f.statted = true
+		f.fileSize = -1
+		if info, err := os.Stat(f.Path); err == nil {
+			f.fileSize = info.Size()
+		}
+	}
+	return f.fileSize
+}
+
+// Width and height as shown, images rotated by their EXIF orientation are swapped. Zero when the format cannot
+// be read.
+func (f *File) dimensions() (int, int) {
+	f.decode()
+	return f.width, f.height
+}
+
+func (f *File) camera() string {
+	f.decode()
+	return f.cameraName
+}
+
+func (f *File) lens() string {
+	f.decode()
+	return f.lensModel
+}
+
+func (f *File) taken() time.Time {
+	f.decode()
+	return f.dateTaken
+}
+
+func (f *File) decode() {
+	if f.decoded {
+		return
+	}
+	f.decoded = true
+
+	if file, err := os.Open(f.Path); err == nil {
+		if config, _, err := image.DecodeConfig(file); err == nil {
+			f.width, f.height = config.Width, config.Height
+		}
+		file.Close()
+	}
+
+	meta, err := imagemeta.Read(f.Path)
+	if err != nil || len(meta.Exif) == 0 {
+		return
+	}
+	// orientations 5 to 8 turn the image by 90 degrees
+	if meta.Orientation() >= 5 {
+		f.width, f.height = f.height, f.width
+	}
+	decoded, err := exif.Decode(bytes.NewReader(meta.Exif))
+	if err != nil {
+		return
+	}
+	var camera []string
+	for _, field := range []exif.FieldName{exif.Make, exif.Model} {
+		if value := stringField(decoded, field); value != "" {
+			camera = append(camera, value)
+		}
+	}
+	f.cameraName = strings.Join(camera, " ")
+	f.lensModel = stringField(decoded, exif.LensModel)
+	if date, err := decoded.DateTime(); err == nil {
+		f.dateTaken = date
+	}
+}
+
+func stringField(decoded *exif.Exif, field exif.FieldName) string {
+	tag, err := decoded.Get(field)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
@@ -2,6 +2,7 @@ package colorutils
 
 import (
 	"fmt"
+	"hash/fnv"
 	"image/color"
 	"math"
 	"strconv"
@@ -119,3 +120,76 @@ func HexToColor(hex string) (color.Color, error) {
 		A: 255,
 	}, nil
 }
+
+// Converts a color to a hex string like #1A2B3C, transparency is dropped
+func ColorToHex(c color.Color) string {
+	nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02X%02X%02X", nrgba.R, nrgba.G, nrgba.B)
+}
+
+// Picks a color for a new tag from its name, the same name always gets the same color
+func AutoColor(name string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	return HSVToHex(float64(hash.Sum32()%360), 0.55, 0.85)
+}
+
+// Converts 0-255 RGB to CIE L*a*b*, where distances are close to how different people see two colors
+func RGBToLab(r, g, b float64) (float64, float64, float64) {
+	linear := func(c float64) float64 {
+		c /= 255
+		if c <= 0.04045 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	rl, gl, bl := linear(r), linear(g), linear(b)
+
+	// sRGB to XYZ relative to the D65 white point
+	x := (0.4124*rl + 0.3576*gl + 0.1805*bl) / 0.95047
+	y := 0.2126*rl + 0.7152*gl + 0.0722*bl
+	z := (0.0193*rl + 0.1192*gl + 0.9505*bl) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 0.008856 {
+			return math.Cbrt(t)
+		}
+		return 7.787*t + 16.0/116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// Returns the CIE76 distance between two hex colors, around 2.3 is barely noticeable and 100 is black to white
+func DeltaE(a string, b string) float64 {
+	l1, a1, b1 := RGBToLab(HexToRgb(a))
+	l2, a2, b2 := RGBToLab(HexToRgb(b))
+	return math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (b1-b2)*(b1-b2))
+}
+
+// Returns the WCAG relative luminance of a color, 0 for black and 1 for white
+func RelativeLuminance(c color.Color) float64 {
+	nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	channel := func(v uint8) float64 {
+		s := float64(v) / 255
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(nrgba.R) + 0.7152*channel(nrgba.G) + 0.0722*channel(nrgba.B)
+}
+
+// Returns the WCAG contrast ratio of two colors, from 1 for the same color to 21 for black on white
+func ContrastRatio(a color.Color, b color.Color) float64 {
+	la, lb := RelativeLuminance(a), RelativeLuminance(b)
+	return (max(la, lb) + 0.05) / (min(la, lb) + 0.05)
+}
+
+// Picks black or white text for a background, whichever has more contrast with it
+func TextColor(background color.Color) color.Color {
+	if ContrastRatio(background, color.Black) >= ContrastRatio(background, color.White) {
+		return color.Black
+	}
+	return color.White
+}
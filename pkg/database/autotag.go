@@ -0,0 +1,357 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"main/pkg/autotag"
+	"main/pkg/colorutils"
+	"main/pkg/tagpath"
+	"strings"
+)
+
+// What running auto-tagging rules changed, or would change on a dry run
+type AutoTagResult struct {
+	Files   int // files matched by at least one rule
+	Added   int
+	Removed int
+}
+
+// Returns every auto-tagging rule in the order they were made, rules that cannot be read are skipped
+func GetTagRules(db *sql.DB) ([]autotag.Rule, error) {
+	rows, err := db.Query("SELECT id, name, enabled, rule FROM TagRule ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []autotag.Rule
+	for rows.Next() {
+		var id int
+		var name, data string
+		var enabled bool
+		if err := rows.Scan(&id, &name, &enabled, &data); err != nil {
+			return nil, err
+		}
+		rule, err := autotag.Parse(data)
+		if err != nil {
+			appLogger.Println("Skipping unreadable tag rule", name, err)
+			continue
+		}
+		rule.Id, rule.Name, rule.Enabled = id, name, enabled
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// Stores the rule and returns its id, rules without an id are added
+func SaveTagRule(db *sql.DB, rule autotag.Rule) (int, error) {
+	if strings.TrimSpace(rule.Name) == "" {
+		return 0, fmt.Errorf("the rule needs a name")
+	}
+	data, err := autotag.Marshal(rule)
+	if err != nil {
+		return 0, err
+	}
+	if rule.Id == 0 {
+		result, err := db.Exec("INSERT INTO TagRule (name, enabled, rule) VALUES (?, ?, ?)", rule.Name, rule.Enabled, data)
+		if err != nil {
+			return 0, err
+		}
+		id, err := result.LastInsertId()
+		return int(id), err
+	}
+	_, err = db.Exec("UPDATE TagRule SET name = ?, enabled = ?, rule = ? WHERE id = ?", rule.Name, rule.Enabled, data, rule.Id)
+	return rule.Id, err
+}
+
+func SetTagRuleEnabled(db *sql.DB, ruleId int, enabled bool) error {
+	_, err := db.Exec("UPDATE TagRule SET enabled = ? WHERE id = ?", enabled, ruleId)
+	return err
+}
+
+func DeleteTagRule(db *sql.DB, ruleId int) error {
+	_, err := db.Exec("DELETE FROM TagRule WHERE id = ?", ruleId)
+	return err
+}
+
+// Returns the rules that run at discovery
+func enabledTagRules(db *sql.DB) ([]autotag.Rule, error) {
+	rules, err := GetTagRules(db)
+	if err != nil {
+		return nil, err
+	}
+	var enabled []autotag.Rule
+	for _, rule := range rules {
+		if rule.Enabled {
+			enabled = append(enabled, rule)
+		}
+	}
+	return enabled, nil
+}
+
+// Files tagged per transaction by a real run, the write lock is given up between batches
+const tagRuleBatch = 200
+
+// Runs the rules on the files, nil runs them on the whole library. Added tags are created when they do not exist
+// and bring the tags they imply along. With dryRun nothing is written, the result tells what a real run would
+// change. progress is told how many files were done, it can be nil. A real run commits the files in batches,
+// cancelling the context stops it before the next batch and keeps the batches already done.
+func RunTagRules(ctx context.Context, db *sql.DB, rules []autotag.Rule, fileIds []int, dryRun bool, progress func(done int, total int)) (AutoTagResult, error) {
+	files, err := getFilePaths(db, fileIds)
+	if err != nil {
+		return AutoTagResult{}, err
+	}
+	if dryRun {
+		return previewTagRules(ctx, db, rules, files, progress)
+	}
+
+	var result AutoTagResult
+	// tag ids are looked up once per name, added tags with their implied tags
+	added := map[string][]int{}
+	for start := 0; start < len(files); start += tagRuleBatch {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		batch := files[start:min(start+tagRuleBatch, len(files))]
+		done, err := runTagRuleBatch(db, rules, batch, added, func(i int) {
+			if progress != nil {
+				progress(start+i+1, len(files))
+			}
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Files += done.Files
+		result.Added += done.Added
+		result.Removed += done.Removed
+	}
+	return result, nil
+}
+
+// Runs the rules on the files in one transaction. added caches the ids of added tags across batches.
+func runTagRuleBatch(db *sql.DB, rules []autotag.Rule, files []filePath, added map[string][]int, done func(i int)) (AutoTagResult, error) {
+	var result AutoTagResult
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	addTag := func(file filePath, name string) (int, error) {
+		ids, ok := added[name]
+		if !ok {
+			tagId, err := ensureTagPath(tx, name, colorutils.AutoColor(tagpath.Clean(name)))
+			if err != nil {
+				return 0, err
+			}
+			if ids, err = withImpliedTags(tx, []int{tagId}); err != nil {
+				return 0, err
+			}
+			added[name] = ids
+		}
+		count := 0
+		for _, tagId := range ids {
+			changed, err := tx.Exec(`INSERT INTO FileTag (fileId, tagId) SELECT ?1, ?2
+				WHERE NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = ?1 AND tagId = ?2)`, file.id, tagId)
+			if err != nil {
+				return 0, fmt.Errorf("failed to tag %s: %w", file.path, err)
+			}
+			rows, _ := changed.RowsAffected()
+			count += int(rows)
+		}
+		return count, nil
+	}
+	removeTag := func(file filePath, name string) (int, error) {
+		tagId, err := lookupTag(tx, name)
+		if err != nil || tagId == 0 {
+			return 0, err
+		}
+		changed, err := tx.Exec("DELETE FROM FileTag WHERE fileId = ? AND tagId = ?", file.id, tagId)
+		if err != nil {
+			return 0, fmt.Errorf("failed to untag %s: %w", file.path, err)
+		}
+		rows, _ := changed.RowsAffected()
+		return int(rows), nil
+	}
+
+	for i, file := range files {
+		if err := applyTagRules(rules, file, &result, addTag, removeTag); err != nil {
+			return result, err
+		}
+		done(i)
+	}
+	return result, tx.Commit()
+}
+
+// Works out what running the rules would change by following the tags of each file in memory, the database is
+// only read
+func previewTagRules(ctx context.Context, db *sql.DB, rules []autotag.Rule, files []filePath, progress func(done int, total int)) (AutoTagResult, error) {
+	var result AutoTagResult
+	// tags a real run would create get made up negative ids
+	created := map[string]int{}
+	added := map[string][]int{}
+	lookup := func(name string) (int, error) {
+		if id, ok := created[tagpath.Clean(name)]; ok {
+			return id, nil
+		}
+		return lookupTag(db, name)
+	}
+
+	for i, file := range files {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		tags, err := fileTagSet(db, file.id)
+		if err != nil {
+			return result, err
+		}
+		addTag := func(file filePath, name string) (int, error) {
+			ids, ok := added[name]
+			if !ok {
+				tagId, err := lookup(name)
+				if err != nil {
+					return 0, err
+				}
+				if tagId == 0 {
+					tagId = -len(created) - 1
+					created[tagpath.Clean(name)] = tagId
+					ids = []int{tagId}
+				} else if ids, err = withImpliedTags(db, []int{tagId}); err != nil {
+					return 0, err
+				}
+				added[name] = ids
+			}
+			count := 0
+			for _, tagId := range ids {
+				if !tags[tagId] {
+					tags[tagId] = true
+					count++
+				}
+			}
+			return count, nil
+		}
+		removeTag := func(file filePath, name string) (int, error) {
+			tagId, err := lookup(name)
+			if err != nil || !tags[tagId] {
+				return 0, err
+			}
+			delete(tags, tagId)
+			return 1, nil
+		}
+
+		if err := applyTagRules(rules, file, &result, addTag, removeTag); err != nil {
+			return result, err
+		}
+		if progress != nil {
+			progress(i+1, len(files))
+		}
+	}
+	return result, nil
+}
+
+// Runs the rules on one file and counts what they changed, add and remove change the tags of the file and return
+// how many tags they changed
+func applyTagRules(rules []autotag.Rule, file filePath, result *AutoTagResult, add func(file filePath, name string) (int, error),
+	remove func(file filePath, name string) (int, error)) error {
+	f := autotag.NewFile(file.path)
+	matched := false
+	for _, rule := range rules {
+		ok, err := rule.Matches(f)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		if !ok {
+			continue
+		}
+		matched = true
+
+		for _, name := range rule.Add {
+			count, err := add(file, name)
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+			result.Added += count
+		}
+		for _, name := range rule.Remove {
+			count, err := remove(file, name)
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+			result.Removed += count
+		}
+	}
+	if matched {
+		result.Files++
+	}
+	return nil
+}
+
+// Returns the id of the tag or alias with the name, 0 when there is none
+func lookupTag(q querier, name string) (int, error) {
+	var tagId int
+	err := q.QueryRow("SELECT id FROM Tag WHERE name = ?1 UNION ALL SELECT tagId FROM TagAlias WHERE alias = ?1 LIMIT 1", tagpath.Clean(name)).Scan(&tagId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return tagId, err
+}
+
+// Returns the ids of the tags on the file
+func fileTagSet(db *sql.DB, fileId int) (map[int]bool, error) {
+	rows, err := db.Query("SELECT tagId FROM FileTag WHERE fileId = ?", fileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[int]bool{}
+	for rows.Next() {
+		var tagId int
+		if err := rows.Scan(&tagId); err != nil {
+			return nil, err
+		}
+		tags[tagId] = true
+	}
+	return tags, rows.Err()
+}
+
+type filePath struct {
+	id   int
+	path string
+}
+
+// Returns the ids and paths of the files, nil returns every file
+func getFilePaths(db *sql.DB, fileIds []int) ([]filePath, error) {
+	var files []filePath
+	read := func(query string, args ...interface{}) error {
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var file filePath
+			if err := rows.Scan(&file.id, &file.path); err != nil {
+				return err
+			}
+			files = append(files, file)
+		}
+		return rows.Err()
+	}
+
+	if fileIds == nil {
+		return files, read("SELECT id, path FROM File ORDER BY id")
+	}
+	for start := 0; start < len(fileIds); start += maxQueryParams {
+		chunk := fileIds[start:min(start+maxQueryParams, len(fileIds))]
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		if err := read("SELECT id, path FROM File WHERE id IN (?"+strings.Repeat(",?", len(chunk)-1)+") ORDER BY id", args...); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -3,17 +3,23 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"main/pkg/fileutils"
 	"main/pkg/imageconv"
+	"main/pkg/imageedit"
 	"main/pkg/logger"
 	"main/pkg/options"
+	"main/pkg/tagpath"
+	"main/pkg/tagquery"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -21,19 +27,31 @@ import (
 // var Db *sql.DB = nil
 var appLogger = logger.InitLogger()
 
+var ErrTagExists = errors.New("a tag with that name already exists")
+
 func Init() *sql.DB {
-	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s.db?timeout=10000&_busy_timeout=10000", runtime.GOOS))
+	db, err := Open(fmt.Sprintf("file:%s.db?timeout=10000&_busy_timeout=10000", runtime.GOOS))
+	if err != nil {
+		appLogger.Fatal(err)
+	}
+	appLogger.Println("DB connection success!")
+	return db
+}
+
+// Opens the database at the data source and adds the tables and columns it is missing, tests open an in-memory one
+func Open(dataSource string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dataSource)
 	if err != nil {
-		appLogger.Fatal("Failed to open database: ", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 	db.SetMaxOpenConns(2)
 	if err := db.Ping(); err != nil {
-		appLogger.Fatal("Failed to connect to database: ", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	appLogger.Println("DB connection success!")
 
 	setupTables(db)
-	return db
+	return db, nil
 }
 
 func setupTables(db *sql.DB) {
@@ -43,14 +61,182 @@ func setupTables(db *sql.DB) {
 		"CREATE INDEX IF NOT EXISTS idx_image_path ON File(path);", // Creates index on File.path to make searching by path faster
 		"CREATE TABLE IF NOT EXISTS `FileTag`(`id` INTEGER PRIMARY KEY NOT NULL, `fileId` INTEGER NOT NULL, `tagId` INTEGER NOT NULL);",
 		"CREATE TABLE IF NOT EXISTS `Options`(`id` INTEGER PRIMARY KEY NOT NULL, `DatabasePath` VARCHAR(255) NOT NULL, `ExcludedDirs` VARCHAR(255) NOT NULL, `Timezone` VARCHAR(1024) NOT NULL, `SortDesc` BOOLEAN DEFAULT true, `UseRGB` BOOLEAN DEFAULT false, `ImageNumber` INTEGER NOT NULL DEFAULT 20, `ThumbnailSize` INTEGER NOT NULL DEFAULT 256, `Profiling` BOOLEAN DEFAULT false, `ExifFields` VARCHAR(255), `FirstBoot` BOOLEAN DEFAULT false);",
+		"CREATE TABLE IF NOT EXISTS `FileVersion`(`id` INTEGER PRIMARY KEY NOT NULL, `fileId` INTEGER NOT NULL, `sourceId` INTEGER NOT NULL, UNIQUE(`fileId`, `sourceId`));",    // Links converted files to the file they were converted from
+		"CREATE TABLE IF NOT EXISTS `ImageEdit`(`fileId` INTEGER PRIMARY KEY NOT NULL, `edits` TEXT NOT NULL);",                                                                 // Edit stack rendered on top of the untouched file
+		"CREATE TABLE IF NOT EXISTS `Namespace`(`name` VARCHAR(255) PRIMARY KEY NOT NULL, `color` VARCHAR(7) NOT NULL);",                                                        // Color shared by the tags of a namespace
+		"CREATE TABLE IF NOT EXISTS `TagAlias`(`alias` VARCHAR(255) PRIMARY KEY NOT NULL, `tagId` INTEGER NOT NULL);",                                                           // Other names resolving to a tag
+		"CREATE TABLE IF NOT EXISTS `TagImplication`(`id` INTEGER PRIMARY KEY NOT NULL, `tagId` INTEGER NOT NULL, `impliedId` INTEGER NOT NULL, UNIQUE(`tagId`, `impliedId`));", // Tags added along with a tag
+		"CREATE TABLE IF NOT EXISTS `TagPair`(`tagId` INTEGER NOT NULL, `otherId` INTEGER NOT NULL, `files` INTEGER NOT NULL, PRIMARY KEY(`tagId`, `otherId`));",                // Files two tags are on together, kept by triggers
+		"CREATE TABLE IF NOT EXISTS `TagRule`(`id` INTEGER PRIMARY KEY NOT NULL, `name` VARCHAR(255) NOT NULL, `enabled` BOOLEAN NOT NULL DEFAULT true, `rule` TEXT NOT NULL);", // Auto-tagging rules run at discovery
+		"CREATE TABLE IF NOT EXISTS `DirectoryTag`(`fileId` INTEGER NOT NULL, `tagId` INTEGER NOT NULL, PRIMARY KEY(`fileId`, `tagId`));",                                       // File tags added from directory names
+		"CREATE TABLE IF NOT EXISTS `FileColor`(`fileId` INTEGER NOT NULL, `color` VARCHAR(7) NOT NULL, `weight` REAL NOT NULL, PRIMARY KEY(`fileId`, `color`));",               // Dominant colors found while making the thumbnail
+		"CREATE INDEX IF NOT EXISTS idx_filetag_file ON FileTag(fileId);",                                                                                                       // Tags of a file are looked up on every tag change
 		"PRAGMA journal_mode=WAL;",
 		// "INSERT INTO `Tag` (`name`, `color`) VALUES ('GIF', '#000000'), ('JPG', '#000000'), ('PNG', '#000000'), ('AVIF', '#000000'), ('WEBP', '#000000'), ('BMP', '#000000'), ('HEIC', '#000000'), ('TIFF', '#000000'), ('TIF', '#000000'), ('QOI', '#000000');",
 	}
-	for _, table := range tables {
+	tables = append(tables, tagPairTriggers...)
+	for _, table := range append(tables, directoryTagTriggers...) {
 		if _, err := db.Exec(table); err != nil {
 			appLogger.Fatal("Failed to create table: ", err)
 		}
 	}
+
+	// Columns added after the first release, databases created before them need the columns added
+	columns := []string{
+		"ALTER TABLE `Options` ADD COLUMN `ArchiveDir` VARCHAR(1024) NOT NULL DEFAULT '';",
+		"ALTER TABLE `Options` ADD COLUMN `ArchiveName` VARCHAR(255) NOT NULL DEFAULT '{date}';",
+		"ALTER TABLE `Options` ADD COLUMN `ConvertPresets` TEXT NOT NULL DEFAULT '{}';",
+		"ALTER TABLE `Options` ADD COLUMN `Shortcuts` TEXT NOT NULL DEFAULT '{}';",
+		"ALTER TABLE `Options` ADD COLUMN `DirectoryTags` TEXT NOT NULL DEFAULT '{}';",
+		"ALTER TABLE `Tag` ADD COLUMN `parentId` INTEGER;",                             // Parent of a nested tag, the name holds the whole path
+		"ALTER TABLE `Tag` ADD COLUMN `namespace` VARCHAR(255) NOT NULL DEFAULT '';",   // Namespace of tags like artist:someone
+		"ALTER TABLE `Tag` ADD COLUMN `lastUsed` INTEGER NOT NULL DEFAULT 0;",          // Unix time the tag was last added to a file
+		"ALTER TABLE `Tag` ADD COLUMN `fromDirectory` BOOLEAN NOT NULL DEFAULT false;", // Made by directory tagging, deleted with its tags
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			appLogger.Fatal("Failed to add column: ", err)
+		}
+	}
+
+	if err := linkTagParents(db); err != nil {
+		appLogger.Println("Failed to link nested tags to their parents: ", err)
+	}
+	if err := updateNamespaces(db); err != nil {
+		appLogger.Println("Failed to set the namespaces of tags: ", err)
+	}
+	if err := fillTagPairs(db); err != nil {
+		appLogger.Println("Failed to count the tags used together: ", err)
+	}
+}
+
+// Namespace of a tag computed from its name the same way as tagpath.SplitNamespace
+const namespaceExpr = `CASE WHEN instr(name, ':') > 1 AND instr(name, ':') < length(name)
+	AND instr(substr(name, 1, instr(name, ':')), '/') = 0
+	THEN substr(name, 1, instr(name, ':') - 1) ELSE '' END`
+
+// Sets the namespace of every tag from its name, run after tags were renamed
+func updateNamespaces(q querier) error {
+	_, err := q.Exec("UPDATE Tag SET namespace = " + namespaceExpr + " WHERE namespace != " + namespaceExpr)
+	return err
+}
+
+// Runs queries on the database or inside a transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Links tags named with a path before tags had parents, the missing parents are created
+func linkTagParents(db *sql.DB) error {
+	rows, err := db.Query("SELECT name, color FROM Tag WHERE name LIKE '%/%' AND parentId IS NULL")
+	if err != nil {
+		return err
+	}
+	var names, colors []string
+	for rows.Next() {
+		var name, color string
+		if err := rows.Scan(&name, &color); err != nil {
+			rows.Close()
+			return err
+		}
+		names, colors = append(names, name), append(colors, color)
+	}
+	rows.Close()
+
+	for i, name := range names {
+		if _, err := ensureTagPath(db, name, colors[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the id of the tag with the path, creating it and any missing parents with the color. Tags in a
+// namespace with its own color get that color instead.
+func ensureTagPath(q querier, path string, color string) (int, error) {
+	tagId, _, err := createTagPath(q, path, color)
+	return tagId, err
+}
+
+// Same as ensureTagPath, also returns the ids of the levels it created
+func createTagPath(q querier, path string, color string) (int, []int, error) {
+	levels := strings.Split(tagpath.Clean(path), tagpath.Separator)
+	if levels[0] == "" {
+		return 0, nil, fmt.Errorf("tag name cannot be empty")
+	}
+
+	var aliased int
+	err := q.QueryRow("SELECT tagId FROM TagAlias WHERE alias = ?", tagpath.Clean(path)).Scan(&aliased)
+	if err == nil {
+		return aliased, nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, nil, err
+	}
+
+	var tagId int
+	var created []int
+	var parentId sql.NullInt64
+	name := ""
+	for _, level := range levels {
+		if name != "" {
+			name += tagpath.Separator
+		}
+		name += level
+
+		namespace, _ := tagpath.SplitNamespace(name)
+		result, err := q.Exec(`INSERT INTO Tag (name, color, parentId, namespace)
+			SELECT ?1, COALESCE((SELECT color FROM Namespace WHERE name = ?4), ?2), ?3, ?4
+			WHERE NOT EXISTS (SELECT 1 FROM Tag WHERE name = ?1)`, name, color, parentId, namespace)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to create tag %s: %w", name, err)
+		}
+		if err := q.QueryRow("SELECT id FROM Tag WHERE name = ?", name).Scan(&tagId); err != nil {
+			return 0, nil, err
+		}
+		if rows, _ := result.RowsAffected(); rows > 0 {
+			created = append(created, tagId)
+		}
+		// tags made before they had parents get linked on the way
+		if parentId.Valid {
+			if _, err := q.Exec("UPDATE Tag SET parentId = ? WHERE id = ? AND parentId IS NULL", parentId, tagId); err != nil {
+				return 0, nil, err
+			}
+		}
+		parentId = sql.NullInt64{Int64: int64(tagId), Valid: true}
+	}
+	return tagId, created, nil
+}
+
+// Returns the id of the tag, a slash separated path like Places/Latvia/Riga creates every missing level
+func EnsureTag(db *sql.DB, path string, color string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	tagId, err := ensureTagPath(tx, path, color)
+	if err != nil {
+		return 0, err
+	}
+	return tagId, tx.Commit()
+}
+
+// Creates a new tag, parents missing from its path are created with the same color. Names used by an alias are
+// taken too.
+func CreateTag(db *sql.DB, path string, color string) (int, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM Tag WHERE name = ?1) OR EXISTS (SELECT 1 FROM TagAlias WHERE alias = ?1)"
+	if err := db.QueryRow(query, tagpath.Clean(path)).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, ErrTagExists
+	}
+	return EnsureTag(db, path, color)
 }
 
 func VacuumDb(db *sql.DB) error {
@@ -96,6 +282,25 @@ func GetImageCount(db *sql.DB) int {
 	return imgCount
 }
 
+// Returns the path of every file in the order the library is listed in, newest first
+func GetImagePaths(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT path FROM File ORDER BY dateAdded DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
 func GetImagesFromDatabase(db *sql.DB, page int, imageCount uint) ([]string, error) {
 	images, err := db.Query("SELECT path FROM File ORDER BY dateAdded DESC LIMIT ?,?", page, imageCount)
 	if err != nil {
@@ -135,8 +340,18 @@ func GetDate(db *sql.DB, path string) string {
 	return date
 }
 
+// Tags matching the name or one of their aliases and every tag nested under them, a search for a parent also
+// finds its descendants
+const matchedTagsQuery = `WITH RECURSIVE Matched(id) AS (
+		SELECT id FROM Tag WHERE name LIKE ?1
+		UNION
+		SELECT tagId FROM TagAlias WHERE alias LIKE ?1
+		UNION
+		SELECT Tag.id FROM Tag JOIN Matched ON Tag.parentId = Matched.id
+	)`
+
 func GetImagePathsByTag(db *sql.DB, tagName string) ([]string, error) {
-	query := `SELECT DISTINCT File.path FROM File JOIN FileTag ON File.id = FileTag.fileId JOIN Tag ON FileTag.tagId = Tag.id WHERE Tag.name LIKE ?`
+	query := matchedTagsQuery + ` SELECT DISTINCT File.path FROM File JOIN FileTag ON File.id = FileTag.fileId WHERE FileTag.tagId IN (SELECT id FROM Matched)`
 
 	// appLogger.Println("Searchable Tag: ", tagName)
 
@@ -186,14 +401,59 @@ func GetImagePathsByTag(db *sql.DB, tagName string) ([]string, error) {
 	return paths, nil
 }
 
+// Returns the files matching a search like `artist:* -project:old`, newest first. Every word has to match a tag
+// of the file or one of its aliases, or with a leading - must not, and matching a parent tag includes its
+// descendants. See
+// tagquery.Parse for the syntax, an empty search returns the first page of files.
+func SearchFiles(db *sql.DB, query string) ([]string, error) {
+	terms := tagquery.Parse(query)
+	if len(terms) == 0 {
+		return GetImagesFromDatabase(db, 0, 20)
+	}
+
+	var matched, conditions []string
+	var args []interface{}
+	for i, term := range terms {
+		matched = append(matched, fmt.Sprintf(`Matched%[1]d(id) AS (
+			SELECT id FROM Tag WHERE name LIKE ?%[2]d ESCAPE '\'
+			UNION
+			SELECT tagId FROM TagAlias WHERE alias LIKE ?%[2]d ESCAPE '\'
+			UNION
+			SELECT Tag.id FROM Tag JOIN Matched%[1]d ON Tag.parentId = Matched%[1]d.id
+		)`, i, i+1))
+		args = append(args, term.Pattern)
+		operator := "IN"
+		if term.Exclude {
+			operator = "NOT IN"
+		}
+		conditions = append(conditions, fmt.Sprintf("File.id %s (SELECT fileId FROM FileTag WHERE tagId IN (SELECT id FROM Matched%d))", operator, i))
+	}
+
+	rows, err := db.Query("WITH RECURSIVE "+strings.Join(matched, ", ")+
+		" SELECT File.path FROM File WHERE "+strings.Join(conditions, " AND ")+" ORDER BY File.dateAdded DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
 // Function to handle tag-based search
 func SearchImagesByTag(db *sql.DB, tagName string) ([]string, error) {
-	query := `
+	query := matchedTagsQuery + `
 		SELECT DISTINCT File.path
 		FROM File
 		JOIN FileTag ON File.id = FileTag.fileId
-		JOIN Tag ON FileTag.tagId = Tag.id
-		WHERE Tag.name LIKE ?;
+		WHERE FileTag.tagId IN (SELECT id FROM Matched);
 	`
 
 	rows, err := db.Query(query, tagName)
@@ -224,20 +484,19 @@ func replaceHomeDir(path string) string {
 	return strings.Replace(path, homeDir, "~", 1)
 }
 
-func DiscoverImages(db *sql.DB, blacklist map[string]int) (bool, error) {
-	userHome, err := os.UserHomeDir()
+// Adds the images under the discovery roots to the database. New files are tagged with their extension, the
+// names of their directories when directoryTags is enabled and the enabled auto-tagging rules.
+func DiscoverImages(db *sql.DB, blacklist map[string]int, directoryTags options.DirectoryTagOptions) (bool, error) {
+	directories, err := DiscoveryRoots()
 	if err != nil {
-		return false, fmt.Errorf("error getting user home directory: %w", err)
+		return false, err
 	}
 
 	var count int = 0
+	var newFiles []filePath
 
 	appLogger.Println("Discovery started.")
 
-	directories := []string{
-		filepath.Join(userHome),
-	}
-
 	appLogger.Println("Home dir: ", directories)
 
 	// adds context so we can cancel the operation
@@ -295,6 +554,9 @@ func DiscoverImages(db *sql.DB, blacklist map[string]int) (bool, error) {
 					return fmt.Errorf("failed to insert image into database: %w", err)
 				}
 				lastId, _ := insertId.LastInsertId()
+				if rows, _ := insertId.RowsAffected(); rows > 0 {
+					newFiles = append(newFiles, filePath{id: int(lastId), path: path})
+				}
 
 				extension := filepath.Ext(path)[1:]
 				// extension = strings.TrimPrefix(extension, ".") // replace with slice 1 from front instead
@@ -317,6 +579,34 @@ func DiscoverImages(db *sql.DB, blacklist map[string]int) (bool, error) {
 		}
 	}
 
+	if len(newFiles) > 0 && directoryTags.Enabled {
+		added, err := addDirectoryTags(context.Background(), db, directories, newFiles, directoryTags)
+		if err != nil {
+			appLogger.Println("Failed to add directory tags: ", err)
+		} else {
+			appLogger.Println("Added ", added, " directory tags to new files.")
+		}
+	}
+
+	// the user's auto-tagging rules tag the new files after the extension and directory tags
+	if len(newFiles) > 0 {
+		fileIds := make([]int, len(newFiles))
+		for i, file := range newFiles {
+			fileIds[i] = file.id
+		}
+		rules, err := enabledTagRules(db)
+		if err != nil {
+			appLogger.Println("Failed to load the auto-tagging rules: ", err)
+		} else if len(rules) > 0 {
+			result, err := RunTagRules(context.Background(), db, rules, fileIds, false, nil)
+			if err != nil {
+				appLogger.Println("Failed to run the auto-tagging rules: ", err)
+			} else {
+				appLogger.Println("Auto-tagging added ", result.Added, " and removed ", result.Removed, " tags on ", result.Files, " new files.")
+			}
+		}
+	}
+
 	appLogger.Println("DISCOVERY COMPLETE. Added or Discovered ", count, " new files.")
 
 	return true, nil
@@ -351,12 +641,705 @@ func GetTags(db *sql.DB) (map[int]string, error) {
 	return tag, nil
 }
 
+// Returns the md5 stored for each of the paths, paths that are not in the database are hashed from disk
+func GetFileHashes(db *sql.DB, paths []string) (map[string]string, error) {
+	hashes := make(map[string]string, len(paths))
+	for _, path := range paths {
+		var hash string
+		err := db.QueryRow("SELECT md5 FROM File WHERE path = ?", path).Scan(&hash)
+		if err == sql.ErrNoRows {
+			hash, err = fileutils.GetFileMD5HashBuffered(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get md5 of %s: %w", path, err)
+		}
+		hashes[path] = hash
+	}
+	return hashes, nil
+}
+
+// Returns the name of the tag shared by most of the files, ignoring the image type tags
+func GetCommonTag(db *sql.DB, paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+
+	args := make([]interface{}, 0, len(paths)+len(imageconv.ImageTypes))
+	for _, path := range paths {
+		args = append(args, path)
+	}
+	for _, imageType := range imageconv.ImageTypes {
+		args = append(args, imageType)
+	}
+
+	query := `SELECT Tag.name FROM Tag
+		JOIN FileTag ON FileTag.tagId = Tag.id
+		JOIN File ON File.id = FileTag.fileId
+		WHERE File.path IN (?` + strings.Repeat(",?", len(paths)-1) + `)
+		AND Tag.name NOT IN (?` + strings.Repeat(",?", len(imageconv.ImageTypes)-1) + `)
+		GROUP BY Tag.id ORDER BY COUNT(DISTINCT File.id) DESC, Tag.name LIMIT 1`
+
+	var tagName string
+	err := db.QueryRow(query, args...).Scan(&tagName)
+	if err != nil && err != sql.ErrNoRows {
+		appLogger.Println("Error getting common tag:", err)
+	}
+	return tagName
+}
+
+// Adds a converted file to the database right away and gives it the tags of its source, except the image type tag
+// which is set from the output. The output is linked to its source in FileVersion, with tagSource it is also
+// tagged converted-from:<source format>.
+func AddConvertedFile(db *sql.DB, source string, output string, tagSource bool) error {
+	hash, err := fileutils.GetFileMD5HashBuffered(output)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", output, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// md5 is unique, an output identical to a file already in the database is linked through that file
+	var fileId int
+	err = tx.QueryRow("SELECT id FROM File WHERE md5 = ?", hash).Scan(&fileId)
+	if err == sql.ErrNoRows {
+		// converting again overwrites the output so its hash is updated instead of adding it twice
+		_, err = tx.Exec(`INSERT INTO File (path, dateAdded, md5) VALUES (?, DATETIME('now'), ?)
+			ON CONFLICT(path) DO UPDATE SET md5 = excluded.md5`, output, hash)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to the database: %w", output, err)
+		}
+		err = tx.QueryRow("SELECT id FROM File WHERE path = ?", output).Scan(&fileId)
+	}
+	if err != nil {
+		return err
+	}
+
+	var sourceId int
+	err = tx.QueryRow("SELECT id FROM File WHERE path = ?", source).Scan(&sourceId)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	hasSource := err == nil
+	if hasSource && sourceId == fileId {
+		// the output has the same bytes as the source, there is nothing to link
+		return nil
+	}
+
+	addTag := func(tagId int) error {
+		_, err := tx.Exec(`INSERT INTO FileTag (fileId, tagId) SELECT ?, ?
+			WHERE NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = ? AND tagId = ?)`, fileId, tagId, fileId, tagId)
+		return err
+	}
+	tagByName := func(name string) (int, error) {
+		return ensureTagPath(tx, name, "#373c40")
+	}
+
+	outputType := strings.ToUpper(strings.TrimPrefix(filepath.Ext(output), "."))
+	typeId, err := tagByName(outputType)
+	if err != nil {
+		return fmt.Errorf("failed to get tag %s: %w", outputType, err)
+	}
+	if err := addTag(typeId); err != nil {
+		return fmt.Errorf("failed to tag %s: %w", output, err)
+	}
+
+	if hasSource {
+		args := []interface{}{fileId, sourceId}
+		for _, imageType := range imageconv.ImageTypes {
+			args = append(args, imageType)
+		}
+		args = append(args, fileId)
+		_, err = tx.Exec(`INSERT INTO FileTag (fileId, tagId)
+			SELECT DISTINCT ?, FileTag.tagId FROM FileTag JOIN Tag ON Tag.id = FileTag.tagId
+			WHERE FileTag.fileId = ? AND Tag.name NOT IN (?`+strings.Repeat(",?", len(imageconv.ImageTypes)-1)+`)
+			AND FileTag.tagId NOT IN (SELECT tagId FROM FileTag WHERE fileId = ?)`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to copy tags to %s: %w", output, err)
+		}
+
+		if _, err := tx.Exec("INSERT OR IGNORE INTO FileVersion (fileId, sourceId) VALUES (?, ?)", fileId, sourceId); err != nil {
+			return fmt.Errorf("failed to link %s to its source: %w", output, err)
+		}
+	}
+
+	if tagSource {
+		sourceType := strings.ToLower(strings.TrimPrefix(filepath.Ext(source), "."))
+		convertedId, err := tagByName("converted-from:" + sourceType)
+		if err != nil {
+			return fmt.Errorf("failed to get converted-from tag: %w", err)
+		}
+		if err := addTag(convertedId); err != nil {
+			return fmt.Errorf("failed to tag %s: %w", output, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Returns the files the file was converted from and the files converted from it, following the whole chain
+func GetFileVersions(db *sql.DB, path string) ([]string, error) {
+	rows, err := db.Query(`
+		WITH RECURSIVE Related(id) AS (
+			SELECT id FROM File WHERE path = ?
+			UNION
+			SELECT CASE WHEN FileVersion.fileId = Related.id THEN FileVersion.sourceId ELSE FileVersion.fileId END
+			FROM FileVersion JOIN Related ON Related.id IN (FileVersion.fileId, FileVersion.sourceId)
+		)
+		SELECT File.path FROM File JOIN Related ON Related.id = File.id
+		WHERE File.path != ? ORDER BY File.dateAdded, File.path`, path, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// Removes the file and everything linked to it from the database, the file itself is not touched
+func RemoveFile(db *sql.DB, path string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fileId int
+	if err := tx.QueryRow("SELECT id FROM File WHERE path = ?", path).Scan(&fileId); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	queries := []string{
+		"DELETE FROM FileTag WHERE fileId = ?",
+		"DELETE FROM ImageEdit WHERE fileId = ?",
+		"DELETE FROM FileColor WHERE fileId = ?",
+		"DELETE FROM FileVersion WHERE fileId = ?1 OR sourceId = ?1",
+		"DELETE FROM File WHERE id = ?",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, fileId); err != nil {
+			return fmt.Errorf("failed to remove %s from the database: %w", path, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Returns the edit stack of the file, files that were never edited have none
+func GetEdits(db *sql.DB, path string) ([]imageedit.Edit, error) {
+	var edits string
+	err := db.QueryRow("SELECT ImageEdit.edits FROM ImageEdit JOIN File ON File.id = ImageEdit.fileId WHERE File.path = ?", path).Scan(&edits)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return imageedit.Parse(edits)
+}
+
+// Replaces the edit stack of the file, an empty stack removes it
+func SetEdits(db *sql.DB, path string, edits []imageedit.Edit) error {
+	// edits change the colors, the palette is found again with the next thumbnail
+	if _, err := db.Exec("DELETE FROM FileColor WHERE fileId = (SELECT id FROM File WHERE path = ?)", path); err != nil {
+		return err
+	}
+	if len(edits) == 0 {
+		_, err := db.Exec("DELETE FROM ImageEdit WHERE fileId = (SELECT id FROM File WHERE path = ?)", path)
+		return err
+	}
+
+	data, err := imageedit.Marshal(edits)
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(`INSERT INTO ImageEdit (fileId, edits) SELECT id, ? FROM File WHERE path = ?
+		ON CONFLICT(fileId) DO UPDATE SET edits = excluded.edits`, data, path)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%s is not in the database", path)
+	}
+	return nil
+}
+
 func GetTagColorById(db *sql.DB, tagId int) (string, error) {
 	var tagColor string
 	err := db.QueryRow("SELECT color FROM Tag WHERE id = ?", tagId).Scan(&tagColor)
 	return tagColor, err
 }
 
+// Returns every tag with the number of files that have it and when it was last used, sorted as a tree
+func GetTagUsage(db *sql.DB) ([]TagCount, error) {
+	rows, err := db.Query(`SELECT Tag.id, Tag.name, Tag.color, Tag.lastUsed, COUNT(DISTINCT FileTag.fileId) FROM Tag
+		LEFT JOIN FileTag ON FileTag.tagId = Tag.id GROUP BY Tag.id ORDER BY Tag.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []TagCount
+	for rows.Next() {
+		var tag TagCount
+		var lastUsed int64
+		if err := rows.Scan(&tag.Id, &tag.Name, &tag.Color, &lastUsed, &tag.Files); err != nil {
+			return nil, err
+		}
+		if lastUsed > 0 {
+			tag.LastUsed = time.Unix(lastUsed, 0)
+		}
+		tags = append(tags, tag)
+	}
+	slices.SortFunc(tags, func(a, b TagCount) int { return tagpath.Compare(a.Name, b.Name) })
+	return tags, rows.Err()
+}
+
+// Renames and recolors the tag, the files keep it. Renaming moves the tags nested under it along, a new parent
+// path is created when it does not exist yet.
+func UpdateTag(db *sql.DB, tagId int, name string, color string) error {
+	name = tagpath.Clean(name)
+	if name == "" {
+		return fmt.Errorf("tag name cannot be empty")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldName string
+	if err := tx.QueryRow("SELECT name FROM Tag WHERE id = ?", tagId).Scan(&oldName); err != nil {
+		return err
+	}
+	if tagpath.IsDescendant(name, oldName) {
+		return fmt.Errorf("%s can not be moved under itself", oldName)
+	}
+
+	if _, err := tx.Exec("UPDATE Tag SET color = ? WHERE id = ?", color, tagId); err != nil {
+		return err
+	}
+	if name != oldName {
+		if err := moveTag(tx, tagId, oldName, name, color); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Renames the tag and its descendants and links it to the parent of the new path
+func moveTag(tx *sql.Tx, tagId int, oldName string, name string, color string) error {
+	_, err := tx.Exec("UPDATE Tag SET name = ?1 || substr(name, ?2) WHERE name = ?3 OR substr(name, 1, ?2) = ?3 || ?4",
+		name, utf8.RuneCountInString(oldName)+1, oldName, tagpath.Separator)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrTagExists
+		}
+		return err
+	}
+
+	var parentId sql.NullInt64
+	if parent := tagpath.Parent(name); parent != "" {
+		id, err := ensureTagPath(tx, parent, color)
+		if err != nil {
+			return err
+		}
+		parentId = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+	if _, err := tx.Exec("UPDATE Tag SET parentId = ? WHERE id = ?", parentId, tagId); err != nil {
+		return err
+	}
+	return updateNamespaces(tx)
+}
+
+// Removes the tag and every tag nested under it from the files and deletes them
+func DeleteTag(db *sql.DB, tagId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	subtree := `WITH RECURSIVE Subtree(id) AS (
+			SELECT ?
+			UNION
+			SELECT Tag.id FROM Tag JOIN Subtree ON Tag.parentId = Subtree.id
+		) `
+	if _, err := tx.Exec(subtree+"DELETE FROM FileTag WHERE tagId IN (SELECT id FROM Subtree)", tagId); err != nil {
+		return fmt.Errorf("failed to remove tag %d from its files: %w", tagId, err)
+	}
+	if _, err := tx.Exec(subtree+"DELETE FROM TagAlias WHERE tagId IN (SELECT id FROM Subtree)", tagId); err != nil {
+		return fmt.Errorf("failed to remove the aliases of tag %d: %w", tagId, err)
+	}
+	_, err = tx.Exec(subtree+"DELETE FROM TagImplication WHERE tagId IN (SELECT id FROM Subtree) OR impliedId IN (SELECT id FROM Subtree)", tagId)
+	if err != nil {
+		return fmt.Errorf("failed to remove the implications of tag %d: %w", tagId, err)
+	}
+	if _, err := tx.Exec(subtree+"DELETE FROM Tag WHERE id IN (SELECT id FROM Subtree)", tagId); err != nil {
+		return fmt.Errorf("failed to delete tag %d: %w", tagId, err)
+	}
+	return tx.Commit()
+}
+
+// Moves the files of the tags to the target tag and deletes them, a file that ends up with the target twice
+// keeps one row. Tags nested under a merged tag move under the target, merging with the target's own subtags
+// of the same name.
+func MergeTags(db *sql.DB, target int, tagIds []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, tagId := range tagIds {
+		if tagId == target {
+			continue
+		}
+		if err := mergeTag(tx, target, tagId); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func mergeTag(tx *sql.Tx, target int, tagId int) error {
+	var targetName, targetColor, name string
+	if err := tx.QueryRow("SELECT name, color FROM Tag WHERE id = ?", target).Scan(&targetName, &targetColor); err != nil {
+		return err
+	}
+	if err := tx.QueryRow("SELECT name FROM Tag WHERE id = ?", tagId).Scan(&name); err != nil {
+		// already merged as part of another tag's subtree
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if tagpath.IsDescendant(targetName, name) {
+		return fmt.Errorf("%s can not be merged into %s which is nested under it", name, targetName)
+	}
+
+	rows, err := tx.Query("SELECT id, name FROM Tag WHERE parentId = ?", tagId)
+	if err != nil {
+		return err
+	}
+	var childIds []int
+	var childNames []string
+	for rows.Next() {
+		var id int
+		var childName string
+		if err := rows.Scan(&id, &childName); err != nil {
+			rows.Close()
+			return err
+		}
+		childIds, childNames = append(childIds, id), append(childNames, childName)
+	}
+	rows.Close()
+
+	for i, childId := range childIds {
+		moved := targetName + tagpath.Separator + tagpath.Leaf(childNames[i])
+		var existing int
+		err := tx.QueryRow("SELECT id FROM Tag WHERE name = ?", moved).Scan(&existing)
+		switch {
+		case err == nil:
+			err = mergeTag(tx, existing, childId)
+		case err == sql.ErrNoRows:
+			err = moveTag(tx, childId, childNames[i], moved, targetColor)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE FileTag SET tagId = ? WHERE tagId = ?", target, tagId); err != nil {
+		return fmt.Errorf("failed to move files of tag %d: %w", tagId, err)
+	}
+	if err := mergeTagRules(tx, target, tagId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Tag WHERE id = ?", tagId); err != nil {
+		return fmt.Errorf("failed to delete tag %d: %w", tagId, err)
+	}
+	_, err = tx.Exec("DELETE FROM FileTag WHERE tagId = ?1 AND id NOT IN (SELECT MIN(id) FROM FileTag WHERE tagId = ?1 GROUP BY fileId)", target)
+	if err != nil {
+		return fmt.Errorf("failed to remove duplicate file tags: %w", err)
+	}
+	return nil
+}
+
+// A namespace with the number of tags in it, Color is empty when the tags keep their own colors
+type NamespaceInfo struct {
+	Name  string
+	Color string
+	Tags  int
+}
+
+func GetNamespaces(db *sql.DB) ([]NamespaceInfo, error) {
+	rows, err := db.Query(`SELECT Tag.namespace, COALESCE(Namespace.color, ''), COUNT(*) FROM Tag
+		LEFT JOIN Namespace ON Namespace.name = Tag.namespace
+		WHERE Tag.namespace != '' GROUP BY Tag.namespace ORDER BY Tag.namespace`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var namespaces []NamespaceInfo
+	for rows.Next() {
+		var namespace NamespaceInfo
+		if err := rows.Scan(&namespace.Name, &namespace.Color, &namespace.Tags); err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, rows.Err()
+}
+
+// Colors every tag of the namespace, tags created in it later get the color too
+func SetNamespaceColor(db *sql.DB, namespace string, color string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO Namespace (name, color) VALUES (?, ?) ON CONFLICT(name) DO UPDATE SET color = excluded.color", namespace, color)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE Tag SET color = ? WHERE namespace = ?", color, namespace); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Renames the namespace of all its tags, an empty name removes the namespace from them
+func RenameNamespace(db *sql.DB, namespace string, name string) error {
+	name = strings.TrimSpace(name)
+	if strings.ContainsAny(name, ":"+tagpath.Separator) {
+		return fmt.Errorf("a namespace can not contain : or %s", tagpath.Separator)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// artist:someone keeps the colon after the new name, without a name the colon goes too
+	prefix, start := name, utf8.RuneCountInString(namespace)+1
+	if name == "" {
+		start++
+	}
+	_, err = tx.Exec("UPDATE Tag SET name = ? || substr(name, ?) WHERE namespace = ?", prefix, start, namespace)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrTagExists
+		}
+		return err
+	}
+	if err := updateNamespaces(tx); err != nil {
+		return err
+	}
+
+	if name != "" {
+		if _, err := tx.Exec("UPDATE OR IGNORE Namespace SET name = ? WHERE name = ?", name, namespace); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM Namespace WHERE name = ?", namespace); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// A tag and how many files of a selection have it
+type TagCount struct {
+	Id       int
+	Name     string
+	Color    string
+	Files    int
+	LastUsed time.Time // only set by GetTagUsage, zero when the tag was never added to a file
+}
+
+// One FileTag row added or removed by ApplyTagChanges, kept so the change can be undone
+type TagChange struct {
+	FileId int
+	TagId  int
+	Added  bool
+}
+
+// SQLite limits the number of parameters in a query so long path lists are looked up in chunks
+const maxQueryParams = 500
+
+// Returns the ids of the paths that are in the database
+func getFileIds(db *sql.DB, paths []string) ([]int, error) {
+	var ids []int
+	for start := 0; start < len(paths); start += maxQueryParams {
+		chunk := paths[start:min(start+maxQueryParams, len(paths))]
+		args := make([]interface{}, len(chunk))
+		for i, path := range chunk {
+			args[i] = path
+		}
+		rows, err := db.Query("SELECT id FROM File WHERE path IN (?"+strings.Repeat(",?", len(chunk)-1)+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// Returns every tag sorted as a tree with the number of the files that have it, and how many of the files are in
+// the database
+func GetTagCounts(db *sql.DB, paths []string) ([]TagCount, int, error) {
+	fileIds, err := getFileIds(db, paths)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	counts := map[int]int{}
+	for start := 0; start < len(fileIds); start += maxQueryParams {
+		chunk := fileIds[start:min(start+maxQueryParams, len(fileIds))]
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := db.Query("SELECT tagId, COUNT(DISTINCT fileId) FROM FileTag WHERE fileId IN (?"+strings.Repeat(",?", len(chunk)-1)+") GROUP BY tagId", args...)
+		if err != nil {
+			return nil, 0, err
+		}
+		for rows.Next() {
+			var tagId, files int
+			if err := rows.Scan(&tagId, &files); err != nil {
+				rows.Close()
+				return nil, 0, err
+			}
+			counts[tagId] += files
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	rows, err := db.Query("SELECT id, name, color FROM Tag ORDER BY name")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var tags []TagCount
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Id, &tag.Name, &tag.Color); err != nil {
+			return nil, 0, err
+		}
+		tag.Files = counts[tag.Id]
+		tags = append(tags, tag)
+	}
+	slices.SortFunc(tags, func(a, b TagCount) int { return tagpath.Compare(a.Name, b.Name) })
+	return tags, len(fileIds), rows.Err()
+}
+
+// Adds and removes tags on all the files in one transaction, files that are not in the database are skipped.
+// Tags implied by the added tags are added as well.
+// Only the rows that really changed are returned so undoing them restores the tags exactly.
+func ApplyTagChanges(db *sql.DB, paths []string, add []int, remove []int) ([]TagChange, error) {
+	fileIds, err := getFileIds(db, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// implied tags are added too and undone with the rest
+	add, err = withImpliedTags(tx, add)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []TagChange
+	for _, tagId := range add {
+		if len(fileIds) > 0 {
+			if err := touchTag(tx, tagId); err != nil {
+				return nil, err
+			}
+		}
+		for _, fileId := range fileIds {
+			result, err := tx.Exec(`INSERT INTO FileTag (fileId, tagId) SELECT ?1, ?2
+				WHERE NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = ?1 AND tagId = ?2)`, fileId, tagId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add tag %d: %w", tagId, err)
+			}
+			if rows, _ := result.RowsAffected(); rows > 0 {
+				changes = append(changes, TagChange{FileId: fileId, TagId: tagId, Added: true})
+			}
+		}
+	}
+	for _, tagId := range remove {
+		for _, fileId := range fileIds {
+			result, err := tx.Exec("DELETE FROM FileTag WHERE fileId = ? AND tagId = ?", fileId, tagId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to remove tag %d: %w", tagId, err)
+			}
+			if rows, _ := result.RowsAffected(); rows > 0 {
+				changes = append(changes, TagChange{FileId: fileId, TagId: tagId, Added: false})
+			}
+		}
+	}
+	return changes, tx.Commit()
+}
+
+// Reverts the changes returned by ApplyTagChanges in one transaction
+func UndoTagChanges(db *sql.DB, changes []TagChange) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if change.Added {
+			_, err = tx.Exec("DELETE FROM FileTag WHERE fileId = ? AND tagId = ?", change.FileId, change.TagId)
+		} else {
+			_, err = tx.Exec(`INSERT INTO FileTag (fileId, tagId) SELECT ?1, ?2
+				WHERE NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = ?1 AND tagId = ?2)`, change.FileId, change.TagId)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to undo tag change: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
 // func init() {
 // 	Db, err := sql.Open("sqlite3", "file:../index.db")
 // 	if err != nil {
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"main/pkg/colorutils"
+	"main/pkg/dirtags"
+	"main/pkg/options"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keep DirectoryTag pointing at the FileTag rows added from directory names. A record goes away with the last
+// row for its file and tag, and follows the row when a merge moves it to a tag the file did not have yet.
+var directoryTagTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS FileTagDirectoryDelete AFTER DELETE ON FileTag BEGIN
+		DELETE FROM DirectoryTag WHERE fileId = OLD.fileId AND tagId = OLD.tagId
+			AND NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = OLD.fileId AND tagId = OLD.tagId);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS FileTagDirectoryUpdate AFTER UPDATE OF fileId, tagId ON FileTag BEGIN
+		UPDATE OR IGNORE DirectoryTag SET fileId = NEW.fileId, tagId = NEW.tagId WHERE fileId = OLD.fileId AND tagId = OLD.tagId
+			AND NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = NEW.fileId AND tagId = NEW.tagId AND id != NEW.id);
+		DELETE FROM DirectoryTag WHERE fileId = OLD.fileId AND tagId = OLD.tagId
+			AND NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = OLD.fileId AND tagId = OLD.tagId);
+	END;`,
+}
+
+// Directories searched for images, directory tags are made from the path below them
+func DiscoveryRoots() ([]string, error) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("error getting user home directory: %w", err)
+	}
+	return []string{filepath.Join(userHome)}, nil
+}
+
+// Returns the deepest root the path is in, empty when it is in none
+func rootOf(roots []string, path string) string {
+	found := ""
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(root) > len(found) {
+			found = root
+		}
+	}
+	return found
+}
+
+// Tags every file of the library with the names of its directories and returns how many tags were added
+func ApplyDirectoryTags(ctx context.Context, db *sql.DB, settings options.DirectoryTagOptions) (int, error) {
+	roots, err := DiscoveryRoots()
+	if err != nil {
+		return 0, err
+	}
+	files, err := getFilePaths(db, nil)
+	if err != nil {
+		return 0, err
+	}
+	return addDirectoryTags(ctx, db, roots, files, settings)
+}
+
+// Adds the directory tags to the files in one transaction. Every added FileTag row is recorded and every tag
+// made on the way is marked so RemoveDirectoryTags only takes back what was added here.
+func addDirectoryTags(ctx context.Context, db *sql.DB, roots []string, files []filePath, settings options.DirectoryTagOptions) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	tagIds := map[string][]int{}
+	var created []int
+	added := 0
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		root := rootOf(roots, file.path)
+		if root == "" {
+			continue
+		}
+		for _, name := range dirtags.Names(root, file.path, settings) {
+			ids, ok := tagIds[name]
+			if !ok {
+				tagId, made, err := createTagPath(tx, name, colorutils.AutoColor(name))
+				if err != nil {
+					return 0, err
+				}
+				created = append(created, made...)
+				// implied tags come along and are taken back with the rest
+				if ids, err = withImpliedTags(tx, []int{tagId}); err != nil {
+					return 0, err
+				}
+				tagIds[name] = ids
+			}
+			for _, tagId := range ids {
+				result, err := tx.Exec(`INSERT INTO FileTag (fileId, tagId) SELECT ?1, ?2
+					WHERE NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = ?1 AND tagId = ?2)`, file.id, tagId)
+				if err != nil {
+					return 0, fmt.Errorf("failed to tag %s: %w", file.path, err)
+				}
+				if rows, _ := result.RowsAffected(); rows == 0 {
+					continue
+				}
+				if _, err := tx.Exec("INSERT OR IGNORE INTO DirectoryTag (fileId, tagId) VALUES (?, ?)", file.id, tagId); err != nil {
+					return 0, err
+				}
+				added++
+			}
+		}
+	}
+
+	for _, tagId := range created {
+		if _, err := tx.Exec("UPDATE Tag SET fromDirectory = true WHERE id = ?", tagId); err != nil {
+			return 0, err
+		}
+	}
+	return added, tx.Commit()
+}
+
+// Takes back the tags added from directory names and deletes the tags made for them that nothing uses anymore.
+// Tags the files had before, and tags given aliases or implications since, are kept. Returns how many tags were
+// removed from files.
+func RemoveDirectoryTags(db *sql.DB) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM FileTag WHERE EXISTS (SELECT 1 FROM DirectoryTag
+		WHERE DirectoryTag.fileId = FileTag.fileId AND DirectoryTag.tagId = FileTag.tagId)`)
+	if err != nil {
+		return 0, err
+	}
+	removed, _ := result.RowsAffected()
+	if _, err := tx.Exec("DELETE FROM DirectoryTag"); err != nil {
+		return 0, err
+	}
+
+	// parents can only go once their children are gone
+	for {
+		result, err := tx.Exec(`DELETE FROM Tag WHERE fromDirectory
+			AND NOT EXISTS (SELECT 1 FROM FileTag WHERE FileTag.tagId = Tag.id)
+			AND NOT EXISTS (SELECT 1 FROM Tag AS Child WHERE Child.parentId = Tag.id)
+			AND NOT EXISTS (SELECT 1 FROM TagAlias WHERE TagAlias.tagId = Tag.id)
+			AND NOT EXISTS (SELECT 1 FROM TagImplication WHERE TagImplication.tagId = Tag.id OR TagImplication.impliedId = Tag.id)`)
+		if err != nil {
+			return 0, err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			break
+		}
+	}
+	return removed, tx.Commit()
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"main/pkg/palette"
+	"slices"
+)
+
+// Tells whether the dominant colors of the file were stored already
+func HasPalette(db *sql.DB, path string) bool {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM FileColor JOIN File ON File.id = FileColor.fileId WHERE File.path = ?)", path).Scan(&exists)
+	if err != nil {
+		appLogger.Println("Error checking the palette of", path, err)
+	}
+	return exists
+}
+
+// Replaces the dominant colors of the file
+func SavePalette(db *sql.DB, path string, swatches []palette.Swatch) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fileId int
+	if err := tx.QueryRow("SELECT id FROM File WHERE path = ?", path).Scan(&fileId); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM FileColor WHERE fileId = ?", fileId); err != nil {
+		return err
+	}
+	for _, swatch := range swatches {
+		if _, err := tx.Exec("INSERT INTO FileColor (fileId, color, weight) VALUES (?, ?, ?)", fileId, swatch.Color, swatch.Weight); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Returns the dominant colors of the file, the largest first. Files without thumbnails made yet have none.
+func GetPalette(db *sql.DB, fileId int) ([]palette.Swatch, error) {
+	rows, err := db.Query("SELECT color, weight FROM FileColor WHERE fileId = ? ORDER BY weight DESC", fileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var swatches []palette.Swatch
+	for rows.Next() {
+		var swatch palette.Swatch
+		if err := rows.Scan(&swatch.Color, &swatch.Weight); err != nil {
+			return nil, err
+		}
+		swatches = append(swatches, swatch)
+	}
+	return swatches, rows.Err()
+}
+
+// Returns the files with a dominant color within maxDistance of the hex color, the closest first
+func SearchFilesByColor(db *sql.DB, hex string, maxDistance float64) ([]string, error) {
+	rows, err := db.Query("SELECT File.path, FileColor.color, FileColor.weight FROM FileColor JOIN File ON File.id = FileColor.fileId ORDER BY File.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	palettes := map[string][]palette.Swatch{}
+	for rows.Next() {
+		var path string
+		var swatch palette.Swatch
+		if err := rows.Scan(&path, &swatch.Color, &swatch.Weight); err != nil {
+			return nil, err
+		}
+		if _, ok := palettes[path]; !ok {
+			paths = append(paths, path)
+		}
+		palettes[path] = append(palettes[path], swatch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	distances := map[string]float64{}
+	var matches []string
+	for _, path := range paths {
+		if d, ok := palette.Match(palettes[path], hex, maxDistance); ok {
+			distances[path] = d
+			matches = append(matches, path)
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b string) int {
+		switch {
+		case distances[a] < distances[b]:
+			return -1
+		case distances[a] > distances[b]:
+			return 1
+		}
+		return 0
+	})
+	return matches, nil
+}
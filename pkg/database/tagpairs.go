@@ -0,0 +1,150 @@
+package database
+
+import (
+	"database/sql"
+	"main/pkg/tagsuggest"
+	"strings"
+)
+
+// Keep TagPair counting the files every two tags are on together while FileTag rows are added, removed and
+// moved by merges. Both orders of a pair are stored so the tags of a file can be looked up directly, the pair of
+// a tag with itself counts the files of the tag.
+var tagPairTriggers = []string{
+	// made before the tags counted their own files
+	"DROP TRIGGER IF EXISTS FileTagPairInsert;",
+	"DROP TRIGGER IF EXISTS FileTagPairDelete;",
+	"DROP TRIGGER IF EXISTS FileTagPairUpdate;",
+	`CREATE TRIGGER IF NOT EXISTS TagPairInsert AFTER INSERT ON FileTag BEGIN
+		INSERT INTO TagPair (tagId, otherId, files) SELECT NEW.tagId, tagId, 1 FROM FileTag WHERE fileId = NEW.fileId AND tagId != NEW.tagId
+			ON CONFLICT (tagId, otherId) DO UPDATE SET files = files + 1;
+		INSERT INTO TagPair (tagId, otherId, files) SELECT tagId, NEW.tagId, 1 FROM FileTag WHERE fileId = NEW.fileId AND tagId != NEW.tagId
+			ON CONFLICT (tagId, otherId) DO UPDATE SET files = files + 1;
+		INSERT INTO TagPair (tagId, otherId, files) VALUES (NEW.tagId, NEW.tagId, 1)
+			ON CONFLICT (tagId, otherId) DO UPDATE SET files = files + 1;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS TagPairDelete AFTER DELETE ON FileTag BEGIN
+		UPDATE TagPair SET files = files - (SELECT COUNT(*) FROM FileTag WHERE fileId = OLD.fileId AND tagId = TagPair.otherId)
+			WHERE tagId = OLD.tagId AND otherId != OLD.tagId AND otherId IN (SELECT tagId FROM FileTag WHERE fileId = OLD.fileId);
+		UPDATE TagPair SET files = files - (SELECT COUNT(*) FROM FileTag WHERE fileId = OLD.fileId AND tagId = TagPair.tagId)
+			WHERE otherId = OLD.tagId AND tagId != OLD.tagId AND tagId IN (SELECT tagId FROM FileTag WHERE fileId = OLD.fileId);
+		UPDATE TagPair SET files = files - 1 WHERE tagId = OLD.tagId AND otherId = OLD.tagId;
+		DELETE FROM TagPair WHERE files <= 0;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS TagPairUpdate AFTER UPDATE OF fileId, tagId ON FileTag BEGIN
+		UPDATE TagPair SET files = files - (SELECT COUNT(*) FROM FileTag WHERE fileId = OLD.fileId AND tagId = TagPair.otherId AND id != NEW.id)
+			WHERE tagId = OLD.tagId AND otherId != OLD.tagId AND otherId IN (SELECT tagId FROM FileTag WHERE fileId = OLD.fileId AND id != NEW.id);
+		UPDATE TagPair SET files = files - (SELECT COUNT(*) FROM FileTag WHERE fileId = OLD.fileId AND tagId = TagPair.tagId AND id != NEW.id)
+			WHERE otherId = OLD.tagId AND tagId != OLD.tagId AND tagId IN (SELECT tagId FROM FileTag WHERE fileId = OLD.fileId AND id != NEW.id);
+		UPDATE TagPair SET files = files - 1 WHERE tagId = OLD.tagId AND otherId = OLD.tagId;
+		DELETE FROM TagPair WHERE files <= 0;
+		INSERT INTO TagPair (tagId, otherId, files) SELECT NEW.tagId, tagId, 1 FROM FileTag WHERE fileId = NEW.fileId AND tagId != NEW.tagId AND id != NEW.id
+			ON CONFLICT (tagId, otherId) DO UPDATE SET files = files + 1;
+		INSERT INTO TagPair (tagId, otherId, files) SELECT tagId, NEW.tagId, 1 FROM FileTag WHERE fileId = NEW.fileId AND tagId != NEW.tagId AND id != NEW.id
+			ON CONFLICT (tagId, otherId) DO UPDATE SET files = files + 1;
+		INSERT INTO TagPair (tagId, otherId, files) VALUES (NEW.tagId, NEW.tagId, 1)
+			ON CONFLICT (tagId, otherId) DO UPDATE SET files = files + 1;
+	END;`,
+}
+
+// Counts the pairs of the tags added before the triggers existed, pairs only while TagPair is empty and the files
+// of every tag while no tag has its count
+func fillTagPairs(db *sql.DB) error {
+	_, err := db.Exec(`INSERT INTO TagPair (tagId, otherId, files)
+		SELECT a.tagId, b.tagId, COUNT(*) FROM FileTag AS a JOIN FileTag AS b ON a.fileId = b.fileId AND a.tagId != b.tagId
+		WHERE NOT EXISTS (SELECT 1 FROM TagPair) GROUP BY a.tagId, b.tagId`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO TagPair (tagId, otherId, files) SELECT tagId, tagId, COUNT(*) FROM FileTag
+		WHERE NOT EXISTS (SELECT 1 FROM TagPair WHERE tagId = otherId) GROUP BY tagId`)
+	return err
+}
+
+// Runs the query once per chunk of ids, the ids replace the ? after IN
+func queryChunks(db *sql.DB, query string, ids []int, scan func(rows *sql.Rows) error) error {
+	for start := 0; start < len(ids); start += maxQueryParams {
+		chunk := ids[start:min(start+maxQueryParams, len(ids))]
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := db.Query(strings.Replace(query, "IN (?)", "IN (?"+strings.Repeat(",?", len(chunk)-1)+")", 1), args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns tags often found on the same files as the tags, best first and without the tags themselves. Files
+// holds how many files have the suggested tag.
+func GetRelatedTags(db *sql.DB, tagIds []int, limit int) ([]TagCount, error) {
+	if len(tagIds) == 0 {
+		return nil, nil
+	}
+
+	var pairs []tagsuggest.Pair
+	counted := map[int]bool{}
+	ids := []int{}
+	for _, id := range tagIds {
+		if !counted[id] {
+			counted[id] = true
+			ids = append(ids, id)
+		}
+	}
+	err := queryChunks(db, "SELECT tagId, otherId, files FROM TagPair WHERE tagId IN (?) AND otherId != tagId", tagIds, func(rows *sql.Rows) error {
+		var pair tagsuggest.Pair
+		if err := rows.Scan(&pair.TagId, &pair.OtherId, &pair.Files); err != nil {
+			return err
+		}
+		pairs = append(pairs, pair)
+		if !counted[pair.OtherId] {
+			counted[pair.OtherId] = true
+			ids = append(ids, pair.OtherId)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	var totalFiles int
+	if err := db.QueryRow("SELECT COUNT(*) FROM File").Scan(&totalFiles); err != nil {
+		return nil, err
+	}
+	// the files of the tags are the counts of their pairs with themselves
+	tagFiles := map[int]int{}
+	tags := map[int]TagCount{}
+	err = queryChunks(db, `SELECT Tag.id, Tag.name, Tag.color, TagPair.files FROM TagPair JOIN Tag ON Tag.id = TagPair.tagId
+		WHERE TagPair.tagId IN (?) AND TagPair.otherId = TagPair.tagId`, ids, func(rows *sql.Rows) error {
+		var tag TagCount
+		if err := rows.Scan(&tag.Id, &tag.Name, &tag.Color, &tag.Files); err != nil {
+			return err
+		}
+		tagFiles[tag.Id] = tag.Files
+		tags[tag.Id] = tag
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var related []TagCount
+	for _, r := range tagsuggest.RankRelated(pairs, tagFiles, totalFiles, tagIds, limit) {
+		related = append(related, tags[r.Id])
+	}
+	return related, nil
+}
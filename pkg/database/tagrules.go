@@ -0,0 +1,284 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"main/pkg/tagpath"
+)
+
+var ErrImplicationCycle = errors.New("the implication would make a cycle")
+
+// Another name for a tag, typing the alias anywhere means the tag
+type TagAlias struct {
+	Alias   string
+	TagId   int
+	TagName string
+}
+
+// Files tagged with the tag also get the implied tag, implied tags can imply further tags
+type TagImplication struct {
+	TagId       int
+	TagName     string
+	ImpliedId   int
+	ImpliedName string
+}
+
+// Returns the id of the tag with the name, aliases resolve to their tag
+func ResolveTag(db *sql.DB, name string) (int, error) {
+	name = tagpath.Clean(name)
+	var tagId int
+	err := db.QueryRow("SELECT id FROM Tag WHERE name = ?1 UNION ALL SELECT tagId FROM TagAlias WHERE alias = ?1 LIMIT 1", name).Scan(&tagId)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("there is no tag named %s", name)
+	}
+	return tagId, err
+}
+
+func GetAliases(db *sql.DB) ([]TagAlias, error) {
+	rows, err := db.Query("SELECT TagAlias.alias, Tag.id, Tag.name FROM TagAlias JOIN Tag ON Tag.id = TagAlias.tagId ORDER BY TagAlias.alias")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []TagAlias
+	for rows.Next() {
+		var alias TagAlias
+		if err := rows.Scan(&alias.Alias, &alias.TagId, &alias.TagName); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// Makes alias another name for the tag. A tag already named like the alias is merged into the tag so its files
+// are not lost.
+func AddAlias(db *sql.DB, alias string, tagId int) error {
+	alias = tagpath.Clean(alias)
+	if alias == "" {
+		return fmt.Errorf("alias cannot be empty")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow("SELECT id FROM Tag WHERE name = ?", alias).Scan(&existing)
+	switch {
+	case err == nil && existing == tagId:
+		return fmt.Errorf("%s can not be an alias of itself", alias)
+	case err == nil:
+		if err := mergeTag(tx, tagId, existing); err != nil {
+			return err
+		}
+	case err != sql.ErrNoRows:
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO TagAlias (alias, tagId) VALUES (?, ?) ON CONFLICT(alias) DO UPDATE SET tagId = excluded.tagId", alias, tagId)
+	if err != nil {
+		return fmt.Errorf("failed to add alias %s: %w", alias, err)
+	}
+	return tx.Commit()
+}
+
+func RemoveAlias(db *sql.DB, alias string) error {
+	_, err := db.Exec("DELETE FROM TagAlias WHERE alias = ?", alias)
+	return err
+}
+
+func GetImplications(db *sql.DB) ([]TagImplication, error) {
+	rows, err := db.Query(`SELECT Tag.id, Tag.name, Implied.id, Implied.name FROM TagImplication
+		JOIN Tag ON Tag.id = TagImplication.tagId
+		JOIN Tag AS Implied ON Implied.id = TagImplication.impliedId
+		ORDER BY Tag.name, Implied.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var implications []TagImplication
+	for rows.Next() {
+		var implication TagImplication
+		if err := rows.Scan(&implication.TagId, &implication.TagName, &implication.ImpliedId, &implication.ImpliedName); err != nil {
+			return nil, err
+		}
+		implications = append(implications, implication)
+	}
+	return implications, rows.Err()
+}
+
+// Tags implied by the tag, directly or through other implied tags
+const impliedTagsQuery = `WITH RECURSIVE Implied(id) AS (
+		SELECT impliedId FROM TagImplication WHERE tagId = ?
+		UNION
+		SELECT TagImplication.impliedId FROM TagImplication JOIN Implied ON TagImplication.tagId = Implied.id
+	) SELECT id FROM Implied`
+
+func impliedTags(q querier, tagId int) ([]int, error) {
+	rows, err := q.Query(impliedTagsQuery, tagId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var implied []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		implied = append(implied, id)
+	}
+	return implied, rows.Err()
+}
+
+// Makes the tag imply another one, implications leading back to the tag are refused
+func AddImplication(db *sql.DB, tagId int, impliedId int) error {
+	// the check and the insert share a transaction so no other implication can close the cycle in between
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addImplication(tx, tagId, impliedId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addImplication(tx *sql.Tx, tagId int, impliedId int) error {
+	if tagId == impliedId {
+		return fmt.Errorf("%w: a tag can not imply itself", ErrImplicationCycle)
+	}
+	implied, err := impliedTags(tx, impliedId)
+	if err != nil {
+		return err
+	}
+	for _, id := range implied {
+		if id == tagId {
+			return fmt.Errorf("%w: the implied tag already implies the tag", ErrImplicationCycle)
+		}
+	}
+	_, err = tx.Exec("INSERT OR IGNORE INTO TagImplication (tagId, impliedId) VALUES (?, ?)", tagId, impliedId)
+	return err
+}
+
+func RemoveImplication(db *sql.DB, tagId int, impliedId int) error {
+	_, err := db.Exec("DELETE FROM TagImplication WHERE tagId = ? AND impliedId = ?", tagId, impliedId)
+	return err
+}
+
+// Adds the tag and every tag it implies to the file
+func AddTagToFile(db *sql.DB, fileId int, tagId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	implied, err := impliedTags(tx, tagId)
+	if err != nil {
+		return err
+	}
+	for _, id := range append([]int{tagId}, implied...) {
+		_, err := tx.Exec(`INSERT INTO FileTag (fileId, tagId) SELECT ?1, ?2
+			WHERE NOT EXISTS (SELECT 1 FROM FileTag WHERE fileId = ?1 AND tagId = ?2)`, fileId, id)
+		if err != nil {
+			return fmt.Errorf("failed to tag file %d: %w", fileId, err)
+		}
+		if err := touchTag(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Marks the tag as used now so tag suggestions rank it higher
+func touchTag(q querier, tagId int) error {
+	_, err := q.Exec("UPDATE Tag SET lastUsed = CAST(strftime('%s', 'now') AS INTEGER) WHERE id = ?", tagId)
+	return err
+}
+
+// Adds the implied tags to the files tagged before the implications existed and returns how many were added
+func ApplyImplications(db *sql.DB) (int64, error) {
+	result, err := db.Exec(`WITH RECURSIVE Closure(tagId, impliedId) AS (
+			SELECT tagId, impliedId FROM TagImplication
+			UNION
+			SELECT Closure.tagId, TagImplication.impliedId FROM Closure JOIN TagImplication ON TagImplication.tagId = Closure.impliedId
+		)
+		INSERT INTO FileTag (fileId, tagId)
+		SELECT DISTINCT FileTag.fileId, Closure.impliedId FROM FileTag JOIN Closure ON Closure.tagId = FileTag.tagId
+		WHERE NOT EXISTS (SELECT 1 FROM FileTag AS Existing WHERE Existing.fileId = FileTag.fileId AND Existing.tagId = Closure.impliedId)`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Adds the tags implied by the tags to the list, every tag is listed once
+func withImpliedTags(q querier, tagIds []int) ([]int, error) {
+	seen := map[int]bool{}
+	var all []int
+	for _, tagId := range tagIds {
+		implied, err := impliedTags(q, tagId)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range append([]int{tagId}, implied...) {
+			if !seen[id] {
+				seen[id] = true
+				all = append(all, id)
+			}
+		}
+	}
+	return all, nil
+}
+
+// Points the rules of a merged tag at the tag it was merged into. Implications are added again with the same check
+// as AddImplication, the ones that became duplicates, point at themselves or would close a cycle are dropped.
+func mergeTagRules(tx *sql.Tx, target int, tagId int) error {
+	if _, err := tx.Exec("UPDATE TagAlias SET tagId = ? WHERE tagId = ?", target, tagId); err != nil {
+		return fmt.Errorf("failed to move the aliases of tag %d: %w", tagId, err)
+	}
+
+	rows, err := tx.Query("SELECT tagId, impliedId FROM TagImplication WHERE tagId = ?1 OR impliedId = ?1", tagId)
+	if err != nil {
+		return err
+	}
+	var implications [][2]int
+	for rows.Next() {
+		var implication [2]int
+		if err := rows.Scan(&implication[0], &implication[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		implications = append(implications, implication)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM TagImplication WHERE tagId = ?1 OR impliedId = ?1", tagId); err != nil {
+		return fmt.Errorf("failed to move the implications of tag %d: %w", tagId, err)
+	}
+	for _, implication := range implications {
+		for i := range implication {
+			if implication[i] == tagId {
+				implication[i] = target
+			}
+		}
+		err := addImplication(tx, implication[0], implication[1])
+		if err != nil && !errors.Is(err, ErrImplicationCycle) {
+			return fmt.Errorf("failed to move the implications of tag %d: %w", tagId, err)
+		}
+	}
+	return nil
+}
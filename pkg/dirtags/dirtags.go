@@ -0,0 +1,73 @@
+package dirtags
+
+import (
+	"main/pkg/options"
+	"main/pkg/tagpath"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Returns the tags for a file from the directories between the scan root and the file, like 2023, Japan and
+// Kyoto for Photos/2023/Japan/Kyoto/img.jpg when Photos is ignored. Files outside of the root get none.
+func Names(root string, path string, settings options.DirectoryTagOptions) []string {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+
+	ignored := map[string]bool{}
+	for _, name := range settings.Ignore {
+		ignored[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	var levels []string
+	for _, dir := range strings.Split(rel, string(filepath.Separator)) {
+		// slashes in a tag would nest it
+		dir = strings.TrimSpace(strings.ReplaceAll(dir, tagpath.Separator, " "))
+		if dir == "" || strings.HasPrefix(dir, ".") || ignored[strings.ToLower(dir)] {
+			continue
+		}
+		levels = append(levels, normalizeCase(dir, settings.Case))
+	}
+	if settings.MaxDepth > 0 && len(levels) > settings.MaxDepth {
+		levels = levels[len(levels)-settings.MaxDepth:]
+	}
+	if len(levels) == 0 {
+		return nil
+	}
+
+	prefix := ""
+	if parent := tagpath.Clean(settings.Parent); parent != "" {
+		prefix = parent + tagpath.Separator
+	}
+	if settings.Nested {
+		return []string{prefix + strings.Join(levels, tagpath.Separator)}
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, level := range levels {
+		if !seen[level] {
+			seen[level] = true
+			names = append(names, prefix+level)
+		}
+	}
+	return names
+}
+
+func normalizeCase(name string, mode string) string {
+	switch mode {
+	case options.CaseLower:
+		return strings.ToLower(name)
+	case options.CaseTitle:
+		words := strings.Fields(strings.ToLower(name))
+		for i, word := range words {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		}
+		return strings.Join(words, " ")
+	}
+	return name
+}
@@ -29,6 +29,7 @@ var (
 		".avif": true,
 		".avi":  true,
 		".qoi":  true,
+		".jxl":  true,
 		".dng":  true,
 	}
 )
@@ -0,0 +1,137 @@
+package fileutils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrTrashUnsupported = errors.New("moving files to the trash is not supported on this system")
+
+// Moves the file to the trash of the user so it can still be restored. Linux and BSD use the freedesktop.org
+// trash with its .trashinfo files, macOS uses ~/.Trash and Windows the Recycle Bin.
+func MoveToTrash(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return moveToRecycleBin(path)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return moveToTrashDir(path, filepath.Join(home, ".Trash"), nil)
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly":
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		trash := filepath.Join(dataHome, "Trash")
+		return moveToTrashDir(path, filepath.Join(trash, "files"), func(name string) error {
+			info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+				(&url.URL{Path: path}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+			infoDir := filepath.Join(trash, "info")
+			if err := os.MkdirAll(infoDir, 0700); err != nil {
+				return err
+			}
+			// O_EXCL keeps two deletions from claiming the same name
+			file, err := os.OpenFile(filepath.Join(infoDir, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = file.WriteString(info)
+			return err
+		})
+	}
+	return ErrTrashUnsupported
+}
+
+// Moves the file into dir under a name not used there yet, writeInfo runs first so the trash never holds a file
+// it does not know the origin of
+func moveToTrashDir(path string, dir string, writeInfo func(name string) error) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	name := base
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			if writeInfo == nil {
+				break
+			}
+			err := writeInfo(name)
+			if err == nil {
+				break
+			}
+			if !os.IsExist(err) {
+				return err
+			}
+		}
+		name = strings.TrimSuffix(base, ext) + "." + strconv.Itoa(n) + ext
+	}
+
+	err := os.Rename(path, filepath.Join(dir, name))
+	if errors.Is(err, syscall.EXDEV) {
+		// the trash is on another file system than the file, so it is copied there instead
+		err = moveAcross(path, filepath.Join(dir, name))
+	}
+	if err != nil {
+		if writeInfo != nil {
+			os.Remove(filepath.Join(filepath.Dir(dir), "info", name+".trashinfo"))
+		}
+		return fmt.Errorf("failed to move %s to the trash: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// Copies the file to target, keeping its mode and modification time, and removes it once the copy is complete
+func moveAcross(path string, target string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", filepath.Base(path))
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(target, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		src.Close()
+		err = os.Remove(path)
+	}
+	if err != nil {
+		os.Remove(target)
+	}
+	return err
+}
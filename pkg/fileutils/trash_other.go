@@ -0,0 +1,7 @@
+//go:build !windows
+
+package fileutils
+
+func moveToRecycleBin(path string) error {
+	return ErrTrashUnsupported
+}
@@ -0,0 +1,50 @@
+package fileutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+const (
+	foDelete          = 0x3
+	fofSilent         = 0x4
+	fofNoConfirmation = 0x10
+	fofAllowUndo      = 0x40
+	fofNoErrorUI      = 0x400
+)
+
+// The SHFILEOPSTRUCTW the shell file operations take
+type shFileOpStruct struct {
+	hwnd                 uintptr
+	wFunc                uint32
+	from                 *uint16
+	to                   *uint16
+	flags                uint16
+	anyOperationsAborted int32
+	nameMappings         uintptr
+	progressTitle        *uint16
+}
+
+var shFileOperation = syscall.NewLazyDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// Deletes the file through the shell with undo allowed, which puts it in the Recycle Bin
+func moveToRecycleBin(path string) error {
+	// the list of files ends with an empty name
+	from := append(utf16.Encode([]rune(path)), 0, 0)
+	op := shFileOpStruct{
+		wFunc: foDelete,
+		from:  &from[0],
+		flags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+	ret, _, _ := shFileOperation.Call(uintptr(unsafe.Pointer(&op)))
+	if ret != 0 {
+		return fmt.Errorf("failed to move %s to the trash: error %#x", filepath.Base(path), ret)
+	}
+	if op.anyOperationsAborted != 0 {
+		return fmt.Errorf("moving %s to the trash was aborted", filepath.Base(path))
+	}
+	return nil
+}
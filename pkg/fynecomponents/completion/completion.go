@@ -0,0 +1,167 @@
+package completion
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const maxVisible = 8
+
+// Entry that lists suggestions under itself while typing. Up and Down pick a suggestion, Enter or a click
+// chooses it and Enter without a picked suggestion chooses the typed text. Down in the empty entry lists the
+// suggestions for no text.
+type Entry struct {
+	widget.Entry
+
+	Suggest     func(text string) []string // suggestions for the text, called after every change
+	OnChosen    func(text string)          // the picked suggestion or the typed text, the entry is cleared after
+	OnBackspace func()                     // Backspace was pressed in the empty entry
+
+	suggestions []string
+	selected    int
+	navigating  bool
+	list        *suggestionList
+	popUp       *widget.PopUp
+}
+
+func NewEntry() *Entry {
+	e := &Entry{selected: -1}
+	e.ExtendBaseWidget(e)
+	e.OnChanged = func(text string) {
+		if strings.TrimSpace(text) == "" {
+			e.HideSuggestions()
+			return
+		}
+		e.showSuggestions(text)
+	}
+	return e
+}
+
+func (e *Entry) TypedKey(key *fyne.KeyEvent) {
+	shown := e.popUp != nil && e.popUp.Visible()
+	switch key.Name {
+	case fyne.KeyDown:
+		if !shown {
+			e.showSuggestions(e.Text)
+			return
+		}
+		e.pick((e.selected + 1) % len(e.suggestions))
+	case fyne.KeyUp:
+		if !shown {
+			e.Entry.TypedKey(key)
+			return
+		}
+		e.pick((e.selected - 1 + len(e.suggestions)) % len(e.suggestions))
+	case fyne.KeyReturn, fyne.KeyEnter:
+		if shown && e.selected >= 0 {
+			e.choose(e.suggestions[e.selected])
+		} else if strings.TrimSpace(e.Text) != "" {
+			e.choose(strings.TrimSpace(e.Text))
+		}
+	case fyne.KeyEscape:
+		e.HideSuggestions()
+	case fyne.KeyBackspace:
+		if e.Text == "" && e.OnBackspace != nil {
+			e.OnBackspace()
+			return
+		}
+		e.Entry.TypedKey(key)
+	default:
+		e.Entry.TypedKey(key)
+	}
+}
+
+func (e *Entry) HideSuggestions() {
+	if e.popUp != nil {
+		e.popUp.Hide()
+	}
+	e.selected = -1
+}
+
+func (e *Entry) showSuggestions(text string) {
+	if e.Suggest == nil {
+		return
+	}
+	e.suggestions = e.Suggest(text)
+	e.selected = -1
+	if len(e.suggestions) == 0 {
+		e.HideSuggestions()
+		return
+	}
+	c := fyne.CurrentApp().Driver().CanvasForObject(e)
+	if c == nil {
+		return
+	}
+
+	if e.popUp == nil {
+		e.list = newSuggestionList(e)
+		e.popUp = widget.NewPopUp(e.list, c)
+	}
+	e.list.UnselectAll()
+	e.list.Refresh()
+	e.list.ScrollToTop()
+
+	itemHeight := e.list.CreateItem().MinSize().Height + theme.SeparatorThicknessSize()
+	visible := float32(min(len(e.suggestions), maxVisible))
+	e.popUp.Resize(fyne.NewSize(e.Size().Width, visible*itemHeight+2*theme.Padding()))
+	e.popUp.ShowAtPosition(fyne.CurrentApp().Driver().AbsolutePositionForObject(e).Add(fyne.NewPos(0, e.Size().Height)))
+	// the pop up takes the keyboard, the list hands the keys back to the entry
+	c.Focus(e.list)
+}
+
+// Highlights a suggestion without choosing it
+func (e *Entry) pick(id int) {
+	e.selected = id
+	e.navigating = true
+	e.list.Select(id)
+	e.navigating = false
+}
+
+func (e *Entry) choose(text string) {
+	e.HideSuggestions()
+	if e.OnChosen != nil {
+		e.OnChosen(text)
+	}
+	e.SetText("")
+}
+
+// List of the suggestions that passes the keys typed while it has the focus on to the entry
+type suggestionList struct {
+	widget.List
+	entry *Entry
+}
+
+func newSuggestionList(e *Entry) *suggestionList {
+	l := &suggestionList{entry: e}
+	l.Length = func() int { return len(e.suggestions) }
+	l.CreateItem = func() fyne.CanvasObject { return widget.NewLabel("") }
+	l.UpdateItem = func(id widget.ListItemID, o fyne.CanvasObject) {
+		o.(*widget.Label).SetText(e.suggestions[id])
+	}
+	l.OnSelected = func(id widget.ListItemID) {
+		if !e.navigating {
+			e.choose(e.suggestions[id])
+		}
+	}
+	l.ExtendBaseWidget(l)
+	return l
+}
+
+func (l *suggestionList) FocusGained() {}
+
+func (l *suggestionList) FocusLost() {}
+
+func (l *suggestionList) TypedKey(key *fyne.KeyEvent) {
+	l.entry.TypedKey(key)
+}
+
+func (l *suggestionList) TypedRune(r rune) {
+	l.entry.TypedRune(r)
+}
+
+func (l *suggestionList) TypedShortcut(shortcut fyne.Shortcut) {
+	l.entry.TypedShortcut(shortcut)
+}
@@ -0,0 +1,346 @@
+package tagchip
+
+import (
+	"image/color"
+	"main/pkg/colorutils"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	DefaultMaxLength = 24                     // characters shown before a name is shortened
+	tooltipDelay     = 500 * time.Millisecond // how long the mouse rests on a shortened name before it is shown whole
+	hoverAmount      = 0.18                   // how far the color moves towards the text color while hovered
+	ellipsis         = "…"
+)
+
+// Tag drawn in its color. The text is black or white, whichever has the better WCAG contrast with the color.
+// Names that do not fit are shortened and shown whole when the mouse rests on them.
+type Chip struct {
+	widget.BaseWidget
+
+	Text      string
+	Color     color.Color
+	Outlined  bool // only the border has the color, for tags that could be added
+	MaxLength int  // characters shown at most, 0 shows the whole name if it fits
+	OnTapped  func()
+	OnRemoved func() // shows an x calling it when set
+
+	hovered   bool
+	truncated bool
+	timer     *time.Timer
+	tip       *tooltip
+}
+
+func New(text string, c color.Color, onTapped func()) *Chip {
+	chip := &Chip{Text: text, Color: c, MaxLength: DefaultMaxLength, OnTapped: onTapped}
+	chip.ExtendBaseWidget(chip)
+	return chip
+}
+
+// Chip with an x that calls onRemoved
+func NewRemovable(text string, c color.Color, onTapped func(), onRemoved func()) *Chip {
+	chip := New(text, c, onTapped)
+	chip.OnRemoved = onRemoved
+	return chip
+}
+
+func (c *Chip) CreateRenderer() fyne.WidgetRenderer {
+	c.ExtendBaseWidget(c)
+	r := &chipRenderer{
+		chip:       c,
+		background: canvas.NewRectangle(color.Transparent),
+		text:       canvas.NewText("", color.Black),
+		remove:     newRemoveButton(c),
+	}
+	r.text.TextSize = theme.TextSize()
+	r.objects = []fyne.CanvasObject{r.background, r.text, r.remove}
+	r.Refresh()
+	return r
+}
+
+func (c *Chip) Tapped(*fyne.PointEvent) {
+	if c.OnTapped != nil {
+		c.OnTapped()
+	}
+}
+
+func (c *Chip) Cursor() desktop.Cursor {
+	if c.OnTapped != nil {
+		return desktop.PointerCursor
+	}
+	return desktop.DefaultCursor
+}
+
+func (c *Chip) MouseIn(*desktop.MouseEvent) {
+	c.setHovered(true)
+	if c.truncated {
+		c.timer = time.AfterFunc(tooltipDelay, c.showTooltip)
+	}
+}
+
+func (c *Chip) MouseMoved(*desktop.MouseEvent) {}
+
+func (c *Chip) MouseOut() {
+	c.setHovered(false)
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+func (c *Chip) setHovered(hovered bool) {
+	if c.hovered != hovered {
+		c.hovered = hovered
+		c.Refresh()
+	}
+}
+
+// The tooltip takes the mouse while it is shown, taps on the chip are handed back through here
+func (c *Chip) tapAt(position fyne.Position) {
+	if c.OnRemoved != nil {
+		remove := c.removeArea(c.Size())
+		if position.X >= remove.X && position.Y >= remove.Y && position.X < remove.X+removeSize() &&
+			position.Y < remove.Y+removeSize() {
+			c.OnRemoved()
+			return
+		}
+	}
+	c.Tapped(&fyne.PointEvent{Position: position})
+}
+
+func (c *Chip) removeArea(size fyne.Size) fyne.Position {
+	return fyne.NewPos(size.Width-theme.InnerPadding()/2-removeSize(), (size.Height-removeSize())/2)
+}
+
+func removeSize() float32 {
+	return theme.IconInlineSize()
+}
+
+// Colors of the chip as it is drawn now
+func (c *Chip) colors() (fill color.Color, stroke color.Color, text color.Color) {
+	base := c.Color
+	if base == nil {
+		base = theme.Color(theme.ColorNameButton)
+	}
+	if c.Outlined {
+		fill = color.Transparent
+		if c.hovered {
+			fill = mix(color.Transparent, base, 0.25)
+		}
+		return fill, base, theme.Color(theme.ColorNameForeground)
+	}
+	text = colorutils.TextColor(base)
+	fill = base
+	if c.hovered {
+		fill = mix(base, text, hoverAmount)
+	}
+	return fill, color.Transparent, text
+}
+
+// Blends b into a by amount, alpha included
+func mix(a color.Color, b color.Color, amount float64) color.Color {
+	ca := color.NRGBAModel.Convert(a).(color.NRGBA)
+	cb := color.NRGBAModel.Convert(b).(color.NRGBA)
+	blend := func(x, y uint8) uint8 {
+		return uint8(float64(x) + (float64(y)-float64(x))*amount)
+	}
+	if ca.A == 0 {
+		// a transparent color has no hue to keep
+		return color.NRGBA{R: cb.R, G: cb.G, B: cb.B, A: blend(0, cb.A)}
+	}
+	return color.NRGBA{R: blend(ca.R, cb.R), G: blend(ca.G, cb.G), B: blend(ca.B, cb.B), A: blend(ca.A, cb.A)}
+}
+
+// Cuts the name to at most maxLength characters
+func limit(text string, maxLength int) string {
+	runes := []rune(text)
+	if maxLength <= 0 || len(runes) <= maxLength {
+		return text
+	}
+	return string(runes[:max(maxLength-1, 1)]) + ellipsis
+}
+
+// Shortens the name until it fits in the width, keeping as much of its start as possible
+func fit(text string, width float32, style fyne.TextStyle) string {
+	size := theme.TextSize()
+	if fyne.MeasureText(text, size, style).Width <= width {
+		return text
+	}
+	runes := []rune(text)
+	low, high := 0, len(runes)
+	for low < high {
+		middle := (low + high + 1) / 2
+		if fyne.MeasureText(string(runes[:middle])+ellipsis, size, style).Width <= width {
+			low = middle
+		} else {
+			high = middle - 1
+		}
+	}
+	return string(runes[:low]) + ellipsis
+}
+
+type chipRenderer struct {
+	chip       *Chip
+	background *canvas.Rectangle
+	text       *canvas.Text
+	remove     *removeButton
+	objects    []fyne.CanvasObject
+}
+
+func (r *chipRenderer) MinSize() fyne.Size {
+	padding := theme.InnerPadding()
+	text := fyne.MeasureText(limit(r.chip.Text, r.chip.MaxLength), theme.TextSize(), r.text.TextStyle)
+	width := 2*padding + text.Width
+	height := text.Height
+	if r.chip.OnRemoved != nil {
+		width += padding/2 + removeSize()
+		height = max(height, removeSize())
+	}
+	return fyne.NewSize(width, height+padding)
+}
+
+func (r *chipRenderer) Layout(size fyne.Size) {
+	padding := theme.InnerPadding()
+	r.background.Resize(size)
+
+	available := size.Width - 2*padding
+	if r.chip.OnRemoved != nil {
+		available -= padding/2 + removeSize()
+		r.remove.Move(r.chip.removeArea(size))
+		r.remove.Resize(fyne.NewSquareSize(removeSize()))
+	}
+	r.text.Text = fit(limit(r.chip.Text, r.chip.MaxLength), available, r.text.TextStyle)
+	r.chip.truncated = r.text.Text != r.chip.Text
+
+	// the name sits in the middle of the space the x leaves
+	text := r.text.MinSize()
+	r.text.Move(fyne.NewPos(padding+max(available-text.Width, 0)/2, (size.Height-text.Height)/2))
+	r.text.Resize(text)
+}
+
+func (r *chipRenderer) Refresh() {
+	fill, stroke, text := r.chip.colors()
+	r.background.FillColor = fill
+	r.background.StrokeColor = stroke
+	r.background.StrokeWidth = 0
+	if r.chip.Outlined {
+		r.background.StrokeWidth = 2
+	}
+	r.background.CornerRadius = theme.InputRadiusSize()
+	r.text.Color = text
+	r.text.TextSize = theme.TextSize()
+	r.remove.color = text
+	if r.chip.OnRemoved != nil {
+		r.remove.Show()
+	} else {
+		r.remove.Hide()
+	}
+
+	r.Layout(r.chip.Size())
+	r.background.Refresh()
+	r.text.Refresh()
+	r.remove.Refresh()
+}
+
+func (r *chipRenderer) Objects() []fyne.CanvasObject {
+	return r.objects
+}
+
+func (r *chipRenderer) Destroy() {
+	if r.chip.timer != nil {
+		r.chip.timer.Stop()
+	}
+}
+
+// The x of a removable chip, drawn in the text color so it is as readable as the name
+type removeButton struct {
+	widget.BaseWidget
+	chip    *Chip
+	color   color.Color
+	hovered bool
+}
+
+func newRemoveButton(chip *Chip) *removeButton {
+	b := &removeButton{chip: chip, color: color.Black}
+	b.ExtendBaseWidget(b)
+	return b
+}
+
+func (b *removeButton) CreateRenderer() fyne.WidgetRenderer {
+	r := &removeRenderer{
+		button:    b,
+		highlight: canvas.NewCircle(color.Transparent),
+		lines:     [2]*canvas.Line{canvas.NewLine(b.color), canvas.NewLine(b.color)},
+	}
+	r.Refresh()
+	return r
+}
+
+func (b *removeButton) Tapped(*fyne.PointEvent) {
+	if b.chip.OnRemoved != nil {
+		b.chip.OnRemoved()
+	}
+}
+
+func (b *removeButton) Cursor() desktop.Cursor {
+	return desktop.PointerCursor
+}
+
+// The chip stays highlighted while the mouse is on its x
+func (b *removeButton) MouseIn(*desktop.MouseEvent) {
+	b.hovered = true
+	b.chip.setHovered(true)
+	b.Refresh()
+}
+
+func (b *removeButton) MouseMoved(*desktop.MouseEvent) {}
+
+func (b *removeButton) MouseOut() {
+	b.hovered = false
+	b.chip.setHovered(false)
+	b.Refresh()
+}
+
+type removeRenderer struct {
+	button    *removeButton
+	highlight *canvas.Circle
+	lines     [2]*canvas.Line
+}
+
+func (r *removeRenderer) MinSize() fyne.Size {
+	return fyne.NewSquareSize(removeSize())
+}
+
+func (r *removeRenderer) Layout(size fyne.Size) {
+	r.highlight.Resize(size)
+	inset := size.Width * 0.3
+	r.lines[0].Position1 = fyne.NewPos(inset, inset)
+	r.lines[0].Position2 = fyne.NewPos(size.Width-inset, size.Height-inset)
+	r.lines[1].Position1 = fyne.NewPos(size.Width-inset, inset)
+	r.lines[1].Position2 = fyne.NewPos(inset, size.Height-inset)
+}
+
+func (r *removeRenderer) Refresh() {
+	r.highlight.FillColor = color.Transparent
+	if r.button.hovered {
+		r.highlight.FillColor = mix(color.Transparent, r.button.color, 0.25)
+	}
+	r.highlight.Refresh()
+	for _, line := range r.lines {
+		line.StrokeColor = r.button.color
+		line.StrokeWidth = 1.5
+		line.Refresh()
+	}
+	r.Layout(r.button.Size())
+}
+
+func (r *removeRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.highlight, r.lines[0], r.lines[1]}
+}
+
+func (r *removeRenderer) Destroy() {}
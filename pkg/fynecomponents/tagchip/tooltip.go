@@ -0,0 +1,96 @@
+package tagchip
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Whole name of a shortened chip shown under it. Fyne has no tooltips, this is a layer over the window that takes
+// the mouse while it is shown, so it hides itself once the mouse leaves the chip and hands taps on the chip back.
+type tooltip struct {
+	widget.BaseWidget
+	chip     *Chip
+	box      *fyne.Container
+	label    *widget.Label
+	position fyne.Position // of the chip on the canvas
+	canvas   fyne.Canvas
+}
+
+func (c *Chip) showTooltip() {
+	if !c.hovered || !c.truncated {
+		return
+	}
+	cnv := fyne.CurrentApp().Driver().CanvasForObject(c)
+	if cnv == nil {
+		return
+	}
+	if c.tip == nil {
+		c.tip = &tooltip{chip: c, label: widget.NewLabel("")}
+		background := canvas.NewRectangle(theme.Color(theme.ColorNameOverlayBackground))
+		background.StrokeColor = theme.Color(theme.ColorNameShadow)
+		background.StrokeWidth = 1
+		background.CornerRadius = theme.InputRadiusSize()
+		c.tip.box = container.NewStack(background, c.tip.label)
+		c.tip.ExtendBaseWidget(c.tip)
+	}
+	t := c.tip
+	t.canvas = cnv
+	t.label.SetText(c.Text)
+	t.position = fyne.CurrentApp().Driver().AbsolutePositionForObject(c)
+
+	// under the chip, kept inside the window
+	size := t.box.MinSize()
+	x := min(t.position.X, cnv.Size().Width-size.Width)
+	y := t.position.Y + c.Size().Height + theme.Padding()
+	if y+size.Height > cnv.Size().Height {
+		y = t.position.Y - size.Height - theme.Padding()
+	}
+	t.box.Move(fyne.NewPos(max(x, 0), max(y, 0)))
+	t.box.Resize(size)
+	t.Resize(cnv.Size())
+	cnv.Overlays().Add(t)
+}
+
+func (t *tooltip) hide() {
+	if t.canvas != nil {
+		t.canvas.Overlays().Remove(t)
+		t.canvas = nil
+	}
+	t.chip.setHovered(false)
+}
+
+// Tells whether a point of the canvas is on the chip
+func (t *tooltip) onChip(position fyne.Position) bool {
+	size := t.chip.Size()
+	return position.X >= t.position.X && position.Y >= t.position.Y &&
+		position.X < t.position.X+size.Width && position.Y < t.position.Y+size.Height
+}
+
+func (t *tooltip) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewWithoutLayout(t.box))
+}
+
+func (t *tooltip) Tapped(event *fyne.PointEvent) {
+	t.hide()
+	if t.onChip(event.Position) {
+		t.chip.tapAt(event.Position.Subtract(t.position))
+	}
+}
+
+func (t *tooltip) MouseIn(event *desktop.MouseEvent) {
+	t.MouseMoved(event)
+}
+
+func (t *tooltip) MouseMoved(event *desktop.MouseEvent) {
+	if !t.onChip(event.Position) {
+		t.hide()
+	}
+}
+
+func (t *tooltip) MouseOut() {
+	t.hide()
+}
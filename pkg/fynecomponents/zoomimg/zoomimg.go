@@ -0,0 +1,194 @@
+package zoomimg
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/widget"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	MinScale   = 0.01
+	MaxScale   = 32.0
+	zoomFactor = 1.25
+)
+
+// Shows an image at any zoom level, the mouse wheel zooms around the cursor, dragging pans and a double tap
+// switches between fit and 1:1. Only the visible part is scaled so large images stay fast.
+type ZoomImage struct {
+	widget.BaseWidget
+
+	mu      sync.Mutex
+	src     image.Image
+	fit     bool
+	scale   float64 // screen pixels per image pixel
+	centerX float64 // image point shown in the middle of the widget
+	centerY float64
+	width   int // size of the last render in screen pixels
+	height  int
+	ratio   float32 // screen pixels per fyne unit
+	raster  *canvas.Raster
+}
+
+func NewZoomImage(img image.Image) *ZoomImage {
+	z := &ZoomImage{src: img, fit: true, scale: 1, ratio: 1}
+	z.ExtendBaseWidget(z)
+	z.raster = canvas.NewRaster(z.render)
+	return z
+}
+
+func (z *ZoomImage) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(z.raster)
+}
+
+// Replaces the image and fits it into the widget
+func (z *ZoomImage) SetImage(img image.Image) {
+	z.mu.Lock()
+	z.src = img
+	z.fit = true
+	z.mu.Unlock()
+	z.Refresh()
+}
+
+// Returns the zoom level, 1 shows every image pixel as one screen pixel
+func (z *ZoomImage) Scale() float64 {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.scale
+}
+
+// Shows the whole image, small images are not enlarged
+func (z *ZoomImage) ZoomToFit() {
+	z.mu.Lock()
+	z.fit = true
+	z.mu.Unlock()
+	z.Refresh()
+}
+
+// Shows the image at 1:1 around its current center
+func (z *ZoomImage) ZoomActual() {
+	z.mu.Lock()
+	z.fit = false
+	z.scale = 1
+	z.mu.Unlock()
+	z.Refresh()
+}
+
+func (z *ZoomImage) ZoomIn() {
+	z.zoomAt(zoomFactor, -1, -1)
+}
+
+func (z *ZoomImage) ZoomOut() {
+	z.zoomAt(1/zoomFactor, -1, -1)
+}
+
+func (z *ZoomImage) Scrolled(event *fyne.ScrollEvent) {
+	factor := zoomFactor
+	if event.Scrolled.DY < 0 {
+		factor = 1 / zoomFactor
+	} else if event.Scrolled.DY == 0 {
+		return
+	}
+	z.zoomAt(factor, event.Position.X, event.Position.Y)
+}
+
+func (z *ZoomImage) Dragged(event *fyne.DragEvent) {
+	z.mu.Lock()
+	z.fit = false
+	z.centerX -= float64(event.Dragged.DX*z.ratio) / z.scale
+	z.centerY -= float64(event.Dragged.DY*z.ratio) / z.scale
+	z.mu.Unlock()
+	z.Refresh()
+}
+
+func (z *ZoomImage) DragEnd() {}
+
+func (z *ZoomImage) DoubleTapped(event *fyne.PointEvent) {
+	z.mu.Lock()
+	fit := z.fit
+	z.mu.Unlock()
+	if !fit {
+		z.ZoomToFit()
+		return
+	}
+	z.zoomAt(1/z.Scale(), event.Position.X, event.Position.Y)
+}
+
+// Multiplies the zoom keeping the image point under x, y in place, a negative position zooms around the center
+func (z *ZoomImage) zoomAt(factor float64, x float32, y float32) {
+	z.mu.Lock()
+	if z.src == nil {
+		z.mu.Unlock()
+		return
+	}
+	// offset of the point from the middle of the widget in screen pixels
+	offsetX, offsetY := 0.0, 0.0
+	if x >= 0 && y >= 0 {
+		offsetX = float64(x*z.ratio) - float64(z.width)/2
+		offsetY = float64(y*z.ratio) - float64(z.height)/2
+	}
+	pointX := z.centerX + offsetX/z.scale
+	pointY := z.centerY + offsetY/z.scale
+
+	z.fit = false
+	z.scale = math.Min(MaxScale, math.Max(MinScale, z.scale*factor))
+	z.centerX = pointX - offsetX/z.scale
+	z.centerY = pointY - offsetY/z.scale
+	z.mu.Unlock()
+
+	z.Refresh()
+}
+
+func (z *ZoomImage) render(width int, height int) image.Image {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	z.width, z.height = width, height
+	if size := z.Size(); size.Width > 0 {
+		z.ratio = float32(width) / size.Width
+	}
+	if z.src == nil || width == 0 || height == 0 {
+		return dst
+	}
+
+	bounds := z.src.Bounds()
+	imgWidth, imgHeight := float64(bounds.Dx()), float64(bounds.Dy())
+	if z.fit {
+		z.scale = math.Min(1, math.Min(float64(width)/imgWidth, float64(height)/imgHeight))
+		z.centerX, z.centerY = imgWidth/2, imgHeight/2
+	}
+	// the center can not leave the image so some of it always stays visible
+	z.centerX = math.Min(imgWidth, math.Max(0, z.centerX))
+	z.centerY = math.Min(imgHeight, math.Max(0, z.centerY))
+
+	left := z.centerX - float64(width)/2/z.scale
+	top := z.centerY - float64(height)/2/z.scale
+	srcRect := image.Rect(
+		int(math.Floor(math.Max(0, left))),
+		int(math.Floor(math.Max(0, top))),
+		int(math.Ceil(math.Min(imgWidth, left+float64(width)/z.scale))),
+		int(math.Ceil(math.Min(imgHeight, top+float64(height)/z.scale))),
+	)
+	if srcRect.Empty() {
+		return dst
+	}
+	dstRect := image.Rect(
+		int((float64(srcRect.Min.X)-left)*z.scale),
+		int((float64(srcRect.Min.Y)-top)*z.scale),
+		int((float64(srcRect.Max.X)-left)*z.scale),
+		int((float64(srcRect.Max.Y)-top)*z.scale),
+	)
+
+	// pixels stay sharp when zoomed in, zooming out averages them
+	var scaler xdraw.Scaler = xdraw.ApproxBiLinear
+	if z.scale >= 1 {
+		scaler = xdraw.NearestNeighbor
+	}
+	scaler.Scale(dst, dstRect, z.src, srcRect.Add(bounds.Min), xdraw.Over, nil)
+	return dst
+}
@@ -0,0 +1,95 @@
+package imageconv
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"main/pkg/animation"
+	"main/pkg/options"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Formats that keep every frame when an animation is converted to them, PNG is written as APNG
+var animatedFormats = map[string]bool{"GIF": true, "WEBP": true, "PNG": true}
+
+// Converts every frame of an animated GIF, PNG or WebP, keeping the frame delays and loop count
+func convertAnimation(source string, output string, selectedFormat string, opts options.ConvertOptions) error {
+	anim, err := animation.Read(source)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", filepath.Base(source), err)
+	}
+	bounds := anim.Bounds()
+	anim.Resize(targetSize(bounds.Dx(), bounds.Dy(), opts))
+
+	var encoded bytes.Buffer
+	switch selectedFormat {
+	case "GIF":
+		err = animation.EncodeGIF(&encoded, anim, true)
+	case "PNG":
+		err = animation.EncodeAPNG(&encoded, anim)
+	case "WEBP":
+		err = animation.EncodeWebP(&encoded, anim, func(frame image.Image) ([]byte, error) {
+			var buf bytes.Buffer
+			err := encodeImage(&buf, frame, "WEBP", opts)
+			return buf.Bytes(), err
+		})
+	default:
+		return fmt.Errorf("%s can not hold an animation", selectedFormat)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(source), err)
+	}
+
+	return os.WriteFile(output, encoded.Bytes(), 0644)
+}
+
+// Writes every frame of the animation to selectedDir as name-001.png, name-002.png...
+func ExtractFrames(source string, selectedDir string) ([]string, error) {
+	anim, err := animation.Read(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filepath.Base(source), err)
+	}
+
+	name := filepath.Base(source)
+	name = name[:len(name)-len(filepath.Ext(name))]
+
+	var outputs []string
+	for i, frame := range anim.Frames {
+		output := filepath.Join(selectedDir, fmt.Sprintf("%s-%03d.png", name, i+1))
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, frame.Image); err != nil {
+			return outputs, fmt.Errorf("failed to encode frame %d: %w", i+1, err)
+		}
+		if err := os.WriteFile(output, encoded.Bytes(), 0644); err != nil {
+			return outputs, fmt.Errorf("failed to write frame %d: %w", i+1, err)
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// Builds a GIF from the images in the given order, every image is fitted to the size of the first one
+func MakeGIF(selectedFiles []string, output string, delay time.Duration, loopCount int, dither bool) error {
+	images := make([]image.Image, 0, len(selectedFiles))
+	for _, file := range selectedFiles {
+		img, err := decodeImage(file)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", filepath.Base(file), err)
+		}
+		images = append(images, img)
+	}
+
+	anim, err := animation.FromImages(images, delay, loopCount)
+	if err != nil {
+		return err
+	}
+
+	var encoded bytes.Buffer
+	if err := animation.EncodeGIF(&encoded, anim, dither); err != nil {
+		return fmt.Errorf("failed to encode gif: %w", err)
+	}
+	return os.WriteFile(output, encoded.Bytes(), 0644)
+}
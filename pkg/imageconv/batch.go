@@ -0,0 +1,208 @@
+package imageconv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"main/pkg/animation"
+	"main/pkg/imageedit"
+	"main/pkg/imagemeta"
+	"main/pkg/options"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	chaiWebp "github.com/chai2010/webp"
+)
+
+// Outcome of converting a single file, Output is empty if the conversion did not start
+type ConvertResult struct {
+	Source string
+	Output string
+	Err    error
+}
+
+// Results of a batch in the order the files were given
+type ConvertReport struct {
+	Results []ConvertResult
+}
+
+func (r *ConvertReport) Converted() int {
+	converted := 0
+	for _, result := range r.Results {
+		if result.Err == nil {
+			converted++
+		}
+	}
+	return converted
+}
+
+func (r *ConvertReport) Failed() []ConvertResult {
+	var failed []ConvertResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Called after every file, done counts the files finished so far
+type ConvertProgress func(done int, total int, result ConvertResult)
+
+// Converts the files into selectedDir with a pool of workers, workers <= 0 uses one per CPU.
+// A failed file does not stop the others, cancelling ctx marks the files not yet started as cancelled.
+// progress may be nil and is called from the worker goroutines.
+func ConvertImages(ctx context.Context, selectedFiles []string, selectedFormat string, selectedDir string, opts options.ConvertOptions, workers int, progress ConvertProgress) *ConvertReport {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	report := &ConvertReport{Results: make([]ConvertResult, len(selectedFiles))}
+	outputs := planOutputPaths(selectedFiles, selectedFormat, filepath.Clean(selectedDir))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+
+	for range min(workers, len(selectedFiles)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := ConvertResult{Source: selectedFiles[i], Output: outputs[i]}
+				if err := ctx.Err(); err != nil {
+					result.Output = ""
+					result.Err = err
+				} else {
+					result.Err = convertFile(selectedFiles[i], outputs[i], selectedFormat, opts)
+				}
+				report.Results[i] = result
+
+				mu.Lock()
+				done++
+				if progress != nil {
+					progress(done, len(selectedFiles), result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range selectedFiles {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return report
+}
+
+// Picks the output path of every file. Files that share a name or would overwrite a file already on the disk, the
+// source itself included, get -2, -3... so nothing is overwritten and workers never write the same file.
+func planOutputPaths(selectedFiles []string, selectedFormat string, selectedDir string) []string {
+	ext := "." + strings.ToLower(selectedFormat)
+	used := make(map[string]bool, len(selectedFiles))
+	outputs := make([]string, len(selectedFiles))
+	taken := func(output string) bool {
+		if used[output] {
+			return true
+		}
+		_, err := os.Stat(output)
+		return !os.IsNotExist(err)
+	}
+
+	for i, file := range selectedFiles {
+		name := filepath.Base(file)
+		name = name[:len(name)-len(filepath.Ext(name))]
+
+		output := filepath.Join(selectedDir, name+ext)
+		for n := 2; taken(output); n++ {
+			output = filepath.Join(selectedDir, name+"-"+strconv.Itoa(n)+ext)
+		}
+		used[output] = true
+		outputs[i] = output
+	}
+	return outputs
+}
+
+// Converts one file, the output is only written once the image was encoded completely
+func convertFile(source string, output string, selectedFormat string, opts options.ConvertOptions) error {
+	if animatedFormats[selectedFormat] && animation.IsAnimated(source) {
+		return convertAnimation(source, output, selectedFormat, opts)
+	}
+
+	img, err := decodeImage(source)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", filepath.Base(source), err)
+	}
+	img = imageedit.Orient(img, imagemeta.ReadOrientation(source))
+	img = resizeImage(img, opts)
+
+	var encoded bytes.Buffer
+	if err := encodeImage(&encoded, img, selectedFormat, opts); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(source), err)
+	}
+
+	data, err := copyMetadata(source, encoded.Bytes(), selectedFormat, opts.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to copy metadata of %s: %w", filepath.Base(source), err)
+	}
+
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		os.Remove(output)
+		return fmt.Errorf("failed to write converted file: %w", err)
+	}
+	return nil
+}
+
+// Adds the EXIF and ICC data of the source to the encoded image as far as the metadata mode allows.
+// Only JPG, PNG and WEBP outputs can carry metadata, the other formats are written without it.
+func copyMetadata(source string, data []byte, selectedFormat string, mode string) ([]byte, error) {
+	if mode == options.MetadataStrip {
+		return data, nil
+	}
+
+	meta, err := imagemeta.Read(source)
+	if err != nil {
+		// unreadable metadata is dropped rather than failing the conversion
+		return data, nil
+	}
+	if mode == options.MetadataColorOnly {
+		meta.Exif = nil
+	}
+	// the pixels were already turned upright
+	meta.ResetOrientation()
+	if meta.Empty() {
+		return data, nil
+	}
+
+	switch selectedFormat {
+	case "JPG", "JPEG":
+		return imagemeta.WriteJPEG(data, meta)
+	case "PNG":
+		return imagemeta.WritePNG(data, meta)
+	case "WEBP":
+		if len(meta.ICC) > 0 {
+			if data, err = chaiWebp.SetMetadata(data, meta.ICC, "ICCP"); err != nil {
+				return nil, err
+			}
+		}
+		if len(meta.Exif) > 0 {
+			if data, err = chaiWebp.SetMetadata(data, meta.Exif, "EXIF"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
+
+// Reports whether the conversion was stopped rather than failing on its own
+func IsCancelled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,120 @@
+package imageconv
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"main/pkg/animation"
+	"main/pkg/imageedit"
+	"main/pkg/imagemeta"
+	"main/pkg/options"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Formats an edited file is written back as, the others are saved as PNG
+var editableFormats = map[string]bool{"JPG": true, "JPEG": true, "PNG": true, "WEBP": true, "GIF": true, "BMP": true, "TIFF": true, "TIF": true, "AVIF": true, "HEIC": true, "QOI": true, "JXL": true}
+
+// Loads the image upright according to its EXIF orientation and applies the edit stack
+func DecodeEdited(source string, edits []imageedit.Edit) (image.Image, error) {
+	img, err := decodeImage(source)
+	if err != nil {
+		return nil, err
+	}
+	img = imageedit.Orient(img, imagemeta.ReadOrientation(source))
+	if len(edits) == 0 {
+		return img, nil
+	}
+	return imageedit.Apply(img, edits), nil
+}
+
+// Writes the edited image next to the source as name-edited.ext and returns its path. The source is never
+// touched and the new file only appears once it was written completely.
+func ApplyEdits(source string, edits []imageedit.Edit) (string, error) {
+	format := strings.ToUpper(strings.TrimPrefix(filepath.Ext(source), "."))
+	if !editableFormats[format] {
+		format = "PNG"
+	}
+	output := editedPath(source, "."+strings.ToLower(format))
+
+	var encoded bytes.Buffer
+	if animatedFormats[format] && animation.IsAnimated(source) {
+		anim, err := animation.Read(source)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode %s: %w", filepath.Base(source), err)
+		}
+		for i, frame := range anim.Frames {
+			anim.Frames[i].Image = imageedit.Apply(frame.Image, edits)
+		}
+
+		switch format {
+		case "GIF":
+			err = animation.EncodeGIF(&encoded, anim, true)
+		case "PNG":
+			err = animation.EncodeAPNG(&encoded, anim)
+		case "WEBP":
+			err = animation.EncodeWebP(&encoded, anim, func(frame image.Image) ([]byte, error) {
+				var buf bytes.Buffer
+				err := encodeImage(&buf, frame, "WEBP", editOptions())
+				return buf.Bytes(), err
+			})
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to encode %s: %w", filepath.Base(output), err)
+		}
+	} else {
+		img, err := DecodeEdited(source, edits)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode %s: %w", filepath.Base(source), err)
+		}
+		if err := encodeImage(&encoded, img, format, editOptions()); err != nil {
+			return "", fmt.Errorf("failed to encode %s: %w", filepath.Base(output), err)
+		}
+	}
+
+	data, err := copyMetadata(source, encoded.Bytes(), format, options.MetadataKeep)
+	if err != nil {
+		return "", fmt.Errorf("failed to copy metadata of %s: %w", filepath.Base(source), err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return "", fmt.Errorf("failed to write edited file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write edited file: %w", err)
+	}
+	if err := os.Rename(temp.Name(), output); err != nil {
+		return "", fmt.Errorf("failed to write edited file: %w", err)
+	}
+	return output, nil
+}
+
+// Edits are saved at a high quality so applying them does not visibly lose detail
+func editOptions() options.ConvertOptions {
+	opts := options.DefaultConvertOptions()
+	opts.Quality = max(opts.Quality, 95)
+	return opts
+}
+
+// Returns name-edited.ext next to the source, or name-edited-2.ext... if that already exists
+func editedPath(source string, ext string) string {
+	name := filepath.Base(source)
+	name = name[:len(name)-len(filepath.Ext(name))] + "-edited"
+	dir := filepath.Dir(source)
+
+	output := filepath.Join(dir, name+ext)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(output); os.IsNotExist(err) {
+			return output
+		}
+		output = filepath.Join(dir, name+"-"+strconv.Itoa(n)+ext)
+	}
+}
@@ -2,6 +2,10 @@ package imageconv
 
 import (
 	"fmt"
+	"io"
+	"main/pkg/animation"
+	"main/pkg/jxl"
+	"main/pkg/options"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +24,7 @@ import (
 	strukHeif "github.com/strukturag/libheif/go/heif"
 	"github.com/xfmoulet/qoi"
 	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
 	"golang.org/x/image/tiff"
 	"golang.org/x/image/webp"
 )
@@ -35,211 +40,161 @@ var ImageTypes []string = []string{
 	"AVIF",
 	"HEIC",
 	"QOI",
+	"JXL",
 }
 
 // var home, _ = os.UserHomeDir()
 
-func ConvertImage(selectedFiles []string, selectedFormat string, selectedDir string) (bool, error) {
-	// stores the converted image bytes
-	// var resImages map[string]image.Image
-	selectedDir = filepath.Clean(selectedDir) + "/"
-	fmt.Println("Selected Dir Filepath Clean: ", selectedDir)
-
-	// loops through selected files and decodes them
-	for key := range selectedFiles {
-		// gets the file extension
-		ext := filepath.Ext(selectedFiles[key])
-		// open the image file
-		fmt.Println("Selected File: ", selectedFiles[key])
-		file, err := os.Open(selectedFiles[key])
+// Decodes the image based on its extension and closes the file before returning
+func decodeImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// switch on the image extension
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		img, _, err := image.Decode(file)
+		return img, err
+	case ".bmp":
+		return bmp.Decode(file)
+	case ".tiff", ".tif":
+		return tiff.Decode(file)
+	case ".webp":
+		img, err := webp.Decode(file)
 		if err != nil {
-			// return error if failed to open file
-			// should be changed to not crash the func
-			return false, err
-		}
-		defer file.Close()
-
-		// decode the image
-		var img image.Image
-
-		// switch on the image extension
-		switch ext {
-		case ".jpg", ".jpeg":
-			img, _, err = image.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		case ".png":
-			img, _, err = image.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		case ".gif":
-			img, _, err = image.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		case ".bmp":
-			img, err = bmp.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		case ".tiff", ".tif":
-			img, err = tiff.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		case ".webp":
-			img, err = webp.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		case ".svg":
-			img, err = svg.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		case ".avif":
-			img, err = avif.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		case ".heif", ".heic":
-			img, err = goheif.Decode(file)
-			if err != nil {
-				return false, err
+			// the standard decoder does not read animations, those give their first frame
+			if anim, animErr := animation.Read(path); animErr == nil {
+				return anim.Frames[0].Image, nil
 			}
-		case ".qoi":
-			img, err = qoi.Decode(file)
-			if err != nil {
-				return false, err
-			}
-		default:
-			return false, fmt.Errorf("selected file not an image")
 		}
+		return img, err
+	case ".svg":
+		return svg.Decode(file)
+	case ".avif":
+		return avif.Decode(file)
+	case ".heif", ".heic":
+		return goheif.Decode(file)
+	case ".qoi":
+		return qoi.Decode(file)
+	case ".jxl":
+		return jxl.Decode(file)
+	default:
+		return nil, fmt.Errorf("selected file not an image")
+	}
+}
 
-		// switch on the selected format
-		// encode the image
-		// save bytes to array
-		imageName := filepath.Base(selectedFiles[key])
-		// fmt.Println("Filepath Base: ", imageName)
-		imageName = imageName[:len(imageName)-len(filepath.Ext(imageName))]
-		// fmt.Println("No Extension: ", imageName)
-		imageName += "." + strings.ToLower(selectedFormat)
-		// fmt.Println("New Extension: ", imageName)
-		fmt.Println("Selected Dir Before Create: ", selectedDir)
-		res, err := os.Create(selectedDir + imageName)
-		// res = os.NewFile(res.Fd(), res.Name())
-		if err != nil {
-			fmt.Println("Failed to create converted file: ", err)
-			return false, err
+// Encodes the image in the selected format with the quality, effort and lossless settings of opts
+func encodeImage(res io.Writer, img image.Image, selectedFormat string, opts options.ConvertOptions) error {
+	quality := max(1, min(opts.Quality, 100))
+	effort := max(0, min(opts.Effort, 10))
+
+	switch selectedFormat {
+	case "PNG":
+		encoder := png.Encoder{CompressionLevel: png.DefaultCompression}
+		if effort <= 2 {
+			encoder.CompressionLevel = png.BestSpeed
+		} else if effort >= 7 {
+			encoder.CompressionLevel = png.BestCompression
 		}
-		defer res.Close()
-
-		resFile, err := os.Open(selectedDir + "/" + imageName)
+		return encoder.Encode(res, img)
+	case "JPG", "JPEG":
+		return jpeg.Encode(res, img, &jpeg.Options{Quality: quality})
+	case "WEBP":
+		return chaiWebp.Encode(res, img, &chaiWebp.Options{Quality: float32(quality), Lossless: opts.Lossless})
+	case "GIF":
+		return gif.Encode(res, img, &gif.Options{})
+	case "BMP":
+		return bmp.Encode(res, img)
+	case "TIFF", "TIF":
+		return tiff.Encode(res, img, &tiff.Options{Compression: tiff.Deflate})
+	case "AVIF":
+		// avif is lossless at quality 100
+		if opts.Lossless {
+			quality = 100
+		}
+		return avif.Encode(res, img, avif.Options{Quality: quality, QualityAlpha: quality, Speed: 10 - effort})
+	case "HEIC":
+		lossless := strukHeif.LosslessModeDisabled
+		if opts.Lossless {
+			lossless = strukHeif.LosslessModeEnabled
+		}
+		heifCtx, err := strukHeif.EncodeFromImage(img, strukHeif.Compression(strukHeif.CompressionHEVC), quality, lossless, strukHeif.LoggingLevelBasic)
 		if err != nil {
-			fmt.Println("Failed to open converted file: ", err)
-			return false, err
+			return err
 		}
-		defer resFile.Close()
+		return writeHEIC(res, heifCtx)
+	case "QOI":
+		return qoi.Encode(res, img)
+	case "JXL":
+		// only lossless JPEG XL is written, so quality and effort do not apply
+		return jxl.Encode(res, img)
+	default:
+		return fmt.Errorf("selected format not an image type")
+	}
+}
 
-		switch selectedFormat {
-		case "PNG":
-			err = png.Encode(res, img)
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-			// resImages[key] = res
-		case "JPG", "JPEG":
-			err = jpeg.Encode(res, img, &jpeg.Options{Quality: 85})
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-		case "WEBP":
-			err = chaiWebp.Encode(res, img, &chaiWebp.Options{Quality: 85})
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-		case "GIF":
-			err = gif.Encode(res, img, &gif.Options{})
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-		case "BMP":
-			err = bmp.Encode(res, img)
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-		case "TIFF", "TIF":
-			err = tiff.Encode(res, img, &tiff.Options{Compression: tiff.Deflate})
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-		// case "SVG":
-		// 	// err = svg.Encode(res, img)
-		// 	w := img.Bounds().Size().X
-		// 	h := img.Bounds().Size().Y
-
-		// 	canvas := svgo.New(res)
-		// 	canvas.Start(w, h)
-		// 	canvas.Rect(0, 0, w, h)
-
-		// 	for y := 0; y < h; y++ {
-		// 		for x := 0; x < w; x++ {
-		// 			r, g, b, a := img.At(x, y).RGBA()
-		// 			canvas.RGBA((x*100)+50, (y*100)+50, r, g, b, a)
-		// 			canvas.Writer
-		// 		}
-		// 	}
-
-		// 	canvas.End()
-		case "AVIF":
-			err = avif.Encode(res, img, avif.Options{Quality: 85, QualityAlpha: 85})
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-		case "HEIC":
-			// heif_ctx, _ := strukHeif.NewContext()
-			_, err = strukHeif.EncodeFromImage(img, strukHeif.Compression(strukHeif.CompressionHEVC), 85, strukHeif.LosslessModeEnabled, strukHeif.LoggingLevelBasic)
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-			// strukHeif.NewImage()
-		case "QOI":
-			err = qoi.Encode(res, img)
-			if err != nil {
-				return false, err
-			}
-			fmt.Println("File Converted: ", res.Name())
-		default:
-			return false, fmt.Errorf("selected format not an image type")
-		}
+// libheif can only write a context to a file so it goes through a temporary one
+func writeHEIC(w io.Writer, ctx *strukHeif.Context) error {
+	tempDir, err := os.MkdirTemp("", "rtagger-heic")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	output := filepath.Join(tempDir, "output.heic")
+	if err := ctx.WriteToFile(output); err != nil {
+		return err
+	}
+	return copyFile(w, output)
+}
+
+// Returns the size the image is scaled to, it is never enlarged and keeps its aspect ratio
+func targetSize(width int, height int, opts options.ConvertOptions) (int, int) {
+	scale := 1.0
+	if opts.Scale > 0 && opts.Scale < 100 {
+		scale = float64(opts.Scale) / 100
+	}
+	if opts.MaxWidth > 0 && float64(width)*scale > float64(opts.MaxWidth) {
+		scale = float64(opts.MaxWidth) / float64(width)
+	}
+	if opts.MaxHeight > 0 && float64(height)*scale > float64(opts.MaxHeight) {
+		scale = float64(opts.MaxHeight) / float64(height)
+	}
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
 
-		// loop through resImages array and batch write images to disk
-		// for imageName, image := range resImages {
-		// 	// gets the filename with extension from path
-		// 	imageName = filepath.Base(imageName)
-		// 	// trims extension from filename
-		// 	imageName = imageName[:len(imageName)-len(filepath.Ext(imageName))]
-		// 	// adds selectedFormat to filename
-		// 	imageName += "." + strings.ToLower(selectedFormat)
-		// 	err := os.WriteFile(imageName, image, 0644)
-		// 	if err != nil {
-		// 		// return error if failed to create file
-		// 		// should be changed to not crash the func
-		// 		return false, err
-		// 	}
-		// }
-		// return true, nil
+// Scales the image down to fit the size limits of opts
+func resizeImage(img image.Image, opts options.ConvertOptions) image.Image {
+	bounds := img.Bounds()
+	width, height := targetSize(bounds.Dx(), bounds.Dy(), opts)
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return img
 	}
 
-	return true, nil
+	var scaler draw.Scaler
+	switch opts.Filter {
+	case "Nearest":
+		scaler = draw.NearestNeighbor
+	case "Bilinear":
+		scaler = draw.BiLinear
+	default:
+		scaler = draw.CatmullRom
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaler.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+	return resized
+}
+
+func copyFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
 }
@@ -0,0 +1,174 @@
+package imageedit
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// Edit operations, they are stored by name so saved edit stacks stay readable
+const (
+	RotateLeft     = "rotate-left"
+	RotateRight    = "rotate-right"
+	FlipHorizontal = "flip-horizontal"
+	FlipVertical   = "flip-vertical"
+	Crop           = "crop"
+)
+
+// One step of an edit stack. A crop is given as fractions of the image it is applied to, so the same
+// stack works on a thumbnail and on the full size image.
+type Edit struct {
+	Op     string  `json:"op"`
+	X      float64 `json:"x,omitempty"`
+	Y      float64 `json:"y,omitempty"`
+	Width  float64 `json:"width,omitempty"`
+	Height float64 `json:"height,omitempty"`
+}
+
+func (e Edit) String() string {
+	if e.Op == Crop {
+		return fmt.Sprintf("crop %.0f%%x%.0f%% at %.0f%%,%.0f%%", e.Width*100, e.Height*100, e.X*100, e.Y*100)
+	}
+	return e.Op
+}
+
+// Parses an edit stack saved with Marshal, an empty string is an empty stack
+func Parse(data string) ([]Edit, error) {
+	var edits []Edit
+	if data == "" {
+		return edits, nil
+	}
+	if err := json.Unmarshal([]byte(data), &edits); err != nil {
+		return nil, err
+	}
+	for _, edit := range edits {
+		if err := edit.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return edits, nil
+}
+
+func Marshal(edits []Edit) (string, error) {
+	for _, edit := range edits {
+		if err := edit.validate(); err != nil {
+			return "", err
+		}
+	}
+	data, err := json.Marshal(edits)
+	return string(data), err
+}
+
+func (e Edit) validate() error {
+	switch e.Op {
+	case RotateLeft, RotateRight, FlipHorizontal, FlipVertical:
+		return nil
+	case Crop:
+		if e.X < 0 || e.Y < 0 || e.Width <= 0 || e.Height <= 0 || e.X+e.Width > 1 || e.Y+e.Height > 1 {
+			return fmt.Errorf("crop %v is outside of the image", e)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown edit %q", e.Op)
+}
+
+// Applies the edits in order and returns the result, the source image is not changed
+func Apply(img image.Image, edits []Edit) *image.NRGBA {
+	result := toNRGBA(img)
+	for _, edit := range edits {
+		switch edit.Op {
+		case RotateLeft:
+			result = transform(result, rotateLeft)
+		case RotateRight:
+			result = transform(result, rotateRight)
+		case FlipHorizontal:
+			result = transform(result, flipHorizontal)
+		case FlipVertical:
+			result = transform(result, flipVertical)
+		case Crop:
+			result = crop(result, edit)
+		}
+	}
+	return result
+}
+
+// Turns the image upright according to its EXIF orientation
+func Orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return Apply(img, []Edit{{Op: FlipHorizontal}})
+	case 3:
+		return Apply(img, []Edit{{Op: RotateRight}, {Op: RotateRight}})
+	case 4:
+		return Apply(img, []Edit{{Op: FlipVertical}})
+	case 5:
+		return Apply(img, []Edit{{Op: RotateRight}, {Op: FlipHorizontal}})
+	case 6:
+		return Apply(img, []Edit{{Op: RotateRight}})
+	case 7:
+		return Apply(img, []Edit{{Op: RotateRight}, {Op: FlipVertical}})
+	case 8:
+		return Apply(img, []Edit{{Op: RotateLeft}})
+	}
+	return img
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+	bounds := img.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(result, result.Rect, img, bounds.Min, draw.Src)
+	return result
+}
+
+// Maps a pixel of a width x height image to its position in the transformed image
+type pixelMapping func(x, y, width, height int) (int, int)
+
+func rotateLeft(x, y, width, height int) (int, int)     { return y, width - 1 - x }
+func rotateRight(x, y, width, height int) (int, int)    { return height - 1 - y, x }
+func flipHorizontal(x, y, width, height int) (int, int) { return width - 1 - x, y }
+func flipVertical(x, y, width, height int) (int, int)   { return x, height - 1 - y }
+
+func transform(img *image.NRGBA, mapping pixelMapping) *image.NRGBA {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	// rotations swap the sides, mapping the far corner gives the new size
+	maxX, maxY := mapping(width-1, height-1, width, height)
+	minX, minY := mapping(0, 0, width, height)
+	result := image.NewNRGBA(image.Rect(0, 0, max(maxX, minX)+1, max(maxY, minY)+1))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			tx, ty := mapping(x, y, width, height)
+			src := y*img.Stride + x*4
+			dst := ty*result.Stride + tx*4
+			copy(result.Pix[dst:dst+4], img.Pix[src:src+4])
+		}
+	}
+	return result
+}
+
+func crop(img *image.NRGBA, edit Edit) *image.NRGBA {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	rect := image.Rect(
+		int(edit.X*float64(width)+0.5),
+		int(edit.Y*float64(height)+0.5),
+		int((edit.X+edit.Width)*float64(width)+0.5),
+		int((edit.Y+edit.Height)*float64(height)+0.5),
+	).Intersect(img.Rect)
+	// a crop never leaves less than one pixel, even on a tiny thumbnail
+	if rect.Dx() < 1 {
+		rect.Max.X = min(rect.Min.X+1, width)
+		rect.Min.X = rect.Max.X - 1
+	}
+	if rect.Dy() < 1 {
+		rect.Max.Y = min(rect.Min.Y+1, height)
+		rect.Min.Y = rect.Max.Y - 1
+	}
+
+	result := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(result, result.Rect, img, rect.Min, draw.Src)
+	return result
+}
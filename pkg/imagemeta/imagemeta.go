@@ -0,0 +1,251 @@
+package imagemeta
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Metadata carried over when converting, Exif is the raw TIFF structure without the "Exif\0\0" prefix
+type Metadata struct {
+	Exif []byte
+	ICC  []byte
+}
+
+func (m *Metadata) Empty() bool {
+	return m == nil || (len(m.Exif) == 0 && len(m.ICC) == 0)
+}
+
+var (
+	exifPrefix = []byte("Exif\x00\x00")
+	iccPrefix  = []byte("ICC_PROFILE\x00")
+	pngMagic   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Reads the EXIF and ICC data of a JPEG, PNG or WebP file, other formats return empty metadata
+func Read(path string) (*Metadata, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+	default:
+		return &Metadata{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Reads the metadata from the bytes of a JPEG, PNG or WebP image
+func Parse(data []byte) (*Metadata, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return parseJPEG(data)
+	case bytes.HasPrefix(data, pngMagic):
+		return parsePNG(data)
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return parseWebP(data)
+	}
+	return &Metadata{}, nil
+}
+
+func parseJPEG(data []byte) (*Metadata, error) {
+	meta := &Metadata{}
+	iccChunks := map[byte][]byte{}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, errors.New("invalid jpeg segment")
+		}
+		marker := data[pos+1]
+		// start of scan, the metadata segments all come before the image data
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, errors.New("truncated jpeg segment")
+		}
+		payload := data[pos+4 : pos+2+length]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, exifPrefix) && meta.Exif == nil:
+			meta.Exif = bytes.Clone(payload[len(exifPrefix):])
+		case marker == 0xE2 && bytes.HasPrefix(payload, iccPrefix) && len(payload) > len(iccPrefix)+2:
+			// profiles bigger than a segment are split with a sequence number
+			iccChunks[payload[len(iccPrefix)]] = payload[len(iccPrefix)+2:]
+		}
+		pos += 2 + length
+	}
+
+	if len(iccChunks) > 0 {
+		sequence := make([]int, 0, len(iccChunks))
+		for seq := range iccChunks {
+			sequence = append(sequence, int(seq))
+		}
+		sort.Ints(sequence)
+		for _, seq := range sequence {
+			meta.ICC = append(meta.ICC, iccChunks[byte(seq)]...)
+		}
+	}
+	return meta, nil
+}
+
+func parsePNG(data []byte) (*Metadata, error) {
+	meta := &Metadata{}
+
+	pos := len(pngMagic)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if pos+12+length > len(data) {
+			return nil, errors.New("truncated png chunk")
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		payload := data[pos+8 : pos+8+length]
+
+		switch chunkType {
+		case "eXIf":
+			meta.Exif = bytes.Clone(payload)
+		case "iCCP":
+			// profile name, a null byte, the compression method and the zlib compressed profile
+			nameEnd := bytes.IndexByte(payload, 0)
+			if nameEnd < 0 || nameEnd+2 > len(payload) {
+				return nil, errors.New("invalid png iCCP chunk")
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(payload[nameEnd+2:]))
+			if err != nil {
+				return nil, err
+			}
+			meta.ICC, err = io.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				return nil, err
+			}
+		case "IDAT", "IEND":
+			// the metadata chunks must come before the image data
+			return meta, nil
+		}
+		pos += 12 + length
+	}
+	return meta, nil
+}
+
+func parseWebP(data []byte) (*Metadata, error) {
+	meta := &Metadata{}
+
+	pos := 12
+	for pos+8 <= len(data) {
+		chunkType := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		if pos+8+length > len(data) {
+			return nil, errors.New("truncated webp chunk")
+		}
+		payload := data[pos+8 : pos+8+length]
+
+		switch chunkType {
+		case "EXIF":
+			// some writers keep the jpeg style prefix
+			meta.Exif = bytes.Clone(bytes.TrimPrefix(payload, exifPrefix))
+		case "ICCP":
+			meta.ICC = bytes.Clone(payload)
+		}
+		// chunks are padded to an even size
+		pos += 8 + length + length%2
+	}
+	return meta, nil
+}
+
+// Inserts the metadata right after the start of image marker of a JPEG
+func WriteJPEG(data []byte, meta *Metadata) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return nil, errors.New("not a jpeg image")
+	}
+	if meta.Empty() {
+		return data, nil
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+
+	if len(meta.Exif) > 0 {
+		payload := append(bytes.Clone(exifPrefix), meta.Exif...)
+		if err := writeJPEGSegment(&out, 0xE1, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	// a segment holds at most 65533 bytes, minus the ICC_PROFILE header and the sequence bytes
+	const iccChunkSize = 65533 - 14
+	chunks := (len(meta.ICC) + iccChunkSize - 1) / iccChunkSize
+	if chunks > 255 {
+		return nil, errors.New("icc profile is too big for a jpeg")
+	}
+	for i := 0; i < chunks; i++ {
+		chunk := meta.ICC[i*iccChunkSize : min((i+1)*iccChunkSize, len(meta.ICC))]
+		payload := append(bytes.Clone(iccPrefix), byte(i+1), byte(chunks))
+		if err := writeJPEGSegment(&out, 0xE2, append(payload, chunk...)); err != nil {
+			return nil, err
+		}
+	}
+
+	out.Write(data[2:])
+	return out.Bytes(), nil
+}
+
+func writeJPEGSegment(out *bytes.Buffer, marker byte, payload []byte) error {
+	if len(payload)+2 > 0xFFFF {
+		return errors.New("metadata is too big for a jpeg segment")
+	}
+	out.Write([]byte{0xFF, marker})
+	binary.Write(out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	return nil
+}
+
+// Inserts the metadata chunks right after the IHDR chunk of a PNG
+func WritePNG(data []byte, meta *Metadata) ([]byte, error) {
+	// the signature followed by the 25 byte IHDR chunk
+	ihdrEnd := len(pngMagic) + 25
+	if !bytes.HasPrefix(data, pngMagic) || len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return nil, errors.New("not a png image")
+	}
+	if meta.Empty() {
+		return data, nil
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+
+	if len(meta.ICC) > 0 {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(meta.ICC)
+		zw.Close()
+		writePNGChunk(&out, "iCCP", append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...))
+	}
+	if len(meta.Exif) > 0 {
+		writePNGChunk(&out, "eXIf", meta.Exif)
+	}
+
+	out.Write(data[ihdrEnd:])
+	return out.Bytes(), nil
+}
+
+func writePNGChunk(out *bytes.Buffer, chunkType string, payload []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(payload)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(payload)
+	out.WriteString(chunkType)
+	out.Write(payload)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
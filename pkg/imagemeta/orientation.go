@@ -0,0 +1,81 @@
+package imagemeta
+
+import "encoding/binary"
+
+// EXIF orientation values, 1 is upright and 2-8 describe the rotation and mirroring a viewer has to apply
+const (
+	OrientationNormal  = 1
+	orientationTag     = 0x0112
+	exifTypeShort      = 3
+	tiffHeaderSize     = 8
+	ifdEntrySize       = 12
+	orientationMaximum = 8
+)
+
+// Returns the byte order of the TIFF structure and the offset of the orientation value, -1 if there is none
+func findOrientation(exif []byte) (binary.ByteOrder, int) {
+	if len(exif) < tiffHeaderSize {
+		return nil, -1
+	}
+	var order binary.ByteOrder
+	switch string(exif[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, -1
+	}
+
+	ifd := int(order.Uint32(exif[4:8]))
+	if ifd < tiffHeaderSize || ifd+2 > len(exif) {
+		return nil, -1
+	}
+	entries := int(order.Uint16(exif[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*ifdEntrySize
+		if entry+ifdEntrySize > len(exif) {
+			break
+		}
+		if order.Uint16(exif[entry:entry+2]) == orientationTag && order.Uint16(exif[entry+2:entry+4]) == exifTypeShort {
+			// a single short is stored in the first two bytes of the value field
+			return order, entry + 8
+		}
+	}
+	return nil, -1
+}
+
+// Returns the EXIF orientation, images without one are upright
+func (m *Metadata) Orientation() int {
+	if m == nil {
+		return OrientationNormal
+	}
+	order, offset := findOrientation(m.Exif)
+	if offset < 0 {
+		return OrientationNormal
+	}
+	orientation := int(order.Uint16(m.Exif[offset : offset+2]))
+	if orientation < OrientationNormal || orientation > orientationMaximum {
+		return OrientationNormal
+	}
+	return orientation
+}
+
+// Marks the image as upright, used once the orientation was applied to the pixels
+func (m *Metadata) ResetOrientation() {
+	order, offset := findOrientation(m.Exif)
+	if offset < 0 {
+		return
+	}
+	m.Exif = append([]byte(nil), m.Exif...)
+	order.PutUint16(m.Exif[offset:offset+2], OrientationNormal)
+}
+
+// Reads the EXIF orientation of a file, files without readable metadata are upright
+func ReadOrientation(path string) int {
+	meta, err := Read(path)
+	if err != nil {
+		return OrientationNormal
+	}
+	return meta.Orientation()
+}
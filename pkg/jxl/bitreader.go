@@ -0,0 +1,170 @@
+package jxl
+
+import (
+	"math"
+)
+
+// Reads the codestream bits, least significant bit of each byte first
+type bitReader struct {
+	data []byte
+	pos  int    // next byte to load into buf
+	buf  uint64 // loaded bits not consumed yet
+	n    uint   // number of bits in buf
+	read int64  // bits consumed so far
+	err  error
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (br *bitReader) refill() {
+	for br.n <= 56 && br.pos < len(br.data) {
+		br.buf |= uint64(br.data[br.pos]) << br.n
+		br.pos++
+		br.n += 8
+	}
+}
+
+// Reads n bits, n is at most 32
+func (br *bitReader) bits(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	if br.n < n {
+		br.refill()
+		if br.n < n {
+			if br.err == nil {
+				br.err = errTruncated
+			}
+			br.buf, br.n = 0, 0
+			return 0
+		}
+	}
+	v := uint32(br.buf & (1<<n - 1))
+	br.buf >>= n
+	br.n -= n
+	br.read += int64(n)
+	return v
+}
+
+// Returns the next n bits without consuming them, missing bits past the end read as zero
+func (br *bitReader) peek(n uint) uint32 {
+	if br.n < n {
+		br.refill()
+	}
+	return uint32(br.buf & (1<<n - 1))
+}
+
+// Consumes n bits that were looked at with peek
+func (br *bitReader) skip(n uint) {
+	if br.n < n {
+		if br.err == nil {
+			br.err = errTruncated
+		}
+		br.buf, br.n = 0, 0
+		return
+	}
+	br.buf >>= n
+	br.n -= n
+	br.read += int64(n)
+}
+
+func (br *bitReader) bool() bool {
+	return br.bits(1) == 1
+}
+
+// Skips to the next byte boundary, the skipped bits have to be zero
+func (br *bitReader) zeroPadToByte() {
+	if pad := uint(-br.read & 7); pad > 0 && br.bits(pad) != 0 && br.err == nil {
+		br.err = FormatError("nonzero padding bits")
+	}
+}
+
+// Skips n bits, used for extensions the decoder does not know
+func (br *bitReader) skipBits(n uint64) {
+	for n > 0 && br.err == nil {
+		step := uint(min(n, 32))
+		br.bits(step)
+		n -= uint64(step)
+	}
+}
+
+// Number of bytes the reader has moved into, a partly read byte counts
+func (br *bitReader) bytesRead() int {
+	return int((br.read + 7) / 8)
+}
+
+// One of the four distributions of a U32 field: a constant plus the value of bits extra bits
+type u32Dist struct {
+	offset uint32
+	bits   uint
+}
+
+func val(v uint32) u32Dist                { return u32Dist{offset: v} }
+func bitsOf(n uint) u32Dist               { return u32Dist{bits: n} }
+func bitsOffset(n uint, o uint32) u32Dist { return u32Dist{offset: o, bits: n} }
+
+func (br *bitReader) u32(d0, d1, d2, d3 u32Dist) uint32 {
+	d := [4]u32Dist{d0, d1, d2, d3}[br.bits(2)]
+	return d.offset + br.bits(d.bits)
+}
+
+func (br *bitReader) u64() uint64 {
+	switch br.bits(2) {
+	case 0:
+		return 0
+	case 1:
+		return 1 + uint64(br.bits(4))
+	case 2:
+		return 17 + uint64(br.bits(8))
+	}
+	v := uint64(br.bits(12))
+	for shift := uint(12); br.bool(); {
+		if shift == 60 {
+			v |= uint64(br.bits(4)) << shift
+			break
+		}
+		v |= uint64(br.bits(8)) << shift
+		shift += 8
+	}
+	return v
+}
+
+func (br *bitReader) enum() uint32 {
+	return br.u32(val(0), val(1), bitsOffset(4, 2), bitsOffset(6, 18))
+}
+
+// Variable length byte used by the entropy code headers
+func (br *bitReader) u8() uint32 {
+	if !br.bool() {
+		return 0
+	}
+	n := uint(br.bits(3))
+	return 1<<n + br.bits(n)
+}
+
+func (br *bitReader) f16() float32 {
+	return halfToFloat(uint16(br.bits(16)))
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+	switch {
+	case exp == 0:
+		f := float32(mant) / 1024 / 16384
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case exp == 31:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+}
+
+func unpackSigned(v uint32) int32 {
+	return int32(v>>1) ^ -int32(v&1)
+}
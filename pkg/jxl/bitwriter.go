@@ -0,0 +1,307 @@
+package jxl
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Writes bits the way bitReader reads them
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint
+}
+
+func (w *bitWriter) bits(v uint32, n uint) {
+	w.acc |= uint64(v) & (1<<n - 1) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.n -= 8
+	}
+}
+
+func (w *bitWriter) bool(b bool) {
+	if b {
+		w.bits(1, 1)
+	} else {
+		w.bits(0, 1)
+	}
+}
+
+func (w *bitWriter) zeroPadToByte() {
+	if w.n > 0 {
+		w.bits(0, 8-w.n)
+	}
+}
+
+// Pads to a byte boundary and returns what was written
+func (w *bitWriter) bytes() []byte {
+	w.zeroPadToByte()
+	return w.buf
+}
+
+// Writes v with the first of the four distributions that can hold it
+func (w *bitWriter) u32(v uint32, d0, d1, d2, d3 u32Dist) {
+	for i, d := range [4]u32Dist{d0, d1, d2, d3} {
+		if v >= d.offset && uint64(v-d.offset) < 1<<d.bits {
+			w.bits(uint32(i), 2)
+			w.bits(v-d.offset, d.bits)
+			return
+		}
+	}
+	panic("jxl: value does not fit its U32 field")
+}
+
+func (w *bitWriter) enum(v uint32) {
+	w.u32(v, val(0), val(1), bitsOffset(4, 2), bitsOffset(6, 18))
+}
+
+func packSigned(v int32) uint32 {
+	return uint32(v<<1) ^ uint32(v>>31)
+}
+
+// The hybrid uint configuration the encoder uses for everything
+var encodeConfig = hybridUintConfig{splitExponent: 4, msbInToken: 2, lsbInToken: 0}
+
+// Splits v into the token and the raw bits that follow it
+func (c hybridUintConfig) encode(v uint32) (token, n, extra uint32) {
+	split := uint32(1) << c.splitExponent
+	if v < split {
+		return v, 0, 0
+	}
+	top := uint32(bits.Len32(v)) - 1
+	m := v - 1<<top
+	token = split + (top-c.splitExponent)<<(c.msbInToken+c.lsbInToken) +
+		(m>>(top-c.msbInToken))<<c.lsbInToken + m&(1<<c.lsbInToken-1)
+	n = top - c.msbInToken - c.lsbInToken
+	return token, n, v >> c.lsbInToken & (1<<n - 1)
+}
+
+// Code lengths of a Huffman code for counts, no longer than limit
+func huffmanLengths(counts []uint32, limit int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	type node struct {
+		count       uint64
+		left, right int
+	}
+	for {
+		var nodes []node
+		var queue []int
+		for sym, c := range counts {
+			if c > 0 {
+				nodes = append(nodes, node{count: uint64(c), left: -1, right: sym})
+				queue = append(queue, len(nodes)-1)
+			}
+		}
+		if len(queue) == 1 {
+			lengths[nodes[0].right] = 1
+			return lengths
+		}
+		for len(queue) > 1 {
+			sort.SliceStable(queue, func(i, j int) bool { return nodes[queue[i]].count < nodes[queue[j]].count })
+			a, b := queue[0], queue[1]
+			nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, left: a, right: b})
+			queue = append(queue[2:], len(nodes)-1)
+		}
+		tooLong := false
+		var walk func(i int, depth uint8)
+		walk = func(i int, depth uint8) {
+			if nodes[i].left < 0 {
+				lengths[nodes[i].right] = depth
+				tooLong = tooLong || int(depth) > limit
+				return
+			}
+			walk(nodes[i].left, depth+1)
+			walk(nodes[i].right, depth+1)
+		}
+		walk(queue[0], 0)
+		if !tooLong {
+			return lengths
+		}
+		for i, c := range counts {
+			if c > 0 {
+				counts[i] = c/2 + 1
+			}
+		}
+	}
+}
+
+// The canonical codes of lengths as buildPrefixCode assigns them, bit reversed so they can be written as they are
+func canonicalCodes(lengths []uint8) []uint32 {
+	var count [maxPrefixBits + 1]int
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [maxPrefixBits + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= maxPrefixBits; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for sym, l := range lengths {
+		if l > 0 {
+			codes[sym] = bits.Reverse32(next[l]) >> (32 - uint(l))
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// Writes a prefix code for counts over an alphabet of len(counts) symbols and returns its code lengths
+func writePrefixCode(w *bitWriter, counts []uint32) []uint8 {
+	size := len(counts)
+	if size == 1 {
+		return []uint8{0}
+	}
+	var used []int
+	for sym, c := range counts {
+		if c > 0 {
+			used = append(used, sym)
+		}
+	}
+	if len(used) <= 4 {
+		sort.SliceStable(used, func(i, j int) bool { return counts[used[i]] > counts[used[j]] })
+		lengths := make([]uint8, size)
+		w.bits(1, 2)
+		w.bits(uint32(len(used)-1), 2)
+		for _, sym := range used {
+			w.bits(uint32(sym), uint(bits.Len(uint(size-1))))
+		}
+		switch len(used) {
+		case 2:
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		case 3:
+			lengths[used[0]], lengths[used[1]], lengths[used[2]] = 1, 2, 2
+		case 4:
+			w.bool(false)
+			for _, sym := range used {
+				lengths[sym] = 2
+			}
+		}
+		return lengths
+	}
+
+	lengths := huffmanLengths(append([]uint32(nil), counts...), maxPrefixBits)
+	var lengthCounts [18]uint32
+	for _, l := range lengths {
+		lengthCounts[l]++
+	}
+	lengthLengths := huffmanLengths(lengthCounts[:], 5)
+	numCodes := 0
+	for _, l := range lengthLengths {
+		if l > 0 {
+			numCodes++
+		}
+	}
+	if numCodes == 1 {
+		// a single length is read with no bits, whatever length it says it has
+		for i := range lengthLengths {
+			if lengthLengths[i] > 0 {
+				lengthLengths[i] = 1
+			}
+		}
+	}
+
+	w.bits(0, 2)
+	space := 32
+	for _, sym := range codeLengthOrder {
+		v := lengthLengths[sym]
+		for idx, pv := range codeLengthPrefixValue {
+			if pv == v {
+				w.bits(uint32(idx), uint(codeLengthPrefixLength[idx]))
+				break
+			}
+		}
+		if v != 0 {
+			space -= 32 >> v
+		}
+		if numCodes > 1 && space == 0 {
+			break
+		}
+	}
+	lengthCodes := canonicalCodes(lengthLengths)
+	for _, l := range lengths {
+		if numCodes > 1 {
+			w.bits(lengthCodes[l], uint(lengthLengths[l]))
+		}
+	}
+	return lengths
+}
+
+type token struct {
+	ctx   int
+	value uint32
+}
+
+// Collects the integers of an entropy coded stream and writes them with a prefix code per context
+type tokenWriter struct {
+	numCtx int
+	tokens []token
+}
+
+func (tw *tokenWriter) add(ctx int, v uint32) {
+	tw.tokens = append(tw.tokens, token{ctx, v})
+}
+
+// Writes the entropy code of the collected integers followed by the integers
+func (tw *tokenWriter) write(w *bitWriter) {
+	if tw.numCtx > 8 {
+		panic("jxl: too many contexts for a simple context map")
+	}
+	counts := make([][]uint32, tw.numCtx)
+	for _, t := range tw.tokens {
+		sym, _, _ := encodeConfig.encode(t.value)
+		for int(sym) >= len(counts[t.ctx]) {
+			counts[t.ctx] = append(counts[t.ctx], 0)
+		}
+		counts[t.ctx][sym]++
+	}
+
+	w.bool(false) // no lz77
+	if tw.numCtx > 1 {
+		w.bool(true)
+		nbits := uint(bits.Len(uint(tw.numCtx - 1)))
+		w.bits(uint32(nbits), 2)
+		for ctx := range tw.numCtx {
+			w.bits(uint32(ctx), nbits)
+		}
+	}
+	w.bool(true) // prefix codes
+	for range tw.numCtx {
+		w.bits(encodeConfig.splitExponent, uint(bits.Len32(maxPrefixBits)))
+		w.bits(encodeConfig.msbInToken, uint(bits.Len32(encodeConfig.splitExponent)))
+		w.bits(encodeConfig.lsbInToken, uint(bits.Len32(encodeConfig.splitExponent-encodeConfig.msbInToken)))
+	}
+	for ctx := range counts {
+		if len(counts[ctx]) == 0 {
+			counts[ctx] = []uint32{1}
+		}
+		if size := len(counts[ctx]); size == 1 {
+			w.bool(false)
+		} else {
+			n := uint(bits.Len(uint(size-1))) - 1
+			w.bool(true)
+			w.bits(uint32(n), 4)
+			w.bits(uint32(size-1-1<<n), n)
+		}
+	}
+	codes := make([][]uint32, tw.numCtx)
+	lengths := make([][]uint8, tw.numCtx)
+	for ctx := range counts {
+		lengths[ctx] = writePrefixCode(w, counts[ctx])
+		codes[ctx] = canonicalCodes(lengths[ctx])
+	}
+
+	for _, t := range tw.tokens {
+		sym, n, extra := encodeConfig.encode(t.value)
+		if l := lengths[t.ctx]; len(l) > 1 && l[sym] > 0 {
+			w.bits(codes[t.ctx][sym], uint(l[sym]))
+		}
+		w.bits(extra, uint(n))
+	}
+}
@@ -0,0 +1,630 @@
+package jxl
+
+import (
+	"image"
+	"math"
+)
+
+// A frame or the canvas as floating point planes, the colour planes followed by one per extra channel
+type planes struct {
+	w, h   int
+	colour int
+	p      [][]float32
+}
+
+func newPlanes(w, h, colour, extra int) *planes {
+	p := &planes{w: w, h: h, colour: colour, p: make([][]float32, colour+extra)}
+	for i := range p.p {
+		p.p[i] = make([]float32, w*h)
+	}
+	return p
+}
+
+type patchRef struct {
+	ref, x0, y0, w, h int
+}
+
+// One placement of a rectangle of a reference frame onto the frame, with a blending per channel
+type patch struct {
+	ref       patchRef
+	x, y      int
+	blendings []blending
+}
+
+type decoder struct {
+	data   []byte
+	header *imageHeader
+	refs   [4]*planes
+}
+
+func (d *decoder) decode(br *bitReader) (image.Image, error) {
+	h := d.header
+	if h.wantICC {
+		if err := skipICC(br); err != nil {
+			return nil, err
+		}
+	}
+	br.zeroPadToByte()
+	if br.err != nil {
+		return nil, br.err
+	}
+	pos := br.bytesRead()
+
+	if h.havePreview {
+		br = newBitReader(d.data[pos:])
+		fh, err := readFrameHeader(br, h, h.previewW, h.previewH)
+		if err != nil {
+			return nil, err
+		}
+		_, end, err := readTOC(br, fh, d.data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += end
+	}
+
+	for pos < len(d.data) {
+		data := d.data[pos:]
+		br = newBitReader(data)
+		fh, err := readFrameHeader(br, h, h.width, h.height)
+		if err != nil {
+			return nil, err
+		}
+		sections, end, err := readTOC(br, fh, data)
+		if err != nil {
+			return nil, err
+		}
+		pos += end
+
+		frame, err := d.decodeFrame(fh, sections)
+		if err != nil {
+			return nil, err
+		}
+		if fh.saveBeforeCT {
+			d.refs[fh.saveAsRef] = frame
+		}
+		if h.xybEncoded {
+			d.xybToRGB(frame)
+		}
+		if fh.frameType != frameRegular && fh.frameType != frameSkipProgressive {
+			if fh.canBeReferenced() && !fh.saveBeforeCT {
+				d.refs[fh.saveAsRef] = frame
+			}
+			continue
+		}
+		canvas := d.composite(fh, frame)
+		if fh.canBeReferenced() && !fh.saveBeforeCT {
+			d.refs[fh.saveAsRef] = canvas
+		}
+		if fh.isLast || fh.duration > 0 {
+			return d.toImage(canvas), nil
+		}
+	}
+	return nil, errTruncated
+}
+
+// Decodes the sections of one frame into planes, the colour is still in the frame's colour space
+func (d *decoder) decodeFrame(fh *frameHeader, sections [][]byte) (*planes, error) {
+	h := d.header
+	if !fh.modular {
+		return nil, UnsupportedError("VarDCT frames")
+	}
+	if fh.frameType == frameLF || fh.flags&flagUseLF != 0 {
+		return nil, UnsupportedError("LF frames")
+	}
+	if fh.doYCbCr {
+		return nil, UnsupportedError("YCbCr frames")
+	}
+	if fh.upsampling != 1 {
+		return nil, UnsupportedError("upsampling")
+	}
+	for i, ec := range h.extraChannels {
+		if fh.ecUpsampling[i] != 1 || ec.dimShift != 0 {
+			return nil, UnsupportedError("upsampling")
+		}
+	}
+
+	section := func(i int) *bitReader {
+		return newBitReader(sections[i])
+	}
+	if len(sections) == 1 {
+		shared := newBitReader(sections[0])
+		section = func(int) *bitReader { return shared }
+	}
+
+	br := section(0)
+	var patches []patch
+	if fh.flags&flagPatches != 0 {
+		var err error
+		if patches, err = d.readPatches(br, fh); err != nil {
+			return nil, err
+		}
+	}
+	if fh.flags&flagSplines != 0 {
+		return nil, UnsupportedError("splines")
+	}
+	if fh.flags&flagNoise != 0 {
+		br.skipBits(8 * 10)
+	}
+	lfDequant := [3]float32{1.0 / 4096, 1.0 / 512, 1.0 / 256}
+	if !br.bool() {
+		for i := range lfDequant {
+			lfDequant[i] = br.f16() / 128
+		}
+	}
+
+	colour := 3
+	if h.colourSpace == colourSpaceGrey && !h.xybEncoded {
+		colour = 1
+	}
+	img := &modularImage{bitDepth: h.depth.bits}
+	for range colour + len(h.extraChannels) {
+		img.channels = append(img.channels, newChannel(fh.width, fh.height, 0, 0))
+	}
+	var tree *maTree
+	if br.bool() {
+		limit := min(1<<22, 1024+fh.width*fh.height*len(img.channels)/16)
+		var err error
+		if tree, err = readTree(br, limit); err != nil {
+			return nil, err
+		}
+	}
+	if err := decodeModularStream(br, img, 0, fh.groupDim, tree, false); err != nil {
+		return nil, err
+	}
+
+	for g := range fh.numLFGroups {
+		x0, y0 := g%fh.lfGroupsX*fh.lfGroupDim, g/fh.lfGroupsX*fh.lfGroupDim
+		if err := decodeGroup(section(1+g), img, fh, x0, y0, fh.lfGroupDim, 3, math.MaxInt, 1+fh.numLFGroups+g, tree); err != nil {
+			return nil, err
+		}
+	}
+	for pass := range fh.numPasses {
+		minShift, maxShift := fh.downsamplingBracket(pass)
+		for g := range fh.numGroups {
+			x0, y0 := g%fh.groupsX*fh.groupDim, g/fh.groupsX*fh.groupDim
+			streamID := 1 + 3*fh.numLFGroups + 17 + fh.numGroups*pass + g
+			br := section(2 + fh.numLFGroups + fh.numGroups*pass + g)
+			if err := decodeGroup(br, img, fh, x0, y0, fh.groupDim, minShift, maxShift, streamID, tree); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := img.undoTransforms(); err != nil {
+		return nil, err
+	}
+	if len(img.channels) != colour+len(h.extraChannels) {
+		return nil, FormatError("transforms changed the number of channels")
+	}
+
+	frame := newPlanes(fh.width, fh.height, colour, len(h.extraChannels))
+	for c, ch := range img.channels {
+		if ch.w != fh.width || ch.h != fh.height {
+			return nil, FormatError("transforms changed a channel size")
+		}
+		depth := h.depth
+		if c >= colour {
+			depth = h.extraChannels[c-colour].depth
+		}
+		factor := float32(1)
+		src := ch
+		if h.xybEncoded && c < 3 {
+			// XYB is coded as Y, X and B-Y
+			factor = lfDequant[c]
+			if c < 2 {
+				src = img.channels[1-c]
+			}
+		}
+		out := frame.p[c]
+		switch {
+		case h.xybEncoded && c == 2:
+			y := img.channels[0]
+			for i, v := range src.pix {
+				out[i] = float32(v+y.pix[i]) * factor
+			}
+		case h.xybEncoded && c < 2:
+			for i, v := range src.pix {
+				out[i] = float32(v) * factor
+			}
+		case depth.floatSample:
+			for i, v := range src.pix {
+				out[i] = sampleToFloat(uint32(v), depth)
+			}
+		default:
+			scale := 1 / float32(uint64(1)<<depth.bits-1)
+			for i, v := range src.pix {
+				out[i] = float32(v) * scale
+			}
+		}
+	}
+
+	if fh.gab {
+		gaborish(frame, fh.gabWeights)
+	}
+	if len(patches) > 0 {
+		d.applyPatches(frame, patches)
+	}
+	return frame, nil
+}
+
+// Decodes the part of img that one LF group or pass group carries
+func decodeGroup(br *bitReader, img *modularImage, fh *frameHeader, x0, y0, size, minShift, maxShift, streamID int, tree *maTree) error {
+	gi := &modularImage{bitDepth: img.bitDepth}
+	c := img.nbMeta
+	for ; c < len(img.channels); c++ {
+		if ch := img.channels[c]; ch.w > fh.groupDim || ch.h > fh.groupDim {
+			break
+		}
+	}
+	type target struct{ c, x, y int }
+	var targets []target
+	for ; c < len(img.channels); c++ {
+		ch := img.channels[c]
+		if shift := min(ch.hshift, ch.vshift); shift < minShift || shift > maxShift {
+			continue
+		}
+		cx, cy := x0>>ch.hshift, y0>>ch.vshift
+		w, h := min(size>>ch.hshift, ch.w-cx), min(size>>ch.vshift, ch.h-cy)
+		if w <= 0 || h <= 0 {
+			continue
+		}
+		gi.channels = append(gi.channels, newChannel(w, h, ch.hshift, ch.vshift))
+		targets = append(targets, target{c, cx, cy})
+	}
+	if len(gi.channels) == 0 {
+		return nil
+	}
+	if err := decodeModularStream(br, gi, streamID, math.MaxInt, tree, true); err != nil {
+		return err
+	}
+	if len(gi.channels) != len(targets) {
+		return FormatError("group transforms changed the number of channels")
+	}
+	for i, t := range targets {
+		src, dst := gi.channels[i], img.channels[t.c]
+		for y := range src.h {
+			copy(dst.pix[(t.y+y)*dst.w+t.x:], src.pix[y*src.w:(y+1)*src.w])
+		}
+	}
+	return nil
+}
+
+// Turns the bits of a float sample of the given depth into its value
+func sampleToFloat(v uint32, depth bitDepth) float32 {
+	if depth.bits == 32 && depth.expBits == 8 {
+		return math.Float32frombits(v)
+	}
+	mantBits := depth.bits - depth.expBits - 1
+	sign := v >> (depth.bits - 1) & 1
+	exp := int(v>>mantBits) & (1<<depth.expBits - 1)
+	mant := float64(v&(1<<mantBits-1)) / float64(uint64(1)<<mantBits)
+	bias := 1<<(depth.expBits-1) - 1
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, 1-bias)
+	case 1<<depth.expBits - 1:
+		f = math.Inf(1)
+		if mant != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(1+mant, exp-bias)
+	}
+	if sign != 0 {
+		f = -f
+	}
+	return float32(f)
+}
+
+func mirror(v, size int) int {
+	for v < 0 || v >= size {
+		if v < 0 {
+			v = -v - 1
+		} else {
+			v = 2*size - 1 - v
+		}
+	}
+	return v
+}
+
+// Applies the gaborish loop filter, a small blur that undoes the sharpening the encoder did
+func gaborish(p *planes, weights [3][2]float32) {
+	row := make([]float32, p.w*3)
+	for c := range p.colour {
+		w1, w2 := weights[c][0], weights[c][1]
+		norm := 1 / (1 + 4*(w1+w2))
+		w0, w1, w2 := norm, w1*norm, w2*norm
+		src := p.p[c]
+		out := make([]float32, len(src))
+		for y := range p.h {
+			up, down := mirror(y-1, p.h), mirror(y+1, p.h)
+			for i, sy := range [3]int{up, y, down} {
+				copy(row[i*p.w:], src[sy*p.w:(sy+1)*p.w])
+			}
+			for x := range p.w {
+				l, r := mirror(x-1, p.w), mirror(x+1, p.w)
+				out[y*p.w+x] = w0*row[p.w+x] +
+					w1*(row[x]+row[2*p.w+x]+row[p.w+l]+row[p.w+r]) +
+					w2*(row[l]+row[r]+row[2*p.w+l]+row[2*p.w+r])
+			}
+		}
+		p.p[c] = out
+	}
+}
+
+// Reads the patches of a frame, placements of parts of earlier frames that are blended onto it
+func (d *decoder) readPatches(br *bitReader, fh *frameHeader) ([]patch, error) {
+	numEC := len(d.header.extraChannels)
+	code, err := readEntropyCode(br, 10, false)
+	if err != nil {
+		return nil, err
+	}
+	dec := newEntropyDecoder(code, br, 0)
+	const (
+		ctxNumRefs = iota
+		ctxRef
+		ctxSize
+		ctxRefPosition
+		ctxPosition
+		ctxBlendMode
+		ctxOffset
+		ctxCount
+		ctxAlpha
+		ctxClamp
+	)
+	maxRefs := 1024 + fh.width*fh.height/4
+	numRefs := int(dec.readUint(ctxNumRefs))
+	if numRefs > maxRefs {
+		return nil, FormatError("too many patches")
+	}
+	var patches []patch
+	for range numRefs {
+		var ref patchRef
+		ref.ref = int(dec.readUint(ctxRef))
+		if ref.ref >= len(d.refs) || d.refs[ref.ref] == nil {
+			return nil, FormatError("patch from a missing reference frame")
+		}
+		src := d.refs[ref.ref]
+		ref.x0 = int(dec.readUint(ctxRefPosition))
+		ref.y0 = int(dec.readUint(ctxRefPosition))
+		ref.w = int(dec.readUint(ctxSize)) + 1
+		ref.h = int(dec.readUint(ctxSize)) + 1
+		if ref.x0+ref.w > src.w || ref.y0+ref.h > src.h {
+			return nil, FormatError("patch outside its reference frame")
+		}
+		count := int(dec.readUint(ctxCount)) + 1
+		if len(patches)+count > 4*maxRefs {
+			return nil, FormatError("too many patches")
+		}
+		for i := range count {
+			p := patch{ref: ref}
+			if i == 0 {
+				p.x = int(dec.readUint(ctxPosition))
+				p.y = int(dec.readUint(ctxPosition))
+			} else {
+				prev := patches[len(patches)-1]
+				p.x = prev.x + int(unpackSigned(dec.readUint(ctxOffset)))
+				p.y = prev.y + int(unpackSigned(dec.readUint(ctxOffset)))
+			}
+			if p.x < 0 || p.y < 0 || p.x+ref.w > fh.width || p.y+ref.h > fh.height {
+				return nil, FormatError("patch outside the frame")
+			}
+			p.blendings = make([]blending, 1+numEC)
+			for j := range p.blendings {
+				mode := int(dec.readUint(ctxBlendMode))
+				if mode >= numBlendModes {
+					return nil, FormatError("invalid patch blend mode")
+				}
+				b := blending{mode: mode}
+				usesAlpha := mode == blendAbove || mode == blendBelow ||
+					mode == blendAlphaWeightedAddAbove || mode == blendAlphaWeightedAddBelow
+				if usesAlpha && numEC > 1 {
+					b.alphaChannel = int(dec.readUint(ctxAlpha))
+					if b.alphaChannel >= numEC {
+						return nil, FormatError("invalid patch alpha channel")
+					}
+				}
+				if usesAlpha || mode == blendMul {
+					b.clamp = dec.readUint(ctxClamp) != 0
+				}
+				p.blendings[j] = b
+			}
+			patches = append(patches, p)
+		}
+	}
+	if err := dec.finish(); err != nil {
+		return nil, err
+	}
+	return patches, nil
+}
+
+func (d *decoder) applyPatches(frame *planes, patches []patch) {
+	n := len(frame.p)
+	bg, fg, tmp := make([]float32, n), make([]float32, n), make([]float32, n)
+	for _, p := range patches {
+		src := d.refs[p.ref.ref]
+		for y := range p.ref.h {
+			for x := range p.ref.w {
+				fi := (p.y+y)*frame.w + p.x + x
+				si := (p.ref.y0+y)*src.w + p.ref.x0 + x
+				for c := range n {
+					bg[c] = frame.p[c][fi]
+					if c < len(src.p) {
+						fg[c] = src.p[c][si]
+					} else {
+						fg[c] = 0
+					}
+				}
+				d.blendPixel(tmp, bg, fg, frame.colour, p.blendings[0], p.blendings[1:])
+				for c := range n {
+					frame.p[c][fi] = tmp[c]
+				}
+			}
+		}
+	}
+}
+
+// Blends the frame onto the reference frames its blending info names and returns the new canvas
+func (d *decoder) composite(fh *frameHeader, frame *planes) *planes {
+	h := d.header
+	allReplace := fh.blending.mode == blendReplace
+	for _, b := range fh.ecBlending {
+		allReplace = allReplace && b.mode == blendReplace
+	}
+	if allReplace && !fh.partial(h.width, h.height) && frame.w == h.width && frame.h == h.height {
+		return frame
+	}
+
+	canvas := newPlanes(h.width, h.height, frame.colour, len(frame.p)-frame.colour)
+	for c, plane := range canvas.p {
+		source := fh.blending.source
+		if c >= frame.colour {
+			source = fh.ecBlending[c-frame.colour].source
+		}
+		ref := d.refs[source]
+		if ref == nil || c >= len(ref.p) {
+			continue
+		}
+		for y := range min(ref.h, canvas.h) {
+			copy(plane[y*canvas.w:y*canvas.w+min(ref.w, canvas.w)], ref.p[c][y*ref.w:])
+		}
+	}
+
+	n := len(frame.p)
+	bg, fg, tmp := make([]float32, n), make([]float32, n), make([]float32, n)
+	for y := max(0, -fh.y0); y < frame.h && y+fh.y0 < canvas.h; y++ {
+		for x := max(0, -fh.x0); x < frame.w && x+fh.x0 < canvas.w; x++ {
+			ci := (y+fh.y0)*canvas.w + x + fh.x0
+			fi := y*frame.w + x
+			if allReplace {
+				for c := range n {
+					canvas.p[c][ci] = frame.p[c][fi]
+				}
+				continue
+			}
+			for c := range n {
+				bg[c], fg[c] = canvas.p[c][ci], frame.p[c][fi]
+			}
+			d.blendPixel(tmp, bg, fg, frame.colour, fh.blending, fh.ecBlending)
+			for c := range n {
+				canvas.p[c][ci] = tmp[c]
+			}
+		}
+	}
+	return canvas
+}
+
+func clamp01(v float32) float32 {
+	return min(max(v, 0), 1)
+}
+
+// Blends one pixel of fg onto bg, the extra channels first so the colour still sees the old alpha
+func (d *decoder) blendPixel(out, bg, fg []float32, colour int, b blending, ecBlendings []blending) {
+	ecs := d.header.extraChannels
+	hasAlpha := d.header.alphaChannel() >= 0
+	alphaOf := func(v float32, clamp bool) float32 {
+		if clamp {
+			return clamp01(v)
+		}
+		return v
+	}
+	over := func(bgv, bga, fgv, fga float32, premultiplied bool) float32 {
+		if premultiplied {
+			return fgv + bgv*(1-fga)
+		}
+		newA := 1 - (1-fga)*(1-bga)
+		if newA <= 0 {
+			return 0
+		}
+		return (fgv*fga + bgv*bga*(1-fga)) / newA
+	}
+
+	for i, eb := range ecBlendings {
+		j := colour + i
+		a := colour + eb.alphaChannel
+		switch eb.mode {
+		case blendAdd:
+			out[j] = bg[j] + fg[j]
+		case blendAbove, blendBelow:
+			lo, hi := bg, fg
+			if eb.mode == blendBelow {
+				lo, hi = fg, bg
+			}
+			ha := alphaOf(hi[a], eb.clamp)
+			if j == a {
+				out[j] = 1 - (1-ha)*(1-lo[a])
+			} else {
+				out[j] = over(lo[j], lo[a], hi[j], ha, ecs[eb.alphaChannel].alphaAssociated)
+			}
+		case blendAlphaWeightedAddAbove:
+			out[j] = bg[j] + fg[j]*alphaOf(fg[a], eb.clamp)
+		case blendAlphaWeightedAddBelow:
+			out[j] = fg[j] + bg[j]*alphaOf(bg[a], eb.clamp)
+		case blendMul:
+			out[j] = bg[j] * alphaOf(fg[j], eb.clamp)
+		case blendReplace:
+			out[j] = fg[j]
+		default:
+			out[j] = bg[j]
+		}
+	}
+
+	a := colour + b.alphaChannel
+	for c := range colour {
+		switch {
+		case b.mode == blendAdd || (!hasAlpha && (b.mode == blendAlphaWeightedAddAbove || b.mode == blendAlphaWeightedAddBelow)):
+			out[c] = bg[c] + fg[c]
+		case hasAlpha && (b.mode == blendAbove || b.mode == blendBelow):
+			lo, hi := bg, fg
+			if b.mode == blendBelow {
+				lo, hi = fg, bg
+			}
+			ha := alphaOf(hi[a], b.clamp)
+			out[c] = over(lo[c], lo[a], hi[c], ha, ecs[b.alphaChannel].alphaAssociated)
+			out[a] = 1 - (1-ha)*(1-lo[a])
+		case b.mode == blendAlphaWeightedAddAbove:
+			out[c] = bg[c] + fg[c]*alphaOf(fg[a], b.clamp)
+		case b.mode == blendAlphaWeightedAddBelow:
+			out[c] = fg[c] + bg[c]*alphaOf(bg[a], b.clamp)
+		case b.mode == blendMul:
+			out[c] = bg[c] * alphaOf(fg[c], b.clamp)
+		case b.mode == blendNone:
+			out[c] = bg[c]
+		default:
+			out[c] = fg[c]
+		}
+	}
+}
+
+// Turns the XYB planes of a frame into sRGB
+func (d *decoder) xybToRGB(p *planes) {
+	h := d.header
+	var m [9]float32
+	for i, v := range h.opsinInverse {
+		m[i] = v * 255 / h.intensityTarget
+	}
+	var cbrtBias [3]float32
+	for i, b := range h.opsinBias {
+		cbrtBias[i] = float32(math.Cbrt(float64(b)))
+	}
+	px, py, pb := p.p[0], p.p[1], p.p[2]
+	for i := range px {
+		gr := py[i] + px[i] - cbrtBias[0]
+		gg := py[i] - px[i] - cbrtBias[1]
+		gb := pb[i] - cbrtBias[2]
+		mr := gr*gr*gr + h.opsinBias[0]
+		mg := gg*gg*gg + h.opsinBias[1]
+		mb := gb*gb*gb + h.opsinBias[2]
+		px[i] = linearToSRGB(m[0]*mr + m[1]*mg + m[2]*mb)
+		py[i] = linearToSRGB(m[3]*mr + m[4]*mg + m[5]*mb)
+		pb[i] = linearToSRGB(m[6]*mr + m[7]*mg + m[8]*mb)
+	}
+}
+
+func linearToSRGB(v float32) float32 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return float32(1.055*math.Pow(float64(v), 1/2.4) - 0.055)
+}
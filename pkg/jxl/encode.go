@@ -0,0 +1,312 @@
+package jxl
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// Encode writes img to w as a lossless JPEG XL codestream. Images with 16 bits per sample keep all of them,
+// everything else is written with 8.
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width > maxImageSide || height > maxImageSide {
+		return UnsupportedError("image size")
+	}
+
+	depth := uint32(8)
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		depth = 16
+	}
+	grey := false
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		grey = true
+	}
+	channels := samples(img, depth, grey)
+	hasAlpha := false
+	if !grey {
+		opaque := int32(1)<<depth - 1
+		for _, v := range channels[3].pix {
+			if v != opaque {
+				hasAlpha = true
+				break
+			}
+		}
+		if !hasAlpha {
+			channels = channels[:3]
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.bits(0xff, 8)
+	bw.bits(0x0a, 8)
+	writeSizeHeader(bw, width, height)
+	writeImageMetadata(bw, depth, grey, hasAlpha)
+	bw.bool(true) // default transform data
+	bw.zeroPadToByte()
+	numExtra := 0
+	if hasAlpha {
+		numExtra = 1
+	}
+	writeFrameHeader(bw, numExtra)
+
+	groupDim := 256
+	groupsX, groupsY := (width+groupDim-1)/groupDim, (height+groupDim-1)/groupDim
+	numGroups := groupsX * groupsY
+	lfGroups := ((width + 8*groupDim - 1) / (8 * groupDim)) * ((height + 8*groupDim - 1) / (8 * groupDim))
+
+	rct := !grey
+	var sections [][]byte
+	if numGroups == 1 {
+		s := &bitWriter{}
+		s.bool(true)  // default LF dequantization
+		s.bool(false) // no global tree
+		writeModularStream(s, channels, rct)
+		sections = append(sections, s.bytes())
+	} else {
+		s := &bitWriter{}
+		s.bool(true)
+		s.bool(false)
+		// the channels are too large for the global stream, it only carries the colour transform
+		writeModularHeader(s, rct)
+		sections = append(sections, s.bytes())
+		if rct {
+			channels = forwardYCoCg(channels)
+		}
+		for range lfGroups + 1 {
+			sections = append(sections, nil)
+		}
+		for g := range numGroups {
+			x0, y0 := g%groupsX*groupDim, g/groupsX*groupDim
+			gw, gh := min(groupDim, width-x0), min(groupDim, height-y0)
+			group := make([]*channel, len(channels))
+			for c, ch := range channels {
+				group[c] = newChannel(gw, gh, 0, 0)
+				for y := range gh {
+					copy(group[c].pix[y*gw:(y+1)*gw], ch.pix[(y0+y)*width+x0:])
+				}
+			}
+			s := &bitWriter{}
+			writeModularStream(s, group, false)
+			sections = append(sections, s.bytes())
+		}
+	}
+
+	bw.bool(false) // sections in order
+	bw.zeroPadToByte()
+	for _, s := range sections {
+		bw.u32(uint32(len(s)), bitsOf(10), bitsOffset(14, 1024), bitsOffset(22, 17408), bitsOffset(30, 4211712))
+	}
+	bw.zeroPadToByte()
+	for _, s := range sections {
+		bw.buf = append(bw.buf, s...)
+	}
+	_, err := w.Write(bw.buf)
+	return err
+}
+
+// Splits img into channels of samples with depth bits: grey, or red, green, blue and alpha
+func samples(img image.Image, depth uint32, grey bool) []*channel {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	n := 4
+	if grey {
+		n = 1
+	}
+	channels := make([]*channel, n)
+	for c := range channels {
+		channels[c] = newChannel(width, height, 0, 0)
+	}
+	switch src := img.(type) {
+	case *image.Gray:
+		for y := range height {
+			for x := range width {
+				channels[0].pix[y*width+x] = int32(src.Pix[y*src.Stride+x])
+			}
+		}
+		return channels
+	case *image.NRGBA:
+		for y := range height {
+			for x := range width {
+				p := src.Pix[y*src.Stride+4*x:]
+				for c := range 4 {
+					channels[c].pix[y*width+x] = int32(p[c])
+				}
+			}
+		}
+		return channels
+	}
+	for y := range height {
+		for x := range width {
+			i := y*width + x
+			if grey {
+				v := color.Gray16Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray16).Y
+				channels[0].pix[i] = int32(v >> (16 - depth))
+				continue
+			}
+			c := color.NRGBA64Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64)
+			for k, v := range [4]uint16{c.R, c.G, c.B, c.A} {
+				channels[k].pix[i] = int32(v >> (16 - depth))
+			}
+		}
+	}
+	return channels
+}
+
+func writeSizeValue(bw *bitWriter, v int) {
+	bw.u32(uint32(v), bitsOffset(9, 1), bitsOffset(13, 1), bitsOffset(18, 1), bitsOffset(30, 1))
+}
+
+func writeSizeHeader(bw *bitWriter, width, height int) {
+	bw.bool(false)
+	writeSizeValue(bw, height)
+	bw.bits(0, 3)
+	writeSizeValue(bw, width)
+}
+
+func writeBitDepth(bw *bitWriter, depth uint32) {
+	bw.bool(false)
+	bw.u32(depth, val(8), val(10), val(12), bitsOffset(6, 1))
+}
+
+func writeImageMetadata(bw *bitWriter, depth uint32, grey, alpha bool) {
+	bw.bool(false) // not all default
+	bw.bool(false) // no extra fields
+	writeBitDepth(bw, depth)
+	bw.bool(depth <= 8)
+	if !alpha {
+		bw.u32(0, val(0), val(1), bitsOffset(4, 2), bitsOffset(12, 1))
+	} else {
+		bw.u32(1, val(0), val(1), bitsOffset(4, 2), bitsOffset(12, 1))
+		bw.bool(false)
+		bw.enum(extraChannelAlpha)
+		writeBitDepth(bw, depth)
+		bw.u32(0, val(0), val(3), val(4), bitsOffset(3, 1))
+		bw.u32(0, val(0), bitsOf(4), bitsOffset(5, 16), bitsOffset(10, 48))
+		bw.bool(false) // not premultiplied
+	}
+	bw.bool(false) // not XYB
+	if !grey {
+		bw.bool(true) // sRGB
+	} else {
+		bw.bool(false)
+		bw.bool(false) // no ICC
+		bw.enum(colourSpaceGrey)
+		bw.enum(1) // D65
+		bw.bool(false)
+		bw.enum(13) // sRGB transfer function
+		bw.enum(1)  // relative rendering intent
+	}
+	bw.bits(0, 2) // no extensions
+}
+
+// Writes the header of a single lossless modular frame covering the image
+func writeFrameHeader(bw *bitWriter, numExtra int) {
+	bw.bool(false)
+	bw.bits(frameRegular, 2)
+	bw.bool(true) // modular
+	bw.bits(0, 2) // no flags
+	bw.bool(false)
+	bw.u32(1, val(1), val(2), val(4), val(8))
+	for range numExtra {
+		bw.u32(1, val(1), val(2), val(4), val(8))
+	}
+	bw.bits(1, 2) // 256 pixel groups
+	bw.u32(1, val(1), val(2), val(3), bitsOffset(3, 4))
+	bw.bool(false) // no crop
+	for range 1 + numExtra {
+		bw.u32(frameBlendReplace, val(frameBlendReplace), val(frameBlendAdd), val(frameBlendBlend), bitsOffset(2, 3))
+	}
+	bw.bool(true) // last frame
+	bw.u32(0, val(0), bitsOf(4), bitsOffset(5, 16), bitsOffset(10, 48))
+	bw.bool(false) // loop filters off
+	bw.bool(false)
+	bw.bits(0, 2)
+	bw.bits(0, 2)
+	bw.bits(0, 2)
+}
+
+// Writes a modular stream header with the default weighted predictor and, for colour, the YCoCg transform
+func writeModularHeader(bw *bitWriter, rct bool) {
+	bw.bool(false) // local tree
+	bw.bool(true)  // default weighted predictor
+	if !rct {
+		bw.u32(0, val(0), val(1), bitsOffset(4, 2), bitsOffset(8, 18))
+		return
+	}
+	bw.u32(1, val(0), val(1), bitsOffset(4, 2), bitsOffset(8, 18))
+	bw.u32(transformRCT, val(transformRCT), val(transformPalette), val(transformSqueeze), val(3))
+	bw.u32(0, bitsOf(3), bitsOffset(6, 8), bitsOffset(10, 72), bitsOffset(13, 1096))
+	bw.u32(6, val(6), bitsOf(2), bitsOffset(4, 2), bitsOffset(6, 10))
+}
+
+// Turns the first three channels from RGB into YCoCg, the inverse of RCT type 6
+func forwardYCoCg(channels []*channel) []*channel {
+	out := make([]*channel, len(channels))
+	copy(out, channels)
+	r, g, b := channels[0], channels[1], channels[2]
+	y, co, cg := newChannel(r.w, r.h, 0, 0), newChannel(r.w, r.h, 0, 0), newChannel(r.w, r.h, 0, 0)
+	for i := range r.pix {
+		co.pix[i] = r.pix[i] - b.pix[i]
+		tmp := b.pix[i] + co.pix[i]>>1
+		cg.pix[i] = g.pix[i] - tmp
+		y.pix[i] = tmp + cg.pix[i]>>1
+	}
+	out[0], out[1], out[2] = y, co, cg
+	return out
+}
+
+// Writes channels as a modular stream with a tree that gives every channel its own context and predicts
+// every pixel with the clamped gradient
+func writeModularStream(bw *bitWriter, channels []*channel, rct bool) {
+	writeModularHeader(bw, rct)
+	if rct {
+		channels = forwardYCoCg(channels)
+	}
+
+	// the tree splits on the channel index until every channel has a leaf, channels past 8 share the last. Its
+	// nodes are coded breadth first, so the leaves of the last two channels come after all the others.
+	leaves := min(len(channels), 8)
+	tree := &tokenWriter{numCtx: 6}
+	leaf := func() {
+		tree.add(1, 0)
+		tree.add(2, predGradient)
+		tree.add(3, 0)
+		tree.add(4, 0)
+		tree.add(5, 0)
+	}
+	for i := range leaves - 1 {
+		tree.add(1, 1) // property 0, the channel index
+		tree.add(0, packSigned(int32(i)))
+		if i > 0 {
+			leaf()
+		}
+	}
+	leaf()
+	if leaves > 1 {
+		leaf()
+	}
+	tree.write(bw)
+
+	data := &tokenWriter{numCtx: leaves}
+	for c, ch := range channels {
+		ctx := c
+		switch {
+		case c >= leaves-1 && leaves > 1:
+			ctx = leaves - 2
+		case c == leaves-2:
+			ctx = leaves - 1
+		}
+		for y := range ch.h {
+			for x := range ch.w {
+				nb := ch.neighbours(x, y)
+				residual := ch.pix[y*ch.w+x] - int32(predict(predGradient, &nb, 0))
+				data.add(ctx, packSigned(residual))
+			}
+		}
+	}
+	data.write(bw)
+}
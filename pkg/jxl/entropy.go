@@ -0,0 +1,697 @@
+package jxl
+
+import (
+	"math/bits"
+)
+
+const (
+	ansLogTabSize   = 12
+	ansTabSize      = 1 << ansLogTabSize
+	ansFinalState   = 0x130000
+	lz77WindowSize  = 1 << 20
+	maxPrefixBits   = 15
+	numSpecialDists = 120
+)
+
+// How the entropy coded tokens turn into integers, small values are the token itself and larger ones keep some
+// of their high and low bits in the token with the rest read raw
+type hybridUintConfig struct {
+	splitExponent uint32
+	msbInToken    uint32
+	lsbInToken    uint32
+}
+
+func readHybridUintConfig(br *bitReader, logAlphaSize uint32) (hybridUintConfig, error) {
+	var c hybridUintConfig
+	c.splitExponent = br.bits(uint(bits.Len32(logAlphaSize)))
+	if c.splitExponent != logAlphaSize {
+		c.msbInToken = br.bits(uint(bits.Len32(c.splitExponent)))
+		if c.msbInToken > c.splitExponent {
+			return c, FormatError("invalid hybrid uint config")
+		}
+		c.lsbInToken = br.bits(uint(bits.Len32(c.splitExponent - c.msbInToken)))
+	}
+	if c.splitExponent > logAlphaSize || c.msbInToken+c.lsbInToken > c.splitExponent {
+		return c, FormatError("invalid hybrid uint config")
+	}
+	return c, br.err
+}
+
+func (c hybridUintConfig) read(br *bitReader, token uint32) uint32 {
+	split := uint32(1) << c.splitExponent
+	if token < split {
+		return token
+	}
+	n := c.splitExponent - c.msbInToken - c.lsbInToken + (token-split)>>(c.msbInToken+c.lsbInToken)
+	if n > 31 {
+		if br.err == nil {
+			br.err = FormatError("hybrid uint out of range")
+		}
+		return 0
+	}
+	low := token & (1<<c.lsbInToken - 1)
+	token >>= c.lsbInToken
+	high := token&(1<<c.msbInToken-1) | 1<<c.msbInToken
+	return (high<<n|br.bits(uint(n)))<<c.lsbInToken | low
+}
+
+type lz77Params struct {
+	enabled      bool
+	minSymbol    uint32
+	minLength    uint32
+	lengthConfig hybridUintConfig
+}
+
+// A prefix code as a table indexed by the next maxLen bits of the stream
+type prefixCode struct {
+	symbols []uint16
+	lengths []uint8
+	maxLen  uint
+}
+
+// One slot of the alias table that maps an ANS state to its symbol
+type aliasEntry struct {
+	cutoff     uint32
+	rightValue uint32
+	offsets1   uint32
+	freq0      uint32
+	freq1      uint32
+}
+
+// The distributions of a set of contexts, the contexts are mapped to clusters that share a distribution
+type entropyCode struct {
+	lz77         lz77Params
+	contextMap   []uint8
+	usePrefix    bool
+	logAlphaSize uint32
+	configs      []hybridUintConfig
+	prefix       []*prefixCode
+	alias        [][]aliasEntry
+}
+
+// Reads the distributions of numDist contexts
+func readEntropyCode(br *bitReader, numDist int, disallowLZ77 bool) (*entropyCode, error) {
+	code := &entropyCode{}
+	if code.lz77.enabled = br.bool(); code.lz77.enabled {
+		if disallowLZ77 {
+			return nil, FormatError("lz77 not allowed here")
+		}
+		code.lz77.minSymbol = br.u32(val(224), val(512), val(4096), bitsOffset(15, 8))
+		code.lz77.minLength = br.u32(val(3), val(4), bitsOffset(2, 5), bitsOffset(8, 9))
+		var err error
+		if code.lz77.lengthConfig, err = readHybridUintConfig(br, 8); err != nil {
+			return nil, err
+		}
+		numDist++
+	}
+
+	code.contextMap = make([]uint8, numDist)
+	numClusters := 1
+	if numDist > 1 {
+		var err error
+		if numClusters, err = readContextMap(br, code.contextMap); err != nil {
+			return nil, err
+		}
+	}
+
+	code.usePrefix = br.bool()
+	if code.usePrefix {
+		code.logAlphaSize = maxPrefixBits
+	} else {
+		code.logAlphaSize = 5 + br.bits(2)
+	}
+	code.configs = make([]hybridUintConfig, numClusters)
+	for i := range code.configs {
+		var err error
+		if code.configs[i], err = readHybridUintConfig(br, code.logAlphaSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if code.usePrefix {
+		sizes := make([]int, numClusters)
+		for i := range sizes {
+			sizes[i] = 1
+			if br.bool() {
+				n := uint(br.bits(4))
+				sizes[i] = 1 + 1<<n + int(br.bits(n))
+			}
+			if sizes[i] > 1<<maxPrefixBits {
+				return nil, FormatError("prefix code alphabet too large")
+			}
+		}
+		code.prefix = make([]*prefixCode, numClusters)
+		for i, size := range sizes {
+			var err error
+			if code.prefix[i], err = readPrefixCode(br, size); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		code.alias = make([][]aliasEntry, numClusters)
+		for i := range code.alias {
+			counts, err := readDistribution(br)
+			if err != nil {
+				return nil, err
+			}
+			if len(counts) > 1<<code.logAlphaSize {
+				return nil, FormatError("distribution larger than its alphabet")
+			}
+			code.alias[i] = makeAliasTable(counts, code.logAlphaSize)
+		}
+	}
+	return code, br.err
+}
+
+// Reads which cluster every context uses and returns the number of clusters
+func readContextMap(br *bitReader, contextMap []uint8) (int, error) {
+	if br.bool() {
+		nbits := uint(br.bits(2))
+		for i := range contextMap {
+			contextMap[i] = uint8(br.bits(nbits))
+		}
+	} else {
+		useMTF := br.bool()
+		code, err := readEntropyCode(br, 1, len(contextMap) <= 2)
+		if err != nil {
+			return 0, err
+		}
+		dec := newEntropyDecoder(code, br, 0)
+		for i := range contextMap {
+			v := dec.readUint(0)
+			if v >= 256 {
+				return 0, FormatError("context map cluster out of range")
+			}
+			contextMap[i] = uint8(v)
+		}
+		if err := dec.finish(); err != nil {
+			return 0, err
+		}
+		if useMTF {
+			inverseMoveToFront(contextMap)
+		}
+	}
+
+	numClusters := 0
+	for _, c := range contextMap {
+		numClusters = max(numClusters, int(c)+1)
+	}
+	seen := make([]bool, numClusters)
+	found := 0
+	for _, c := range contextMap {
+		if !seen[c] {
+			seen[c] = true
+			found++
+		}
+	}
+	if found != numClusters {
+		return 0, FormatError("incomplete context map")
+	}
+	return numClusters, br.err
+}
+
+func inverseMoveToFront(values []uint8) {
+	var mtf [256]uint8
+	for i := range mtf {
+		mtf[i] = uint8(i)
+	}
+	for i, index := range values {
+		value := mtf[index]
+		values[i] = value
+		copy(mtf[1:index+1], mtf[:index])
+		mtf[0] = value
+	}
+}
+
+// The prefix code the logarithms of the distribution counts are written with, as code length and code per value
+var logCountCodes = [14][2]uint32{
+	{5, 17}, {4, 11}, {4, 15}, {4, 3}, {4, 9}, {4, 7}, {3, 4}, {3, 2}, {3, 5}, {3, 6}, {3, 0}, {6, 33}, {7, 1}, {7, 65},
+}
+
+var logCountTable = func() (table [128][2]uint8) {
+	for value, c := range logCountCodes {
+		for rest := uint32(0); rest < 1<<(7-c[0]); rest++ {
+			table[c[1]|rest<<c[0]] = [2]uint8{uint8(c[0]), uint8(value)}
+		}
+	}
+	return table
+}()
+
+// Reads an ANS distribution, the counts add up to 1<<12
+func readDistribution(br *bitReader) ([]uint32, error) {
+	if br.bool() {
+		numSymbols := br.bits(1) + 1
+		var symbols [2]uint32
+		maxSymbol := uint32(0)
+		for i := range numSymbols {
+			symbols[i] = br.u8()
+			maxSymbol = max(maxSymbol, symbols[i])
+		}
+		counts := make([]uint32, maxSymbol+1)
+		if numSymbols == 1 {
+			counts[symbols[0]] = ansTabSize
+		} else {
+			if symbols[0] == symbols[1] {
+				return nil, FormatError("duplicate symbol in distribution")
+			}
+			counts[symbols[0]] = br.bits(ansLogTabSize)
+			counts[symbols[1]] = ansTabSize - counts[symbols[0]]
+		}
+		return counts, br.err
+	}
+
+	if br.bool() {
+		size := br.u8() + 1
+		counts := make([]uint32, size)
+		for i := range counts {
+			counts[i] = ansTabSize / size
+			if uint32(i) < ansTabSize%size {
+				counts[i]++
+			}
+		}
+		return counts, br.err
+	}
+
+	log := uint(0)
+	for log < 3 && br.bool() {
+		log++
+	}
+	shift := int(br.bits(log)|1<<log) - 1
+	if shift > ansLogTabSize+1 {
+		return nil, FormatError("invalid distribution shift")
+	}
+
+	size := int(br.u8()) + 3
+	counts := make([]uint32, size)
+	logCounts := make([]int, size)
+	same := make([]int, size)
+	omitLog, omitPos := -1, -1
+	for i := 0; i < size; i++ {
+		entry := logCountTable[br.peek(7)]
+		br.skip(uint(entry[0]))
+		logCounts[i] = int(entry[1])
+		if logCounts[i] == ansLogTabSize+1 {
+			rle := int(br.u8())
+			same[i] = rle + 5
+			i += rle + 3
+			continue
+		}
+		if logCounts[i] > omitLog {
+			omitLog, omitPos = logCounts[i], i
+		}
+	}
+	if omitPos < 0 {
+		return nil, FormatError("invalid distribution")
+	}
+
+	total := uint32(0)
+	prev, numSame := uint32(0), 0
+	for i := 0; i < size; i++ {
+		if same[i] != 0 {
+			numSame = same[i] - 1
+			if i > 0 {
+				prev = counts[i-1]
+			} else {
+				prev = 0
+			}
+		}
+		if numSame > 0 {
+			counts[i] = prev
+			numSame--
+		} else {
+			code := logCounts[i]
+			if i == omitPos || code == 0 {
+				continue
+			}
+			if code == 1 {
+				counts[i] = 1
+			} else {
+				bitCount := max(0, min(code-1, shift-(ansLogTabSize-(code-1))>>1))
+				counts[i] = 1<<(code-1) + br.bits(uint(bitCount))<<(code-1-bitCount)
+			}
+		}
+		total += counts[i]
+	}
+	if total >= ansTabSize {
+		return nil, FormatError("distribution counts too large")
+	}
+	counts[omitPos] = ansTabSize - total
+	return counts, br.err
+}
+
+// Builds the alias table of a distribution, the slot layout is part of the format as it fixes the ANS states
+func makeAliasTable(counts []uint32, logAlphaSize uint32) []aliasEntry {
+	for len(counts) > 0 && counts[len(counts)-1] == 0 {
+		counts = counts[:len(counts)-1]
+	}
+	if len(counts) == 0 {
+		counts = []uint32{ansTabSize}
+	}
+	tableSize := 1 << logAlphaSize
+	entrySize := uint32(ansTabSize >> logAlphaSize)
+	table := make([]aliasEntry, tableSize)
+
+	for sym, c := range counts {
+		if c == ansTabSize {
+			for i := range table {
+				table[i] = aliasEntry{rightValue: uint32(sym), offsets1: entrySize * uint32(i), freq1: ansTabSize}
+			}
+			return table
+		}
+	}
+
+	cutoffs := make([]uint32, tableSize)
+	var underfull, overfull []int
+	for i := range tableSize {
+		if i < len(counts) {
+			cutoffs[i] = counts[i]
+		}
+		if cutoffs[i] > entrySize {
+			overfull = append(overfull, i)
+		} else if cutoffs[i] < entrySize {
+			underfull = append(underfull, i)
+		}
+	}
+	for len(overfull) > 0 {
+		o := overfull[len(overfull)-1]
+		overfull = overfull[:len(overfull)-1]
+		u := underfull[len(underfull)-1]
+		underfull = underfull[:len(underfull)-1]
+		cutoffs[o] -= entrySize - cutoffs[u]
+		table[u].rightValue = uint32(o)
+		table[u].offsets1 = cutoffs[o]
+		if cutoffs[o] < entrySize {
+			underfull = append(underfull, o)
+		} else if cutoffs[o] > entrySize {
+			overfull = append(overfull, o)
+		}
+	}
+	for i := range table {
+		if cutoffs[i] == entrySize {
+			table[i].rightValue = uint32(i)
+			table[i].offsets1 = 0
+			table[i].cutoff = 0
+		} else {
+			table[i].offsets1 -= cutoffs[i]
+			table[i].cutoff = cutoffs[i]
+		}
+		if i < len(counts) {
+			table[i].freq0 = counts[i]
+		}
+		if r := int(table[i].rightValue); r < len(counts) {
+			table[i].freq1 = counts[r]
+		}
+	}
+	return table
+}
+
+var codeLengthOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// The fixed code the code length code lengths are written with, indexed by the next 4 bits
+var codeLengthPrefixLength = [16]uint8{2, 2, 2, 3, 2, 2, 2, 4, 2, 2, 2, 3, 2, 2, 2, 4}
+var codeLengthPrefixValue = [16]uint8{0, 4, 3, 2, 0, 4, 3, 1, 0, 4, 3, 2, 0, 4, 3, 5}
+
+// Reads a Brotli style prefix code over an alphabet of size symbols
+func readPrefixCode(br *bitReader, size int) (*prefixCode, error) {
+	if size == 1 {
+		return singleSymbolCode(0), nil
+	}
+	lengths := make([]uint8, size)
+	hskip := int(br.bits(2))
+	if hskip == 1 {
+		numSymbols := int(br.bits(2)) + 1
+		alphabetBits := uint(bits.Len(uint(size - 1)))
+		symbols := make([]int, numSymbols)
+		for i := range symbols {
+			symbols[i] = int(br.bits(alphabetBits))
+			if symbols[i] >= size {
+				return nil, FormatError("prefix code symbol out of range")
+			}
+			for j := range i {
+				if symbols[j] == symbols[i] {
+					return nil, FormatError("duplicate prefix code symbol")
+				}
+			}
+		}
+		switch numSymbols {
+		case 1:
+			return singleSymbolCode(symbols[0]), br.err
+		case 2:
+			lengths[symbols[0]], lengths[symbols[1]] = 1, 1
+		case 3:
+			lengths[symbols[0]], lengths[symbols[1]], lengths[symbols[2]] = 1, 2, 2
+		case 4:
+			if br.bool() {
+				lengths[symbols[0]], lengths[symbols[1]], lengths[symbols[2]], lengths[symbols[3]] = 1, 2, 3, 3
+			} else {
+				lengths[symbols[0]], lengths[symbols[1]], lengths[symbols[2]], lengths[symbols[3]] = 2, 2, 2, 2
+			}
+		}
+		return buildPrefixCode(lengths)
+	}
+
+	var codeLengthLengths [18]uint8
+	space, numCodes := 32, 0
+	for i := hskip; i < 18 && space > 0; i++ {
+		idx := br.peek(4)
+		br.skip(uint(codeLengthPrefixLength[idx]))
+		v := codeLengthPrefixValue[idx]
+		codeLengthLengths[codeLengthOrder[i]] = v
+		if v != 0 {
+			space -= 32 >> v
+			numCodes++
+		}
+	}
+	if numCodes != 1 && space != 0 {
+		return nil, FormatError("incomplete code length code")
+	}
+	var lengthCode *prefixCode
+	if numCodes == 1 {
+		for sym, l := range codeLengthLengths {
+			if l != 0 {
+				lengthCode = singleSymbolCode(sym)
+			}
+		}
+	} else {
+		var err error
+		if lengthCode, err = buildPrefixCode(codeLengthLengths[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	symbol := 0
+	prevLen, repeat, repeatLen := uint8(8), 0, uint8(0)
+	space = 1 << maxPrefixBits
+	for symbol < size && space > 0 {
+		p := lengthCode.read(br)
+		if br.err != nil {
+			return nil, br.err
+		}
+		if p < 16 {
+			repeat = 0
+			lengths[symbol] = uint8(p)
+			symbol++
+			if p != 0 {
+				prevLen = uint8(p)
+				space -= (1 << maxPrefixBits) >> p
+			}
+			continue
+		}
+		extraBits, newLen := uint(3), uint8(0)
+		if p == 16 {
+			extraBits, newLen = 2, prevLen
+		}
+		if repeatLen != newLen {
+			repeat, repeatLen = 0, newLen
+		}
+		oldRepeat := repeat
+		if repeat > 0 {
+			repeat = (repeat - 2) << extraBits
+		}
+		repeat += int(br.bits(extraBits)) + 3
+		delta := repeat - oldRepeat
+		if symbol+delta > size {
+			return nil, FormatError("prefix code lengths overflow the alphabet")
+		}
+		for range delta {
+			lengths[symbol] = repeatLen
+			symbol++
+		}
+		if repeatLen != 0 {
+			space -= delta * ((1 << maxPrefixBits) >> repeatLen)
+		}
+	}
+	if space != 0 {
+		return nil, FormatError("incomplete prefix code")
+	}
+	return buildPrefixCode(lengths)
+}
+
+func singleSymbolCode(symbol int) *prefixCode {
+	return &prefixCode{symbols: []uint16{uint16(symbol)}, lengths: []uint8{0}}
+}
+
+// Builds the canonical code of the lengths, shorter codes and then smaller symbols come first and the first bit
+// read is the most significant bit of the code
+func buildPrefixCode(lengths []uint8) (*prefixCode, error) {
+	maxLen := uint8(0)
+	var count [maxPrefixBits + 1]int
+	for _, l := range lengths {
+		if l > maxPrefixBits {
+			return nil, FormatError("prefix code too long")
+		}
+		if l > 0 {
+			count[l]++
+			maxLen = max(maxLen, l)
+		}
+	}
+	var next [maxPrefixBits + 1]uint32
+	code, kraft := uint32(0), 0
+	for l := 1; l <= maxPrefixBits; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+		kraft += count[l] << (maxPrefixBits - l)
+	}
+	if kraft != 1<<maxPrefixBits {
+		return nil, FormatError("prefix code is not complete")
+	}
+
+	pc := &prefixCode{maxLen: uint(maxLen)}
+	pc.symbols = make([]uint16, 1<<maxLen)
+	pc.lengths = make([]uint8, 1<<maxLen)
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		reversed := bits.Reverse32(c) >> (32 - uint(l))
+		for fill := uint32(0); fill < 1<<(maxLen-l); fill++ {
+			pc.symbols[reversed|fill<<l] = uint16(sym)
+			pc.lengths[reversed|fill<<l] = l
+		}
+	}
+	return pc, nil
+}
+
+func (pc *prefixCode) read(br *bitReader) uint32 {
+	if pc.maxLen == 0 {
+		return uint32(pc.symbols[0])
+	}
+	idx := br.peek(pc.maxLen)
+	br.skip(uint(pc.lengths[idx]))
+	return uint32(pc.symbols[idx])
+}
+
+var specialDistances = [numSpecialDists][2]int8{
+	{0, 1}, {1, 0}, {1, 1}, {-1, 1}, {0, 2}, {2, 0}, {1, 2}, {-1, 2}, {2, 1}, {-2, 1}, {2, 2}, {-2, 2},
+	{0, 3}, {3, 0}, {1, 3}, {-1, 3}, {3, 1}, {-3, 1}, {2, 3}, {-2, 3}, {3, 2}, {-3, 2}, {0, 4}, {4, 0},
+	{1, 4}, {-1, 4}, {4, 1}, {-4, 1}, {3, 3}, {-3, 3}, {2, 4}, {-2, 4}, {4, 2}, {-4, 2}, {0, 5}, {3, 4},
+	{-3, 4}, {4, 3}, {-4, 3}, {5, 0}, {1, 5}, {-1, 5}, {5, 1}, {-5, 1}, {2, 5}, {-2, 5}, {5, 2}, {-5, 2},
+	{4, 4}, {-4, 4}, {3, 5}, {-3, 5}, {5, 3}, {-5, 3}, {0, 6}, {6, 0}, {1, 6}, {-1, 6}, {6, 1}, {-6, 1},
+	{2, 6}, {-2, 6}, {6, 2}, {-6, 2}, {4, 5}, {-4, 5}, {5, 4}, {-5, 4}, {3, 6}, {-3, 6}, {6, 3}, {-6, 3},
+	{0, 7}, {7, 0}, {1, 7}, {-1, 7}, {5, 5}, {-5, 5}, {7, 1}, {-7, 1}, {4, 6}, {-4, 6}, {6, 4}, {-6, 4},
+	{2, 7}, {-2, 7}, {7, 2}, {-7, 2}, {3, 7}, {-3, 7}, {7, 3}, {-7, 3}, {5, 6}, {-5, 6}, {6, 5}, {-6, 5},
+	{8, 0}, {4, 7}, {-4, 7}, {7, 4}, {-7, 4}, {8, 1}, {8, 2}, {6, 6}, {-6, 6}, {8, 3}, {5, 7}, {-5, 7},
+	{7, 5}, {-7, 5}, {8, 4}, {6, 7}, {-6, 7}, {7, 6}, {-7, 6}, {8, 5}, {7, 7}, {-7, 7}, {8, 6}, {8, 7},
+}
+
+// Reads the integers of an entropy coded stream
+type entropyDecoder struct {
+	code  *entropyCode
+	br    *bitReader
+	state uint32
+
+	window     []uint32
+	numToCopy  uint32
+	copyPos    uint32
+	numDecoded uint32
+	distances  [numSpecialDists]uint32
+	distMult   uint32
+}
+
+// Starts decoding with code, distMult is the width used by the lz77 distances of image data and 0 elsewhere
+func newEntropyDecoder(code *entropyCode, br *bitReader, distMult uint32) *entropyDecoder {
+	d := &entropyDecoder{code: code, br: br, distMult: distMult}
+	if !code.usePrefix {
+		d.state = br.bits(32)
+	}
+	if code.lz77.enabled {
+		d.window = make([]uint32, lz77WindowSize)
+		for i, sd := range specialDistances {
+			d.distances[i] = uint32(max(1, int(sd[0])+int(distMult)*int(sd[1])))
+		}
+	}
+	return d
+}
+
+func (d *entropyDecoder) readSymbol(cluster uint8) uint32 {
+	if d.code.usePrefix {
+		return d.code.prefix[cluster].read(d.br)
+	}
+	table := d.code.alias[cluster]
+	logEntrySize := ansLogTabSize - d.code.logAlphaSize
+	idx := d.state & (ansTabSize - 1)
+	e := &table[idx>>logEntrySize]
+	pos := idx & (1<<logEntrySize - 1)
+	symbol, offset, freq := idx>>logEntrySize, pos, e.freq0
+	if pos >= e.cutoff {
+		symbol, offset, freq = e.rightValue, e.offsets1+pos, e.freq1
+	}
+	d.state = freq*(d.state>>ansLogTabSize) + offset
+	if d.state < 1<<16 {
+		d.state = d.state<<16 | d.br.bits(16)
+	}
+	return symbol
+}
+
+// Reads the next integer of context ctx
+func (d *entropyDecoder) readUint(ctx int) uint32 {
+	if d.numToCopy > 0 {
+		return d.copyNext()
+	}
+	code := d.code
+	cluster := code.contextMap[ctx]
+	token := d.readSymbol(cluster)
+	if code.lz77.enabled && token >= code.lz77.minSymbol {
+		d.numToCopy = code.lz77.lengthConfig.read(d.br, token-code.lz77.minSymbol) + code.lz77.minLength
+		distCluster := code.contextMap[len(code.contextMap)-1]
+		distance := code.configs[distCluster].read(d.br, d.readSymbol(distCluster))
+		if d.distMult == 0 {
+			distance++
+		} else if distance < numSpecialDists {
+			distance = d.distances[distance]
+		} else {
+			distance -= numSpecialDists - 1
+		}
+		distance = min(distance, d.numDecoded, lz77WindowSize)
+		d.copyPos = d.numDecoded - distance
+		return d.copyNext()
+	}
+	v := code.configs[cluster].read(d.br, token)
+	if code.lz77.enabled {
+		d.window[d.numDecoded&(lz77WindowSize-1)] = v
+		d.numDecoded++
+	}
+	return v
+}
+
+func (d *entropyDecoder) copyNext() uint32 {
+	v := d.window[d.copyPos&(lz77WindowSize-1)]
+	d.copyPos++
+	d.numToCopy--
+	d.window[d.numDecoded&(lz77WindowSize-1)] = v
+	d.numDecoded++
+	return v
+}
+
+// Checks the stream ended where the encoder finished it
+func (d *entropyDecoder) finish() error {
+	if d.br.err != nil {
+		return d.br.err
+	}
+	if !d.code.usePrefix && d.state != ansFinalState {
+		return FormatError("ANS stream did not end in its final state")
+	}
+	return nil
+}
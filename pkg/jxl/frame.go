@@ -0,0 +1,365 @@
+package jxl
+
+import (
+	"math/bits"
+)
+
+const (
+	frameRegular         = 0
+	frameLF              = 1
+	frameReferenceOnly   = 2
+	frameSkipProgressive = 3
+
+	flagNoise   = 1
+	flagPatches = 2
+	flagSplines = 16
+	flagUseLF   = 32
+)
+
+// The blend modes of frames, as numbered in the frame header
+const (
+	frameBlendReplace = iota
+	frameBlendAdd
+	frameBlendBlend
+	frameBlendAlphaWeightedAdd
+	frameBlendMul
+)
+
+// The blend modes of patches, frames are blended with the same code after mapping their modes to these
+const (
+	blendNone = iota
+	blendReplace
+	blendAdd
+	blendMul
+	blendAbove
+	blendBelow
+	blendAlphaWeightedAddAbove
+	blendAlphaWeightedAddBelow
+	numBlendModes
+)
+
+var frameBlendModes = [...]int{blendReplace, blendAdd, blendAbove, blendAlphaWeightedAddAbove, blendMul}
+
+type blending struct {
+	mode         int
+	alphaChannel int
+	clamp        bool
+	source       int
+}
+
+type frameHeader struct {
+	frameType      uint32
+	modular        bool
+	flags          uint64
+	doYCbCr        bool
+	upsampling     uint32
+	ecUpsampling   []uint32
+	groupSizeShift uint32
+	numPasses      int
+	downsample     []uint32
+	lastPass       []uint32
+	x0, y0         int
+	width, height  int
+	blending       blending
+	ecBlending     []blending
+	duration       uint32
+	isLast         bool
+	saveAsRef      int
+	saveBeforeCT   bool
+	gab            bool
+	gabWeights     [3][2]float32
+	epfIters       uint32
+
+	groupDim, groupsX, groupsY, numGroups         int
+	lfGroupDim, lfGroupsX, lfGroupsY, numLFGroups int
+}
+
+func (fh *frameHeader) canBeReferenced() bool {
+	return !fh.isLast && fh.frameType != frameLF && (fh.duration == 0 || fh.saveAsRef != 0)
+}
+
+// A frame that does not cover the whole image has to be blended onto what is below it
+func (fh *frameHeader) partial(width, height int) bool {
+	return fh.x0 > 0 || fh.y0 > 0 || fh.x0+fh.width < width || fh.y0+fh.height < height
+}
+
+func readBlending(br *bitReader, numEC int, partial bool) blending {
+	mode := br.u32(val(frameBlendReplace), val(frameBlendAdd), val(frameBlendBlend), bitsOffset(2, 3))
+	if mode > frameBlendMul {
+		if br.err == nil {
+			br.err = FormatError("invalid blend mode")
+		}
+		return blending{}
+	}
+	b := blending{mode: frameBlendModes[mode]}
+	if numEC > 0 && (mode == frameBlendBlend || mode == frameBlendAlphaWeightedAdd) {
+		b.alphaChannel = int(br.u32(val(0), val(1), val(2), bitsOffset(3, 3)))
+	}
+	if (numEC > 0 && (mode == frameBlendBlend || mode == frameBlendAlphaWeightedAdd)) || mode == frameBlendMul {
+		b.clamp = br.bool()
+	}
+	if mode != frameBlendReplace || partial {
+		b.source = int(br.u32(val(0), val(1), val(2), val(3)))
+	}
+	return b
+}
+
+func readCropValue(br *bitReader) uint32 {
+	return br.u32(bitsOf(8), bitsOffset(11, 256), bitsOffset(14, 2304), bitsOffset(30, 18688))
+}
+
+// Reads a frame header, width and height are the size of a frame that does not say otherwise
+func readFrameHeader(br *bitReader, h *imageHeader, width, height int) (*frameHeader, error) {
+	numEC := len(h.extraChannels)
+	fh := &frameHeader{
+		upsampling:     1,
+		groupSizeShift: 1,
+		numPasses:      1,
+		width:          width,
+		height:         height,
+		isLast:         true,
+		gab:            true,
+		epfIters:       1,
+		ecUpsampling:   make([]uint32, numEC),
+		ecBlending:     make([]blending, numEC),
+	}
+	for i := range fh.ecUpsampling {
+		fh.ecUpsampling[i] = 1
+	}
+	fh.blending.mode = blendReplace
+	for i := range fh.ecBlending {
+		fh.ecBlending[i].mode = blendReplace
+	}
+
+	if !br.bool() {
+		fh.frameType = br.bits(2)
+		fh.modular = br.bool()
+		fh.flags = br.u64()
+		if !h.xybEncoded {
+			fh.doYCbCr = br.bool()
+		}
+		if fh.flags&flagUseLF == 0 {
+			if fh.doYCbCr {
+				br.bits(6)
+			}
+			fh.upsampling = br.u32(val(1), val(2), val(4), val(8))
+			for i := range fh.ecUpsampling {
+				fh.ecUpsampling[i] = br.u32(val(1), val(2), val(4), val(8))
+			}
+		}
+		if fh.modular {
+			fh.groupSizeShift = br.bits(2)
+		} else if h.xybEncoded {
+			br.bits(6)
+		}
+		if fh.frameType != frameReferenceOnly {
+			fh.numPasses = int(br.u32(val(1), val(2), val(3), bitsOffset(3, 4)))
+			if fh.numPasses != 1 {
+				numDownsample := br.u32(val(0), val(1), val(2), bitsOffset(1, 3))
+				if int(numDownsample) >= fh.numPasses {
+					return nil, FormatError("invalid pass downsampling")
+				}
+				br.skipBits(2 * uint64(fh.numPasses-1))
+				fh.downsample = make([]uint32, numDownsample)
+				for i := range fh.downsample {
+					fh.downsample[i] = br.u32(val(1), val(2), val(4), val(8))
+				}
+				fh.lastPass = make([]uint32, numDownsample)
+				for i := range fh.lastPass {
+					fh.lastPass[i] = br.u32(val(0), val(1), val(2), bitsOf(3))
+					if int(fh.lastPass[i]) >= fh.numPasses {
+						return nil, FormatError("invalid last pass")
+					}
+				}
+			}
+		}
+		if fh.frameType == frameLF {
+			br.u32(val(1), val(2), val(3), val(4))
+		} else if br.bool() {
+			if fh.frameType == frameRegular || fh.frameType == frameSkipProgressive {
+				fh.x0 = int(unpackSigned(readCropValue(br)))
+				fh.y0 = int(unpackSigned(readCropValue(br)))
+			}
+			fh.width = int(readCropValue(br))
+			fh.height = int(readCropValue(br))
+		}
+
+		normal := fh.frameType == frameRegular || fh.frameType == frameSkipProgressive
+		partial := fh.partial(width, height)
+		if normal {
+			fh.blending = readBlending(br, numEC, partial)
+			for i := range fh.ecBlending {
+				fh.ecBlending[i] = readBlending(br, numEC, partial)
+			}
+			if h.animation != nil {
+				fh.duration = br.u32(val(0), val(1), bitsOf(8), bitsOf(32))
+				if h.animation.haveTimecodes {
+					br.bits(32)
+				}
+			}
+			fh.isLast = br.bool()
+		} else {
+			fh.isLast = false
+		}
+		if fh.frameType != frameLF && !fh.isLast {
+			fh.saveAsRef = int(br.bits(2))
+		}
+		if fh.frameType == frameReferenceOnly ||
+			(fh.canBeReferenced() && fh.blending.mode == blendReplace && !partial && normal) {
+			fh.saveBeforeCT = br.bool()
+		}
+		nameLen := br.u32(val(0), bitsOf(4), bitsOffset(5, 16), bitsOffset(10, 48))
+		br.skipBits(uint64(nameLen) * 8)
+		readLoopFilter(br, fh)
+		readExtensions(br)
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	if fh.width <= 0 || fh.height <= 0 || fh.width > maxImageSide || fh.height > maxImageSide {
+		return nil, FormatError("invalid frame size")
+	}
+
+	fh.groupDim = 128 << fh.groupSizeShift
+	fh.lfGroupDim = fh.groupDim * 8
+	fh.groupsX = (fh.width + fh.groupDim - 1) / fh.groupDim
+	fh.groupsY = (fh.height + fh.groupDim - 1) / fh.groupDim
+	fh.numGroups = fh.groupsX * fh.groupsY
+	fh.lfGroupsX = (fh.width + fh.lfGroupDim - 1) / fh.lfGroupDim
+	fh.lfGroupsY = (fh.height + fh.lfGroupDim - 1) / fh.lfGroupDim
+	fh.numLFGroups = fh.lfGroupsX * fh.lfGroupsY
+	return fh, nil
+}
+
+func readLoopFilter(br *bitReader, fh *frameHeader) {
+	fh.gabWeights = [3][2]float32{{0.115169525, 0.061248592}, {0.115169525, 0.061248592}, {0.115169525, 0.061248592}}
+	if br.bool() {
+		return
+	}
+	if fh.gab = br.bool(); fh.gab && br.bool() {
+		for c := range fh.gabWeights {
+			fh.gabWeights[c][0] = br.f16()
+			fh.gabWeights[c][1] = br.f16()
+		}
+	}
+	fh.epfIters = br.bits(2)
+	if fh.epfIters > 0 {
+		if !fh.modular && br.bool() {
+			br.skipBits(8 * 16)
+		}
+		if br.bool() {
+			br.skipBits(5 * 16)
+		}
+		if br.bool() {
+			if !fh.modular {
+				br.skipBits(16)
+			}
+			br.skipBits(3 * 16)
+		}
+		if fh.modular {
+			br.skipBits(16)
+		}
+	}
+	readExtensions(br)
+}
+
+// The smallest and largest channel shift the groups of a pass carry
+func (fh *frameHeader) downsamplingBracket(pass int) (int, int) {
+	maxShift, minShift := 2, 3
+	for i := 0; ; i++ {
+		for j, last := range fh.lastPass {
+			if i == int(last) {
+				minShift = bits.TrailingZeros32(fh.downsample[j])
+			}
+		}
+		if i == fh.numPasses-1 {
+			minShift = 0
+		}
+		if i == pass {
+			return minShift, maxShift
+		}
+		maxShift = minShift - 1
+	}
+}
+
+func tocContext(v uint32) int {
+	return min(7, bits.Len32(v))
+}
+
+// Reads the table of contents and returns the frame's sections in the order they are decoded, data is what br
+// reads from. The second result is the offset in data where the frame ends.
+func readTOC(br *bitReader, fh *frameHeader, data []byte) ([][]byte, int, error) {
+	count := 1
+	if fh.numGroups > 1 || fh.numPasses > 1 {
+		count = 1 + fh.numLFGroups + 1 + fh.numGroups*fh.numPasses
+	}
+
+	var permutation []int
+	if br.bool() {
+		code, err := readEntropyCode(br, 8, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		dec := newEntropyDecoder(code, br, 0)
+		end := dec.readUint(tocContext(uint32(count)))
+		if int(end) > count {
+			return nil, 0, FormatError("invalid TOC permutation")
+		}
+		lehmer := make([]uint32, count)
+		for i := range int(end) {
+			prev := uint32(0)
+			if i > 0 {
+				prev = lehmer[i-1]
+			}
+			lehmer[i] = dec.readUint(tocContext(prev))
+			if int(lehmer[i]) >= count-i {
+				return nil, 0, FormatError("invalid TOC permutation")
+			}
+		}
+		if err := dec.finish(); err != nil {
+			return nil, 0, err
+		}
+		permutation = decodeLehmer(lehmer)
+	}
+	br.zeroPadToByte()
+	sizes := make([]int, count)
+	for i := range sizes {
+		sizes[i] = int(br.u32(bitsOf(10), bitsOffset(14, 1024), bitsOffset(22, 17408), bitsOffset(30, 4211712)))
+	}
+	br.zeroPadToByte()
+	if br.err != nil {
+		return nil, 0, br.err
+	}
+
+	offsets := make([]int, count)
+	pos := br.bytesRead()
+	for i, size := range sizes {
+		offsets[i] = pos
+		pos += size
+	}
+	if pos > len(data) {
+		return nil, 0, errTruncated
+	}
+	sections := make([][]byte, count)
+	for i := range sections {
+		j := i
+		if permutation != nil {
+			j = permutation[i]
+		}
+		sections[i] = data[offsets[j] : offsets[j]+sizes[j]]
+	}
+	return sections, pos, nil
+}
+
+func decodeLehmer(lehmer []uint32) []int {
+	remaining := make([]int, len(lehmer))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	permutation := make([]int, len(lehmer))
+	for i, l := range lehmer {
+		permutation[i] = remaining[l]
+		remaining = append(remaining[:l], remaining[l+1:]...)
+	}
+	return permutation
+}
@@ -0,0 +1,359 @@
+package jxl
+
+import (
+	"math"
+)
+
+const (
+	colourSpaceRGB  = 0
+	colourSpaceGrey = 1
+	colourSpaceXYB  = 2
+
+	extraChannelAlpha = 0
+	extraChannelSpot  = 2
+	extraChannelCFA   = 5
+
+	maxImageSide = 1 << 20
+)
+
+type bitDepth struct {
+	floatSample bool
+	bits        uint32
+	expBits     uint32
+}
+
+type extraChannelInfo struct {
+	kind            uint32
+	depth           bitDepth
+	dimShift        uint32
+	alphaAssociated bool
+}
+
+type animationHeader struct {
+	tpsNumerator   uint32
+	tpsDenominator uint32
+	numLoops       uint32
+	haveTimecodes  bool
+}
+
+// Everything the codestream says about the image before its frames
+type imageHeader struct {
+	width, height   int
+	orientation     int
+	havePreview     bool
+	previewW        int
+	previewH        int
+	animation       *animationHeader
+	depth           bitDepth
+	extraChannels   []extraChannelInfo
+	xybEncoded      bool
+	colourSpace     uint32
+	wantICC         bool
+	intensityTarget float32
+	opsinInverse    [9]float32
+	opsinBias       [3]float32
+	customUpsample  bool
+}
+
+// The default opsin matrix turning XYB back into linear RGB and its bias
+var defaultOpsinInverse = [9]float32{
+	11.031566901960783, -9.866943921568629, -0.16462299647058826,
+	-3.254147380392157, 4.418770392156863, -0.16462299647058826,
+	-3.6588512862745097, 2.7129230470588235, 1.9459282392156863,
+}
+
+var defaultOpsinBias = [3]float32{-0.0037930732552754493, -0.0037930732552754493, -0.0037930732552754493}
+
+func (h *imageHeader) alphaChannel() int {
+	for i, ec := range h.extraChannels {
+		if ec.kind == extraChannelAlpha {
+			return i
+		}
+	}
+	return -1
+}
+
+func readSizeHeader(br *bitReader) (int, int) {
+	dist := [4]u32Dist{bitsOffset(9, 1), bitsOffset(13, 1), bitsOffset(18, 1), bitsOffset(30, 1)}
+	small := br.bool()
+	var height uint32
+	if small {
+		height = (br.bits(5) + 1) * 8
+	} else {
+		height = br.u32(dist[0], dist[1], dist[2], dist[3])
+	}
+	ratio := br.bits(3)
+	if ratio != 0 {
+		return aspectWidth(ratio, height), int(height)
+	}
+	if small {
+		return int((br.bits(5) + 1) * 8), int(height)
+	}
+	return int(br.u32(dist[0], dist[1], dist[2], dist[3])), int(height)
+}
+
+func aspectWidth(ratio uint32, height uint32) int {
+	h := uint64(height)
+	switch ratio {
+	case 1:
+		return int(h)
+	case 2:
+		return int(h * 12 / 10)
+	case 3:
+		return int(h * 4 / 3)
+	case 4:
+		return int(h * 3 / 2)
+	case 5:
+		return int(h * 16 / 9)
+	case 6:
+		return int(h * 5 / 4)
+	}
+	return int(h * 2)
+}
+
+func readPreviewHeader(br *bitReader) (int, int) {
+	div8 := br.bool()
+	side := func() uint32 {
+		if div8 {
+			return 8 * br.u32(val(16), val(32), bitsOffset(5, 1), bitsOffset(9, 33))
+		}
+		return br.u32(bitsOffset(6, 1), bitsOffset(8, 65), bitsOffset(10, 321), bitsOffset(12, 1345))
+	}
+	height := side()
+	if ratio := br.bits(3); ratio != 0 {
+		return aspectWidth(ratio, height), int(height)
+	}
+	return int(side()), int(height)
+}
+
+func readBitDepth(br *bitReader) (bitDepth, error) {
+	var d bitDepth
+	if d.floatSample = br.bool(); d.floatSample {
+		d.bits = br.u32(val(32), val(16), val(24), bitsOffset(6, 1))
+		d.expBits = br.bits(4) + 1
+		if d.expBits < 2 || d.expBits > 8 || d.bits < d.expBits+2 || d.bits-d.expBits-1 > 23 {
+			return d, FormatError("invalid float bit depth")
+		}
+	} else {
+		d.bits = br.u32(val(8), val(10), val(12), bitsOffset(6, 1))
+		if d.bits > 31 {
+			return d, FormatError("invalid bit depth")
+		}
+	}
+	return d, nil
+}
+
+func readExtensions(br *bitReader) {
+	extensions := br.u64()
+	total := uint64(0)
+	for i := 0; i < 64 && br.err == nil; i++ {
+		if extensions&(1<<i) != 0 {
+			total += br.u64()
+		}
+	}
+	br.skipBits(total)
+}
+
+func readExtraChannelInfo(br *bitReader) (extraChannelInfo, error) {
+	ec := extraChannelInfo{kind: extraChannelAlpha, depth: bitDepth{bits: 8}}
+	if br.bool() {
+		return ec, nil
+	}
+	ec.kind = br.enum()
+	var err error
+	if ec.depth, err = readBitDepth(br); err != nil {
+		return ec, err
+	}
+	ec.dimShift = br.u32(val(0), val(3), val(4), bitsOffset(3, 1))
+	nameLen := br.u32(val(0), bitsOf(4), bitsOffset(5, 16), bitsOffset(10, 48))
+	br.skipBits(uint64(nameLen) * 8)
+	switch ec.kind {
+	case extraChannelAlpha:
+		ec.alphaAssociated = br.bool()
+	case extraChannelSpot:
+		br.skipBits(4 * 16)
+	case extraChannelCFA:
+		br.u32(val(1), bitsOf(2), bitsOffset(4, 3), bitsOffset(8, 19))
+	}
+	return ec, br.err
+}
+
+func readCustomXY(br *bitReader) {
+	for range 2 {
+		br.u32(bitsOf(19), bitsOffset(19, 524288), bitsOffset(20, 1048576), bitsOffset(21, 2097152))
+	}
+}
+
+func readColourEncoding(br *bitReader, h *imageHeader) {
+	h.colourSpace = colourSpaceRGB
+	if br.bool() {
+		return
+	}
+	h.wantICC = br.bool()
+	h.colourSpace = br.enum()
+	if !h.wantICC && h.colourSpace != colourSpaceXYB {
+		if whitePoint := br.enum(); whitePoint == 2 {
+			readCustomXY(br)
+		}
+		if h.colourSpace != colourSpaceGrey {
+			if primaries := br.enum(); primaries == 2 {
+				for range 3 {
+					readCustomXY(br)
+				}
+			}
+		}
+	}
+	if !h.wantICC {
+		if br.bool() {
+			br.bits(24)
+		} else {
+			br.enum()
+		}
+		br.enum()
+	}
+}
+
+// Reads the size header and image metadata that follow the signature
+func readImageHeader(br *bitReader) (*imageHeader, error) {
+	h := &imageHeader{
+		orientation:     1,
+		depth:           bitDepth{bits: 8},
+		xybEncoded:      true,
+		intensityTarget: 255,
+		opsinInverse:    defaultOpsinInverse,
+		opsinBias:       defaultOpsinBias,
+	}
+	h.width, h.height = readSizeHeader(br)
+	if h.width <= 0 || h.height <= 0 || h.width > maxImageSide || h.height > maxImageSide {
+		return nil, FormatError("invalid image size")
+	}
+
+	if !br.bool() {
+		extraFields := br.bool()
+		if extraFields {
+			h.orientation = int(br.bits(3)) + 1
+			if br.bool() {
+				readSizeHeader(br)
+			}
+			if h.havePreview = br.bool(); h.havePreview {
+				h.previewW, h.previewH = readPreviewHeader(br)
+			}
+			if br.bool() {
+				h.animation = &animationHeader{
+					tpsNumerator:   br.u32(val(100), val(1000), bitsOffset(10, 1), bitsOffset(30, 1)),
+					tpsDenominator: br.u32(val(1), val(1001), bitsOffset(8, 1), bitsOffset(10, 1)),
+					numLoops:       br.u32(val(0), bitsOf(3), bitsOf(16), bitsOf(32)),
+				}
+				h.animation.haveTimecodes = br.bool()
+			}
+		}
+		var err error
+		if h.depth, err = readBitDepth(br); err != nil {
+			return nil, err
+		}
+		br.bool() // modular_16bit_buffers, only a hint for decoders
+		numExtra := br.u32(val(0), val(1), bitsOffset(4, 2), bitsOffset(12, 1))
+		if numExtra > 256 {
+			return nil, UnsupportedError("too many extra channels")
+		}
+		for range numExtra {
+			ec, err := readExtraChannelInfo(br)
+			if err != nil {
+				return nil, err
+			}
+			h.extraChannels = append(h.extraChannels, ec)
+		}
+		h.xybEncoded = br.bool()
+		readColourEncoding(br, h)
+		if extraFields && !br.bool() {
+			h.intensityTarget = br.f16()
+			br.f16()
+			br.bool()
+			br.f16()
+			if !(h.intensityTarget > 0) || math.IsInf(float64(h.intensityTarget), 0) {
+				return nil, FormatError("invalid intensity target")
+			}
+		}
+		readExtensions(br)
+	}
+
+	if !br.bool() {
+		if h.xybEncoded && !br.bool() {
+			for i := range h.opsinInverse {
+				h.opsinInverse[i] = br.f16()
+			}
+			for i := range h.opsinBias {
+				h.opsinBias[i] = br.f16()
+			}
+			br.skipBits(4 * 16) // quantization biases, only used by VarDCT
+		}
+		cwMask := br.bits(3)
+		h.customUpsample = cwMask != 0
+		for i, n := range [3]uint64{15, 55, 210} {
+			if cwMask&(1<<i) != 0 {
+				br.skipBits(n * 16)
+			}
+		}
+	}
+	return h, br.err
+}
+
+// ICC profiles are read to get past them, the pixels are returned as they are coded
+func skipICC(br *bitReader) error {
+	size := br.u64()
+	if size > 1<<28 {
+		return FormatError("ICC profile too large")
+	}
+	code, err := readEntropyCode(br, 41, false)
+	if err != nil {
+		return err
+	}
+	dec := newEntropyDecoder(code, br, 0)
+	var prev1, prev2 uint32
+	for i := uint64(0); i < size; i++ {
+		ctx := 0
+		if i > 128 {
+			ctx = 1 + iccByteKind1(prev1) + 8*iccByteKind2(prev2)
+		}
+		b := dec.readUint(ctx)
+		if br.err != nil {
+			return br.err
+		}
+		prev2, prev1 = prev1, b&0xff
+	}
+	return dec.finish()
+}
+
+func iccByteKind1(b uint32) int {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z':
+		return 0
+	case '0' <= b && b <= '9', b == '.', b == ',':
+		return 1
+	case b == 0:
+		return 2
+	case b == 1:
+		return 3
+	case b < 16:
+		return 4
+	case b == 255:
+		return 6
+	case b > 240:
+		return 5
+	}
+	return 7
+}
+
+func iccByteKind2(b uint32) int {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z':
+		return 0
+	case '0' <= b && b <= '9', b == '.', b == ',':
+		return 1
+	case b < 16:
+		return 2
+	case b > 240:
+		return 3
+	}
+	return 4
+}
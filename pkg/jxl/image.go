@@ -0,0 +1,124 @@
+package jxl
+
+import (
+	"image"
+	"image/color"
+)
+
+// Converts the canvas to an image of the colour model DecodeConfig reports, turned the way the header says
+func (d *decoder) toImage(canvas *planes) image.Image {
+	h := d.header
+	w, ht := canvas.w, canvas.h
+	if h.orientation > 4 {
+		w, ht = ht, w
+	}
+	rect := image.Rect(0, 0, w, ht)
+	src := func(x, y int) int {
+		sx, sy := x, y
+		switch h.orientation {
+		case 2:
+			sx = canvas.w - 1 - x
+		case 3:
+			sx, sy = canvas.w-1-x, canvas.h-1-y
+		case 4:
+			sy = canvas.h - 1 - y
+		case 5:
+			sx, sy = y, x
+		case 6:
+			sx, sy = y, canvas.h-1-x
+		case 7:
+			sx, sy = canvas.w-1-y, canvas.h-1-x
+		case 8:
+			sx, sy = canvas.w-1-y, x
+		}
+		return sy*canvas.w + sx
+	}
+
+	alpha := h.alphaChannel()
+	var a []float32
+	if alpha >= 0 {
+		a = canvas.p[canvas.colour+alpha]
+	}
+	r, g, b := canvas.p[0], canvas.p[0], canvas.p[0]
+	if canvas.colour == 3 {
+		g, b = canvas.p[1], canvas.p[2]
+	}
+	to8 := func(v float32) uint8 { return uint8(clamp01(v)*255 + 0.5) }
+	to16 := func(v float32) uint16 { return uint16(clamp01(v)*65535 + 0.5) }
+
+	switch colorModel(h) {
+	case color.GrayModel:
+		img := image.NewGray(rect)
+		for y := range ht {
+			for x := range w {
+				img.Pix[y*img.Stride+x] = to8(r[src(x, y)])
+			}
+		}
+		return img
+	case color.Gray16Model:
+		img := image.NewGray16(rect)
+		for y := range ht {
+			for x := range w {
+				v := to16(r[src(x, y)])
+				img.Pix[y*img.Stride+2*x], img.Pix[y*img.Stride+2*x+1] = uint8(v>>8), uint8(v)
+			}
+		}
+		return img
+	}
+
+	var pix []uint8
+	var stride int
+	var out image.Image
+	premultiplied := alpha >= 0 && h.extraChannels[alpha].alphaAssociated
+	switch colorModel(h) {
+	case color.NRGBAModel, color.RGBAModel:
+		if premultiplied {
+			img := image.NewRGBA(rect)
+			pix, stride, out = img.Pix, img.Stride, img
+		} else {
+			img := image.NewNRGBA(rect)
+			pix, stride, out = img.Pix, img.Stride, img
+		}
+		for y := range ht {
+			for x := range w {
+				i := src(x, y)
+				av := uint8(0xff)
+				if a != nil {
+					av = to8(a[i])
+				}
+				p := pix[y*stride+4*x:]
+				p[0], p[1], p[2], p[3] = to8(r[i]), to8(g[i]), to8(b[i]), av
+				if premultiplied {
+					p[0], p[1], p[2] = min(p[0], av), min(p[1], av), min(p[2], av)
+				}
+			}
+		}
+		return out
+	}
+
+	if premultiplied {
+		img := image.NewRGBA64(rect)
+		pix, stride, out = img.Pix, img.Stride, img
+	} else {
+		img := image.NewNRGBA64(rect)
+		pix, stride, out = img.Pix, img.Stride, img
+	}
+	for y := range ht {
+		for x := range w {
+			i := src(x, y)
+			av := uint16(0xffff)
+			if a != nil {
+				av = to16(a[i])
+			}
+			vals := [4]uint16{to16(r[i]), to16(g[i]), to16(b[i]), av}
+			p := pix[y*stride+8*x:]
+			for k, v := range vals {
+				if premultiplied && k < 3 {
+					v = min(v, av)
+				}
+				p[2*k], p[2*k+1] = uint8(v>>8), uint8(v)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,164 @@
+// Package jxl reads and writes JPEG XL images. Decoding covers modular frames, the mode used by lossless
+// files and by lossy modular encodes, with layers, animation (first frame), patches and alpha. VarDCT
+// frames are reported as unsupported. Encode writes lossless modular images.
+package jxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+// FormatError reports that the input is not a valid JPEG XL image
+type FormatError string
+
+func (e FormatError) Error() string { return "jxl: invalid format: " + string(e) }
+
+// UnsupportedError reports that the input uses a JPEG XL feature this package does not implement
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "jxl: unsupported feature: " + string(e) }
+
+var errTruncated = FormatError("unexpected end of data")
+
+const (
+	codestreamSignature = "\xff\x0a"
+	containerSignature  = "\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a"
+)
+
+func init() {
+	image.RegisterFormat("jxl", codestreamSignature, Decode, DecodeConfig)
+	image.RegisterFormat("jxl", containerSignature, Decode, DecodeConfig)
+}
+
+// Returns the bare codestream of data, which is either a codestream or an ISO BMFF container holding one
+func codestream(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte(codestreamSignature)) {
+		return data, nil
+	}
+	if !bytes.HasPrefix(data, []byte(containerSignature)) {
+		return nil, FormatError("missing signature")
+	}
+	var stream []byte
+	partial := false
+	for rest := data[len(containerSignature):]; len(rest) > 0; {
+		if len(rest) < 8 {
+			return nil, FormatError("truncated box")
+		}
+		size := uint64(binary.BigEndian.Uint32(rest))
+		kind := string(rest[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(rest))
+		case 1:
+			if len(rest) < 16 {
+				return nil, FormatError("truncated box")
+			}
+			size, header = binary.BigEndian.Uint64(rest[8:]), 16
+		}
+		if size < header || size > uint64(len(rest)) {
+			return nil, FormatError("invalid box size")
+		}
+		body := rest[header:size]
+		rest = rest[size:]
+		switch kind {
+		case "jxlc":
+			if partial || stream != nil {
+				return nil, FormatError("more than one codestream box")
+			}
+			return body, nil
+		case "jxlp":
+			if len(body) < 4 {
+				return nil, FormatError("truncated jxlp box")
+			}
+			partial = true
+			stream = append(stream, body[4:]...)
+			if binary.BigEndian.Uint32(body)&(1<<31) != 0 {
+				return stream, nil
+			}
+		}
+	}
+	if stream == nil {
+		return nil, FormatError("no codestream box")
+	}
+	return stream, nil
+}
+
+// Reads the signature and image header at the start of a codestream
+func readHeader(data []byte) (*bitReader, *imageHeader, error) {
+	br := newBitReader(data)
+	if br.bits(16) != 0x0aff {
+		return nil, nil, FormatError("missing signature")
+	}
+	h, err := readImageHeader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	return br, h, nil
+}
+
+func readCodestream(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return codestream(data)
+}
+
+// DecodeConfig returns the colour model and dimensions of a JPEG XL image without decoding its pixels
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := readCodestream(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	_, h, err := readHeader(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	w, ht := h.width, h.height
+	if h.orientation > 4 {
+		w, ht = ht, w
+	}
+	return image.Config{ColorModel: colorModel(h), Width: w, Height: ht}, nil
+}
+
+// Decode reads a JPEG XL image from r. Animations decode to their first frame.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := readCodestream(r)
+	if err != nil {
+		return nil, err
+	}
+	br, h, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{data: data, header: h}
+	return d.decode(br)
+}
+
+func colorModel(h *imageHeader) color.Model {
+	alpha := h.alphaChannel()
+	deep := h.depth.bits > 8
+	for _, ec := range h.extraChannels {
+		deep = deep || ec.depth.bits > 8
+	}
+	switch {
+	case alpha < 0 && h.colourSpace == colourSpaceGrey:
+		if deep {
+			return color.Gray16Model
+		}
+		return color.GrayModel
+	case alpha < 0 || !h.extraChannels[alpha].alphaAssociated:
+		if deep {
+			return color.NRGBA64Model
+		}
+		return color.NRGBAModel
+	}
+	if deep {
+		return color.RGBA64Model
+	}
+	return color.RGBAModel
+}
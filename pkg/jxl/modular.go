@@ -0,0 +1,592 @@
+package jxl
+
+import (
+	"math/bits"
+)
+
+const (
+	predZero = iota
+	predWest
+	predNorth
+	predAverage0
+	predSelect
+	predGradient
+	predWeighted
+	predNorthEast
+	predNorthWest
+	predWestWest
+	predAverage1
+	predAverage2
+	predAverage3
+	predAverage4
+	numPredictors
+)
+
+const (
+	numNonrefProperties = 16
+	propWeighted        = 15
+)
+
+// One plane of integers of a modular image, the shifts say how much it is subsampled
+type channel struct {
+	w, h           int
+	hshift, vshift int
+	pix            []int32
+}
+
+func newChannel(w, h, hshift, vshift int) *channel {
+	return &channel{w: w, h: h, hshift: hshift, vshift: vshift, pix: make([]int32, w*h)}
+}
+
+// The channels a modular stream decodes into, meta channels like palettes come first
+type modularImage struct {
+	channels   []*channel
+	nbMeta     int
+	bitDepth   uint32
+	transforms []transformInfo
+	wpHeader   wpHeader
+}
+
+type treeNode struct {
+	property   int32 // -1 for leaves
+	splitVal   int32
+	left       int32
+	right      int32
+	ctx        int
+	predictor  int
+	offset     int64
+	multiplier int64
+}
+
+// The meta-adaptive tree that picks a context and predictor for every pixel from its neighbourhood
+type maTree struct {
+	nodes   []treeNode
+	code    *entropyCode
+	maxProp int
+	usesWP  bool
+}
+
+// Reads a tree and the distributions of its leaves
+func readTree(br *bitReader, sizeLimit int) (*maTree, error) {
+	code, err := readEntropyCode(br, 6, false)
+	if err != nil {
+		return nil, err
+	}
+	dec := newEntropyDecoder(code, br, 0)
+	t := &maTree{maxProp: -1}
+	toDecode, leaves := 1, 0
+	for toDecode > 0 {
+		if len(t.nodes) > sizeLimit {
+			return nil, FormatError("tree too large")
+		}
+		toDecode--
+		prop := dec.readUint(1)
+		if br.err != nil {
+			return nil, br.err
+		}
+		if prop > 256 {
+			return nil, FormatError("invalid tree property")
+		}
+		if prop == 0 {
+			predictor := dec.readUint(2)
+			if predictor >= numPredictors {
+				return nil, FormatError("invalid predictor")
+			}
+			offset := unpackSigned(dec.readUint(3))
+			mulLog := dec.readUint(4)
+			if mulLog >= 31 {
+				return nil, FormatError("invalid multiplier")
+			}
+			mulBits := dec.readUint(5)
+			if mulBits >= 1<<(31-mulLog)-1 {
+				return nil, FormatError("invalid multiplier")
+			}
+			t.nodes = append(t.nodes, treeNode{
+				property:   -1,
+				ctx:        leaves,
+				predictor:  int(predictor),
+				offset:     int64(offset),
+				multiplier: int64(mulBits+1) << mulLog,
+			})
+			leaves++
+			t.usesWP = t.usesWP || predictor == predWeighted
+			continue
+		}
+		splitVal := unpackSigned(dec.readUint(0))
+		n := int32(len(t.nodes) + toDecode)
+		t.nodes = append(t.nodes, treeNode{property: int32(prop - 1), splitVal: splitVal, left: n + 1, right: n + 2})
+		toDecode += 2
+		t.maxProp = max(t.maxProp, int(prop-1))
+		t.usesWP = t.usesWP || prop-1 == propWeighted
+	}
+	if err := dec.finish(); err != nil {
+		return nil, err
+	}
+	if t.code, err = readEntropyCode(br, leaves, false); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Parameters of the self-correcting weighted predictor
+type wpHeader struct {
+	p1C, p2C, p3Ca, p3Cb, p3Cc, p3Cd, p3Ce int64
+	w                                      [4]uint32
+}
+
+var defaultWPHeader = wpHeader{p1C: 16, p2C: 10, p3Ca: 7, p3Cb: 7, p3Cc: 7, w: [4]uint32{0xd, 0xc, 0xc, 0xc}}
+
+func readWPHeader(br *bitReader) wpHeader {
+	if br.bool() {
+		return defaultWPHeader
+	}
+	var h wpHeader
+	for _, p := range []*int64{&h.p1C, &h.p2C, &h.p3Ca, &h.p3Cb, &h.p3Cc, &h.p3Cd, &h.p3Ce} {
+		*p = int64(br.bits(5))
+	}
+	for i := range h.w {
+		h.w[i] = br.bits(4)
+	}
+	return h
+}
+
+const (
+	wpExtraBits = 3
+	wpRound     = (1<<wpExtraBits)>>1 - 1
+)
+
+var wpDivLookup = func() (t [64]uint32) {
+	for i := range t {
+		t[i] = (1 << 24) / uint32(i+1)
+	}
+	return t
+}()
+
+// The weighted predictor blends four predictors by how well each did on the neighbouring pixels
+type wpState struct {
+	header     wpHeader
+	prediction [4]int64
+	pred       int64
+	predErrors [4][]uint32
+	errors     []int32
+	width      int
+}
+
+func newWPState(header wpHeader, width int) *wpState {
+	s := &wpState{header: header, width: width}
+	for i := range s.predErrors {
+		s.predErrors[i] = make([]uint32, (width+2)*2)
+	}
+	s.errors = make([]int32, (width+2)*2)
+	return s
+}
+
+func wpErrorWeight(x uint64, maxWeight uint32) uint32 {
+	shift := bits.Len64(x+1) - 1 - 5
+	if shift < 0 {
+		shift = 0
+	}
+	return 4 + (maxWeight*wpDivLookup[x>>uint(shift)])>>uint(shift)
+}
+
+func wpWeightedAverage(p *[4]int64, w [4]uint32) int64 {
+	sum := uint32(0)
+	for _, v := range w {
+		sum += v
+	}
+	logWeight := uint(bits.Len32(sum) - 1)
+	sum = 0
+	for i := range w {
+		w[i] >>= logWeight - 4
+		sum += w[i]
+	}
+	total := int64(sum>>1) - 1
+	for i := range p {
+		total += p[i] * int64(w[i])
+	}
+	return (total * int64(wpDivLookup[sum-1])) >> 24
+}
+
+// Predicts the pixel at x, y and returns the prediction and the largest neighbouring error as property 15
+func (s *wpState) predict(x, y int, n, w, ne, nw, nn int64) (int64, int32) {
+	curRow, prevRow := s.width+2, 0
+	if y&1 != 0 {
+		curRow, prevRow = 0, s.width+2
+	}
+	posN := prevRow + x
+	posNE, posNW := posN, posN
+	if x < s.width-1 {
+		posNE = posN + 1
+	}
+	if x > 0 {
+		posNW = posN - 1
+	}
+	var weights [4]uint32
+	for i := range weights {
+		weights[i] = wpErrorWeight(uint64(s.predErrors[i][posN]+s.predErrors[i][posNE]+s.predErrors[i][posNW]), s.header.w[i])
+	}
+
+	n, w, ne, nw, nn = n<<wpExtraBits, w<<wpExtraBits, ne<<wpExtraBits, nw<<wpExtraBits, nn<<wpExtraBits
+	teW := int64(0)
+	if x > 0 {
+		teW = int64(s.errors[curRow+x-1])
+	}
+	teN := int64(s.errors[posN])
+	teNW := int64(s.errors[posNW])
+	teNE := int64(s.errors[posNE])
+	sumWN := teN + teW
+
+	maxErr := teW
+	for _, e := range [3]int64{teN, teNW, teNE} {
+		if abs64(e) > abs64(maxErr) {
+			maxErr = e
+		}
+	}
+
+	h := &s.header
+	s.prediction[0] = w + ne - n
+	s.prediction[1] = n - ((sumWN+teNE)*h.p1C)>>5
+	s.prediction[2] = w - ((sumWN+teNW)*h.p2C)>>5
+	s.prediction[3] = n - (teNW*h.p3Ca+teN*h.p3Cb+teNE*h.p3Cc+(nn-n)*h.p3Cd+(nw-w)*h.p3Ce)>>5
+	s.pred = wpWeightedAverage(&s.prediction, weights)
+
+	if (teN^teW)|(teN^teNW) <= 0 {
+		s.pred = max(min(w, ne, n), min(max(w, ne, n), s.pred))
+	}
+	return (s.pred + wpRound) >> wpExtraBits, int32(maxErr)
+}
+
+func (s *wpState) update(value int64, x, y int) {
+	curRow, prevRow := s.width+2, 0
+	if y&1 != 0 {
+		curRow, prevRow = 0, s.width+2
+	}
+	value <<= wpExtraBits
+	s.errors[curRow+x] = int32(s.pred - value)
+	for i := range s.predErrors {
+		err := uint32((abs64(s.prediction[i]-value) + wpRound) >> wpExtraBits)
+		s.predErrors[i][curRow+x] = err
+		s.predErrors[i][prevRow+x+1] += err
+	}
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func clampedGradient(n, w, nw int64) int64 {
+	lo, hi := min(n, w), max(n, w)
+	if nw < lo {
+		return hi
+	}
+	if nw > hi {
+		return lo
+	}
+	return n + w - nw
+}
+
+// The neighbours of a pixel, the ones outside the channel are replaced the way the format defines
+type neighbours struct {
+	w, n, nw, ne, ww, nn, nee int64
+}
+
+func (c *channel) neighbours(x, y int) neighbours {
+	var nb neighbours
+	row := c.pix[y*c.w:]
+	var prev []int32
+	if y > 0 {
+		prev = c.pix[(y-1)*c.w:]
+	}
+	switch {
+	case x > 0:
+		nb.w = int64(row[x-1])
+	case y > 0:
+		nb.w = int64(prev[x])
+	}
+	nb.n = nb.w
+	if y > 0 {
+		nb.n = int64(prev[x])
+	}
+	nb.nw = nb.w
+	if x > 0 && y > 0 {
+		nb.nw = int64(prev[x-1])
+	}
+	nb.ne = nb.n
+	if x+1 < c.w && y > 0 {
+		nb.ne = int64(prev[x+1])
+	}
+	nb.ww = nb.w
+	if x > 1 {
+		nb.ww = int64(row[x-2])
+	}
+	nb.nn = nb.n
+	if y > 1 {
+		nb.nn = int64(c.pix[(y-2)*c.w+x])
+	}
+	nb.nee = nb.ne
+	if x+2 < c.w && y > 0 {
+		nb.nee = int64(prev[x+2])
+	}
+	return nb
+}
+
+func predict(predictor int, nb *neighbours, wp int64) int64 {
+	switch predictor {
+	case predWest:
+		return nb.w
+	case predNorth:
+		return nb.n
+	case predAverage0:
+		return (nb.w + nb.n) / 2
+	case predSelect:
+		p := nb.n + nb.w - nb.nw
+		if abs64(p-nb.n) < abs64(p-nb.w) {
+			return nb.w
+		}
+		return nb.n
+	case predGradient:
+		return clampedGradient(nb.n, nb.w, nb.nw)
+	case predWeighted:
+		return wp
+	case predNorthEast:
+		return nb.ne
+	case predNorthWest:
+		return nb.nw
+	case predWestWest:
+		return nb.ww
+	case predAverage1:
+		return (nb.w + nb.nw) / 2
+	case predAverage2:
+		return (nb.n + nb.nw) / 2
+	case predAverage3:
+		return (nb.n + nb.ne) / 2
+	case predAverage4:
+		return (6*nb.n - 2*nb.nn + 7*nb.w + nb.ww + nb.nee + 3*nb.ne + 8) / 16
+	}
+	return 0
+}
+
+// Channels before index that have the same size and shifts, they give the reference properties
+func referenceChannels(img *modularImage, index int, count int) []*channel {
+	c := img.channels[index]
+	var refs []*channel
+	for j := index - 1; j >= 0 && len(refs) < count; j-- {
+		r := img.channels[j]
+		if r.w == c.w && r.h == c.h && r.hshift == c.hshift && r.vshift == c.vshift {
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
+
+// Decodes the pixels of one channel with the tree
+func decodeChannel(dec *entropyDecoder, tree *maTree, img *modularImage, index int, streamID int) error {
+	c := img.channels[index]
+	nodes := tree.nodes
+	br := dec.br
+
+	// a single leaf needs no properties
+	if len(nodes) == 1 {
+		leaf := &nodes[0]
+		var wp *wpState
+		if leaf.predictor == predWeighted {
+			wp = newWPState(img.wpHeader, c.w)
+		}
+		for y := range c.h {
+			row := c.pix[y*c.w : (y+1)*c.w]
+			for x := range row {
+				nb := c.neighbours(x, y)
+				var wpPred int64
+				if wp != nil {
+					wpPred, _ = wp.predict(x, y, nb.n, nb.w, nb.ne, nb.nw, nb.nn)
+				}
+				v := int64(unpackSigned(dec.readUint(leaf.ctx)))*leaf.multiplier + leaf.offset + predict(leaf.predictor, &nb, wpPred)
+				row[x] = int32(v)
+				if wp != nil {
+					wp.update(int64(row[x]), x, y)
+				}
+			}
+			if br.err != nil {
+				return br.err
+			}
+		}
+		return nil
+	}
+
+	numRefs := 0
+	if tree.maxProp >= numNonrefProperties {
+		numRefs = (tree.maxProp - numNonrefProperties + 4) / 4
+	}
+	refs := referenceChannels(img, index, numRefs)
+	props := make([]int32, numNonrefProperties+4*numRefs)
+	var wp *wpState
+	if tree.usesWP {
+		wp = newWPState(img.wpHeader, c.w)
+	}
+
+	props[0] = int32(index)
+	props[1] = int32(streamID)
+	for y := range c.h {
+		row := c.pix[y*c.w : (y+1)*c.w]
+		props[2] = int32(y)
+		props[9] = 0
+		for x := range row {
+			nb := c.neighbours(x, y)
+			props[3] = int32(x)
+			props[4] = int32(abs64(nb.n))
+			props[5] = int32(abs64(nb.w))
+			props[6] = int32(nb.n)
+			props[7] = int32(nb.w)
+			props[8] = int32(nb.w) - props[9]
+			props[9] = int32(nb.w + nb.n - nb.nw)
+			props[10] = int32(nb.w - nb.nw)
+			props[11] = int32(nb.nw - nb.n)
+			props[12] = int32(nb.n - nb.ne)
+			props[13] = int32(nb.n - nb.nn)
+			props[14] = int32(nb.w - nb.ww)
+			var wpPred int64
+			if wp != nil {
+				wpPred, props[propWeighted] = wp.predict(x, y, nb.n, nb.w, nb.ne, nb.nw, nb.nn)
+			}
+			for k, r := range refs {
+				v := int64(r.pix[y*r.w+x])
+				var left, top, topLeft int64
+				if x > 0 {
+					left = int64(r.pix[y*r.w+x-1])
+				}
+				top, topLeft = left, left
+				if y > 0 {
+					top = int64(r.pix[(y-1)*r.w+x])
+					if x > 0 {
+						topLeft = int64(r.pix[(y-1)*r.w+x-1])
+					}
+				}
+				residual := v - clampedGradient(left, top, topLeft)
+				p := props[numNonrefProperties+4*k:]
+				p[0], p[1], p[2], p[3] = int32(abs64(v)), int32(v), int32(abs64(residual)), int32(residual)
+			}
+
+			n := 0
+			for nodes[n].property >= 0 {
+				if props[nodes[n].property] > nodes[n].splitVal {
+					n = int(nodes[n].left)
+				} else {
+					n = int(nodes[n].right)
+				}
+			}
+			leaf := &nodes[n]
+			v := int64(unpackSigned(dec.readUint(leaf.ctx)))*leaf.multiplier + leaf.offset + predict(leaf.predictor, &nb, wpPred)
+			row[x] = int32(v)
+			if wp != nil {
+				wp.update(int64(row[x]), x, y)
+			}
+		}
+		if br.err != nil {
+			return br.err
+		}
+	}
+	return nil
+}
+
+// Reads the header of a modular stream, the transforms are applied to the channel list right away
+func readModularHeader(br *bitReader, img *modularImage) (bool, error) {
+	useGlobalTree := br.bool()
+	img.wpHeader = readWPHeader(br)
+	numTransforms := br.u32(val(0), val(1), bitsOffset(4, 2), bitsOffset(8, 18))
+	for range numTransforms {
+		t, err := readTransform(br)
+		if err != nil {
+			return false, err
+		}
+		if err := t.metaApply(img); err != nil {
+			return false, err
+		}
+		img.transforms = append(img.transforms, t)
+	}
+	return useGlobalTree, br.err
+}
+
+// Decodes a modular stream into img, channels larger than maxChanSize are left for the groups. The global tree
+// is used when the stream asks for it.
+func decodeModularStream(br *bitReader, img *modularImage, streamID int, maxChanSize int, global *maTree, undo bool) error {
+	if len(img.channels) == 0 {
+		return nil
+	}
+	useGlobalTree, err := readModularHeader(br, img)
+	if err != nil {
+		return err
+	}
+	for _, c := range img.channels {
+		if c.w*c.h > maxImageSide*maxImageSide/4 {
+			return FormatError("channel too large")
+		}
+	}
+
+	decoded := func(i int) (bool, bool) {
+		c := img.channels[i]
+		if c.w == 0 || c.h == 0 {
+			return false, true
+		}
+		if i >= img.nbMeta && (c.w > maxChanSize || c.h > maxChanSize) {
+			return false, false
+		}
+		return true, true
+	}
+
+	distMult, numChannels, pixels := 0, 0, 0
+	for i, c := range img.channels {
+		ok, more := decoded(i)
+		if !more {
+			break
+		}
+		if ok {
+			distMult = max(distMult, c.w)
+			numChannels++
+			pixels += c.w * c.h
+		}
+	}
+	if numChannels > 0 {
+		tree := global
+		if !useGlobalTree {
+			if tree, err = readTree(br, min(1<<20, 1024+pixels)); err != nil {
+				return err
+			}
+		} else if tree == nil {
+			return FormatError("global tree missing")
+		}
+
+		dec := newEntropyDecoder(tree.code, br, uint32(distMult))
+		for i := range img.channels {
+			ok, more := decoded(i)
+			if !more {
+				break
+			}
+			if ok {
+				if err := decodeChannel(dec, tree, img, i, streamID); err != nil {
+					return err
+				}
+			}
+		}
+		if err := dec.finish(); err != nil {
+			return err
+		}
+	}
+
+	if undo {
+		return img.undoTransforms()
+	}
+	return nil
+}
+
+func (img *modularImage) undoTransforms() error {
+	for i := len(img.transforms) - 1; i >= 0; i-- {
+		if err := img.transforms[i].inverse(img); err != nil {
+			return err
+		}
+	}
+	img.transforms = nil
+	return nil
+}
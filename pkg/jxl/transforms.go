@@ -0,0 +1,459 @@
+package jxl
+
+const (
+	transformRCT     = 0
+	transformPalette = 1
+	transformSqueeze = 2
+)
+
+type squeezeParams struct {
+	horizontal bool
+	inPlace    bool
+	beginC     int
+	numC       int
+}
+
+// A reversible transform of a modular image, the channels are coded transformed and turned back after decoding
+type transformInfo struct {
+	id        int
+	beginC    int
+	rctType   int
+	numC      int
+	nbColours int
+	nbDeltas  int
+	dPred     int
+	squeezes  []squeezeParams
+	wpHeader  wpHeader
+}
+
+func readTransform(br *bitReader) (transformInfo, error) {
+	var t transformInfo
+	t.id = int(br.u32(val(transformRCT), val(transformPalette), val(transformSqueeze), val(3)))
+	beginDist := [4]u32Dist{bitsOf(3), bitsOffset(6, 8), bitsOffset(10, 72), bitsOffset(13, 1096)}
+	switch t.id {
+	case transformRCT:
+		t.beginC = int(br.u32(beginDist[0], beginDist[1], beginDist[2], beginDist[3]))
+		t.rctType = int(br.u32(val(6), bitsOf(2), bitsOffset(4, 2), bitsOffset(6, 10)))
+		if t.rctType >= 42 {
+			return t, FormatError("invalid RCT type")
+		}
+	case transformPalette:
+		t.beginC = int(br.u32(beginDist[0], beginDist[1], beginDist[2], beginDist[3]))
+		t.numC = int(br.u32(val(1), val(3), val(4), bitsOffset(13, 1)))
+		t.nbColours = int(br.u32(bitsOffset(8, 0), bitsOffset(10, 256), bitsOffset(12, 1280), bitsOffset(16, 5376)))
+		t.nbDeltas = int(br.u32(val(0), bitsOffset(8, 1), bitsOffset(10, 257), bitsOffset(16, 1281)))
+		t.dPred = int(br.bits(4))
+		if t.dPred >= numPredictors {
+			return t, FormatError("invalid palette predictor")
+		}
+	case transformSqueeze:
+		numSqueezes := br.u32(val(0), bitsOffset(4, 1), bitsOffset(6, 9), bitsOffset(8, 41))
+		for range numSqueezes {
+			var sp squeezeParams
+			sp.horizontal = br.bool()
+			sp.inPlace = br.bool()
+			sp.beginC = int(br.u32(beginDist[0], beginDist[1], beginDist[2], beginDist[3]))
+			sp.numC = int(br.u32(val(1), val(2), val(3), bitsOffset(4, 4)))
+			t.squeezes = append(t.squeezes, sp)
+		}
+	default:
+		return t, FormatError("invalid transform")
+	}
+	return t, br.err
+}
+
+// Changes the channel list of img to the one the transformed data is coded in
+func (t *transformInfo) metaApply(img *modularImage) error {
+	t.wpHeader = img.wpHeader
+	switch t.id {
+	case transformRCT:
+		if t.beginC+3 > len(img.channels) || !sameChannels(img, t.beginC, t.beginC+2) {
+			return FormatError("invalid RCT channels")
+		}
+	case transformPalette:
+		endC := t.beginC + t.numC - 1
+		if t.numC < 1 || endC >= len(img.channels) || !sameChannels(img, t.beginC, endC) {
+			return FormatError("invalid palette channels")
+		}
+		if t.beginC >= img.nbMeta {
+			img.nbMeta++
+		} else {
+			if endC >= img.nbMeta {
+				return FormatError("palette mixes meta and image channels")
+			}
+			img.nbMeta += 2 - t.numC
+		}
+		img.channels = append(img.channels[:t.beginC+1], img.channels[endC+1:]...)
+		palette := newChannel(t.nbColours+t.nbDeltas, t.numC, -1, -1)
+		img.channels = append([]*channel{palette}, img.channels...)
+	case transformSqueeze:
+		if len(t.squeezes) == 0 {
+			t.squeezes = defaultSqueezes(img)
+		}
+		for _, sp := range t.squeezes {
+			endC := sp.beginC + sp.numC - 1
+			if sp.numC < 1 || endC >= len(img.channels) {
+				return FormatError("invalid squeeze channels")
+			}
+			if sp.beginC < img.nbMeta {
+				if endC >= img.nbMeta || !sp.inPlace {
+					return FormatError("invalid squeeze of meta channels")
+				}
+				img.nbMeta += sp.numC
+			}
+			offset := len(img.channels)
+			if sp.inPlace {
+				offset = endC + 1
+			}
+			for c := sp.beginC; c <= endC; c++ {
+				ch := img.channels[c]
+				if ch.hshift > 30 || ch.vshift > 30 {
+					return FormatError("too many squeezes")
+				}
+				if ch.w == 0 || ch.h == 0 {
+					return FormatError("squeezing an empty channel")
+				}
+				w, h := ch.w, ch.h
+				if sp.horizontal {
+					ch.w = (w + 1) / 2
+					if ch.hshift >= 0 {
+						ch.hshift++
+					}
+					w -= ch.w
+				} else {
+					ch.h = (h + 1) / 2
+					if ch.vshift >= 0 {
+						ch.vshift++
+					}
+					h -= ch.h
+				}
+				ch.pix = make([]int32, ch.w*ch.h)
+				residual := newChannel(w, h, ch.hshift, ch.vshift)
+				at := offset + c - sp.beginC
+				img.channels = append(img.channels[:at], append([]*channel{residual}, img.channels[at:]...)...)
+			}
+		}
+	}
+	return nil
+}
+
+func sameChannels(img *modularImage, from, to int) bool {
+	first := img.channels[from]
+	for _, c := range img.channels[from+1 : to+1] {
+		if c.w != first.w || c.h != first.h || c.hshift != first.hshift || c.vshift != first.vshift {
+			return false
+		}
+	}
+	return true
+}
+
+// The squeezes used when the stream lists none, chroma first and then everything down to an 8x8 preview
+func defaultSqueezes(img *modularImage) []squeezeParams {
+	first := img.nbMeta
+	numChannels := len(img.channels) - first
+	if numChannels <= 0 {
+		return nil
+	}
+	w, h := img.channels[first].w, img.channels[first].h
+	var params []squeezeParams
+	if numChannels > 2 && img.channels[first+1].w == w && img.channels[first+1].h == h {
+		params = append(params,
+			squeezeParams{horizontal: true, beginC: first + 1, numC: 2},
+			squeezeParams{horizontal: false, beginC: first + 1, numC: 2})
+	}
+	sp := squeezeParams{beginC: first, numC: numChannels, inPlace: true}
+	if w <= h && h > 8 {
+		sp.horizontal = false
+		params = append(params, sp)
+		h = (h + 1) / 2
+	}
+	for w > 8 || h > 8 {
+		if w > 8 {
+			sp.horizontal = true
+			params = append(params, sp)
+			w = (w + 1) / 2
+		}
+		if h > 8 {
+			sp.horizontal = false
+			params = append(params, sp)
+			h = (h + 1) / 2
+		}
+	}
+	return params
+}
+
+// Turns the decoded channels back into the ones the transform was applied to
+func (t *transformInfo) inverse(img *modularImage) error {
+	switch t.id {
+	case transformRCT:
+		inverseRCT(img, t.beginC, t.rctType)
+	case transformPalette:
+		return inversePalette(img, t)
+	case transformSqueeze:
+		return inverseSqueeze(img, t.squeezes)
+	}
+	return nil
+}
+
+func inverseRCT(img *modularImage, begin int, rctType int) {
+	permutation, kind := rctType/7, rctType%7
+	in := [3]*channel{img.channels[begin], img.channels[begin+1], img.channels[begin+2]}
+	out := [3]int{
+		begin + permutation%3,
+		begin + (permutation+1+permutation/3)%3,
+		begin + (permutation+2-permutation/3)%3,
+	}
+	if kind != 0 {
+		second, third := kind>>1, kind&1
+		for i := range in[0].pix {
+			a, b, c := in[0].pix[i], in[1].pix[i], in[2].pix[i]
+			if kind == 6 {
+				tmp := a - c>>1
+				g := c + tmp
+				bl := tmp - b>>1
+				a, b, c = bl+b, g, bl
+			} else {
+				if third == 1 {
+					c += a
+				}
+				switch second {
+				case 1:
+					b += a
+				case 2:
+					b += (a + c) >> 1
+				}
+			}
+			in[0].pix[i], in[1].pix[i], in[2].pix[i] = a, b, c
+		}
+	}
+	for i, o := range out {
+		img.channels[o] = in[i]
+	}
+}
+
+var deltaPalette = [72][3]int32{
+	{0, 0, 0}, {4, 4, 4}, {11, 0, 0}, {0, 0, -13}, {0, -12, 0}, {-10, -10, -10},
+	{-18, -18, -18}, {-27, -27, -27}, {-18, -18, 0}, {0, 0, -32}, {-32, 0, 0}, {-37, -37, -37},
+	{0, -32, -32}, {24, 24, 45}, {50, 50, 50}, {-45, -24, -24}, {-24, -45, -45}, {0, -24, -24},
+	{-34, -34, 0}, {-24, 0, -24}, {-45, -45, -24}, {64, 64, 64}, {-32, 0, -32}, {0, -32, 0},
+	{-32, 0, 32}, {-24, -45, -24}, {45, 24, 45}, {24, -24, -45}, {-45, -24, 24}, {80, 80, 80},
+	{64, 0, 0}, {0, 0, -64}, {0, -64, -64}, {-24, -24, 45}, {96, 96, 96}, {64, 64, 0},
+	{45, -24, -24}, {34, -34, 0}, {112, 112, 112}, {24, -45, -45}, {45, 45, -24}, {0, -32, 32},
+	{24, -24, 45}, {0, 96, 96}, {45, -24, 24}, {24, -45, -24}, {-24, -45, 24}, {0, -64, 0},
+	{96, 0, 0}, {128, 128, 128}, {64, 0, 64}, {144, 144, 144}, {96, 96, 0}, {-36, -36, 36},
+	{45, -24, -45}, {45, -45, -24}, {0, 0, -96}, {0, 128, 128}, {0, 96, 0}, {45, 24, -45},
+	{-128, 0, 0}, {24, -45, 24}, {-45, 24, -45}, {64, 0, -64}, {64, -64, -64}, {96, 0, 96},
+	{45, -24, 45}, {24, 45, -45}, {64, 64, -64}, {128, 128, 0}, {0, 0, -128}, {-24, 45, -45},
+}
+
+// The value of palette index for channel c, indices outside the palette stand for delta entries and two
+// implicit colour cubes
+func paletteValue(palette *channel, index int32, c int, bitDepth uint32) int32 {
+	size := int32(palette.w)
+	switch {
+	case index < 0:
+		if c >= 3 {
+			return 0
+		}
+		i := -(index + 1) % (1 + 2*(int32(len(deltaPalette))-1))
+		v := deltaPalette[(i+1)>>1][c]
+		if i&1 == 0 {
+			v = -v
+		}
+		if bitDepth > 8 {
+			v <<= bitDepth - 8
+		}
+		return v
+	case index >= size && index < size+64:
+		if c >= 3 {
+			return 0
+		}
+		i := (index - size) >> (2 * c)
+		return scalePalette(int64(i%4), bitDepth, 4) + int32(1)<<max(0, int(bitDepth)-3)
+	case index >= size+64:
+		if c >= 3 {
+			return 0
+		}
+		i := index - size - 64
+		for range c {
+			i /= 5
+		}
+		return scalePalette(int64(i%5), bitDepth, 4)
+	}
+	return palette.pix[c*palette.w+int(index)]
+}
+
+func scalePalette(v int64, bitDepth uint32, denom int64) int32 {
+	return int32(v * (int64(1)<<bitDepth - 1) / denom)
+}
+
+func inversePalette(img *modularImage, t *transformInfo) error {
+	if img.nbMeta < 1 || t.beginC+1 >= len(img.channels) {
+		return FormatError("palette transform without palette")
+	}
+	palette := img.channels[0]
+	indices := img.channels[t.beginC+1]
+	nb := palette.h
+	bitDepth := min(img.bitDepth, 24)
+
+	outputs := make([]*channel, nb)
+	for c := range outputs {
+		outputs[c] = newChannel(indices.w, indices.h, indices.hshift, indices.vshift)
+	}
+	for c, out := range outputs {
+		var wp *wpState
+		if t.nbDeltas > 0 && t.dPred == predWeighted {
+			wp = newWPState(t.wpHeader, out.w)
+		}
+		for y := range out.h {
+			for x := range out.w {
+				i := y*out.w + x
+				index := indices.pix[i]
+				v := int64(paletteValue(palette, index, c, bitDepth))
+				if index < int32(t.nbDeltas) {
+					nb := out.neighbours(x, y)
+					var wpPred int64
+					if wp != nil {
+						wpPred, _ = wp.predict(x, y, nb.n, nb.w, nb.ne, nb.nw, nb.nn)
+					}
+					v += predict(t.dPred, &nb, wpPred)
+				}
+				out.pix[i] = int32(v)
+				if wp != nil {
+					wp.update(int64(out.pix[i]), x, y)
+				}
+			}
+		}
+	}
+
+	rest := img.channels[t.beginC+2:]
+	channels := append([]*channel{}, img.channels[1:t.beginC+1]...)
+	channels = append(channels, outputs...)
+	img.channels = append(channels, rest...)
+	img.nbMeta--
+	return nil
+}
+
+func inverseSqueeze(img *modularImage, params []squeezeParams) error {
+	for i := len(params) - 1; i >= 0; i-- {
+		sp := params[i]
+		endC := sp.beginC + sp.numC - 1
+		offset := len(img.channels) + sp.beginC - endC - 1
+		if sp.inPlace {
+			offset = endC + 1
+		}
+		if endC >= len(img.channels) || offset+sp.numC > len(img.channels) {
+			return FormatError("invalid squeeze channels")
+		}
+		if sp.beginC < img.nbMeta {
+			img.nbMeta -= sp.numC
+		}
+		for c := sp.beginC; c <= endC; c++ {
+			avg, residual := img.channels[c], img.channels[offset+c-sp.beginC]
+			if avg.w < residual.w || avg.h < residual.h {
+				return FormatError("corrupted squeeze transform")
+			}
+			if sp.horizontal {
+				if avg.h != residual.h || avg.w != (avg.w+residual.w+1)/2 {
+					return FormatError("corrupted squeeze transform")
+				}
+				img.channels[c] = unsqueezeHorizontal(avg, residual)
+			} else {
+				if avg.w != residual.w || avg.h != (avg.h+residual.h+1)/2 {
+					return FormatError("corrupted squeeze transform")
+				}
+				img.channels[c] = unsqueezeVertical(avg, residual)
+			}
+		}
+		img.channels = append(img.channels[:offset], img.channels[offset+sp.numC:]...)
+	}
+	return nil
+}
+
+func smoothTendency(b, a, n int64) int64 {
+	diff := int64(0)
+	if b >= a && a >= n {
+		diff = (4*b - 3*n - a + 6) / 12
+		if diff-(diff&1) > 2*(b-a) {
+			diff = 2*(b-a) + 1
+		}
+		if diff+(diff&1) > 2*(a-n) {
+			diff = 2 * (a - n)
+		}
+	} else if b <= a && a <= n {
+		diff = (4*b - 3*n - a - 6) / 12
+		if diff+(diff&1) < 2*(b-a) {
+			diff = 2*(b-a) - 1
+		}
+		if diff-(diff&1) < 2*(a-n) {
+			diff = 2 * (a - n)
+		}
+	}
+	return diff
+}
+
+func unsqueezeHorizontal(avg, residual *channel) *channel {
+	out := newChannel(avg.w+residual.w, avg.h, avg.hshift-1, avg.vshift)
+	if residual.w == 0 {
+		copy(out.pix, avg.pix)
+		return out
+	}
+	for y := range avg.h {
+		a := avg.pix[y*avg.w : (y+1)*avg.w]
+		r := residual.pix[y*residual.w : (y+1)*residual.w]
+		o := out.pix[y*out.w : (y+1)*out.w]
+		for x := range r {
+			av := int64(a[x])
+			next := av
+			if x+1 < avg.w {
+				next = int64(a[x+1])
+			}
+			left := av
+			if x > 0 {
+				left = int64(o[2*x-1])
+			}
+			diff := int64(r[x]) + smoothTendency(left, av, next)
+			first := av + diff/2
+			o[2*x] = int32(first)
+			o[2*x+1] = int32(first - diff)
+		}
+		if out.w&1 != 0 {
+			o[out.w-1] = a[avg.w-1]
+		}
+	}
+	return out
+}
+
+func unsqueezeVertical(avg, residual *channel) *channel {
+	out := newChannel(avg.w, avg.h+residual.h, avg.hshift, avg.vshift-1)
+	if residual.h == 0 {
+		copy(out.pix, avg.pix)
+		return out
+	}
+	w := avg.w
+	for y := range residual.h {
+		a := avg.pix[y*w : (y+1)*w]
+		next := a
+		if y+1 < avg.h {
+			next = avg.pix[(y+1)*w : (y+2)*w]
+		}
+		top := a
+		if y > 0 {
+			top = out.pix[(2*y-1)*w : 2*y*w]
+		}
+		r := residual.pix[y*w : (y+1)*w]
+		o := out.pix[2*y*w : (2*y+1)*w]
+		o2 := out.pix[(2*y+1)*w : (2*y+2)*w]
+		for x := range w {
+			av := int64(a[x])
+			diff := int64(r[x]) + smoothTendency(int64(top[x]), av, int64(next[x]))
+			first := av + diff/2
+			o[x] = int32(first)
+			o2[x] = int32(first - diff)
+		}
+	}
+	if out.h&1 != 0 {
+		copy(out.pix[(out.h-1)*w:], avg.pix[(avg.h-1)*w:])
+	}
+	return out
+}
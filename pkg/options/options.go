@@ -11,16 +11,123 @@ import (
 )
 
 type Options struct {
-	DatabasePath  string
-	ExcludedDirs  map[string]int
-	Profiling     bool
-	Timezone      int // Timezone like UTC+3 or UTC-3
-	SortDesc      bool
-	UseRGB        bool
-	ExifFields    []string // exif fields to display in the sidebar
-	ImageNumber   uint
-	ThumbnailSize int
-	FirstBoot     bool
+	DatabasePath   string
+	ExcludedDirs   map[string]int
+	Profiling      bool
+	Timezone       int // Timezone like UTC+3 or UTC-3
+	SortDesc       bool
+	UseRGB         bool
+	ExifFields     []string // exif fields to display in the sidebar
+	ImageNumber    uint
+	ThumbnailSize  int
+	FirstBoot      bool
+	ArchiveDir     string                    // last directory an archive was saved to
+	ArchiveName    string                    // archive name template, see archives.ExpandNameTemplate
+	ConvertPresets map[string]ConvertOptions // named conversion settings
+	Shortcuts      map[string]string         // key binding of every action in ShortcutActions, like "Ctrl+F"
+	DirectoryTags  DirectoryTagOptions       // tags made from the directories of discovered files
+}
+
+// What happens to the EXIF and ICC data of converted images
+const (
+	MetadataKeep      = "Keep all"
+	MetadataColorOnly = "Keep color profile only"
+	MetadataStrip     = "Strip all"
+)
+
+var MetadataModes = []string{MetadataKeep, MetadataColorOnly, MetadataStrip}
+
+// Resampling filters used when resizing, from fastest to smoothest
+var ResizeFilters = []string{"Nearest", "Bilinear", "CatmullRom"}
+
+// Settings for converting images, see imageconv.ConvertImages
+type ConvertOptions struct {
+	Quality   int    // 1-100, used by JPG, WEBP, AVIF and HEIC
+	Effort    int    // 0-10, higher is slower but smaller, used by PNG and AVIF
+	Lossless  bool   // used by WEBP, AVIF and HEIC, the other formats are always or never lossless
+	MaxWidth  int    // 0 is unlimited
+	MaxHeight int    // 0 is unlimited
+	Scale     int    // percent of the original size, 0 keeps the size
+	Filter    string // one of ResizeFilters
+	Metadata  string // one of MetadataModes
+}
+
+// How the names of directory tags are written
+const (
+	CaseKeep  = "Keep"
+	CaseLower = "lowercase"
+	CaseTitle = "Title Case"
+)
+
+var TagCases = []string{CaseKeep, CaseLower, CaseTitle}
+
+// Settings for tagging discovered files with the names of the directories they are in, see dirtags.Names
+type DirectoryTagOptions struct {
+	Enabled  bool
+	Ignore   []string // directory names that never become tags, compared without case
+	MaxDepth int      // directory levels closest to the file that become tags, 0 uses every level
+	Case     string   // one of TagCases
+	Nested   bool     // one nested tag like 2023/Japan/Kyoto instead of a tag per directory
+	Parent   string   // tag the directory tags are nested under, like Places, empty for none
+}
+
+func DefaultDirectoryTagOptions() DirectoryTagOptions {
+	return DirectoryTagOptions{
+		Ignore: []string{"Pictures", "Photos", "Images", "Downloads", "Desktop", "Documents", "DCIM", "Camera"},
+		Case:   CaseKeep,
+	}
+}
+
+func DefaultConvertOptions() ConvertOptions {
+	return ConvertOptions{Quality: 85, Effort: 4, Filter: "CatmullRom", Metadata: MetadataKeep}
+}
+
+func DefaultConvertPresets() map[string]ConvertOptions {
+	return map[string]ConvertOptions{
+		"Default":          DefaultConvertOptions(),
+		"Web 1600px":       {Quality: 80, Effort: 6, MaxWidth: 1600, MaxHeight: 1600, Filter: "CatmullRom", Metadata: MetadataColorOnly},
+		"Archive lossless": {Quality: 100, Effort: 8, Lossless: true, Filter: "CatmullRom", Metadata: MetadataKeep},
+		"Email":            {Quality: 70, Effort: 6, MaxWidth: 1024, MaxHeight: 1024, Filter: "Bilinear", Metadata: MetadataStrip},
+	}
+}
+
+// Actions that can be bound to a key. Moving with Shift held extends the selection.
+const (
+	ShortcutMoveLeft        = "Move Left"
+	ShortcutMoveRight       = "Move Right"
+	ShortcutMoveUp          = "Move Up"
+	ShortcutMoveDown        = "Move Down"
+	ShortcutOpen            = "Open"
+	ShortcutToggleSelect    = "Toggle Selection"
+	ShortcutAddTag          = "Add Tag"
+	ShortcutDelete          = "Move to Trash"
+	ShortcutSearch          = "Search"
+	ShortcutSelectAll       = "Select All"
+	ShortcutClearSelection  = "Clear Selection"
+	ShortcutInvertSelection = "Invert Selection"
+)
+
+var ShortcutActions = []string{
+	ShortcutMoveLeft, ShortcutMoveRight, ShortcutMoveUp, ShortcutMoveDown, ShortcutOpen, ShortcutToggleSelect,
+	ShortcutAddTag, ShortcutDelete, ShortcutSearch, ShortcutSelectAll, ShortcutClearSelection,
+	ShortcutInvertSelection,
+}
+
+func DefaultShortcuts() map[string]string {
+	return map[string]string{
+		ShortcutMoveLeft:        "Left",
+		ShortcutMoveRight:       "Right",
+		ShortcutMoveUp:          "Up",
+		ShortcutMoveDown:        "Down",
+		ShortcutOpen:            "Return",
+		ShortcutToggleSelect:    "Space",
+		ShortcutAddTag:          "T",
+		ShortcutDelete:          "Delete",
+		ShortcutSearch:          "Ctrl+F",
+		ShortcutSelectAll:       "Ctrl+A",
+		ShortcutClearSelection:  "Escape",
+		ShortcutInvertSelection: "Ctrl+I",
+	}
 }
 
 // Checks if the directory is blacklisted
@@ -50,14 +157,19 @@ func (opts Options) InitDefault() *Options {
 			cwd:            1,
 			// filepath.Dir(os.Args[0]): 1,
 		},
-		Profiling:     false,
-		Timezone:      3,
-		SortDesc:      true,
-		UseRGB:        false,
-		ExifFields:    []string{"DateTime"},
-		ImageNumber:   20,
-		ThumbnailSize: 256,
-		FirstBoot:     true,
+		Profiling:      false,
+		Timezone:       3,
+		SortDesc:       true,
+		UseRGB:         false,
+		ExifFields:     []string{"DateTime"},
+		ImageNumber:    20,
+		ThumbnailSize:  256,
+		FirstBoot:      true,
+		ArchiveDir:     "",
+		ArchiveName:    "{date}",
+		ConvertPresets: DefaultConvertPresets(),
+		Shortcuts:      DefaultShortcuts(),
+		DirectoryTags:  DefaultDirectoryTagOptions(),
 	}
 }
 
@@ -84,6 +196,21 @@ func SaveOptionsToDB(db *sql.DB, options *Options) error {
 		return fmt.Errorf("error marshaling ExifFields: %v", err)
 	}
 
+	convertPresetsJSON, err := json.Marshal(options.ConvertPresets)
+	if err != nil {
+		return fmt.Errorf("error marshaling ConvertPresets: %v", err)
+	}
+
+	shortcutsJSON, err := json.Marshal(options.Shortcuts)
+	if err != nil {
+		return fmt.Errorf("error marshaling Shortcuts: %v", err)
+	}
+
+	directoryTagsJSON, err := json.Marshal(options.DirectoryTags)
+	if err != nil {
+		return fmt.Errorf("error marshaling DirectoryTags: %v", err)
+	}
+
 	var numOptionsDb int64
 	err = db.QueryRow("SELECT COUNT(*) FROM Options").Scan(&numOptionsDb)
 	if err != nil {
@@ -97,8 +224,9 @@ func SaveOptionsToDB(db *sql.DB, options *Options) error {
 		query = `
 		INSERT INTO Options (
 			DatabasePath, ExcludedDirs, Profiling, Timezone, SortDesc, 
-			UseRGB, ExifFields, ImageNumber, ThumbnailSize, FirstBoot
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+			UseRGB, ExifFields, ImageNumber, ThumbnailSize, FirstBoot,
+			ArchiveDir, ArchiveName, ConvertPresets, Shortcuts, DirectoryTags
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 	case 1:
 		options.FirstBoot = false
 		query = `
@@ -112,7 +240,12 @@ func SaveOptionsToDB(db *sql.DB, options *Options) error {
 		ExifFields = ?,
 		ImageNumber = ?,
 		ThumbnailSize = ?,
-		FirstBoot = ?
+		FirstBoot = ?,
+		ArchiveDir = ?,
+		ArchiveName = ?,
+		ConvertPresets = ?,
+		Shortcuts = ?,
+		DirectoryTags = ?
 		WHERE id = 1;
 		`
 	default:
@@ -138,6 +271,11 @@ func SaveOptionsToDB(db *sql.DB, options *Options) error {
 		options.ImageNumber,
 		options.ThumbnailSize,
 		options.FirstBoot,
+		options.ArchiveDir,
+		options.ArchiveName,
+		string(convertPresetsJSON),
+		string(shortcutsJSON),
+		string(directoryTagsJSON),
 	)
 	if err != nil {
 		return fmt.Errorf("error executing statement: %v", err)
@@ -151,11 +289,12 @@ func LoadOptionsFromDB(db *sql.DB) (*Options, error) {
 
 	row := db.QueryRow(`
 		SELECT DatabasePath, ExcludedDirs, Profiling, Timezone, SortDesc, 
-			   UseRGB, ExifFields, ImageNumber, ThumbnailSize, FirstBoot
+			   UseRGB, ExifFields, ImageNumber, ThumbnailSize, FirstBoot,
+			   ArchiveDir, ArchiveName, ConvertPresets, Shortcuts, DirectoryTags
 		FROM options WHERE id = 1 LIMIT 1
 	`)
 
-	var excludedDirsJSON, exifFieldsJSON string
+	var excludedDirsJSON, exifFieldsJSON, convertPresetsJSON, shortcutsJSON, directoryTagsJSON string
 
 	err := row.Scan(
 		&options.DatabasePath,
@@ -168,6 +307,11 @@ func LoadOptionsFromDB(db *sql.DB) (*Options, error) {
 		&options.ImageNumber,
 		&options.ThumbnailSize,
 		&options.FirstBoot,
+		&options.ArchiveDir,
+		&options.ArchiveName,
+		&convertPresetsJSON,
+		&shortcutsJSON,
+		&directoryTagsJSON,
 	)
 	options.FirstBoot = false
 	if err != nil {
@@ -188,5 +332,35 @@ func LoadOptionsFromDB(db *sql.DB) (*Options, error) {
 		return nil, fmt.Errorf("error unmarshaling ExifFields: %v", err)
 	}
 
+	err = json.Unmarshal([]byte(convertPresetsJSON), &options.ConvertPresets)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling ConvertPresets: %v", err)
+	}
+	// databases from before presets existed get the built in ones
+	if len(options.ConvertPresets) == 0 {
+		options.ConvertPresets = DefaultConvertPresets()
+	}
+
+	err = json.Unmarshal([]byte(shortcutsJSON), &options.Shortcuts)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling Shortcuts: %v", err)
+	}
+	// actions added after the shortcuts were saved get their default key
+	if options.Shortcuts == nil {
+		options.Shortcuts = map[string]string{}
+	}
+	for action, binding := range DefaultShortcuts() {
+		if _, ok := options.Shortcuts[action]; !ok {
+			options.Shortcuts[action] = binding
+		}
+	}
+
+	// databases from before directory tags were added get the defaults, which leave the mode off
+	options.DirectoryTags = DefaultDirectoryTagOptions()
+	err = json.Unmarshal([]byte(directoryTagsJSON), &options.DirectoryTags)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling DirectoryTags: %v", err)
+	}
+
 	return options, nil
 }
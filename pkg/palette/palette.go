@@ -0,0 +1,198 @@
+package palette
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"main/pkg/colorutils"
+	"math"
+	"slices"
+)
+
+// A dominant color of an image and the share of the image it covers
+type Swatch struct {
+	Color  string
+	Weight float64
+}
+
+const (
+	Size       = 5    // colors kept per image
+	sampleSide = 64   // the image is sampled on a grid of this many points per side
+	iterations = 12   // k-means passes, the centers rarely move after that
+	minWeight  = 0.03 // colors covering less of the image are noise
+	mergeDelta = 8.0  // colors closer than this look the same and are merged
+)
+
+// Finds up to count dominant colors of the image by k-means clustering a grid of samples, the largest first.
+// Transparent pixels are skipped.
+func Extract(img image.Image, count int) []Swatch {
+	samples := sample(img)
+	if len(samples) == 0 || count <= 0 {
+		return nil
+	}
+	centers := initialCenters(samples, min(count, len(samples)))
+
+	assigned := make([]int, len(samples))
+	sizes := make([]int, len(centers))
+	for range iterations {
+		moved := false
+		for i, s := range samples {
+			nearest := nearestCenter(centers, s)
+			if nearest != assigned[i] {
+				assigned[i] = nearest
+				moved = true
+			}
+		}
+
+		sums := make([][3]float64, len(centers))
+		clear(sizes)
+		for i, s := range samples {
+			for c := range 3 {
+				sums[assigned[i]][c] += s[c]
+			}
+			sizes[assigned[i]]++
+		}
+		for i := range centers {
+			if sizes[i] > 0 {
+				centers[i] = [3]float64{sums[i][0] / float64(sizes[i]), sums[i][1] / float64(sizes[i]), sums[i][2] / float64(sizes[i])}
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	var swatches []Swatch
+	for i, center := range centers {
+		if sizes[i] == 0 {
+			continue
+		}
+		hex := fmt.Sprintf("#%02X%02X%02X", uint8(math.Round(center[0])), uint8(math.Round(center[1])), uint8(math.Round(center[2])))
+		swatches = append(swatches, Swatch{Color: hex, Weight: float64(sizes[i]) / float64(len(samples))})
+	}
+	return clean(swatches)
+}
+
+// Reads the colors on an evenly spaced grid, large images are not decoded pixel by pixel
+func sample(img image.Image) [][3]float64 {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+	stepX := max(bounds.Dx()/sampleSide, 1)
+	stepY := max(bounds.Dy()/sampleSide, 1)
+
+	var samples [][3]float64
+	for y := bounds.Min.Y + stepY/2; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X + stepX/2; x < bounds.Max.X; x += stepX {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			samples = append(samples, [3]float64{float64(c.R), float64(c.G), float64(c.B)})
+		}
+	}
+	return samples
+}
+
+// Starts from the sample closest to the average and adds the sample farthest from the centers picked so far, so
+// the same image always gives the same palette
+func initialCenters(samples [][3]float64, count int) [][3]float64 {
+	var mean [3]float64
+	for _, s := range samples {
+		for c := range 3 {
+			mean[c] += s[c] / float64(len(samples))
+		}
+	}
+	centers := [][3]float64{samples[nearestCenter(samples, mean)]}
+
+	distances := make([]float64, len(samples))
+	for i, s := range samples {
+		distances[i] = distance(s, centers[0])
+	}
+	for len(centers) < count {
+		farthest := 0
+		for i := range samples {
+			if distances[i] > distances[farthest] {
+				farthest = i
+			}
+		}
+		if distances[farthest] == 0 {
+			break
+		}
+		centers = append(centers, samples[farthest])
+		for i, s := range samples {
+			distances[i] = min(distances[i], distance(s, samples[farthest]))
+		}
+	}
+	return centers
+}
+
+func nearestCenter(centers [][3]float64, s [3]float64) int {
+	nearest := 0
+	for i := range centers {
+		if distance(s, centers[i]) < distance(s, centers[nearest]) {
+			nearest = i
+		}
+	}
+	return nearest
+}
+
+func distance(a [3]float64, b [3]float64) float64 {
+	return (a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2])
+}
+
+// Merges colors that look the same into the larger one and drops the ones covering too little of the image
+func clean(swatches []Swatch) []Swatch {
+	slices.SortStableFunc(swatches, func(a, b Swatch) int {
+		if a.Weight > b.Weight {
+			return -1
+		}
+		if a.Weight < b.Weight {
+			return 1
+		}
+		return 0
+	})
+
+	var kept []Swatch
+	for _, swatch := range swatches {
+		merged := false
+		for i := range kept {
+			if colorutils.DeltaE(kept[i].Color, swatch.Color) < mergeDelta {
+				kept[i].Weight += swatch.Weight
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			kept = append(kept, swatch)
+		}
+	}
+	return slices.DeleteFunc(kept, func(s Swatch) bool { return s.Weight < minWeight })
+}
+
+// Returns how far the closest color of the palette is from the target, colors covering a small part of the image
+// count as further away. ok is false when no color is within maxDistance.
+func Match(swatches []Swatch, target string, maxDistance float64) (float64, bool) {
+	best, ok := 0.0, false
+	for _, swatch := range swatches {
+		// a color covering a tenth of the image is moved a little, one covering a third barely at all
+		d := colorutils.DeltaE(swatch.Color, target) + mergeDelta*(1-math.Min(swatch.Weight*3, 1))
+		if d <= maxDistance && (!ok || d < best) {
+			best, ok = d, true
+		}
+	}
+	return best, ok
+}
+
+// Picks a color for a tag from the palette of an image, the largest color that is neither gray nor too dark or
+// light to read on. Palettes without one give an empty string.
+func TagColor(swatches []Swatch) string {
+	for _, swatch := range swatches {
+		_, s, v := colorutils.HexToHSV(swatch.Color)
+		if s >= 0.3 && v >= 0.35 && v <= 0.95 {
+			return swatch.Color
+		}
+	}
+	return ""
+}
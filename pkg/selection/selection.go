@@ -0,0 +1,119 @@
+package selection
+
+import (
+	"sort"
+	"sync"
+)
+
+// Set of selected file paths that is safe to use from the goroutines loading the grid. Listeners are told the
+// new count after every change, outside of the lock so they can read the selection again.
+type Selection struct {
+	mu        sync.RWMutex
+	paths     map[string]bool
+	listeners []func(count int)
+}
+
+func New() *Selection {
+	return &Selection{paths: map[string]bool{}}
+}
+
+// Calls listener after every change with the number of selected files
+func (s *Selection) OnChanged(listener func(count int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *Selection) Contains(path string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.paths[path]
+}
+
+func (s *Selection) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.paths)
+}
+
+// Returns the selected paths sorted by name
+func (s *Selection) Paths() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	paths := make([]string, 0, len(s.paths))
+	for path := range s.paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *Selection) Set(path string, selected bool) {
+	s.SetAll([]string{path}, selected)
+}
+
+// Flips the selection of the path and returns whether it is now selected
+func (s *Selection) Toggle(path string) bool {
+	s.mu.Lock()
+	selected := !s.paths[path]
+	s.set(path, selected)
+	s.mu.Unlock()
+	s.changed()
+	return selected
+}
+
+// Selects or deselects all the paths at once, listeners are only told once
+func (s *Selection) SetAll(paths []string, selected bool) {
+	s.mu.Lock()
+	changed := false
+	for _, path := range paths {
+		if s.paths[path] != selected {
+			s.set(path, selected)
+			changed = true
+		}
+	}
+	s.mu.Unlock()
+	if changed {
+		s.changed()
+	}
+}
+
+// Selects the paths that are not selected and deselects the others, paths outside of the list keep their state
+func (s *Selection) Invert(paths []string) {
+	s.mu.Lock()
+	for _, path := range paths {
+		s.set(path, !s.paths[path])
+	}
+	s.mu.Unlock()
+	if len(paths) > 0 {
+		s.changed()
+	}
+}
+
+func (s *Selection) Clear() {
+	s.mu.Lock()
+	empty := len(s.paths) == 0
+	s.paths = map[string]bool{}
+	s.mu.Unlock()
+	if !empty {
+		s.changed()
+	}
+}
+
+func (s *Selection) set(path string, selected bool) {
+	if selected {
+		s.paths[path] = true
+	} else {
+		delete(s.paths, path)
+	}
+}
+
+func (s *Selection) changed() {
+	s.mu.RLock()
+	count := len(s.paths)
+	listeners := append([]func(int){}, s.listeners...)
+	s.mu.RUnlock()
+	for _, listener := range listeners {
+		listener(count)
+	}
+}
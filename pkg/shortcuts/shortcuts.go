@@ -0,0 +1,182 @@
+package shortcuts
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+)
+
+// Keys with a name, every other key is a single character like "T" or "/"
+var namedKeys = map[fyne.KeyName]bool{
+	fyne.KeyEscape: true, fyne.KeyReturn: true, fyne.KeyEnter: true, fyne.KeyTab: true, fyne.KeyBackspace: true,
+	fyne.KeyInsert: true, fyne.KeyDelete: true, fyne.KeyRight: true, fyne.KeyLeft: true, fyne.KeyDown: true,
+	fyne.KeyUp: true, fyne.KeyPageUp: true, fyne.KeyPageDown: true, fyne.KeyHome: true, fyne.KeyEnd: true,
+	fyne.KeySpace: true, fyne.KeyF1: true, fyne.KeyF2: true, fyne.KeyF3: true, fyne.KeyF4: true, fyne.KeyF5: true,
+	fyne.KeyF6: true, fyne.KeyF7: true, fyne.KeyF8: true, fyne.KeyF9: true, fyne.KeyF10: true, fyne.KeyF11: true,
+	fyne.KeyF12: true,
+}
+
+var modifierNames = []struct {
+	name     string
+	modifier fyne.KeyModifier
+}{
+	{"Ctrl", fyne.KeyModifierControl},
+	{"Alt", fyne.KeyModifierAlt},
+	{"Shift", fyne.KeyModifierShift},
+	{"Super", fyne.KeyModifierSuper},
+}
+
+// Parses a binding like "Ctrl+Shift+F" into its key and modifiers, keys use the fyne key names
+func Parse(binding string) (fyne.KeyName, fyne.KeyModifier, error) {
+	binding = strings.TrimSpace(binding)
+	if binding == "" {
+		return "", 0, fmt.Errorf("no key given")
+	}
+
+	parts := strings.Split(binding, "+")
+	// the plus key itself leaves an empty last part, like in "Ctrl++"
+	if strings.HasSuffix(binding, "+") {
+		parts = append(parts[:len(parts)-2], "+")
+	}
+
+	var modifier fyne.KeyModifier
+	for _, part := range parts[:len(parts)-1] {
+		found := false
+		for _, name := range modifierNames {
+			if strings.EqualFold(strings.TrimSpace(part), name.name) {
+				modifier |= name.modifier
+				found = true
+			}
+		}
+		if !found {
+			return "", 0, fmt.Errorf("unknown modifier %q in %q", part, binding)
+		}
+	}
+
+	key := strings.TrimSpace(parts[len(parts)-1])
+	for name := range namedKeys {
+		if strings.EqualFold(key, string(name)) {
+			return name, modifier, nil
+		}
+	}
+	if len([]rune(key)) == 1 {
+		return fyne.KeyName(strings.ToUpper(key)), modifier, nil
+	}
+	return "", 0, fmt.Errorf("unknown key %q in %q", key, binding)
+}
+
+// Formats a key and modifiers the way Parse reads them
+func Format(key fyne.KeyName, modifier fyne.KeyModifier) string {
+	var parts []string
+	for _, name := range modifierNames {
+		if modifier&name.modifier != 0 {
+			parts = append(parts, name.name)
+		}
+	}
+	return strings.Join(append(parts, string(key)), "+")
+}
+
+// Key bindings of a window. Keys without modifiers only fire while no widget has the focus, so typing in an
+// entry never triggers them.
+type Bindings struct {
+	canvas    fyne.Canvas
+	mu        sync.Mutex
+	keys      map[typedKey]func()
+	shortcuts []fyne.Shortcut
+	shift     bool
+}
+
+// fyne never reports Shift alone as a shortcut, so keys held with Shift are told apart here
+type typedKey struct {
+	name  fyne.KeyName
+	shift bool
+}
+
+func Bind(canvas fyne.Canvas) *Bindings {
+	b := &Bindings{canvas: canvas, keys: map[typedKey]func(){}}
+	if keyCanvas, ok := canvas.(desktop.Canvas); ok {
+		keyCanvas.SetOnKeyDown(func(event *fyne.KeyEvent) { b.setShift(event.Name, true) })
+		keyCanvas.SetOnKeyUp(func(event *fyne.KeyEvent) { b.setShift(event.Name, false) })
+	}
+	canvas.SetOnTypedKey(func(event *fyne.KeyEvent) {
+		b.mu.Lock()
+		action := b.keys[typedKey{name: event.Name, shift: b.shift}]
+		b.mu.Unlock()
+		if action != nil {
+			action()
+		}
+	})
+	return b
+}
+
+func (b *Bindings) setShift(key fyne.KeyName, down bool) {
+	if key == desktop.KeyShiftLeft || key == desktop.KeyShiftRight {
+		b.mu.Lock()
+		b.shift = down
+		b.mu.Unlock()
+	}
+}
+
+// Runs action when the keys of the binding are pressed
+func (b *Bindings) Add(binding string, action func()) error {
+	key, modifier, err := Parse(binding)
+	if err != nil {
+		return err
+	}
+	b.AddKey(key, modifier, action)
+	return nil
+}
+
+func (b *Bindings) AddKey(key fyne.KeyName, modifier fyne.KeyModifier, action func()) {
+	if modifier == 0 || modifier == fyne.KeyModifierShift {
+		b.mu.Lock()
+		b.keys[typedKey{name: key, shift: modifier == fyne.KeyModifierShift}] = action
+		b.mu.Unlock()
+		return
+	}
+
+	shortcut := standardShortcut(key, modifier)
+	if shortcut == nil {
+		shortcut = &desktop.CustomShortcut{KeyName: key, Modifier: modifier}
+	}
+	b.canvas.AddShortcut(shortcut, func(fyne.Shortcut) { action() })
+	b.mu.Lock()
+	b.shortcuts = append(b.shortcuts, shortcut)
+	b.mu.Unlock()
+}
+
+// fyne reports the clipboard, undo and select all keys as its own shortcuts instead of custom ones
+func standardShortcut(key fyne.KeyName, modifier fyne.KeyModifier) fyne.Shortcut {
+	if modifier != fyne.KeyModifierShortcutDefault {
+		return nil
+	}
+	switch key {
+	case fyne.KeyA:
+		return &fyne.ShortcutSelectAll{}
+	case fyne.KeyC:
+		return &fyne.ShortcutCopy{}
+	case fyne.KeyV:
+		return &fyne.ShortcutPaste{}
+	case fyne.KeyX:
+		return &fyne.ShortcutCut{}
+	case fyne.KeyZ:
+		return &fyne.ShortcutUndo{}
+	case fyne.KeyY:
+		return &fyne.ShortcutRedo{}
+	}
+	return nil
+}
+
+// Removes every binding, used before binding the keys again after they were changed
+func (b *Bindings) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keys = map[typedKey]func(){}
+	for _, shortcut := range b.shortcuts {
+		b.canvas.RemoveShortcut(shortcut)
+	}
+	b.shortcuts = nil
+}
@@ -0,0 +1,83 @@
+package tagpath
+
+import (
+	"slices"
+	"strings"
+)
+
+// Separates the levels of a nested tag like Places/Latvia/Riga
+const Separator = "/"
+
+// Trims the spaces around every level and drops empty levels, " Places//Latvia " becomes "Places/Latvia"
+func Clean(path string) string {
+	var parts []string
+	for _, part := range strings.Split(path, Separator) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, Separator)
+}
+
+// Returns the path of the parent tag, top level tags have none
+func Parent(path string) string {
+	if i := strings.LastIndex(path, Separator); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// Returns the last level of the path, the name shown in the tag tree
+func Leaf(path string) string {
+	return path[strings.LastIndex(path, Separator)+1:]
+}
+
+// Returns how deeply the tag is nested, top level tags are 0
+func Depth(path string) int {
+	return strings.Count(path, Separator)
+}
+
+// Returns the paths of every ancestor, starting with the top level one
+func Ancestors(path string) []string {
+	var ancestors []string
+	for parent := Parent(path); parent != ""; parent = Parent(parent) {
+		ancestors = append(ancestors, parent)
+	}
+	slices.Reverse(ancestors)
+	return ancestors
+}
+
+// Reports whether path is nested somewhere under ancestor
+func IsDescendant(path string, ancestor string) bool {
+	return strings.HasPrefix(path, ancestor+Separator)
+}
+
+// Orders paths like a tree, every tag comes right after its parent and before the parent's next sibling
+func Compare(a string, b string) int {
+	return slices.Compare(strings.Split(a, Separator), strings.Split(b, Separator))
+}
+
+// Splits a tag like artist:someone into its namespace and value, tags without one have an empty namespace.
+// Only a colon before the first separator starts a namespace so Places/12:30 has none.
+func SplitNamespace(name string) (string, string) {
+	i := strings.Index(name, ":")
+	if i <= 0 || i == len(name)-1 || strings.Contains(name[:i], Separator) {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+// Orders tags by namespace and then like a tree, tags without a namespace come first
+func CompareByNamespace(a string, b string) int {
+	namespaceA, _ := SplitNamespace(a)
+	namespaceB, _ := SplitNamespace(b)
+	if c := strings.Compare(namespaceA, namespaceB); c != 0 {
+		return c
+	}
+	return Compare(a, b)
+}
+
+// Formats the path for showing it to the user
+func Display(path string) string {
+	return strings.ReplaceAll(path, Separator, " › ")
+}
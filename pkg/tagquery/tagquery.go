@@ -0,0 +1,67 @@
+package tagquery
+
+import (
+	"strings"
+	"unicode"
+)
+
+// One word of a search, files must have a tag matching Pattern or, with Exclude, must not have one
+type Term struct {
+	Pattern string // LIKE pattern with \ as the escape character
+	Exclude bool
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Parses a search like `artist:* -project:old "Places/New York"`. Words are separated by spaces unless quoted and
+// a leading - excludes the tag. Quoted words and words with a * or a namespace match whole tag names, * standing
+// for anything, other words match any tag containing them.
+func Parse(query string) []Term {
+	var terms []Term
+	for _, word := range split(query) {
+		term := Term{}
+		if len(word) > 1 && strings.HasPrefix(word, "-") {
+			term.Exclude = true
+			word = word[1:]
+		}
+		quoted := strings.HasPrefix(word, `"`)
+		word = strings.Trim(word, `"`)
+		if word == "" {
+			continue
+		}
+
+		pattern := likeEscaper.Replace(word)
+		if quoted || strings.Contains(word, "*") || strings.Contains(word, ":") {
+			term.Pattern = strings.ReplaceAll(pattern, "*", "%")
+		} else {
+			term.Pattern = "%" + pattern + "%"
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// Splits at spaces outside of double quotes, the quotes are kept
+func split(query string) []string {
+	var words []string
+	var word strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			word.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
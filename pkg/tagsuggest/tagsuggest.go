@@ -0,0 +1,202 @@
+package tagsuggest
+
+import (
+	"main/pkg/tagpath"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A tag that can be suggested, with how often and how recently it was used
+type Candidate struct {
+	Id       int
+	Name     string
+	Uses     int
+	LastUsed time.Time // zero when the tag was never added to a file
+}
+
+const (
+	matchScore       = 1
+	consecutiveBonus = 5
+	wordStartBonus   = 8
+	exactBonus       = 100
+	gapPenalty       = 1
+	maxGapPenalty    = 10
+	usageWeight      = 4
+	recencyWeight    = 12
+	recencyHalfLife  = 7 * 24 * time.Hour
+)
+
+// Scores how well the query matches the name, the letters of the query have to appear in the name in the same
+// order. Letters at the start of words and runs of letters score higher, so "pl/ri" matches Places/Latvia/Riga.
+func Score(query string, name string) (int, bool) {
+	q := []rune(strings.ToLower(strings.TrimSpace(query)))
+	n := []rune(strings.ToLower(name))
+	if len(q) == 0 {
+		return 0, true
+	}
+
+	// every place the first letter appears is tried as the start, the best one wins
+	best, found := 0, false
+	for start := range n {
+		if n[start] != q[0] {
+			continue
+		}
+		if score, ok := scoreFrom(q, n, start); ok && (!found || score > best) {
+			best, found = score, true
+		}
+	}
+	if !found {
+		return 0, false
+	}
+
+	_, value := tagpath.SplitNamespace(strings.ToLower(name))
+	if string(q) == strings.ToLower(name) || string(q) == value || string(q) == strings.ToLower(tagpath.Leaf(value)) {
+		best += exactBonus
+	}
+	return best, true
+}
+
+// Matches the query greedily from the start position
+func scoreFrom(q []rune, n []rune, start int) (int, bool) {
+	score := 0
+	last := -1
+	qi := 0
+	for ni := start; ni < len(n) && qi < len(q); ni++ {
+		if n[ni] != q[qi] {
+			continue
+		}
+		score += matchScore
+		if last >= 0 && ni == last+1 {
+			score += consecutiveBonus
+		} else if last >= 0 {
+			score -= min(ni-last-1, maxGapPenalty) * gapPenalty
+		}
+		if ni == 0 || isWordSeparator(n[ni-1]) {
+			score += wordStartBonus
+		}
+		last = ni
+		qi++
+	}
+	return score, qi == len(q)
+}
+
+func isWordSeparator(r rune) bool {
+	return r == '/' || r == ':' || r == '_' || r == '-' || r == '.' || unicode.IsSpace(r)
+}
+
+// Adds to the score of tags used on many files and tags used lately, recency halves every week
+func boost(c Candidate, now time.Time) float64 {
+	boost := usageWeight * math.Log2(1+float64(c.Uses))
+	if !c.LastUsed.IsZero() {
+		age := max(now.Sub(c.LastUsed), 0)
+		boost += recencyWeight * math.Pow(0.5, float64(age)/float64(recencyHalfLife))
+	}
+	return boost
+}
+
+// Returns up to limit candidates matching the query, best first. An empty query lists the most used and most
+// recently used tags.
+func Rank(query string, candidates []Candidate, now time.Time, limit int) []Candidate {
+	type ranked struct {
+		candidate Candidate
+		score     float64
+	}
+	var matches []ranked
+	for _, c := range candidates {
+		score, ok := Score(query, c.Name)
+		if !ok {
+			continue
+		}
+		matches = append(matches, ranked{c, float64(score) + boost(c, now)})
+	}
+	slices.SortStableFunc(matches, func(a, b ranked) int {
+		if a.score != b.score {
+			return cmpDesc(a.score, b.score)
+		}
+		return tagpath.Compare(a.candidate.Name, b.candidate.Name)
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	result := make([]Candidate, len(matches))
+	for i, match := range matches {
+		result[i] = match.candidate
+	}
+	return result
+}
+
+// How many files two tags are on together
+type Pair struct {
+	TagId   int
+	OtherId int
+	Files   int
+}
+
+// A tag found along with the tags of a file. Confidence is the share of the files with the tag that also have
+// this one, lift is how much more often that happens than it would by chance.
+type Related struct {
+	Id         int
+	Confidence float64
+	Lift       float64
+}
+
+// Pairs seen on fewer files are left out, one file is not a pattern
+const minSupport = 2
+
+// Ranks the tags paired with the present tags by confidence. Only tags found together more often than chance,
+// with a lift above 1, are kept so tags on nearly every file like JPG are not suggested. tagFiles has the
+// number of files of every tag and totalFiles the size of the library.
+func RankRelated(pairs []Pair, tagFiles map[int]int, totalFiles int, present []int, limit int) []Related {
+	isPresent := map[int]bool{}
+	for _, id := range present {
+		isPresent[id] = true
+	}
+
+	best := map[int]Related{}
+	for _, pair := range pairs {
+		if !isPresent[pair.TagId] || isPresent[pair.OtherId] || pair.Files < minSupport {
+			continue
+		}
+		if tagFiles[pair.TagId] == 0 || tagFiles[pair.OtherId] == 0 || totalFiles == 0 {
+			continue
+		}
+		confidence := float64(pair.Files) / float64(tagFiles[pair.TagId])
+		lift := confidence / (float64(tagFiles[pair.OtherId]) / float64(totalFiles))
+		if lift <= 1 {
+			continue
+		}
+		if current, ok := best[pair.OtherId]; !ok || confidence > current.Confidence ||
+			(confidence == current.Confidence && lift > current.Lift) {
+			best[pair.OtherId] = Related{Id: pair.OtherId, Confidence: confidence, Lift: lift}
+		}
+	}
+
+	related := make([]Related, 0, len(best))
+	for _, r := range best {
+		related = append(related, r)
+	}
+	slices.SortFunc(related, func(a, b Related) int {
+		switch {
+		case a.Confidence != b.Confidence:
+			return cmpDesc(a.Confidence, b.Confidence)
+		case a.Lift != b.Lift:
+			return cmpDesc(a.Lift, b.Lift)
+		}
+		return a.Id - b.Id
+	})
+	if limit > 0 && len(related) > limit {
+		related = related[:limit]
+	}
+	return related
+}
+
+func cmpDesc(a float64, b float64) int {
+	if a > b {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,337 @@
+package tagwindow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"main/pkg/autotag"
+	"main/pkg/database"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Lists the auto-tagging rules. Enabled rules tag new files at discovery, the rules can also be previewed and run
+// on the whole library. onChanged runs after tags of files changed.
+func ShowAutoTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, onChanged func()) {
+	autoTagWindow := a.NewWindow("Auto-Tagging Rules")
+	list := container.NewVBox(widget.NewLabel("Loading rules..."))
+	var rules []autotag.Rule
+
+	showError := func(err error) {
+		dialog.ShowError(fmt.Errorf("showAutoTagWindow: %w", err), autoTagWindow)
+	}
+
+	var load func()
+	load = func() {
+		loaded, err := database.GetTagRules(db)
+		if err != nil {
+			showError(err)
+			return
+		}
+		rules = loaded
+
+		list.RemoveAll()
+		if len(rules) == 0 {
+			list.Add(widget.NewLabel("No rules yet. Rules add and remove tags on files matching their conditions."))
+		}
+		for _, rule := range rules {
+			enabled := widget.NewCheck("", func(on bool) {
+				if err := database.SetTagRuleEnabled(db, rule.Id, on); err != nil {
+					showError(err)
+				}
+			})
+			enabled.Checked = rule.Enabled
+
+			name := widget.NewLabel(rule.Name + "\n" + ruleSummary(rule))
+			name.Truncation = fyne.TextTruncateEllipsis
+			editButton := widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+				showRuleEditor(a, autoTagWindow, db, rule, func() { go load() })
+			})
+			deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				dialog.ShowConfirm("Delete Rule", fmt.Sprintf("Delete the rule %s? Tags it added stay on the files.", rule.Name), func(ok bool) {
+					if !ok {
+						return
+					}
+					if err := database.DeleteTagRule(db, rule.Id); err != nil {
+						showError(err)
+						return
+					}
+					go load()
+				}, autoTagWindow)
+			})
+			list.Add(container.NewBorder(nil, nil, enabled, container.NewHBox(editButton, deleteButton), name))
+		}
+		list.Refresh()
+	}
+
+	enabledRules := func() []autotag.Rule {
+		var enabled []autotag.Rule
+		for _, rule := range rules {
+			if rule.Enabled {
+				enabled = append(enabled, rule)
+			}
+		}
+		return enabled
+	}
+
+	newButton := widget.NewButtonWithIcon("New Rule", theme.ContentAddIcon(), func() {
+		showRuleEditor(a, autoTagWindow, db, autotag.Rule{Enabled: true}, func() { go load() })
+	})
+	previewButton := widget.NewButtonWithIcon("Preview", theme.SearchIcon(), func() {
+		// the checks may have changed since the list was loaded
+		load()
+		runTagRules(autoTagWindow, db, enabledRules(), true, nil)
+	})
+	runButton := widget.NewButtonWithIcon("Run on Library", theme.MediaPlayIcon(), func() {
+		load()
+		enabled := enabledRules()
+		if len(enabled) == 0 {
+			dialog.ShowInformation("Auto-Tagging", "Enable a rule first", autoTagWindow)
+			return
+		}
+		message := fmt.Sprintf("Run %d enabled rules on every file in the library?", len(enabled))
+		dialog.ShowConfirm("Run Rules", message, func(ok bool) {
+			if ok {
+				runTagRules(autoTagWindow, db, enabled, false, onChanged)
+			}
+		}, autoTagWindow)
+	})
+
+	autoTagWindow.SetContent(container.NewBorder(nil,
+		container.NewHBox(newButton, layout.NewSpacer(), previewButton, runButton, widget.NewButton("Close", autoTagWindow.Close)),
+		nil, nil, container.NewVScroll(list)))
+	autoTagWindow.Resize(fyne.NewSize(520, 420))
+	autoTagWindow.Show()
+
+	go load()
+}
+
+// Short description of what a rule does, like "2 conditions, adds beach, removes todo"
+func ruleSummary(rule autotag.Rule) string {
+	parts := []string{fmt.Sprintf("%d conditions", len(rule.Conditions))}
+	if len(rule.Conditions) == 1 {
+		parts[0] = "1 condition"
+	}
+	if len(rule.Add) > 0 {
+		parts = append(parts, "adds "+strings.Join(rule.Add, ", "))
+	}
+	if len(rule.Remove) > 0 {
+		parts = append(parts, "removes "+strings.Join(rule.Remove, ", "))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Runs the rules on the whole library showing progress, a dry run only reports what would change
+func runTagRules(w fyne.Window, db *sql.DB, rules []autotag.Rule, dryRun bool, onChanged func()) {
+	if len(rules) == 0 {
+		dialog.ShowInformation("Auto-Tagging", "There are no enabled rules to run", w)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	progressBar := widget.NewProgressBar()
+	title := "Running Rules"
+	if dryRun {
+		title = "Previewing Rules"
+	}
+	progress := dialog.NewCustom(title, "Cancel", progressBar, w)
+	progress.SetOnClosed(cancel)
+	progress.Resize(fyne.NewSize(400, 150))
+	progress.Show()
+
+	go func() {
+		result, err := database.RunTagRules(ctx, db, rules, nil, dryRun, func(done int, total int) {
+			progressBar.Max = float64(total)
+			progressBar.SetValue(float64(done))
+		})
+		cancelled := ctx.Err() != nil
+		progress.Hide()
+		cancel()
+		if cancelled {
+			// the batches done before cancelling stay tagged
+			if !dryRun && onChanged != nil {
+				onChanged()
+			}
+			return
+		}
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("runTagRules: %w", err), w)
+			return
+		}
+
+		message := fmt.Sprintf("Matched %d files, added %d tags and removed %d", result.Files, result.Added, result.Removed)
+		if dryRun {
+			message = fmt.Sprintf("The rules match %d files, they would add %d tags and remove %d", result.Files, result.Added, result.Removed)
+		}
+		dialog.ShowInformation(title, message, w)
+		if !dryRun && onChanged != nil {
+			onChanged()
+		}
+	}()
+}
+
+// Edits a rule, a rule without an id is added when saved. onSaved runs after the rule was stored.
+func showRuleEditor(a fyne.App, parent fyne.Window, db *sql.DB, rule autotag.Rule, onSaved func()) {
+	editorWindow := a.NewWindow("Auto-Tagging Rule")
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("Name, like Holiday photos")
+	nameEntry.SetText(rule.Name)
+	enabledCheck := widget.NewCheck("Run at discovery", nil)
+	enabledCheck.SetChecked(rule.Enabled)
+
+	conditionList := container.NewVBox()
+	var rows []func() autotag.Condition
+	var addCondition func(condition autotag.Condition)
+	addCondition = func(condition autotag.Condition) {
+		valueEntry := widget.NewEntry()
+		valueEntry.SetText(condition.Value)
+		orientationSelect := widget.NewSelect(autotag.Orientations, nil)
+		orientationSelect.SetSelected(condition.Value)
+		minEntry := widget.NewEntry()
+		minEntry.SetText(condition.Min)
+		maxEntry := widget.NewEntry()
+		maxEntry.SetText(condition.Max)
+		rangeInputs := container.NewGridWithColumns(2, minEntry, maxEntry)
+
+		// only the inputs the field uses are shown
+		fieldSelect := widget.NewSelect(autotag.Fields, func(field string) {
+			valueEntry.Hide()
+			orientationSelect.Hide()
+			rangeInputs.Hide()
+			switch field {
+			case autotag.FieldPath:
+				valueEntry.SetPlaceHolder("**/Holidays/**")
+				valueEntry.Show()
+			case autotag.FieldPathRegex:
+				valueEntry.SetPlaceHolder(`(?i)/20\d\d/`)
+				valueEntry.Show()
+			case autotag.FieldFilename:
+				valueEntry.SetPlaceHolder("IMG_*.jpg")
+				valueEntry.Show()
+			case autotag.FieldCamera, autotag.FieldLens:
+				valueEntry.SetPlaceHolder("Text like Canon")
+				valueEntry.Show()
+			case autotag.FieldOrientation:
+				orientationSelect.Show()
+			case autotag.FieldDate:
+				minEntry.SetPlaceHolder("From 2023-01-31")
+				maxEntry.SetPlaceHolder("To 2023-12-31")
+				rangeInputs.Show()
+			case autotag.FieldSize:
+				minEntry.SetPlaceHolder("At least 500KB")
+				maxEntry.SetPlaceHolder("At most 20MB")
+				rangeInputs.Show()
+			default:
+				minEntry.SetPlaceHolder("At least, in pixels")
+				maxEntry.SetPlaceHolder("At most, in pixels")
+				rangeInputs.Show()
+			}
+		})
+		if condition.Field == "" {
+			condition.Field = autotag.FieldPath
+		}
+		fieldSelect.SetSelected(condition.Field)
+
+		removed := false
+		var row *fyne.Container
+		removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+			removed = true
+			conditionList.Remove(row)
+		})
+		row = container.NewBorder(nil, nil, fieldSelect, removeButton, container.NewStack(valueEntry, orientationSelect, rangeInputs))
+		conditionList.Add(row)
+
+		rows = append(rows, func() autotag.Condition {
+			if removed {
+				return autotag.Condition{}
+			}
+			read := autotag.Condition{Field: fieldSelect.Selected}
+			switch read.Field {
+			case autotag.FieldOrientation:
+				read.Value = orientationSelect.Selected
+			case autotag.FieldPath, autotag.FieldPathRegex, autotag.FieldFilename, autotag.FieldCamera, autotag.FieldLens:
+				read.Value = valueEntry.Text
+			default:
+				read.Min, read.Max = minEntry.Text, maxEntry.Text
+			}
+			return read
+		})
+	}
+	for _, condition := range rule.Conditions {
+		addCondition(condition)
+	}
+	if len(rule.Conditions) == 0 {
+		addCondition(autotag.Condition{})
+	}
+
+	addEntry := widget.NewEntry()
+	addEntry.SetPlaceHolder("Tags to add, separated by commas")
+	addEntry.SetText(strings.Join(rule.Add, ", "))
+	removeEntry := widget.NewEntry()
+	removeEntry.SetPlaceHolder("Tags to remove, separated by commas")
+	removeEntry.SetText(strings.Join(rule.Remove, ", "))
+
+	// the rule as it is in the editor
+	read := func() autotag.Rule {
+		edited := autotag.Rule{Id: rule.Id, Name: strings.TrimSpace(nameEntry.Text), Enabled: enabledCheck.Checked}
+		for _, row := range rows {
+			if condition := row(); condition.Field != "" {
+				edited.Conditions = append(edited.Conditions, condition)
+			}
+		}
+		edited.Add = splitTagNames(addEntry.Text)
+		edited.Remove = splitTagNames(removeEntry.Text)
+		return edited
+	}
+
+	previewButton := widget.NewButtonWithIcon("Preview", theme.SearchIcon(), func() {
+		edited := read()
+		if err := edited.Validate(); err != nil {
+			dialog.ShowInformation("Error", err.Error(), editorWindow)
+			return
+		}
+		runTagRules(editorWindow, db, []autotag.Rule{edited}, true, nil)
+	})
+	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
+		if _, err := database.SaveTagRule(db, read()); err != nil {
+			dialog.ShowInformation("Error", err.Error(), editorWindow)
+			return
+		}
+		if onSaved != nil {
+			onSaved()
+		}
+		editorWindow.Close()
+	})
+	saveButton.Importance = widget.HighImportance
+
+	form := container.NewVBox(
+		widget.NewLabel("Name"), nameEntry, enabledCheck,
+		widget.NewLabel("Files matching all of these"), conditionList,
+		widget.NewButtonWithIcon("Add Condition", theme.ContentAddIcon(), func() { addCondition(autotag.Condition{}) }),
+		widget.NewLabel("Get these tags"), addEntry,
+		widget.NewLabel("Lose these tags"), removeEntry,
+	)
+	editorWindow.SetContent(container.NewBorder(nil,
+		container.NewHBox(layout.NewSpacer(), previewButton, widget.NewButton("Cancel", editorWindow.Close), saveButton),
+		nil, nil, container.NewVScroll(form)))
+	editorWindow.Resize(fyne.NewSize(520, 520))
+	editorWindow.Show()
+}
+
+// Splits a comma separated list of tag names, empty names are dropped
+func splitTagNames(text string) []string {
+	var names []string
+	for _, name := range strings.Split(text, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -2,21 +2,33 @@ package tagwindow
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"image/color"
 	"log"
 	"main/pkg/colorutils"
 	"main/pkg/database"
+	"main/pkg/fynecomponents/completion"
+	"main/pkg/fynecomponents/tagchip"
 	"main/pkg/options"
+	"main/pkg/palette"
+	"main/pkg/tagpath"
+	"main/pkg/tagsuggest"
+	"slices"
+	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
-func ShowCreateTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *options.Options, edit bool, tag string, tagId int) {
+// Creates a tag, with edit the tag is renamed and recolored instead. onSaved runs after the tag was saved.
+func ShowCreateTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *options.Options, edit bool, tag string, tagId int, onSaved func()) {
 
 	tagWindow := a.NewWindow("Create Tag")
 
@@ -46,7 +58,7 @@ func ShowCreateTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *optio
 	if edit {
 		stringInput.SetText(tag)
 	}
-	stringInput.SetPlaceHolder("Enter Tag name")
+	stringInput.SetPlaceHolder("Enter Tag name, like Places/Latvia/Riga")
 
 	var content *fyne.Container
 	var updateColor func()
@@ -119,9 +131,10 @@ func ShowCreateTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *optio
 
 		hexColor := getHexColor()
 
-		_, err := db.Exec("INSERT INTO Tag (name, color) VALUES (?, ?)", tagName, hexColor)
+		// a path like Places/Latvia/Riga creates the parents that do not exist yet
+		_, err := database.CreateTag(db, tagName, hexColor)
 		if err != nil {
-			if err.Error() == "UNIQUE constraint failed: Tag.name" {
+			if err == database.ErrTagExists {
 				dialog.ShowInformation("Error", "Tag name already exists", tagWindow)
 				return
 			}
@@ -129,7 +142,10 @@ func ShowCreateTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *optio
 			return
 		}
 
-		dialog.ShowInformation("Tag Created", fmt.Sprintf("Tag Name: %s\nColor: %s", tagName, hexColor), tagWindow)
+		dialog.ShowInformation("Tag Created", fmt.Sprintf("Tag Name: %s\nColor: %s", tagName, hexColor), parent)
+		if onSaved != nil {
+			onSaved()
+		}
 		tagWindow.Close()
 	})
 
@@ -142,17 +158,24 @@ func ShowCreateTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *optio
 
 		hexColor := getHexColor()
 
-		_, err := db.Exec("INSERT INTO Tag (name, color) VALUES (?, ?)", tagName, hexColor)
+		err := database.UpdateTag(db, tagId, tagName, hexColor)
+		if err == database.ErrTagExists {
+			dialog.ShowInformation("Error", "Tag name already exists", tagWindow)
+			return
+		}
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("showCreateTagWindow: %w", err), tagWindow)
 			return
 		}
 
-		dialog.ShowInformation("Tag Created", fmt.Sprintf("Tag Name: %s\nColor: %s", tagName, hexColor), tagWindow)
+		dialog.ShowInformation("Tag Saved", fmt.Sprintf("Tag Name: %s\nColor: %s", tagName, hexColor), parent)
+		if onSaved != nil {
+			onSaved()
+		}
 		tagWindow.Close()
 	})
 
-	content.Add(widget.NewLabel("Enter tag name:"))
+	content.Add(widget.NewLabel("Enter tag name, use / to nest it:"))
 	content.Add(stringInput)
 	if edit {
 		content.Add(updateButton)
@@ -171,12 +194,12 @@ func ShowTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, imgId int, tagLis
 	tagWindow := a.NewWindow("Tags")
 	tagWindow.SetTitle("Add a Tag")
 
-	content := container.NewGridWithColumns(4)
+	content := container.NewVBox()
 	loadingLabel := widget.NewLabel("Loading tags...")
 	content.Add(loadingLabel)
 
 	tagWindow.SetContent(container.NewVScroll(content))
-	tagWindow.Resize(fyne.NewSize(300, 200))
+	tagWindow.Resize(fyne.NewSize(300, 400))
 	tagWindow.Show()
 
 	go func() {
@@ -189,41 +212,56 @@ func ShowTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, imgId int, tagLis
 		}
 		defer tags.Close()
 
-		var buttons []*fyne.Container
+		var available []database.TagCount
 		for tags.Next() {
-			var id int
-			var name string
-			var color string
-			if err := tags.Scan(&id, &name, &color); err != nil {
+			var tag database.TagCount
+			if err := tags.Scan(&tag.Id, &tag.Name, &tag.Color); err != nil {
 				parent.Canvas().Refresh(parent.Content())
 				fmt.Print("showTagWindow")
 				dialog.ShowError(err, parent)
 				return
 			}
+			available = append(available, tag)
+		}
+		// grouped by namespace, every group is a tag tree
+		slices.SortFunc(available, func(a, b database.TagCount) int { return tagpath.CompareByNamespace(a.Name, b.Name) })
+		listed := map[string]bool{}
+		for _, tag := range available {
+			listed[tag.Name] = true
+		}
 
-			button := widget.NewButton(name, nil)
-			button.Importance = widget.LowImportance
-			c, _ := colorutils.HexToColor(color)
-			rect := canvas.NewRectangle(c)
-			rect.CornerRadius = 5
+		var buttons []fyne.CanvasObject
+		group := ""
+		for _, tag := range available {
+			namespace, value := tagpath.SplitNamespace(tag.Name)
+			if namespace != group {
+				group = namespace
+				buttons = append(buttons, namespaceHeader(namespace))
+			}
+			label, indent := treeLabel(tag.Name, listed)
+			if namespace != "" && !listed[tagpath.Parent(tag.Name)] {
+				label = tagpath.Display(value)
+			}
+			c, _ := colorutils.HexToColor(tag.Color)
+			chip := tagchip.New(label, c, nil)
 
-			tagID := id
-			button.OnTapped = func() {
+			tagID := tag.Id
+			chip.OnTapped = func() {
 				go func() {
-					_, err := db.Exec("INSERT OR IGNORE INTO FileTag (fileId, tagId) VALUES (?, ?)", imgId, tagID)
+					// tags implied by the tag are added with it
+					err := database.AddTagToFile(db, imgId, tagID)
 					parent.Content().Refresh()
 					if err != nil {
 						fmt.Print("showTagWindow")
 						dialog.ShowError(err, parent)
 					} else {
-						tagList.Add(container.NewPadded(container.NewStack(rect, button)))
-						tagList.Refresh()
+						refreshTagDisplay(db, imgId, tagList, parent)
 						dialog.ShowInformation("Success", "Tag Added", parent)
 						tagWindow.Close()
 					}
 				}()
 			}
-			buttons = append(buttons, container.NewPadded(container.NewStack(rect, button)))
+			buttons = append(buttons, container.NewBorder(nil, nil, indent, nil, container.NewPadded(chip)))
 		}
 
 		content.Remove(loadingLabel)
@@ -235,8 +273,9 @@ func ShowTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, imgId int, tagLis
 }
 
 // Modify the createTagDisplay function to include tag removal functionality
+// Tags are grouped by namespace under a header showing only their values
 func CreateTagDisplay(db *sql.DB, imageId int, appLogger *log.Logger, sidebar *fyne.Container, w fyne.Window) *fyne.Container {
-	tagDisplay := container.NewAdaptiveGrid(3)
+	tagDisplay := container.NewVBox()
 
 	rows, err := db.Query("SELECT Tag.id, Tag.name, Tag.color FROM FileTag INNER JOIN Tag ON FileTag.tagId = Tag.id WHERE FileTag.fileId = ?", imageId)
 	if err != nil {
@@ -245,45 +284,828 @@ func CreateTagDisplay(db *sql.DB, imageId int, appLogger *log.Logger, sidebar *f
 	}
 	defer rows.Close()
 
+	var tags []database.TagCount
 	for rows.Next() {
-		var tagId int
-		var tagName, tagColor string
-		if err := rows.Scan(&tagId, &tagName, &tagColor); err != nil {
+		var tag database.TagCount
+		if err := rows.Scan(&tag.Id, &tag.Name, &tag.Color); err != nil {
 			appLogger.Println("Error scanning tag data:", err)
 			continue
 		}
+		tags = append(tags, tag)
+	}
+	slices.SortFunc(tags, func(a, b database.TagCount) int { return tagpath.CompareByNamespace(a.Name, b.Name) })
 
-		tagButton := widget.NewButton(tagName, nil)
-		tagButton.Importance = widget.LowImportance
-		c, _ := colorutils.HexToColor(tagColor)
-		rect := canvas.NewRectangle(c)
-		rect.CornerRadius = 5
+	var grid *fyne.Container
+	group := ""
+	for _, tag := range tags {
+		tagId := tag.Id
+		namespace, value := tagpath.SplitNamespace(tag.Name)
+		// tags without a namespace come first and have no header
+		if grid == nil || namespace != group {
+			if namespace != "" {
+				tagDisplay.Add(namespaceHeader(namespace))
+			}
+			group = namespace
+			grid = container.NewAdaptiveGrid(3)
+			tagDisplay.Add(grid)
+		}
 
-		tagButton.OnTapped = func() {
-			dialog.ShowConfirm("Remove Tag", "Are you sure you want to remove this tag?", func(remove bool) {
-				if remove {
-					if err := database.RemoveTagFromImage(db, imageId, tagId); err != nil {
-						fmt.Print("createTagDisplay")
-						dialog.ShowError(err, nil)
-					} else {
-						// remove the tag from the tag display
-						database.RemoveTagFromImage(db, imageId, tagId)
-						tagButton.Hide()
-						// tagButton.Hide()
-						// tagDisplay.Remove()
-						// rect.Hide()
-						// parent.Content().Refresh()
+		// nested tags show their ancestry, like Places › Latvia › Riga. Tapping the tag searches for it, the x
+		// removes it right away.
+		c, _ := colorutils.HexToColor(tag.Color)
+		name := tag.Name
+		chip := tagchip.NewRemovable(tagpath.Display(value), c, func() {
+			if OnTagSearch != nil {
+				OnTagSearch(name)
+			}
+		}, func() {
+			if err := database.RemoveTagFromImage(db, imageId, tagId); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			refreshTagDisplay(db, imageId, tagDisplay, w)
+		})
+		grid.Add(container.NewPadded(chip))
+	}
+
+	// tags often found along with the tags of the file, added with one tap
+	tagIds := make([]int, len(tags))
+	for i, tag := range tags {
+		tagIds[i] = tag.Id
+	}
+	related, err := database.GetRelatedTags(db, tagIds, relatedTagCount)
+	if err != nil {
+		appLogger.Println("Error getting related tags:", err)
+	}
+	if len(related) > 0 {
+		tagDisplay.Add(widget.NewLabelWithStyle("Suggested", fyne.TextAlignLeading, fyne.TextStyle{Italic: true}))
+		suggested := container.NewAdaptiveGrid(3)
+		for _, tag := range related {
+			tagId := tag.Id
+			c, _ := colorutils.HexToColor(tag.Color)
+			chip := tagchip.New("+ "+tagpath.Display(tag.Name), c, func() {
+				if err := database.AddTagToFile(db, imageId, tagId); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				refreshTagDisplay(db, imageId, tagDisplay, w)
+			})
+			chip.Outlined = true
+			suggested.Add(container.NewPadded(chip))
+		}
+		tagDisplay.Add(suggested)
+	}
+	tagDisplay.Refresh()
+
+	return tagDisplay
+}
+
+// Number of related tags suggested at once
+const relatedTagCount = 6
+
+// Called with the name of a tag tapped in the tags of a file so the main window can search for it
+var OnTagSearch func(name string)
+
+// Builds the tags of the file again so added tags land in their namespace group
+func refreshTagDisplay(db *sql.DB, imageId int, tagList *fyne.Container, w fyne.Window) {
+	tagList.Objects = CreateTagDisplay(db, imageId, log.Default(), nil, w).Objects
+	tagList.Refresh()
+}
+
+// Entry that adds tags to the file while typing. Existing tags are suggested by a fuzzy match of their name,
+// ranked by how many files use them and how recently they were added. Names that are not a tag yet are created
+// with a color from the image or picked from the name, Backspace in the empty entry removes the tag added last.
+func CreateTagEntry(db *sql.DB, imageId int, tagList *fyne.Container, w fyne.Window) fyne.CanvasObject {
+	entry := completion.NewEntry()
+	entry.SetPlaceHolder("Add a tag...")
+
+	// loaded on the first key and again after the tags of the file changed
+	var candidates []tagsuggest.Candidate
+	entry.Suggest = func(text string) []string {
+		if candidates == nil {
+			var err error
+			candidates, err = tagCandidates(db, imageId)
+			if err != nil {
+				log.Println("Error loading tag suggestions:", err)
+				return nil
+			}
+		}
+		var names []string
+		for _, candidate := range tagsuggest.Rank(text, candidates, time.Now(), 20) {
+			names = append(names, candidate.Name)
+		}
+		return names
+	}
+	entry.OnChosen = func(name string) {
+		tagId, err := database.EnsureTag(db, name, newTagColor(db, imageId, name))
+		if err == nil {
+			err = database.AddTagToFile(db, imageId, tagId)
+		}
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		candidates = nil
+		refreshTagDisplay(db, imageId, tagList, w)
+	}
+	entry.OnBackspace = func() {
+		var tagId int
+		err := db.QueryRow("SELECT tagId FROM FileTag WHERE fileId = ? ORDER BY id DESC LIMIT 1", imageId).Scan(&tagId)
+		if err == sql.ErrNoRows {
+			return
+		}
+		if err == nil {
+			err = database.RemoveTagFromImage(db, imageId, tagId)
+		}
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		candidates = nil
+		refreshTagDisplay(db, imageId, tagList, w)
+	}
+	return entry
+}
+
+// Color for a tag made on the image, the main color of the image when it has a clear one. Only used when the tag
+// is new and not in a namespace with its own color.
+func newTagColor(db *sql.DB, imageId int, name string) string {
+	swatches, err := database.GetPalette(db, imageId)
+	if err != nil {
+		log.Println("Error loading the palette:", err)
+	}
+	if color := palette.TagColor(swatches); color != "" {
+		return color
+	}
+	return colorutils.AutoColor(tagpath.Clean(name))
+}
+
+// Tags that can still be added to the file
+func tagCandidates(db *sql.DB, imageId int) ([]tagsuggest.Candidate, error) {
+	tags, err := database.GetTagUsage(db)
+	if err != nil {
+		return nil, err
+	}
+	onFile := map[int]bool{}
+	rows, err := db.Query("SELECT tagId FROM FileTag WHERE fileId = ?", imageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tagId int
+		if err := rows.Scan(&tagId); err != nil {
+			return nil, err
+		}
+		onFile[tagId] = true
+	}
+
+	candidates := []tagsuggest.Candidate{}
+	for _, tag := range tags {
+		if !onFile[tag.Id] {
+			candidates = append(candidates, tagsuggest.Candidate{Id: tag.Id, Name: tag.Name, Uses: tag.Files, LastUsed: tag.LastUsed})
+		}
+	}
+	return candidates, rows.Err()
+}
+
+// Title of a group of tags in the same namespace
+func namespaceHeader(namespace string) fyne.CanvasObject {
+	return widget.NewLabelWithStyle(namespace, fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+}
+
+// States of a tag in the bulk tag window
+const (
+	tagOnNone = iota
+	tagOnSome
+	tagOnAll
+)
+
+type bulkTag struct {
+	tag      database.TagCount
+	original int
+	state    int
+}
+
+// Tapping a tag switches between all and none, tags that were on some of the files can also go back to that
+func (t *bulkTag) next() {
+	switch {
+	case t.state == tagOnAll:
+		t.state = tagOnNone
+	case t.state == tagOnNone && t.original == tagOnSome:
+		t.state = tagOnSome
+	default:
+		t.state = tagOnAll
+	}
+}
+
+// Adds and removes tags on all the files at once. Every tag shows whether it is on all, some or none of them,
+// the changes are written in one transaction and the last one can be undone. onChanged runs after the tags of
+// the files changed.
+func ShowBulkTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, paths []string, onChanged func()) {
+	if len(paths) == 0 {
+		dialog.ShowInformation("Tag Files", "Select the files to tag first", parent)
+		return
+	}
+
+	tagWindow := a.NewWindow(fmt.Sprintf("Tag %d Files", len(paths)))
+
+	summary := widget.NewLabel("Loading tags...")
+	summary.Wrapping = fyne.TextWrapWord
+	filter := widget.NewEntry()
+	filter.SetPlaceHolder("Filter tags")
+	list := container.NewVBox()
+	suggestions := container.NewHBox()
+	status := widget.NewLabel("")
+
+	var tags []*bulkTag
+	var related []database.TagCount
+	var lastChanges []database.TagChange
+	var undoButton, applyButton *widget.Button
+
+	var showTags func()
+	showTags = func() {
+		list.RemoveAll()
+		var shown []*bulkTag
+		listed := map[string]bool{}
+		for _, tag := range tags {
+			if strings.Contains(strings.ToLower(tag.tag.Name), strings.ToLower(filter.Text)) {
+				shown = append(shown, tag)
+				listed[tag.tag.Name] = true
+			}
+		}
+		for _, tag := range shown {
+			icon := theme.CheckButtonIcon()
+			switch tag.state {
+			case tagOnAll:
+				icon = theme.CheckButtonCheckedIcon()
+			case tagOnSome:
+				icon = theme.ContentRemoveIcon()
+			}
+			name, indent := treeLabel(tag.tag.Name, listed)
+			if tag.state != tag.original {
+				name += " *"
+			}
+
+			button := widget.NewButtonWithIcon(name, icon, func() {
+				tag.next()
+				showTags()
+			})
+			button.Importance = widget.LowImportance
+			button.Alignment = widget.ButtonAlignLeading
+			c, _ := colorutils.HexToColor(tag.tag.Color)
+			rect := canvas.NewRectangle(c)
+			rect.CornerRadius = 5
+
+			count := widget.NewLabel(fmt.Sprintf("%d / %d", tag.tag.Files, len(paths)))
+			list.Add(container.NewBorder(nil, nil, indent, count, container.NewPadded(container.NewStack(rect, button))))
+		}
+		list.Refresh()
+
+		// suggestions mark the tag to be added to all the files like tapping it in the list
+		suggestions.RemoveAll()
+		for _, suggestion := range related {
+			for _, tag := range tags {
+				if tag.tag.Id != suggestion.Id || tag.state == tagOnAll {
+					continue
+				}
+				button := widget.NewButtonWithIcon(tagpath.Display(tag.tag.Name), theme.ContentAddIcon(), func() {
+					tag.state = tagOnAll
+					showTags()
+				})
+				button.Importance = widget.LowImportance
+				suggestions.Add(button)
+			}
+		}
+		if len(suggestions.Objects) > 0 {
+			suggestions.Objects = append([]fyne.CanvasObject{widget.NewLabel("Suggested:")}, suggestions.Objects...)
+		}
+		suggestions.Refresh()
+	}
+
+	load := func() {
+		counts, files, err := database.GetTagCounts(db, paths)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("showBulkTagWindow: %w", err), tagWindow)
+			return
+		}
+
+		tags = tags[:0]
+		var present []int
+		for _, count := range counts {
+			state := tagOnSome
+			if count.Files == 0 {
+				state = tagOnNone
+			} else if count.Files >= files {
+				state = tagOnAll
+			}
+			tags = append(tags, &bulkTag{tag: count, original: state, state: state})
+			if state != tagOnNone {
+				present = append(present, count.Id)
+			}
+		}
+		related, err = database.GetRelatedTags(db, present, relatedTagCount)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("showBulkTagWindow: %w", err), tagWindow)
+		}
+
+		text := fmt.Sprintf("Tags on all, some or none of the %d selected files. Tap a tag to change it.", len(paths))
+		if skipped := len(paths) - files; skipped > 0 {
+			text += fmt.Sprintf("\n%d of the files are not in the database and are skipped.", skipped)
+		}
+		summary.SetText(text)
+		showTags()
+	}
+
+	changed := func() {
+		load()
+		if onChanged != nil {
+			onChanged()
+		}
+	}
+
+	applyButton = widget.NewButtonWithIcon("Apply", theme.ConfirmIcon(), func() {
+		var add, remove []int
+		for _, tag := range tags {
+			if tag.state == tag.original {
+				continue
+			}
+			switch tag.state {
+			case tagOnAll:
+				add = append(add, tag.tag.Id)
+			case tagOnNone:
+				remove = append(remove, tag.tag.Id)
+			}
+		}
+		if len(add) == 0 && len(remove) == 0 {
+			dialog.ShowInformation("Tag Files", "No tags were changed", tagWindow)
+			return
+		}
+
+		applyButton.Disable()
+		go func() {
+			defer applyButton.Enable()
+			changes, err := database.ApplyTagChanges(db, paths, add, remove)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("showBulkTagWindow: %w", err), tagWindow)
+				return
+			}
+			added := 0
+			for _, change := range changes {
+				if change.Added {
+					added++
+				}
+			}
+			lastChanges = changes
+			status.SetText(fmt.Sprintf("Added %d and removed %d file tags", added, len(changes)-added))
+			if len(changes) > 0 {
+				undoButton.Enable()
+			}
+			changed()
+		}()
+	})
+
+	undoButton = widget.NewButtonWithIcon("Undo", theme.ContentUndoIcon(), func() {
+		undoButton.Disable()
+		go func() {
+			if err := database.UndoTagChanges(db, lastChanges); err != nil {
+				undoButton.Enable()
+				dialog.ShowError(fmt.Errorf("showBulkTagWindow: %w", err), tagWindow)
+				return
+			}
+			status.SetText(fmt.Sprintf("Undid %d file tag changes", len(lastChanges)))
+			lastChanges = nil
+			changed()
+		}()
+	})
+	undoButton.Disable()
+
+	filter.OnChanged = func(string) { showTags() }
+
+	buttons := container.NewHBox(status, layout.NewSpacer(), undoButton, applyButton,
+		widget.NewButton("Close", tagWindow.Close))
+	tagWindow.SetContent(container.NewBorder(container.NewVBox(summary, container.NewHScroll(suggestions), filter), buttons, nil, nil, container.NewVScroll(list)))
+	tagWindow.Resize(fyne.NewSize(420, 500))
+	tagWindow.Show()
+
+	go load()
+}
+
+// Lists every tag with the number of files using it. Tags can be renamed, recolored and deleted, and the
+// checked tags can be merged into one of them.
+func ShowTagManagerWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *options.Options) {
+	managerWindow := a.NewWindow("Manage Tags")
+
+	filter := widget.NewEntry()
+	filter.SetPlaceHolder("Filter tags")
+	list := container.NewVBox()
+	var tags []database.TagCount
+	checked := map[int]bool{}
+	var mergeButton *widget.Button
+
+	var load func()
+	showTags := func() {
+		list.RemoveAll()
+		if len(tags) == 0 {
+			list.Add(widget.NewLabel("No tags found."))
+		}
+		var shown []database.TagCount
+		listed := map[string]bool{}
+		for _, tag := range tags {
+			if strings.Contains(strings.ToLower(tag.Name), strings.ToLower(filter.Text)) {
+				shown = append(shown, tag)
+				listed[tag.Name] = true
+			}
+		}
+		for _, tag := range shown {
+			check := widget.NewCheck("", func(on bool) {
+				if on {
+					checked[tag.Id] = true
+				} else {
+					delete(checked, tag.Id)
+				}
+				if len(checked) > 1 {
+					mergeButton.Enable()
+				} else {
+					mergeButton.Disable()
+				}
+			})
+			check.Checked = checked[tag.Id]
+
+			c, _ := colorutils.HexToColor(tag.Color)
+			rect := canvas.NewRectangle(c)
+			rect.CornerRadius = 5
+			label, indent := treeLabel(tag.Name, listed)
+			name := container.NewPadded(container.NewStack(rect, widget.NewLabel(label)))
+
+			editButton := widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+				ShowCreateTagWindow(a, managerWindow, db, opts, true, tag.Name, tag.Id, func() { go load() })
+			})
+			deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				message := fmt.Sprintf("Delete the tag %s? It is not used by any files.", tag.Name)
+				if tag.Files > 0 {
+					message = fmt.Sprintf("Delete the tag %s? It is removed from the %d files using it.", tag.Name, tag.Files)
+				}
+				nested := 0
+				for _, other := range tags {
+					if tagpath.IsDescendant(other.Name, tag.Name) {
+						nested++
 					}
 				}
-			}, w)
-			rect.Hide()
+				if nested > 0 {
+					message += fmt.Sprintf("\nThe %d tags nested under it are deleted too.", nested)
+				}
+				dialog.ShowConfirm("Delete Tag", message, func(ok bool) {
+					if !ok {
+						return
+					}
+					if err := database.DeleteTag(db, tag.Id); err != nil {
+						dialog.ShowError(fmt.Errorf("showTagManagerWindow: %w", err), managerWindow)
+						return
+					}
+					delete(checked, tag.Id)
+					go load()
+				}, managerWindow)
+			})
+
+			usage := widget.NewLabel(fmt.Sprintf("%d files", tag.Files))
+			list.Add(container.NewBorder(nil, nil, container.NewHBox(check, indent), container.NewHBox(usage, editButton, deleteButton), name))
+		}
+		list.Refresh()
+	}
+	load = func() {
+		usage, err := database.GetTagUsage(db)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("showTagManagerWindow: %w", err), managerWindow)
 			return
-			// sidebar.Remove(tagButton)
 		}
-		// New version with padding
-		tagDisplay.Add(container.NewPadded(container.NewStack(rect, tagButton)))
-		tagDisplay.Refresh()
+		tags = usage
+		// tags deleted or merged meanwhile can not be checked anymore
+		for id := range checked {
+			if !slices.ContainsFunc(tags, func(tag database.TagCount) bool { return tag.Id == id }) {
+				delete(checked, id)
+			}
+		}
+		if len(checked) < 2 {
+			mergeButton.Disable()
+		}
+		showTags()
 	}
 
-	return tagDisplay
+	mergeButton = widget.NewButtonWithIcon("Merge Checked", theme.ContentPasteIcon(), func() {
+		var names []string
+		var ids []int
+		files := 0
+		for _, tag := range tags {
+			if checked[tag.Id] {
+				names = append(names, tag.Name)
+				ids = append(ids, tag.Id)
+				files += tag.Files
+			}
+		}
+		target := widget.NewSelect(names, nil)
+		target.SetSelectedIndex(0)
+		form := container.NewVBox(
+			widget.NewLabel(fmt.Sprintf("Merge %d tags used %d times into:", len(names), files)),
+			target,
+		)
+		dialog.ShowCustomConfirm("Merge Tags", "Merge", "Cancel", form, func(ok bool) {
+			if !ok {
+				return
+			}
+			targetId := ids[target.SelectedIndex()]
+			if err := database.MergeTags(db, targetId, ids); err != nil {
+				dialog.ShowError(fmt.Errorf("showTagManagerWindow: %w", err), managerWindow)
+				return
+			}
+			checked = map[int]bool{}
+			go load()
+		}, managerWindow)
+	})
+	mergeButton.Disable()
+
+	newButton := widget.NewButtonWithIcon("New Tag", theme.ContentAddIcon(), func() {
+		ShowCreateTagWindow(a, managerWindow, db, opts, false, "", 0, func() { go load() })
+	})
+
+	namespacesButton := widget.NewButtonWithIcon("Namespaces", theme.ListIcon(), func() {
+		ShowNamespaceWindow(a, managerWindow, db, func() { go load() })
+	})
+	rulesButton := widget.NewButtonWithIcon("Rules", theme.SettingsIcon(), func() {
+		ShowTagRulesWindow(a, managerWindow, db, func() { go load() })
+	})
+	autoTagButton := widget.NewButtonWithIcon("Auto-Tag", theme.MediaPlayIcon(), func() {
+		ShowAutoTagWindow(a, managerWindow, db, func() { go load() })
+	})
+
+	filter.OnChanged = func(string) { showTags() }
+
+	managerWindow.SetContent(container.NewBorder(filter, container.NewHBox(newButton, namespacesButton, rulesButton, autoTagButton, layout.NewSpacer(), mergeButton), nil, nil, container.NewVScroll(list)))
+	managerWindow.Resize(fyne.NewSize(560, 500))
+	managerWindow.Show()
+
+	go load()
+}
+
+// Returns the label of a tag in a tag tree and the space in front of it. Nested tags show the last level of their
+// path indented under their parent, when the parent is not listed the whole path is shown instead.
+func treeLabel(path string, listed map[string]bool) (string, fyne.CanvasObject) {
+	level := 0
+	for parent := tagpath.Parent(path); listed[parent]; parent = tagpath.Parent(parent) {
+		level++
+	}
+	indent := canvas.NewRectangle(color.Transparent)
+	indent.SetMinSize(fyne.NewSize(float32(level)*theme.Padding()*4, 0))
+	if level == 0 {
+		return tagpath.Display(path), indent
+	}
+	return tagpath.Leaf(path), indent
+}
+
+// Lists the namespaces of tags like artist:someone. A namespace can be renamed for all its tags at once and
+// given a color used by all of them. onChanged runs after tags were renamed or recolored.
+func ShowNamespaceWindow(a fyne.App, parent fyne.Window, db *sql.DB, onChanged func()) {
+	namespaceWindow := a.NewWindow("Namespaces")
+	list := container.NewVBox(widget.NewLabel("Loading namespaces..."))
+
+	var load func()
+	changed := func() {
+		go load()
+		if onChanged != nil {
+			onChanged()
+		}
+	}
+	load = func() {
+		namespaces, err := database.GetNamespaces(db)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("showNamespaceWindow: %w", err), namespaceWindow)
+			return
+		}
+
+		list.RemoveAll()
+		if len(namespaces) == 0 {
+			list.Add(widget.NewLabel("No namespaces yet, name a tag like artist:someone to add one."))
+		}
+		for _, namespace := range namespaces {
+			swatch := canvas.NewRectangle(color.Transparent)
+			if c, err := colorutils.HexToColor(namespace.Color); err == nil {
+				swatch.FillColor = c
+			}
+			swatch.CornerRadius = 5
+			swatch.SetMinSize(fyne.NewSize(24, 24))
+
+			renameButton := widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+				entry := widget.NewEntry()
+				entry.SetText(namespace.Name)
+				items := []*widget.FormItem{widget.NewFormItem("Name", entry)}
+				dialog.ShowForm("Rename Namespace", "Rename", "Cancel", items, func(ok bool) {
+					if !ok || entry.Text == namespace.Name {
+						return
+					}
+					err := database.RenameNamespace(db, namespace.Name, entry.Text)
+					if err == database.ErrTagExists {
+						dialog.ShowInformation("Error", "Some of the renamed tags already exist, merge them first", namespaceWindow)
+						return
+					}
+					if err != nil {
+						dialog.ShowError(fmt.Errorf("showNamespaceWindow: %w", err), namespaceWindow)
+						return
+					}
+					changed()
+				}, namespaceWindow)
+			})
+			colorButton := widget.NewButtonWithIcon("", theme.ColorPaletteIcon(), func() {
+				picker := dialog.NewColorPicker("Namespace Color", "Color of every "+namespace.Name+" tag", func(c color.Color) {
+					if err := database.SetNamespaceColor(db, namespace.Name, colorutils.ColorToHex(c)); err != nil {
+						dialog.ShowError(fmt.Errorf("showNamespaceWindow: %w", err), namespaceWindow)
+						return
+					}
+					changed()
+				}, namespaceWindow)
+				picker.Advanced = true
+				picker.Show()
+			})
+
+			usage := widget.NewLabel(fmt.Sprintf("%d tags", namespace.Tags))
+			list.Add(container.NewBorder(nil, nil, swatch, container.NewHBox(usage, colorButton, renameButton), widget.NewLabel(namespace.Name)))
+		}
+		list.Refresh()
+	}
+
+	namespaceWindow.SetContent(container.NewVScroll(list))
+	namespaceWindow.Resize(fyne.NewSize(400, 350))
+	namespaceWindow.Show()
+
+	go load()
+}
+
+// Manages aliases, other names that resolve to a tag when tagging and searching, and implications, tags that are
+// added along with a tag. New implications can be applied to the files tagged before them. onChanged runs after
+// tags of files changed.
+func ShowTagRulesWindow(a fyne.App, parent fyne.Window, db *sql.DB, onChanged func()) {
+	rulesWindow := a.NewWindow("Tag Rules")
+
+	aliasList := container.NewVBox()
+	implicationList := container.NewVBox()
+	aliasEntry := widget.NewEntry()
+	aliasEntry.SetPlaceHolder("Alias, like kitty")
+	aliasTarget := widget.NewSelectEntry(nil)
+	aliasTarget.SetPlaceHolder("Tag, like cat")
+	implyingEntry := widget.NewSelectEntry(nil)
+	implyingEntry.SetPlaceHolder("Tag, like corgi")
+	impliedEntry := widget.NewSelectEntry(nil)
+	impliedEntry.SetPlaceHolder("Implies, like dog")
+
+	showError := func(err error) {
+		dialog.ShowError(fmt.Errorf("showTagRulesWindow: %w", err), rulesWindow)
+	}
+	applyImplications := func() {
+		go func() {
+			added, err := database.ApplyImplications(db)
+			if err != nil {
+				showError(err)
+				return
+			}
+			dialog.ShowInformation("Implications Applied", fmt.Sprintf("Added %d implied tags to existing files", added), rulesWindow)
+			if onChanged != nil {
+				onChanged()
+			}
+		}()
+	}
+
+	var load func()
+	load = func() {
+		tags, err := database.GetTagUsage(db)
+		if err != nil {
+			showError(err)
+			return
+		}
+		names := make([]string, len(tags))
+		for i, tag := range tags {
+			names[i] = tag.Name
+		}
+		aliasTarget.SetOptions(names)
+		implyingEntry.SetOptions(names)
+		impliedEntry.SetOptions(names)
+
+		aliases, err := database.GetAliases(db)
+		if err != nil {
+			showError(err)
+			return
+		}
+		aliasList.RemoveAll()
+		if len(aliases) == 0 {
+			aliasList.Add(widget.NewLabel("No aliases yet."))
+		}
+		for _, alias := range aliases {
+			removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				if err := database.RemoveAlias(db, alias.Alias); err != nil {
+					showError(err)
+					return
+				}
+				go load()
+			})
+			aliasList.Add(container.NewBorder(nil, nil, nil, removeButton, widget.NewLabel(alias.Alias+"  →  "+tagpath.Display(alias.TagName))))
+		}
+		aliasList.Refresh()
+
+		implications, err := database.GetImplications(db)
+		if err != nil {
+			showError(err)
+			return
+		}
+		implicationList.RemoveAll()
+		if len(implications) == 0 {
+			implicationList.Add(widget.NewLabel("No implications yet."))
+		}
+		for _, implication := range implications {
+			removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				if err := database.RemoveImplication(db, implication.TagId, implication.ImpliedId); err != nil {
+					showError(err)
+					return
+				}
+				go load()
+			})
+			text := tagpath.Display(implication.TagName) + "  ⇒  " + tagpath.Display(implication.ImpliedName)
+			implicationList.Add(container.NewBorder(nil, nil, nil, removeButton, widget.NewLabel(text)))
+		}
+		implicationList.Refresh()
+	}
+
+	addAliasButton := widget.NewButtonWithIcon("Add Alias", theme.ContentAddIcon(), func() {
+		tagId, err := database.ResolveTag(db, aliasTarget.Text)
+		if err != nil {
+			dialog.ShowInformation("Error", err.Error(), rulesWindow)
+			return
+		}
+		add := func() {
+			if err := database.AddAlias(db, aliasEntry.Text, tagId); err != nil {
+				showError(err)
+				return
+			}
+			aliasEntry.SetText("")
+			go load()
+			if onChanged != nil {
+				onChanged()
+			}
+		}
+		// a tag with the alias name is merged into the tag so ask first
+		if _, err := database.ResolveTag(db, aliasEntry.Text); err == nil {
+			message := fmt.Sprintf("%s is already a tag or an alias, merge it into %s?", aliasEntry.Text, aliasTarget.Text)
+			dialog.ShowConfirm("Add Alias", message, func(ok bool) {
+				if ok {
+					add()
+				}
+			}, rulesWindow)
+			return
+		}
+		add()
+	})
+
+	addImplicationButton := widget.NewButtonWithIcon("Add Implication", theme.ContentAddIcon(), func() {
+		tagId, err := database.ResolveTag(db, implyingEntry.Text)
+		if err != nil {
+			dialog.ShowInformation("Error", err.Error(), rulesWindow)
+			return
+		}
+		impliedId, err := database.ResolveTag(db, impliedEntry.Text)
+		if err != nil {
+			dialog.ShowInformation("Error", err.Error(), rulesWindow)
+			return
+		}
+		err = database.AddImplication(db, tagId, impliedId)
+		if errors.Is(err, database.ErrImplicationCycle) {
+			dialog.ShowInformation("Error", err.Error(), rulesWindow)
+			return
+		}
+		if err != nil {
+			showError(err)
+			return
+		}
+		implyingEntry.SetText("")
+		impliedEntry.SetText("")
+		go load()
+		dialog.ShowConfirm("Implication Added", "Add the implied tags to files that already have the tag?", func(ok bool) {
+			if ok {
+				applyImplications()
+			}
+		}, rulesWindow)
+	})
+
+	aliasTab := container.NewBorder(
+		container.NewVBox(container.NewGridWithColumns(2, aliasEntry, aliasTarget), addAliasButton, widget.NewSeparator()),
+		nil, nil, nil, container.NewVScroll(aliasList))
+	implicationTab := container.NewBorder(
+		container.NewVBox(container.NewGridWithColumns(2, implyingEntry, impliedEntry), addImplicationButton, widget.NewSeparator()),
+		widget.NewButtonWithIcon("Apply to Existing Files", theme.ViewRefreshIcon(), applyImplications),
+		nil, nil, container.NewVScroll(implicationList))
+
+	rulesWindow.SetContent(container.NewAppTabs(
+		container.NewTabItem("Aliases", aliasTab),
+		container.NewTabItem("Implications", implicationTab),
+	))
+	rulesWindow.Resize(fyne.NewSize(500, 450))
+	rulesWindow.Show()
+
+	go load()
 }
@@ -1,26 +1,34 @@
 package utilwindows
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"image/color"
 	"log"
+	"main/pkg/animation"
 	"main/pkg/apptheme"
 	"main/pkg/archives"
 	"main/pkg/colorutils"
 	"main/pkg/database"
+	"main/pkg/dirtags"
 	"main/pkg/imageconv"
 	"main/pkg/options"
+	"main/pkg/shortcuts"
 	"main/pkg/tagwindow"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
@@ -157,39 +165,6 @@ func ShowColorPickerWindow(propertyName string, colorPreview *canvas.Rectangle,
 	updateColor() // Initial color update
 }
 
-func ShowAllTagWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *options.Options) {
-	tagEditWindow := a.NewWindow("Show Tags")
-
-	content := container.NewVBox()
-	scroll := container.NewVScroll(content)
-	tags, _ := database.GetTags(db)
-
-	if len(tags) == 0 {
-		content.Add(widget.NewLabel("No tags found."))
-		tagEditWindow.SetContent(scroll)
-		tagEditWindow.Resize(fyne.NewSize(300, 450))
-		tagEditWindow.Show()
-		return
-	}
-
-	for k, v := range tags {
-		// fmt.Println("Tag Key value: ", k, " ", v)
-		content.Add(
-			container.NewHBox(
-				widget.NewLabel(v),
-				widget.NewButtonWithIcon("Edit", theme.DocumentCreateIcon(), func() {
-					tagwindow.ShowCreateTagWindow(a, parent, db, opts, true, v, k)
-					// ShowEditTagWindow(a, parent, db, i, scroll) // replace this with create tag window but update statement
-				}),
-			),
-		)
-	}
-
-	tagEditWindow.SetContent(scroll)
-	tagEditWindow.Resize(fyne.NewSize(300, 450))
-	tagEditWindow.Show()
-}
-
 // Add a settings window
 func ShowSettingsWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *options.Options) {
 	settingsWindow := a.NewWindow("Settings")
@@ -246,7 +221,8 @@ func ShowSettingsWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *option
 		func(id widget.ListItemID, item fyne.CanvasObject) {
 			label := item.(*widget.Label)
 			var tagName string
-			db.QueryRow("SELECT name FROM Tag WHERE id = ?", id+1).Scan(&tagName)
+			// ids have gaps once tags are deleted or merged
+			db.QueryRow("SELECT name FROM Tag ORDER BY id LIMIT 1 OFFSET ?", id).Scan(&tagName)
 			label.SetText(tagName)
 		},
 	)
@@ -259,6 +235,16 @@ func ShowSettingsWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *option
 		timeZone = widget.NewLabel("Timezone in UTC: UTC" + strconv.Itoa(opts.Timezone))
 	}
 
+	// Create an entry for the archive name template
+	archiveNameEntry := widget.NewEntry()
+	archiveNameEntry.SetText(opts.ArchiveName)
+	archiveNameEntry.SetPlaceHolder("{date}")
+	archiveNameEntry.OnChanged = func(template string) {
+		opts.ArchiveName = template
+	}
+	archiveNameHint := widget.NewLabel("Use {date}, {time}, {tag}, {count} and {n} for a number that avoids overwriting")
+	archiveNameHint.Wrapping = fyne.TextWrapWord
+
 	saveOptionsButton := widget.NewButton("Save Options", func() {
 		err := options.SaveOptionsToDB(db, opts)
 		if err == nil {
@@ -279,13 +265,22 @@ func ShowSettingsWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *option
 		widget.NewLabel("Excluded directories"),
 		blackList,
 		widget.NewLabel("Tags"),
-		widget.NewButton("Show Tags", func() {
-			ShowAllTagWindow(a, parent, db, opts)
+		widget.NewButton("Manage Tags", func() {
+			tagwindow.ShowTagManagerWindow(a, parent, db, opts)
+		}),
+		widget.NewButton("Directory Tags", func() {
+			ShowDirectoryTagsWindow(a, db, opts)
 		}),
 		tagList,
 		timeZone,
+		widget.NewLabel("Archive name template"),
+		archiveNameEntry,
+		archiveNameHint,
 		// themeEditorButton,
 		widget.NewLabel("Default sorting: Date Added, Descending"),
+		widget.NewButtonWithIcon("Keyboard Shortcuts", theme.ComputerIcon(), func() {
+			ShowShortcutsWindow(a, db, opts)
+		}),
 		saveOptionsButton,
 	)
 
@@ -294,6 +289,205 @@ func ShowSettingsWindow(a fyne.App, parent fyne.Window, db *sql.DB, opts *option
 	settingsWindow.Show()
 }
 
+// Settings for tagging discovered files with the names of their directories. The tags can be added to the whole
+// library at once and taken back, which only removes the tags directory tagging added.
+func ShowDirectoryTagsWindow(a fyne.App, db *sql.DB, opts *options.Options) {
+	directoryWindow := a.NewWindow("Directory Tags")
+	settings := opts.DirectoryTags
+
+	enabled := widget.NewCheck("Tag new files with their directory names", nil)
+	enabled.SetChecked(settings.Enabled)
+	ignore := widget.NewEntry()
+	ignore.SetPlaceHolder("Pictures, Photos")
+	ignore.SetText(strings.Join(settings.Ignore, ", "))
+	maxDepth := widget.NewEntry()
+	maxDepth.SetPlaceHolder("0 uses every directory")
+	maxDepth.SetText(strconv.Itoa(settings.MaxDepth))
+	maxDepth.Validator = func(text string) error {
+		if depth, err := strconv.Atoi(strings.TrimSpace(text)); err != nil || depth < 0 {
+			return fmt.Errorf("depth has to be 0 or more")
+		}
+		return nil
+	}
+	tagCase := widget.NewSelect(options.TagCases, nil)
+	tagCase.SetSelected(settings.Case)
+	nested := widget.NewCheck("Nest every directory under the one above it", nil)
+	nested.SetChecked(settings.Nested)
+	parentTag := widget.NewEntry()
+	parentTag.SetPlaceHolder("Places, empty for none")
+	parentTag.SetText(settings.Parent)
+
+	// shows what the settings make of an example path
+	example := widget.NewLabel("")
+	example.Wrapping = fyne.TextWrapWord
+	read := func() options.DirectoryTagOptions {
+		read := options.DirectoryTagOptions{
+			Enabled: enabled.Checked,
+			Case:    tagCase.Selected,
+			Nested:  nested.Checked,
+			Parent:  strings.TrimSpace(parentTag.Text),
+		}
+		for _, name := range strings.Split(ignore.Text, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				read.Ignore = append(read.Ignore, name)
+			}
+		}
+		read.MaxDepth, _ = strconv.Atoi(strings.TrimSpace(maxDepth.Text))
+		return read
+	}
+	updateExample := func() {
+		root := filepath.Join(string(filepath.Separator)+"home", "user")
+		path := filepath.Join(root, "Pictures", "2023", "Japan", "Kyoto", "IMG_0001.jpg")
+		names := dirtags.Names(root, path, read())
+		if len(names) == 0 {
+			names = []string{"no tags"}
+		}
+		example.SetText("~/Pictures/2023/Japan/Kyoto/IMG_0001.jpg gets " + strings.Join(names, ", "))
+	}
+	for _, entry := range []*widget.Entry{ignore, maxDepth, parentTag} {
+		entry.OnChanged = func(string) { updateExample() }
+	}
+	enabled.OnChanged = func(bool) { updateExample() }
+	nested.OnChanged = func(bool) { updateExample() }
+	tagCase.OnChanged = func(string) { updateExample() }
+	updateExample()
+
+	save := func() bool {
+		if err := maxDepth.Validate(); err != nil {
+			dialog.ShowError(err, directoryWindow)
+			return false
+		}
+		opts.DirectoryTags = read()
+		if err := options.SaveOptionsToDB(db, opts); err != nil {
+			dialog.ShowError(err, directoryWindow)
+			return false
+		}
+		return true
+	}
+
+	applyButton := widget.NewButtonWithIcon("Apply to Library", theme.MediaPlayIcon(), func() {
+		if !save() {
+			return
+		}
+		progress := dialog.NewCustomWithoutButtons("Adding Directory Tags", widget.NewProgressBarInfinite(), directoryWindow)
+		progress.Show()
+		go func() {
+			added, err := database.ApplyDirectoryTags(context.Background(), db, opts.DirectoryTags)
+			progress.Hide()
+			if err != nil {
+				dialog.ShowError(err, directoryWindow)
+				return
+			}
+			dialog.ShowInformation("Directory Tags", fmt.Sprintf("Added %d directory tags to files", added), directoryWindow)
+		}()
+	})
+	removeButton := widget.NewButtonWithIcon("Remove Directory Tags", theme.ContentUndoIcon(), func() {
+		message := "Remove every tag added from directory names? Tags the files had before are kept."
+		dialog.ShowConfirm("Remove Directory Tags", message, func(ok bool) {
+			if !ok {
+				return
+			}
+			removed, err := database.RemoveDirectoryTags(db)
+			if err != nil {
+				dialog.ShowError(err, directoryWindow)
+				return
+			}
+			dialog.ShowInformation("Directory Tags", fmt.Sprintf("Removed %d directory tags from files", removed), directoryWindow)
+		}, directoryWindow)
+	})
+	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
+		if save() {
+			directoryWindow.Close()
+		}
+	})
+
+	form := widget.NewForm(
+		widget.NewFormItem("", enabled),
+		widget.NewFormItem("Ignore", ignore),
+		widget.NewFormItem("Max depth", maxDepth),
+		widget.NewFormItem("Case", tagCase),
+		widget.NewFormItem("", nested),
+		widget.NewFormItem("Parent tag", parentTag),
+	)
+	hint := widget.NewLabel("Tags are made from the directories between your home directory and the file. Hidden and ignored directories are skipped, the depth keeps the directories closest to the file.")
+	hint.Wrapping = fyne.TextWrapWord
+
+	directoryWindow.SetContent(container.NewBorder(nil,
+		container.NewVBox(container.NewGridWithColumns(2, applyButton, removeButton), saveButton), nil, nil,
+		container.NewVScroll(container.NewVBox(hint, form, example))))
+	directoryWindow.Resize(fyne.NewSize(460, 480))
+	directoryWindow.Show()
+}
+
+// Called after the keyboard shortcuts were saved so the main window can bind them again
+var OnShortcutsChanged func()
+
+// Lists every action with its key binding, a binding is written like Ctrl+Shift+F and an empty one is unbound
+func ShowShortcutsWindow(a fyne.App, db *sql.DB, opts *options.Options) {
+	shortcutsWindow := a.NewWindow("Keyboard Shortcuts")
+
+	entries := make(map[string]*widget.Entry, len(options.ShortcutActions))
+	form := widget.NewForm()
+	for _, action := range options.ShortcutActions {
+		entry := widget.NewEntry()
+		entry.SetText(opts.Shortcuts[action])
+		entry.Validator = func(binding string) error {
+			if strings.TrimSpace(binding) == "" {
+				return nil
+			}
+			_, _, err := shortcuts.Parse(binding)
+			return err
+		}
+		entries[action] = entry
+		form.Append(action, entry)
+	}
+
+	hint := widget.NewLabel("Moving with Shift held extends the selection. Keys without Ctrl or Alt do nothing while typing in a text field.")
+	hint.Wrapping = fyne.TextWrapWord
+
+	resetButton := widget.NewButton("Reset to Defaults", func() {
+		for action, binding := range options.DefaultShortcuts() {
+			entries[action].SetText(binding)
+		}
+	})
+	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
+		bindings := make(map[string]string, len(entries))
+		usedBy := map[string]string{}
+		for _, action := range options.ShortcutActions {
+			binding := strings.TrimSpace(entries[action].Text)
+			if binding != "" {
+				key, modifier, err := shortcuts.Parse(binding)
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("%s: %w", action, err), shortcutsWindow)
+					return
+				}
+				// written the same way every time so duplicates are found however they were typed
+				binding = shortcuts.Format(key, modifier)
+				if other, ok := usedBy[binding]; ok {
+					dialog.ShowError(fmt.Errorf("%s is used by both %s and %s", binding, other, action), shortcutsWindow)
+					return
+				}
+				usedBy[binding] = action
+			}
+			bindings[action] = binding
+		}
+
+		opts.Shortcuts = bindings
+		if err := options.SaveOptionsToDB(db, opts); err != nil {
+			dialog.ShowError(err, shortcutsWindow)
+			return
+		}
+		if OnShortcutsChanged != nil {
+			OnShortcutsChanged()
+		}
+		shortcutsWindow.Close()
+	})
+
+	shortcutsWindow.SetContent(container.NewBorder(nil, container.NewVBox(hint, container.NewGridWithColumns(2, resetButton, saveButton)), nil, nil, container.NewVScroll(form)))
+	shortcutsWindow.Resize(fyne.NewSize(420, 520))
+	shortcutsWindow.Show()
+}
+
 func ShowChooseDirWindow(a fyne.App, opts *options.Options, logger *log.Logger, db *sql.DB) {
 	chooseDirWindow := a.NewWindow("Choose directories you want to exclude from scanning")
 
@@ -342,117 +536,603 @@ func ShowChooseDirWindow(a fyne.App, opts *options.Options, logger *log.Logger,
 	chooseDirWindow.Show()
 }
 
-func mapToStringSlice(m map[string]bool) []string {
-	slice := make([]string, 0, len(m))
-	for k := range m {
-		slice = append(slice, k)
-	}
-	return slice
-}
-
-func ShowRightClickMenu(w fyne.Window, fileList map[string]bool, a fyne.App) {
-	home, _ := os.UserHomeDir()
-	now := time.Now()
-	formattedDate := now.Format("02-01-2006")
-
-	listedFiles := mapToStringSlice(fileList)
-
+func ShowRightClickMenu(w fyne.Window, listedFiles []string, a fyne.App, db *sql.DB, opts *options.Options) {
 	gzipButton := widget.NewButton("Create Gzip Archive", func() {
-		archivePath := filepath.Join(home, "Desktop", formattedDate+".tar.gz")
-		err := archives.CreateTarGzipArchive(archivePath, listedFiles, w)
-		if err != nil {
-			dialog.ShowError(err, w)
-		} else {
-			dialog.ShowInformation("Success", fmt.Sprintf("Archive created successfully at %s", archivePath), w)
-		}
+		showSaveArchiveDialog(w, db, opts, listedFiles, archives.FormatGzip, false, archives.CreateTarGzipArchive)
 	})
 
 	bzip2Button := widget.NewButton("Create Bzip2 Archive", func() {
-		archivePath := filepath.Join(home, "Desktop", formattedDate+".tar.bz2")
-		err := archives.CreateTarBzip2Archive(archivePath, listedFiles, w)
-		if err != nil {
-			dialog.ShowError(err, w)
-		} else {
-			dialog.ShowInformation("Success", fmt.Sprintf("Archive created successfully at %s", archivePath), w)
-		}
+		showSaveArchiveDialog(w, db, opts, listedFiles, archives.FormatBzip2, false, archives.CreateTarBzip2Archive)
 	})
 
 	zipButton := widget.NewButton("Create Zip Archive", func() {
-		archivePath := filepath.Join(home, "Desktop", formattedDate+".zip")
-		err := archives.CreateZipArchive(archivePath, listedFiles, w)
-		if err != nil {
-			dialog.ShowError(err, w)
-		} else {
-			dialog.ShowInformation("Success", fmt.Sprintf("Archive created successfully at %s", archivePath), w)
-		}
+		showSaveArchiveDialog(w, db, opts, listedFiles, archives.FormatZip, false, archives.CreateZipArchive)
+	})
+
+	zstdButton := widget.NewButton("Create Zstd Archive", func() {
+		showSaveArchiveDialog(w, db, opts, listedFiles, archives.FormatZstd, false, archives.CreateTarZstdArchive)
+	})
+
+	xzButton := widget.NewButton("Create Xz Archive", func() {
+		showSaveArchiveDialog(w, db, opts, listedFiles, archives.FormatXz, false, archives.CreateTarXzArchive)
 	})
 
 	encryptedButton := widget.NewButton("Create Encrypted Archive", func() {
-		showPasswordWindow(a, formattedDate, listedFiles, w)
+		showPasswordWindow(a, db, opts, listedFiles, w)
 	})
 
 	convertButton := widget.NewButton("Convert Files", func() {
-		showChooseConvertDir(a, w, listedFiles)
+		showChooseConvertDir(a, w, db, opts, listedFiles)
+	})
+
+	framesButton := widget.NewButton("Extract Frames", func() {
+		showExtractFramesDialog(w, db, listedFiles)
+	})
+
+	makeGIFButton := widget.NewButton("Make GIF", func() {
+		showMakeGIFDialog(w, db, opts, listedFiles)
+	})
+
+	extractButton := widget.NewButton("Extract Archive", func() {
+		showExtractArchiveWindow(w)
+	})
+
+	verifyButton := widget.NewButton("Verify Archive", func() {
+		showVerifyArchiveWindow(w, db, listedFiles)
 	})
 
 	content := container.NewVBox(
 		convertButton,
+		framesButton,
+		makeGIFButton,
 		gzipButton,
 		bzip2Button,
 		zipButton,
+		zstdButton,
+		xzButton,
+		container.NewBorder(nil, nil, widget.NewLabel("Zstd/Xz level:"), nil, newCompressionLevelSelect()),
+		container.NewBorder(nil, nil, widget.NewLabel("Split into:"), nil, newVolumeSizeSelect()),
 		encryptedButton,
+		extractButton,
+		verifyButton,
 	)
 	dialog.ShowCustom("File Actions", "Close", content, w)
 }
 
-func showChooseConvertDir(a fyne.App, w fyne.Window, fileList []string) {
+// Select that sets the compression level used by zstd and xz archives
+func newCompressionLevelSelect() *widget.Select {
+	levelSelect := widget.NewSelect(archives.LevelNames, func(level string) {
+		for i, name := range archives.LevelNames {
+			if name == level {
+				archives.CompressionLevel = i
+			}
+		}
+	})
+	levelSelect.SetSelected(archives.LevelNames[archives.CompressionLevel])
+	return levelSelect
+}
+
+// Select that sets the size archives are split at, for media with a file size limit
+func newVolumeSizeSelect() *widget.Select {
+	sizeSelect := widget.NewSelect(archives.VolumeSizeNames, func(size string) {
+		for i, name := range archives.VolumeSizeNames {
+			if name == size {
+				archives.VolumeSize = archives.VolumeSizes[i]
+			}
+		}
+	})
+	sizeSelect.SetSelected(archives.VolumeSizeNames[0])
+	for i, size := range archives.VolumeSizes {
+		if size == archives.VolumeSize {
+			sizeSelect.SetSelected(archives.VolumeSizeNames[i])
+		}
+	}
+	return sizeSelect
+}
+
+// Returns the directory the save dialog starts in, the last used one if it still exists
+func defaultArchiveDir(opts *options.Options) string {
+	home, _ := os.UserHomeDir()
+	for _, dir := range []string{opts.ArchiveDir, filepath.Join(home, "Desktop"), home} {
+		if info, err := os.Stat(dir); dir != "" && err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return "."
+}
+
+// Asks where to save the archive, suggesting a name built from the archive name template
+func showSaveArchiveDialog(w fyne.Window, db *sql.DB, opts *options.Options, fileList []string, format string, encrypted bool, create func(string, []string, fyne.Window) error) {
+	dir := defaultArchiveDir(opts)
+	name := archives.ExpandNameTemplate(opts.ArchiveName, database.GetCommonTag(db, fileList), len(fileList), time.Now())
+	suggestedPath := archives.NextFreePath(dir, name, format)
+
+	saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		if writer == nil {
+			return
+		}
+		archivePath := writer.URI().Path()
+		writer.Close()
+
+		// the dialog already created the file, move it if the extension was removed. The dialog only asked about
+		// overwriting the name without the extension, so the name with it must not be taken yet.
+		if !strings.HasSuffix(strings.ToLower(archivePath), format) {
+			os.Remove(archivePath)
+			archivePath = archives.NextFreePath(filepath.Dir(archivePath), filepath.Base(archivePath), format)
+		}
+
+		opts.ArchiveDir = filepath.Dir(archivePath)
+		if err := options.SaveOptionsToDB(db, opts); err != nil {
+			log.Println("Failed to save archive directory: ", err)
+		}
+
+		err = create(archivePath, fileList, w)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+
+		text := fmt.Sprintf("Archive created successfully at %s", archivePath)
+		if archives.VolumeSize > 0 {
+			text = fmt.Sprintf("Archive created successfully as volumes of %s, listed in %s", archivePath, archivePath+archives.ManifestExtension)
+		}
+		message := widget.NewLabel(text)
+		message.Wrapping = fyne.TextWrapWord
+		dialog.ShowCustomConfirm("Success", "Verify", "Close", message, func(verify bool) {
+			if verify {
+				showVerifyReport(w, db, archivePath, fileList, encrypted)
+			}
+		}, w)
+	}, w)
+
+	saveDialog.SetFileName(filepath.Base(suggestedPath))
+	if location, err := storage.ListerForURI(storage.NewFileURI(dir)); err == nil {
+		saveDialog.SetLocation(location)
+	}
+	saveDialog.Show()
+}
+
+// Verifies the archive against the database md5 of the files it should contain and shows the result
+func showVerifyReport(w fyne.Window, db *sql.DB, archivePath string, fileList []string, encrypted bool) {
+	var expected map[string]string
+	if len(fileList) > 0 {
+		hashes, err := database.GetFileHashes(db, fileList)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		expected = hashes
+	}
+
+	progress := dialog.NewCustomWithoutButtons("Verifying", widget.NewProgressBarInfinite(), w)
+	progress.Show()
+
+	go func() {
+		report := archives.VerifyArchive(archivePath, expected, encrypted)
+		progress.Hide()
+
+		result := widget.NewLabel(report.String())
+		result.Wrapping = fyne.TextWrapWord
+		title := "Archive Verified"
+		if !report.OK() {
+			title = "Archive Verification Failed"
+		}
+		resultDialog := dialog.NewCustom(title, "Close", container.NewVScroll(result), w)
+		resultDialog.Resize(fyne.NewSize(450, 300))
+		resultDialog.Show()
+	}()
+}
+
+// Picks an archive and verifies it against the selected files
+func showVerifyArchiveWindow(w fyne.Window, db *sql.DB, fileList []string) {
+	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil || reader == nil {
+			return
+		}
+		archivePath := reader.URI().Path()
+		reader.Close()
+
+		password := widget.NewPasswordEntry()
+		password.SetPlaceHolder("Leave empty if not encrypted")
+		items := []*widget.FormItem{widget.NewFormItem("Password", password)}
+
+		dialog.ShowForm("Verify Archive", "Verify", "Cancel", items, func(verify bool) {
+			if !verify {
+				return
+			}
+			archives.ArchivePassword = password.Text
+			showVerifyReport(w, db, archivePath, fileList, password.Text != "")
+		}, w)
+	}, w)
+}
+
+func showExtractArchiveWindow(w fyne.Window) {
+	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil || reader == nil {
+			return
+		}
+		archivePath := reader.URI().Path()
+		reader.Close()
+
+		if archives.FormatFromPath(archivePath) == "" {
+			dialog.ShowError(fmt.Errorf("unsupported archive type: %s", filepath.Base(archivePath)), w)
+			return
+		}
+
+		password := widget.NewPasswordEntry()
+		password.SetPlaceHolder("Leave empty if not encrypted")
+		items := []*widget.FormItem{widget.NewFormItem("Password", password)}
+
+		dialog.ShowForm("Extract Archive", "Extract", "Cancel", items, func(extract bool) {
+			if !extract {
+				return
+			}
+			archives.ArchivePassword = password.Text
+
+			// extract next to the archive into a folder named after it, volumes and manifests name the whole archive
+			name := filepath.Base(archives.BaseArchivePath(archivePath))
+			destDir := filepath.Join(filepath.Dir(archivePath), name[:len(name)-len(archives.FormatFromPath(name))])
+			err := archives.ExtractArchive(archivePath, destDir, password.Text != "")
+			if err != nil {
+				dialog.ShowError(err, w)
+			} else {
+				dialog.ShowInformation("Success", fmt.Sprintf("Archive extracted to %s", destDir), w)
+			}
+		}, w)
+	}, w)
+}
+
+func showChooseConvertDir(a fyne.App, w fyne.Window, db *sql.DB, opts *options.Options, fileList []string) {
 	// home, _ := os.UserHomeDir()
 	dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
-		if err == nil {
+		if err == nil && uri != nil {
 			path := uri.Path()
 			if uri.Scheme() == "file" {
 				convertedPath := filepath.Clean(path)
-				showChooseConvertType(a, w, fileList, convertedPath)
+				showChooseConvertType(a, w, db, opts, fileList, convertedPath)
 			}
 		}
 	}, w)
 }
 
-func showChooseConvertType(a fyne.App, w fyne.Window, fileList []string, resPath string) {
+func showChooseConvertType(a fyne.App, w fyne.Window, db *sql.DB, opts *options.Options, fileList []string, resPath string) {
 	convertWindow := a.NewWindow("Choose File Type")
 	convertWindow.SetTitle("Choose File Type")
-	convertWindow.Resize(fyne.NewSize(300, 100))
+	convertWindow.Resize(fyne.NewSize(420, 500))
 
-	content := container.NewVBox()
+	form, readOptions, setOptions := newConvertOptionsForm()
+	setOptions(options.DefaultConvertOptions())
+
+	presetNames := make([]string, 0, len(opts.ConvertPresets))
+	for name := range opts.ConvertPresets {
+		presetNames = append(presetNames, name)
+	}
+	sort.Strings(presetNames)
+	presetSelect := widget.NewSelect(presetNames, func(name string) {
+		if preset, ok := opts.ConvertPresets[name]; ok {
+			setOptions(preset)
+		}
+	})
+	presetSelect.PlaceHolder = "Choose a preset"
+
+	savePresetButton := widget.NewButton("Save as Preset", func() {
+		name := widget.NewEntry()
+		name.SetText(presetSelect.Selected)
+		dialog.ShowForm("Save Preset", "Save", "Cancel", []*widget.FormItem{widget.NewFormItem("Name", name)}, func(save bool) {
+			if !save || strings.TrimSpace(name.Text) == "" {
+				return
+			}
+			presetName := strings.TrimSpace(name.Text)
+			if opts.ConvertPresets == nil {
+				opts.ConvertPresets = map[string]options.ConvertOptions{}
+			}
+			if _, exists := opts.ConvertPresets[presetName]; !exists {
+				presetSelect.Options = append(presetSelect.Options, presetName)
+				sort.Strings(presetSelect.Options)
+			}
+			opts.ConvertPresets[presetName] = readOptions()
+			if err := options.SaveOptionsToDB(db, opts); err != nil {
+				dialog.ShowError(err, convertWindow)
+				return
+			}
+			presetSelect.SetSelected(presetName)
+		}, convertWindow)
+	})
+
+	tagSource := widget.NewCheck("Tag outputs with converted-from:<format>", nil)
+
+	formats := container.NewGridWithColumns(3)
 	// var resType string
 
 	for _, file := range imageconv.ImageTypes {
 		button := widget.NewButton(file, func() {
 			// resType = file
-			_, err := imageconv.ConvertImage(fileList, file, resPath)
-			if err != nil {
-				dialog.ShowError(err, w)
-				convertWindow.Close()
-				return
-			}
-			dialog.ShowInformation("Success", fmt.Sprintf("Converted to %s successfully", file), w)
+			convertOptions := readOptions()
 			convertWindow.Close()
+			showConvertProgress(w, db, fileList, file, resPath, convertOptions, tagSource.Checked)
 		})
-		content.Add(button)
+		formats.Add(button)
 	}
 
-	convertWindow.SetContent(content)
+	content := container.NewVBox(
+		container.NewBorder(nil, nil, nil, savePresetButton, presetSelect),
+		form,
+		tagSource,
+		widget.NewSeparator(),
+		widget.NewLabel("Convert to:"),
+		formats,
+	)
+	convertWindow.SetContent(container.NewVScroll(content))
 
 	convertWindow.Show()
 }
 
-func showPasswordWindow(a fyne.App, fmtDate string, fileList []string, tagVaultWindow fyne.Window) {
+// Builds the conversion settings form, returning functions to read the settings from it and to fill it in
+func newConvertOptionsForm() (*widget.Form, func() options.ConvertOptions, func(options.ConvertOptions)) {
+	quality := widget.NewSlider(1, 100)
+	qualityLabel := widget.NewLabel("")
+	quality.OnChanged = func(value float64) {
+		qualityLabel.SetText(strconv.Itoa(int(value)))
+	}
+
+	effort := widget.NewSlider(0, 10)
+	effortLabel := widget.NewLabel("")
+	effort.OnChanged = func(value float64) {
+		effortLabel.SetText(strconv.Itoa(int(value)))
+	}
+
+	lossless := widget.NewCheck("Lossless (WEBP, AVIF, HEIC)", nil)
+
+	maxWidth := widget.NewEntry()
+	maxWidth.SetPlaceHolder("No limit")
+	maxHeight := widget.NewEntry()
+	maxHeight.SetPlaceHolder("No limit")
+	scale := widget.NewEntry()
+	scale.SetPlaceHolder("100")
+
+	filter := widget.NewSelect(options.ResizeFilters, nil)
+	metadata := widget.NewSelect(options.MetadataModes, nil)
+
+	form := widget.NewForm(
+		widget.NewFormItem("Quality", container.NewBorder(nil, nil, nil, qualityLabel, quality)),
+		widget.NewFormItem("Effort", container.NewBorder(nil, nil, nil, effortLabel, effort)),
+		widget.NewFormItem("", lossless),
+		widget.NewFormItem("Max width", maxWidth),
+		widget.NewFormItem("Max height", maxHeight),
+		widget.NewFormItem("Scale %", scale),
+		widget.NewFormItem("Filter", filter),
+		widget.NewFormItem("Metadata", metadata),
+	)
+
+	// empty or invalid numbers mean no limit
+	number := func(entry *widget.Entry) int {
+		value, err := strconv.Atoi(strings.TrimSpace(entry.Text))
+		if err != nil || value < 0 {
+			return 0
+		}
+		return value
+	}
+	text := func(value int) string {
+		if value == 0 {
+			return ""
+		}
+		return strconv.Itoa(value)
+	}
+
+	read := func() options.ConvertOptions {
+		return options.ConvertOptions{
+			Quality:   int(quality.Value),
+			Effort:    int(effort.Value),
+			Lossless:  lossless.Checked,
+			MaxWidth:  number(maxWidth),
+			MaxHeight: number(maxHeight),
+			Scale:     number(scale),
+			Filter:    filter.Selected,
+			Metadata:  metadata.Selected,
+		}
+	}
+	set := func(convertOptions options.ConvertOptions) {
+		quality.SetValue(float64(convertOptions.Quality))
+		effort.SetValue(float64(convertOptions.Effort))
+		lossless.SetChecked(convertOptions.Lossless)
+		maxWidth.SetText(text(convertOptions.MaxWidth))
+		maxHeight.SetText(text(convertOptions.MaxHeight))
+		scale.SetText(text(convertOptions.Scale))
+		filter.SetSelected(convertOptions.Filter)
+		metadata.SetSelected(convertOptions.Metadata)
+	}
+	return form, read, set
+}
+
+// Converts the files in the background showing progress, then lists the files that failed.
+// Every converted file is added to the database as it is written, with the tags of its source.
+func showConvertProgress(w fyne.Window, db *sql.DB, fileList []string, format string, resPath string, convertOptions options.ConvertOptions, tagSource bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	progressBar := widget.NewProgressBar()
+	progressBar.Max = float64(len(fileList))
+	status := widget.NewLabel(fmt.Sprintf("Converting %d files to %s", len(fileList), format))
+	status.Truncation = fyne.TextTruncateEllipsis
+
+	progress := dialog.NewCustom("Converting", "Cancel", container.NewVBox(status, progressBar), w)
+	progress.SetOnClosed(cancel)
+	progress.Resize(fyne.NewSize(400, 150))
+	progress.Show()
+
+	go func() {
+		indexErrors := map[string]error{}
+		report := imageconv.ConvertImages(ctx, fileList, format, resPath, convertOptions, 0, func(done int, total int, result imageconv.ConvertResult) {
+			if result.Err == nil {
+				if err := database.AddConvertedFile(db, result.Source, result.Output, tagSource); err != nil {
+					indexErrors[result.Output] = err
+				}
+			}
+			progressBar.SetValue(float64(done))
+			status.SetText(fmt.Sprintf("%d / %d %s", done, total, filepath.Base(result.Source)))
+		})
+		cancelled := ctx.Err() != nil
+		progress.Hide()
+		cancel()
+
+		failed := report.Failed()
+		summary := fmt.Sprintf("Converted %d of %d files to %s", report.Converted(), len(fileList), format)
+		if cancelled {
+			summary += ", the conversion was cancelled"
+		}
+		if len(failed) == 0 && len(indexErrors) == 0 {
+			dialog.ShowInformation("Success", summary, w)
+			return
+		}
+
+		failures := container.NewVBox()
+		for _, result := range failed {
+			if imageconv.IsCancelled(result.Err) {
+				continue
+			}
+			label := widget.NewLabel(fmt.Sprintf("%s: %v", result.Source, result.Err))
+			label.Wrapping = fyne.TextWrapWord
+			failures.Add(label)
+		}
+		for output, err := range indexErrors {
+			label := widget.NewLabel(fmt.Sprintf("%s was converted but not added to the database: %v", output, err))
+			label.Wrapping = fyne.TextWrapWord
+			failures.Add(label)
+		}
+		resultDialog := dialog.NewCustom("Conversion Finished", "Close", container.NewBorder(widget.NewLabel(summary), nil, nil, nil, container.NewVScroll(failures)), w)
+		resultDialog.Resize(fyne.NewSize(500, 350))
+		resultDialog.Show()
+	}()
+}
+
+// Writes the frames of every animated file in the list to a folder as a PNG sequence
+func showExtractFramesDialog(w fyne.Window, db *sql.DB, fileList []string) {
+	var animated []string
+	for _, file := range fileList {
+		if animation.IsAnimated(file) {
+			animated = append(animated, file)
+		}
+	}
+	if len(animated) == 0 {
+		dialog.ShowInformation("Extract Frames", "None of the selected files is an animated GIF, PNG or WebP", w)
+		return
+	}
+
+	dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
+		if err != nil || uri == nil || uri.Scheme() != "file" {
+			return
+		}
+		dir := filepath.Clean(uri.Path())
+
+		frames := 0
+		for _, file := range animated {
+			outputs, err := imageconv.ExtractFrames(file, dir)
+			for _, output := range outputs {
+				if err := database.AddConvertedFile(db, file, output, false); err != nil {
+					log.Println("Failed to add frame to the database: ", err)
+				}
+			}
+			frames += len(outputs)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("%s: %w", filepath.Base(file), err), w)
+				return
+			}
+		}
+		dialog.ShowInformation("Success", fmt.Sprintf("Extracted %d frames from %d files to %s", frames, len(animated), dir), w)
+	}, w)
+}
+
+// Asks for the frame delay, loop count and dithering, then builds a GIF from the files sorted by name
+func showMakeGIFDialog(w fyne.Window, db *sql.DB, opts *options.Options, fileList []string) {
+	if len(fileList) == 0 {
+		dialog.ShowInformation("Make GIF", "Select the images to animate first", w)
+		return
+	}
+	frames := slices.Clone(fileList)
+	sort.Strings(frames)
+
+	delayEntry := widget.NewEntry()
+	delayEntry.SetText(strconv.Itoa(int(animation.DefaultDelay.Milliseconds())))
+	loopEntry := widget.NewEntry()
+	loopEntry.SetText("0")
+	ditherCheck := widget.NewCheck("Dither", nil)
+	ditherCheck.SetChecked(true)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Frame delay (ms)", delayEntry),
+		widget.NewFormItem("Plays (0 = forever)", loopEntry),
+		widget.NewFormItem("", ditherCheck),
+	}
+	dialog.ShowForm(fmt.Sprintf("Make GIF from %d images", len(frames)), "Save", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		delay, err := strconv.Atoi(delayEntry.Text)
+		if err != nil || delay <= 0 {
+			dialog.ShowError(fmt.Errorf("the frame delay must be a positive number of milliseconds"), w)
+			return
+		}
+		loops, err := strconv.Atoi(loopEntry.Text)
+		if err != nil || loops < 0 {
+			dialog.ShowError(fmt.Errorf("the number of plays must be 0 or more"), w)
+			return
+		}
+
+		saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			output := writer.URI().Path()
+			writer.Close()
+
+			// the dialog already created the file, move it if the extension was removed
+			if !strings.HasSuffix(strings.ToLower(output), ".gif") {
+				os.Remove(output)
+				output += ".gif"
+			}
+
+			if err := imageconv.MakeGIF(frames, output, time.Duration(delay)*time.Millisecond, loops, ditherCheck.Checked); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			for _, frame := range frames {
+				if err := database.AddConvertedFile(db, frame, output, false); err != nil {
+					log.Println("Failed to add gif to the database: ", err)
+				}
+			}
+			dialog.ShowInformation("Success", fmt.Sprintf("GIF created at %s", output), w)
+		}, w)
+
+		dir := defaultArchiveDir(opts)
+		saveDialog.SetFileName("animation.gif")
+		if location, err := storage.ListerForURI(storage.NewFileURI(filepath.Dir(frames[0]))); err == nil {
+			saveDialog.SetLocation(location)
+		} else if location, err := storage.ListerForURI(storage.NewFileURI(dir)); err == nil {
+			saveDialog.SetLocation(location)
+		}
+		saveDialog.Show()
+	}, w)
+}
+
+func showPasswordWindow(a fyne.App, db *sql.DB, opts *options.Options, fileList []string, tagVaultWindow fyne.Window) {
 	passwordWindow := a.NewWindow("Enter Password")
 	label := widget.NewLabel("Enter Password:")
-	password := widget.NewEntry()
+	password := widget.NewPasswordEntry()
 	password.OnSubmitted = func(password string) {
+		// an empty password would produce an archive anyone can open
+		if password == "" {
+			dialog.ShowError(archives.ErrEmptyPassword, passwordWindow)
+			return
+		}
 		archives.ArchivePassword = password
-		showChooseArchiveType(tagVaultWindow, fmtDate, fileList)
+		showChooseArchiveType(tagVaultWindow, db, opts, fileList)
 		passwordWindow.Close()
 	}
 	container := container.NewVBox(label, password)
@@ -461,25 +1141,32 @@ func showPasswordWindow(a fyne.App, fmtDate string, fileList []string, tagVaultW
 	passwordWindow.Show()
 }
 
-func showChooseArchiveType(w fyne.Window, formattedDate string, fileList []string) {
-	home, _ := os.UserHomeDir()
+func showChooseArchiveType(w fyne.Window, db *sql.DB, opts *options.Options, fileList []string) {
 	gzipButton := widget.NewButton("Gzip Archive", func() {
-		archivePath := filepath.Join(home, "Desktop", formattedDate+".tar.gz")
-		archives.CreateEncryptedTarGzipArchive(archivePath, fileList, w)
+		showSaveArchiveDialog(w, db, opts, fileList, archives.FormatGzip, true, archives.CreateEncryptedTarGzipArchive)
 	})
 	bzip2Button := widget.NewButton("Bzip2 Archive", func() {
-		archivePath := filepath.Join(home, "Desktop", formattedDate+".tar.bz2")
-		archives.CreateEncryptedTarBzip2Archive(archivePath, fileList, w)
+		showSaveArchiveDialog(w, db, opts, fileList, archives.FormatBzip2, true, archives.CreateEncryptedTarBzip2Archive)
+	})
+	zipButton := widget.NewButton("Zip Archive ("+archives.ZipEncryptionMethod+")", func() {
+		showSaveArchiveDialog(w, db, opts, fileList, archives.FormatZip, true, archives.CreateEncryptedZipArchive)
+	})
+	zstdButton := widget.NewButton("Zstd Archive", func() {
+		showSaveArchiveDialog(w, db, opts, fileList, archives.FormatZstd, true, archives.CreateEncryptedTarZstdArchive)
 	})
-	zipButton := widget.NewButton("Zip Archive", func() {
-		archivePath := filepath.Join(home, "Desktop", formattedDate+".zip")
-		archives.CreateEncryptedZipArchive(archivePath, fileList, w)
+	xzButton := widget.NewButton("Xz Archive", func() {
+		showSaveArchiveDialog(w, db, opts, fileList, archives.FormatXz, true, archives.CreateEncryptedTarXzArchive)
 	})
 
 	content := container.NewVBox(
+		widget.NewLabel("Tar archives use "+archives.TarEncryptionMethod),
 		gzipButton,
 		bzip2Button,
 		zipButton,
+		zstdButton,
+		xzButton,
+		container.NewBorder(nil, nil, widget.NewLabel("Zstd/Xz level:"), nil, newCompressionLevelSelect()),
+		container.NewBorder(nil, nil, widget.NewLabel("Split into:"), nil, newVolumeSizeSelect()),
 	)
 	dialog.ShowCustom("Choose Archive Type", "Close", content, w)
 }
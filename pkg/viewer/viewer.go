@@ -0,0 +1,294 @@
+package viewer
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+	"main/pkg/database"
+	"main/pkg/fynecomponents/zoomimg"
+	"main/pkg/imageconv"
+	"main/pkg/options"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+var (
+	SlideshowIntervals     = []time.Duration{2 * time.Second, 3 * time.Second, 5 * time.Second, 10 * time.Second}
+	SlideshowIntervalNames = []string{"2 seconds", "3 seconds", "5 seconds", "10 seconds"}
+	// Time every image is shown in the slideshow, set from the interval select
+	SlideshowInterval = 3 * time.Second
+)
+
+// Opens a window showing paths[index] at full resolution. Next and previous go through paths, which should be
+// the files of the current results in the order they were listed.
+func Show(a fyne.App, db *sql.DB, opts *options.Options, paths []string, index int) {
+	if len(paths) == 0 {
+		return
+	}
+	index = min(max(0, index), len(paths)-1)
+
+	w := a.NewWindow(filepath.Base(paths[index]))
+	w.Resize(fyne.NewSize(1000, 700))
+
+	zoom := zoomimg.NewZoomImage(nil)
+	status := widget.NewLabel("")
+	status.Truncation = fyne.TextTruncateEllipsis
+
+	infoLabel := widget.NewLabel("")
+	infoBackground := canvas.NewRectangle(theme.Color(theme.ColorNameOverlayBackground))
+	info := container.NewStack(infoBackground, container.NewPadded(infoLabel))
+	info.Hide()
+
+	var mu sync.Mutex
+	// bumped on every navigation so an image that finishes decoding late is not shown over a newer one
+	generation := 0
+	var stopSlideshow chan struct{}
+
+	load := func(i int) {
+		mu.Lock()
+		index = (i + len(paths)) % len(paths)
+		generation++
+		current, path := generation, paths[index]
+		mu.Unlock()
+
+		w.SetTitle(filepath.Base(path))
+		status.SetText(fmt.Sprintf("%d / %d  Loading %s...", index+1, len(paths), filepath.Base(path)))
+
+		go func() {
+			edits, err := database.GetEdits(db, path)
+			if err != nil {
+				edits = nil
+			}
+			img, err := imageconv.DecodeEdited(path, edits)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if current != generation {
+				return
+			}
+			if err != nil {
+				zoom.SetImage(nil)
+				status.SetText(fmt.Sprintf("%d / %d  Failed to open %s: %v", index+1, len(paths), filepath.Base(path), err))
+				return
+			}
+			zoom.SetImage(img)
+			status.SetText(fmt.Sprintf("%d / %d  %s", index+1, len(paths), filepath.Base(path)))
+			infoLabel.SetText(describe(db, opts, path, img, len(edits) > 0))
+		}()
+	}
+	step := func(delta int) {
+		mu.Lock()
+		i := index + delta
+		mu.Unlock()
+		load(i)
+	}
+
+	var slideshowButton *widget.Button
+	stop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopSlideshow == nil {
+			return
+		}
+		close(stopSlideshow)
+		stopSlideshow = nil
+		slideshowButton.SetIcon(theme.MediaPlayIcon())
+		w.SetFullScreen(false)
+	}
+	start := func() {
+		mu.Lock()
+		if stopSlideshow != nil {
+			mu.Unlock()
+			return
+		}
+		stopSlideshow = make(chan struct{})
+		done := stopSlideshow
+		mu.Unlock()
+
+		slideshowButton.SetIcon(theme.MediaPauseIcon())
+		w.SetFullScreen(true)
+		go func() {
+			ticker := time.NewTicker(SlideshowInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					step(1)
+				}
+			}
+		}()
+	}
+	toggleSlideshow := func() {
+		mu.Lock()
+		running := stopSlideshow != nil
+		mu.Unlock()
+		if running {
+			stop()
+		} else {
+			start()
+		}
+	}
+	toggleInfo := func() {
+		if info.Visible() {
+			info.Hide()
+		} else {
+			info.Show()
+		}
+	}
+
+	slideshowButton = widget.NewButtonWithIcon("", theme.MediaPlayIcon(), toggleSlideshow)
+	intervalSelect := widget.NewSelect(SlideshowIntervalNames, func(name string) {
+		for i, interval := range SlideshowIntervalNames {
+			if interval == name {
+				SlideshowInterval = SlideshowIntervals[i]
+			}
+		}
+	})
+	for i, interval := range SlideshowIntervals {
+		if interval == SlideshowInterval {
+			intervalSelect.SetSelected(SlideshowIntervalNames[i])
+		}
+	}
+
+	toolbar := container.NewHBox(
+		widget.NewButtonWithIcon("", theme.NavigateBackIcon(), func() { step(-1) }),
+		widget.NewButtonWithIcon("", theme.NavigateNextIcon(), func() { step(1) }),
+		widget.NewSeparator(),
+		widget.NewButtonWithIcon("Fit", theme.ZoomFitIcon(), zoom.ZoomToFit),
+		widget.NewButton("1:1", zoom.ZoomActual),
+		widget.NewButtonWithIcon("", theme.ZoomInIcon(), zoom.ZoomIn),
+		widget.NewButtonWithIcon("", theme.ZoomOutIcon(), zoom.ZoomOut),
+		widget.NewSeparator(),
+		slideshowButton,
+		intervalSelect,
+		widget.NewButtonWithIcon("", theme.ViewFullScreenIcon(), func() { w.SetFullScreen(!w.FullScreen()) }),
+		widget.NewButtonWithIcon("", theme.InfoIcon(), toggleInfo),
+	)
+
+	w.Canvas().SetOnTypedKey(func(event *fyne.KeyEvent) {
+		switch event.Name {
+		case fyne.KeyLeft, fyne.KeyPageUp, fyne.KeyBackspace:
+			step(-1)
+		case fyne.KeyRight, fyne.KeyPageDown:
+			step(1)
+		case fyne.KeyHome:
+			load(0)
+		case fyne.KeyEnd:
+			load(len(paths) - 1)
+		case fyne.KeySpace:
+			toggleSlideshow()
+		case fyne.KeyEqual, fyne.KeyPlus:
+			zoom.ZoomIn()
+		case fyne.KeyMinus:
+			zoom.ZoomOut()
+		case fyne.Key0, fyne.KeyF:
+			zoom.ZoomToFit()
+		case fyne.Key1:
+			zoom.ZoomActual()
+		case fyne.KeyI:
+			toggleInfo()
+		case fyne.KeyF11:
+			w.SetFullScreen(!w.FullScreen())
+		case fyne.KeyEscape:
+			mu.Lock()
+			running := stopSlideshow != nil
+			mu.Unlock()
+			switch {
+			case running:
+				stop()
+			case w.FullScreen():
+				w.SetFullScreen(false)
+			default:
+				w.Close()
+			}
+		}
+	})
+	w.SetOnClosed(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopSlideshow != nil {
+			close(stopSlideshow)
+			stopSlideshow = nil
+		}
+	})
+
+	view := container.NewStack(zoom, container.NewVBox(container.NewHBox(info)))
+	w.SetContent(container.NewBorder(toolbar, status, nil, nil, view))
+	load(index)
+	w.Show()
+}
+
+// Text of the metadata overlay, the EXIF fields come from the ExifFields option
+func describe(db *sql.DB, opts *options.Options, path string, img image.Image, edited bool) string {
+	lines := []string{
+		filepath.Base(path),
+		filepath.Dir(path),
+		fmt.Sprintf("%d x %d", img.Bounds().Dx(), img.Bounds().Dy()),
+	}
+	if info, err := os.Stat(path); err == nil {
+		lines = append(lines, formatSize(info.Size()))
+	}
+	lines = append(lines, "Type: "+strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), ".")))
+	if date := database.GetDate(db, path); date != "" {
+		lines = append(lines, "Date Added: "+date)
+	}
+	if edited {
+		lines = append(lines, "Shown with edits")
+	}
+	return strings.Join(append(lines, exifFields(path, opts.ExifFields)...), "\n")
+}
+
+func exifFields(path string, fields []string) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	decoded, err := exif.Decode(file)
+	if err != nil {
+		return nil
+	}
+
+	var lines []string
+	for _, field := range fields {
+		tag, err := decoded.Get(exif.FieldName(field))
+		if err != nil {
+			continue
+		}
+		value, err := tag.StringVal()
+		if err != nil {
+			value = tag.String()
+		}
+		lines = append(lines, field+": "+strings.TrimSpace(value))
+	}
+	return lines
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}